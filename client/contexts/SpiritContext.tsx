@@ -10,6 +10,12 @@ type SpiritContextType = {
   getUserSpirits: () => Promise<Spirit[]>
 }
 
+type FetchSpiritsResponse = {
+  spirits: SpiritRawData[]
+  nextPageToken: string | null
+  hasMore: boolean
+}
+
 const SpiritContext = createContext<SpiritContextType | undefined>(undefined)
 
 export function useSpiritContext() {
@@ -90,14 +96,27 @@ export function SpiritProvider({ children }: { children: React.ReactNode }) {
     const endpoint = url + '/FetchSpirits';
     console.log('FetchSpirits Endpoint:', endpoint);
     try {
-      const response = await axios.get(endpoint, {
-        headers: {
-          'Content-Type': 'application/json',
-          Authorization: `Bearer ${idToken}`,
-        },
-      });
-      const spiritsRawData = response.data as SpiritRawData[];
-      if (!spiritsRawData) {
+      const spiritsRawData: SpiritRawData[] = [];
+      let pageToken: string | null = null;
+      do {
+        const response = await axios.get(endpoint, {
+          headers: {
+            'Content-Type': 'application/json',
+            Authorization: `Bearer ${idToken}`,
+          },
+          params: {
+            pageSize: 50,
+            ...(pageToken ? { pageToken } : {}),
+          },
+        });
+        const page = response.data as FetchSpiritsResponse;
+        if (!page || !page.spirits) {
+          break;
+        }
+        spiritsRawData.push(...page.spirits);
+        pageToken = page.hasMore ? page.nextPageToken : null;
+      } while (pageToken);
+      if (spiritsRawData.length === 0) {
         console.log('No spirits found.');
         return [];
       }
@@ -0,0 +1,76 @@
+// Command migrate_spirits upgrades stored spirit documents to the current
+// schema version.
+//
+// The server serves old spirits upgraded but leaves the stored documents as
+// they are, so run this after adding a migration.
+//
+//	FIREBASE_CREDENTIALS_JSON="$(cat credentials.json)" go run ./cmd/migrate_spirits -dry-run
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"spirit-snap/server/logic/spirit_migrator"
+	"spirit-snap/server/models"
+	"spirit-snap/server/wrappers/datastore"
+
+	firebase "firebase.google.com/go"
+	"google.golang.org/api/option"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "Report the spirits that would be upgraded without writing them")
+	users := flag.String("users", "", "Comma separated IDs of the users to migrate. Every user is migrated when empty")
+	batchSize := flag.Int("batch-size", spirit_migrator.DefaultBatchSize, "Number of spirits read per query")
+	flag.Parse()
+
+	credentials := os.Getenv("FIREBASE_CREDENTIALS_JSON")
+	if credentials == "" {
+		log.Fatal("FIREBASE_CREDENTIALS_JSON environment variable is required")
+	}
+	if *batchSize <= 0 {
+		log.Fatalf("-batch-size must be positive, got %d", *batchSize)
+	}
+
+	ctx := context.Background()
+	firebaseApp, err := firebase.NewApp(ctx, nil, option.WithCredentialsJSON([]byte(credentials)))
+	if err != nil {
+		log.Fatalf("Failed to initialize Firebase App: %v", err)
+	}
+	datastoreClient, err := datastore.NewClient(ctx, firebaseApp)
+	if err != nil {
+		log.Fatalf("Failed to create datastore client: %v", err)
+	}
+	defer datastoreClient.Close()
+
+	for _, migration := range models.SpiritMigrations() {
+		log.Printf("Migration %d: %s", migration.Version, migration.Description)
+	}
+
+	migrator := spirit_migrator.NewSpiritMigrator(datastoreClient)
+	migrator.BatchSize = *batchSize
+	migrator.OnProgress = func(report spirit_migrator.Report) {
+		log.Printf("Progress: %s", report)
+	}
+	options := spirit_migrator.Options{DryRun: *dryRun}
+	if *users != "" {
+		options.UserIDs = strings.Split(*users, ",")
+	}
+
+	report, err := migrator.Run(ctx, options)
+	if *dryRun {
+		log.Printf("Dry run, nothing was written. Upgrading to schema version %d would touch: %s", models.CurrentSpiritSchemaVersion, report)
+	} else {
+		log.Printf("Upgraded to schema version %d: %s", models.CurrentSpiritSchemaVersion, report)
+	}
+	if err != nil {
+		log.Fatalf("Migration stopped: %v", err)
+	}
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
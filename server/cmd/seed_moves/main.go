@@ -0,0 +1,75 @@
+// Command seed_moves loads the moves defined in the move data file into the
+// moves collection.
+//
+// It writes to Firestore, or to the SQLite database of the local backend
+// when DATABASE_PATH is set. Run it from the server directory:
+//
+//	FIREBASE_CREDENTIALS_JSON="$(cat credentials.json)" go run ./cmd/seed_moves -dry-run
+//	DATABASE_PATH=spirit-snap.db go run ./cmd/seed_moves
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"spirit-snap/server/logic/move_seeder"
+	"spirit-snap/server/wrappers/datastore"
+
+	firebase "firebase.google.com/go"
+	"google.golang.org/api/option"
+)
+
+func main() {
+	file := flag.String("file", "data/moves.json", "Path of the move data file")
+	dryRun := flag.Bool("dry-run", false, "Report the moves that would be written without writing them")
+	flag.Parse()
+
+	dataFile, err := os.Open(*file)
+	if err != nil {
+		log.Fatalf("Failed to open move data: %v", err)
+	}
+	data, err := move_seeder.ParseMoveData(dataFile)
+	dataFile.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Read %d moves from %s, version %d", len(data.Moves), *file, data.Version)
+
+	ctx := context.Background()
+	var datastoreClient interface {
+		move_seeder.DatastoreInterface
+		Close() error
+	}
+	if databasePath := os.Getenv("DATABASE_PATH"); databasePath != "" {
+		datastoreClient, err = datastore.NewSQLiteClient(databasePath)
+		if err != nil {
+			log.Fatalf("Failed to open SQLite database: %v", err)
+		}
+	} else {
+		credentials := os.Getenv("FIREBASE_CREDENTIALS_JSON")
+		if credentials == "" {
+			log.Fatal("FIREBASE_CREDENTIALS_JSON or DATABASE_PATH environment variable is required")
+		}
+		firebaseApp, err := firebase.NewApp(ctx, nil, option.WithCredentialsJSON([]byte(credentials)))
+		if err != nil {
+			log.Fatalf("Failed to initialize Firebase App: %v", err)
+		}
+		datastoreClient, err = datastore.NewClient(ctx, firebaseApp)
+		if err != nil {
+			log.Fatalf("Failed to create datastore client: %v", err)
+		}
+	}
+	defer datastoreClient.Close()
+
+	report, err := move_seeder.NewMoveSeeder(datastoreClient).Seed(ctx, data, move_seeder.Options{DryRun: *dryRun})
+	if *dryRun {
+		log.Printf("Dry run, nothing was written. Seeding version %d would result in: %s", data.Version, report)
+	} else {
+		log.Printf("Seeded version %d: %s", data.Version, report)
+	}
+	if err != nil {
+		log.Fatalf("Seeding stopped: %v", err)
+	}
+}
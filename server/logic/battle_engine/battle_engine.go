@@ -0,0 +1,327 @@
+// Package battle_engine resolves battles between two players' teams of
+// spirits on the server, so that clients cannot decide the outcome.
+//
+// The engine is a deterministic state machine: Apply takes a State and an
+// Action and returns the next State without modifying its input. Randomness
+// comes from a generator seeded when the battle starts, whose state is part
+// of State, so the same seed and actions always produce the same battle.
+package battle_engine
+
+import (
+	"errors"
+	"fmt"
+
+	"spirit-snap/server/logic/spirit_types"
+)
+
+var (
+	// ErrBattleOver is returned when acting in a battle that has a winner.
+	ErrBattleOver = errors.New("battle is over")
+	// ErrNotYourTurn is returned when a player acts during the other
+	// player's turn.
+	ErrNotYourTurn = errors.New("not your turn")
+	// ErrInvalidAction is returned when an action breaks the rules of the
+	// battle, e.g. attacking from the bench.
+	ErrInvalidAction = errors.New("invalid action")
+)
+
+// State is the state of a battle.
+type State struct {
+	// Combatants by side and slot. Empty slots are nil.
+	Positions [2][SlotCount]*Combatant `json:"positions"`
+	// Side whose turn it is.
+	Turn Side `json:"turn"`
+	// Number of turns taken so far.
+	TurnNumber int `json:"turnNumber"`
+	// Side that won. Nil while the battle is in progress.
+	Winner *Side `json:"winner,omitempty"`
+	// State of the random number generator. It is not sent to clients, who
+	// could use it to predict critical hits and dodges.
+	RNGState uint64 `json:"-"`
+}
+
+// NewBattle places the teams of both players and gives the first turn to
+// PlayerOne. Each team has from one to six combatants, which fill the
+// frontline, middle left, middle right and then the bench slots in order.
+//
+// Parameters:
+//   - playerOne: The team of PlayerOne.
+//   - playerTwo: The team of PlayerTwo.
+//   - seed: Seeds the random number generator used by the battle.
+//
+// Returns:
+//   - The state at the start of the battle.
+//   - An error if a team is empty, too large or has a fainted combatant.
+func NewBattle(playerOne []Combatant, playerTwo []Combatant, seed int64) (State, error) {
+	state := State{Turn: PlayerOne, RNGState: uint64(seed)}
+	for side, team := range [][]Combatant{playerOne, playerTwo} {
+		if len(team) == 0 || len(team) > SlotCount {
+			return State{}, fmt.Errorf("%v must have from 1 to %d spirits, got %d", Side(side), SlotCount, len(team))
+		}
+		for slot := range team {
+			combatant := team[slot]
+			if combatant.Fainted() {
+				return State{}, fmt.Errorf("spirit %s of %v has no hit points", combatant.SpiritID, Side(side))
+			}
+			state.Positions[side][slot] = &combatant
+		}
+	}
+	return state, nil
+}
+
+// At returns the combatant at a position, or nil if it is empty.
+func (s *State) At(position Position) *Combatant {
+	return s.Positions[position.Side][position.Slot]
+}
+
+// Returns a copy of the state that shares nothing with it that Apply changes.
+func (s State) clone() State {
+	for side := range s.Positions {
+		for slot, combatant := range s.Positions[side] {
+			if combatant != nil {
+				copied := *combatant
+				s.Positions[side][slot] = &copied
+			}
+		}
+	}
+	if s.Winner != nil {
+		winner := *s.Winner
+		s.Winner = &winner
+	}
+	return s
+}
+
+// Reports whether every combatant of a side has fainted.
+func (s *State) defeated(side Side) bool {
+	for _, combatant := range s.Positions[side] {
+		if combatant != nil && !combatant.Fainted() {
+			return false
+		}
+	}
+	return true
+}
+
+// ActionType is the kind of an action.
+type ActionType string
+
+const (
+	// Attacks one of the opponent's arena spirits.
+	ActionMove ActionType = "Move"
+	// Moves the acting player's middle left spirit to the frontline, the
+	// middle right spirit to the middle left and the frontline spirit to the
+	// middle right.
+	ActionRotate ActionType = "Rotate"
+	// Swaps one of the acting player's bench spirits with one of their arena
+	// spirits.
+	ActionSwap ActionType = "Swap"
+	// Gives up the battle. Players may surrender during either turn.
+	ActionSurrender ActionType = "Surrender"
+)
+
+// Action is something a player does in a battle. Every action but Surrender
+// ends the player's turn.
+type Action struct {
+	Type ActionType `json:"type"`
+	// Side taking the action.
+	Player Side `json:"player"`
+	// Set for ActionMove.
+	Move *MoveFields `json:"move,omitempty"`
+	// Set for ActionSwap.
+	Swap *SwapFields `json:"swap,omitempty"`
+}
+
+type MoveFields struct {
+	// Slot of the acting player's attacking spirit.
+	AttackerSlot Slot `json:"attackerSlot"`
+	// Slot of the opponent's spirit that is attacked.
+	TargetSlot Slot `json:"targetSlot"`
+	// ID of one of the attacker's moves. Empty for a basic strike.
+	MoveID string `json:"moveId,omitempty"`
+}
+
+type SwapFields struct {
+	// Bench slot of the spirit that enters the arena.
+	SwapInSlot Slot `json:"swapInSlot"`
+	// Arena slot of the spirit that goes to the bench. It may be empty or
+	// hold a fainted spirit.
+	SwapOutSlot Slot `json:"swapOutSlot"`
+}
+
+// EventType is the kind of an event.
+type EventType string
+
+const (
+	// A spirit took damage.
+	EventDamage EventType = "Damage"
+	// An attack missed because of the move's accuracy.
+	EventMiss EventType = "Miss"
+	// A spirit dodged an attack.
+	EventDodge EventType = "Dodge"
+	// A spirit was unaffected by an attack because of its types.
+	EventImmune EventType = "Immune"
+	// A spirit ran out of hit points.
+	EventFaint EventType = "Faint"
+	// A side's arena spirits rotated.
+	EventRotate EventType = "Rotate"
+	// A side swapped a bench spirit into the arena.
+	EventSwap EventType = "Swap"
+	// A side surrendered.
+	EventSurrender EventType = "Surrender"
+	// A side won the battle.
+	EventWin EventType = "Win"
+)
+
+// Event describes something that happened while applying an action, for
+// clients to animate.
+type Event struct {
+	Type EventType `json:"type"`
+	// Position the event happened at. Only the side is meaningful for
+	// events that are not about a single spirit.
+	Position Position `json:"position"`
+	// Damage dealt, for EventDamage.
+	Damage int `json:"damage,omitempty"`
+	// Whether the attack was a critical hit, for EventDamage.
+	Critical bool `json:"critical,omitempty"`
+	// Multiplier of the damage for the move's type against the spirit's
+	// types, for EventDamage. Above 1 the attack was super effective and
+	// below 1 it was resisted.
+	Effectiveness float64 `json:"effectiveness,omitempty"`
+	// Move that was used, for EventDamage, EventMiss, EventDodge and
+	// EventImmune.
+	MoveID string `json:"moveId,omitempty"`
+}
+
+// Apply validates an action and applies it to a copy of the state.
+//
+// Parameters:
+//   - state: The state of the battle. It is not modified.
+//   - action: The action to take.
+//
+// Returns:
+//   - The state after the action.
+//   - The events that happened, in order.
+//   - An error wrapping ErrBattleOver, ErrNotYourTurn or ErrInvalidAction if
+//     the action cannot be taken.
+func Apply(state State, action Action) (State, []Event, error) {
+	if state.Winner != nil {
+		return State{}, nil, ErrBattleOver
+	}
+	if action.Player != PlayerOne && action.Player != PlayerTwo {
+		return State{}, nil, fmt.Errorf("%w: unknown player %d", ErrInvalidAction, int(action.Player))
+	}
+	if action.Type != ActionSurrender && action.Player != state.Turn {
+		return State{}, nil, ErrNotYourTurn
+	}
+
+	next := state.clone()
+	var events []Event
+	var err error
+	switch action.Type {
+	case ActionMove:
+		events, err = next.applyMove(action.Player, action.Move)
+	case ActionRotate:
+		events = next.applyRotate(action.Player)
+	case ActionSwap:
+		events, err = next.applySwap(action.Player, action.Swap)
+	case ActionSurrender:
+		winner := action.Player.Opponent()
+		next.Winner = &winner
+		return next, []Event{
+			{Type: EventSurrender, Position: Position{Side: action.Player}},
+			{Type: EventWin, Position: Position{Side: winner}},
+		}, nil
+	default:
+		err = fmt.Errorf("%w: unknown action type %q", ErrInvalidAction, action.Type)
+	}
+	if err != nil {
+		return State{}, nil, err
+	}
+
+	next.TurnNumber++
+	if opponent := action.Player.Opponent(); next.defeated(opponent) {
+		winner := action.Player
+		next.Winner = &winner
+		events = append(events, Event{Type: EventWin, Position: Position{Side: action.Player}})
+	} else {
+		next.Turn = opponent
+	}
+	return next, events, nil
+}
+
+func (s *State) applyMove(player Side, fields *MoveFields) ([]Event, error) {
+	if fields == nil {
+		return nil, fmt.Errorf("%w: move fields are missing", ErrInvalidAction)
+	}
+	attacker, err := s.arenaCombatant(Position{Side: player, Slot: fields.AttackerSlot})
+	if err != nil {
+		return nil, err
+	}
+	targetPosition := Position{Side: player.Opponent(), Slot: fields.TargetSlot}
+	target, err := s.arenaCombatant(targetPosition)
+	if err != nil {
+		return nil, err
+	}
+	move, ok := attacker.move(fields.MoveID)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s does not know move %s", ErrInvalidAction, attacker.Name, fields.MoveID)
+	}
+
+	random := rng{state: s.RNGState}
+	result := resolveAttack(attacker, target, move, &random)
+	s.RNGState = random.state
+	if result.effectiveness == spirit_types.Immune {
+		return []Event{{Type: EventImmune, Position: targetPosition, MoveID: move.ID}}, nil
+	}
+	if result.missed {
+		return []Event{{Type: EventMiss, Position: targetPosition, MoveID: move.ID}}, nil
+	}
+	if result.dodged {
+		return []Event{{Type: EventDodge, Position: targetPosition, MoveID: move.ID}}, nil
+	}
+	target.HitPoints = max(target.HitPoints-result.damage, 0)
+	events := []Event{{Type: EventDamage, Position: targetPosition, Damage: result.damage, Critical: result.critical, Effectiveness: result.effectiveness, MoveID: move.ID}}
+	if target.Fainted() {
+		events = append(events, Event{Type: EventFaint, Position: targetPosition})
+	}
+	return events, nil
+}
+
+// Returns the combatant at an arena position that is able to fight.
+func (s *State) arenaCombatant(position Position) (*Combatant, error) {
+	if !position.Slot.InArena() {
+		return nil, fmt.Errorf("%w: %v is not in the arena", ErrInvalidAction, position)
+	}
+	combatant := s.At(position)
+	if combatant == nil {
+		return nil, fmt.Errorf("%w: %v is empty", ErrInvalidAction, position)
+	}
+	if combatant.Fainted() {
+		return nil, fmt.Errorf("%w: the spirit at %v has fainted", ErrInvalidAction, position)
+	}
+	return combatant, nil
+}
+
+func (s *State) applyRotate(player Side) []Event {
+	side := &s.Positions[player]
+	side[Frontline], side[MiddleLeft], side[MiddleRight] = side[MiddleLeft], side[MiddleRight], side[Frontline]
+	return []Event{{Type: EventRotate, Position: Position{Side: player}}}
+}
+
+func (s *State) applySwap(player Side, fields *SwapFields) ([]Event, error) {
+	if fields == nil {
+		return nil, fmt.Errorf("%w: swap fields are missing", ErrInvalidAction)
+	}
+	if !fields.SwapInSlot.OnBench() {
+		return nil, fmt.Errorf("%w: %v is not on the bench", ErrInvalidAction, fields.SwapInSlot)
+	}
+	if !fields.SwapOutSlot.InArena() {
+		return nil, fmt.Errorf("%w: %v is not in the arena", ErrInvalidAction, fields.SwapOutSlot)
+	}
+	side := &s.Positions[player]
+	swapIn := side[fields.SwapInSlot]
+	if swapIn == nil || swapIn.Fainted() {
+		return nil, fmt.Errorf("%w: no spirit at %v can be swapped in", ErrInvalidAction, fields.SwapInSlot)
+	}
+	side[fields.SwapInSlot], side[fields.SwapOutSlot] = side[fields.SwapOutSlot], swapIn
+	return []Event{{Type: EventSwap, Position: Position{Side: player, Slot: fields.SwapOutSlot}}}, nil
+}
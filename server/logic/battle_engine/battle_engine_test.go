@@ -0,0 +1,524 @@
+package battle_engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"spirit-snap/server/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCombatant(id string) Combatant {
+	return Combatant{
+		SpiritID: id,
+		Name:     id,
+		Stats: Stats{
+			Strength: 50, Arcana: 50, Toughness: 50, Aura: 50, Agility: 50, Luck: 50, HitPoints: 100,
+		},
+		Moves:     []Move{{ID: "move_1", Name: "Gust", Type: "Air", Power: DefaultMovePower}},
+		HitPoints: 100,
+	}
+}
+
+func newTestTeam(prefix string, size int) []Combatant {
+	var team []Combatant
+	for i := 0; i < size; i++ {
+		team = append(team, newTestCombatant(fmt.Sprintf("%s%d", prefix, i)))
+	}
+	return team
+}
+
+func newTestBattle(t *testing.T) State {
+	state, err := NewBattle(newTestTeam("a", 6), newTestTeam("b", 6), 42)
+	assert.NoError(t, err)
+	return state
+}
+
+func attack(player Side, attacker, target Slot) Action {
+	return Action{Type: ActionMove, Player: player, Move: &MoveFields{AttackerSlot: attacker, TargetSlot: target, MoveID: "move_1"}}
+}
+
+func TestNewBattle(t *testing.T) {
+	state, err := NewBattle(newTestTeam("a", 6), newTestTeam("b", 2), 42)
+
+	assert.NoError(t, err)
+	assert.Equal(t, PlayerOne, state.Turn)
+	assert.Nil(t, state.Winner)
+	assert.Equal(t, "a0", state.At(Position{Side: PlayerOne, Slot: Frontline}).SpiritID)
+	assert.Equal(t, "a1", state.At(Position{Side: PlayerOne, Slot: MiddleLeft}).SpiritID)
+	assert.Equal(t, "a2", state.At(Position{Side: PlayerOne, Slot: MiddleRight}).SpiritID)
+	assert.Equal(t, "a5", state.At(Position{Side: PlayerOne, Slot: BenchRight}).SpiritID)
+	assert.Equal(t, "b1", state.At(Position{Side: PlayerTwo, Slot: MiddleLeft}).SpiritID)
+	assert.Nil(t, state.At(Position{Side: PlayerTwo, Slot: MiddleRight}))
+}
+
+func TestNewBattle_InvalidTeams(t *testing.T) {
+	fainted := newTestTeam("b", 2)
+	fainted[1].HitPoints = 0
+
+	tests := []struct {
+		name      string
+		playerTwo []Combatant
+	}{
+		{name: "Empty team", playerTwo: nil},
+		{name: "Too many spirits", playerTwo: newTestTeam("b", 7)},
+		{name: "Fainted spirit", playerTwo: fainted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewBattle(newTestTeam("a", 6), tt.playerTwo, 42)
+
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNewCombatant(t *testing.T) {
+	id, name, primaryType, moveId := "spirit_1", "Glimmering Griffon", "Air", "move_1"
+	stat := func(value int) *int { return &value }
+	spirit := models.Spirit{
+		ID: &id, Name: &name, PrimaryType: &primaryType,
+		Strength: stat(70), Arcana: stat(40), Toughness: stat(60), Aura: stat(30),
+		Agility: stat(80), Luck: stat(20), HitPoints: stat(120),
+		Moves: []*models.Move{{ID: &moveId, Type: &primaryType}, nil},
+	}
+
+	combatant, err := NewCombatant(spirit)
+
+	assert.NoError(t, err)
+	assert.Equal(t, Combatant{
+		SpiritID:    "spirit_1",
+		Name:        "Glimmering Griffon",
+		PrimaryType: "Air",
+		Stats:       Stats{Strength: 70, Arcana: 40, Toughness: 60, Aura: 30, Agility: 80, Luck: 20, HitPoints: 120},
+		Moves:       []Move{{ID: "move_1", Type: "Air", Power: DefaultMovePower}},
+		HitPoints:   120,
+	}, combatant)
+
+	spirit.Luck = nil
+	_, err = NewCombatant(spirit)
+	assert.ErrorContains(t, err, "luck")
+	spirit.Luck, spirit.HitPoints = stat(20), stat(0)
+	_, err = NewCombatant(spirit)
+	assert.Error(t, err)
+}
+
+func TestApply_Move(t *testing.T) {
+	state := newTestBattle(t)
+
+	next, events, err := Apply(state, attack(PlayerOne, Frontline, MiddleLeft))
+
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, EventDamage, events[0].Type)
+	assert.Equal(t, Position{Side: PlayerTwo, Slot: MiddleLeft}, events[0].Position)
+	assert.Equal(t, "move_1", events[0].MoveID)
+	assert.Equal(t, 1.0, events[0].Effectiveness)
+	// 40 * 50 / 50 / 2 + 2 = 22, scaled by 85-100%, or 1.5 times that.
+	assert.GreaterOrEqual(t, events[0].Damage, 18)
+	assert.LessOrEqual(t, events[0].Damage, 33)
+	assert.Equal(t, 100-events[0].Damage, next.At(Position{Side: PlayerTwo, Slot: MiddleLeft}).HitPoints)
+	assert.Equal(t, PlayerTwo, next.Turn)
+	assert.Equal(t, 1, next.TurnNumber)
+	assert.NotEqual(t, state.RNGState, next.RNGState)
+
+	// The input state is not modified.
+	assert.Equal(t, 100, state.At(Position{Side: PlayerTwo, Slot: MiddleLeft}).HitPoints)
+	assert.Equal(t, PlayerOne, state.Turn)
+	assert.Equal(t, 0, state.TurnNumber)
+}
+
+func TestApply_IsDeterministic(t *testing.T) {
+	actions := []Action{
+		attack(PlayerOne, Frontline, Frontline),
+		attack(PlayerTwo, Frontline, Frontline),
+		{Type: ActionRotate, Player: PlayerOne},
+		attack(PlayerTwo, MiddleLeft, Frontline),
+		attack(PlayerOne, Frontline, MiddleRight),
+	}
+	play := func(seed int64) []Event {
+		state, err := NewBattle(newTestTeam("a", 6), newTestTeam("b", 6), seed)
+		assert.NoError(t, err)
+		var all []Event
+		for _, action := range actions {
+			var events []Event
+			state, events, err = Apply(state, action)
+			assert.NoError(t, err)
+			all = append(all, events...)
+		}
+		return all
+	}
+
+	assert.Equal(t, play(42), play(42))
+	differs := false
+	for seed := int64(1); seed < 10 && !differs; seed++ {
+		differs = fmt.Sprint(play(42)) != fmt.Sprint(play(seed))
+	}
+	assert.True(t, differs, "different seeds should produce different battles")
+}
+
+func TestResolveAttack_UsesStats(t *testing.T) {
+	averageDamage := func(attacker, target Combatant) int {
+		random := rng{state: 7}
+		total := 0
+		for i := 0; i < 1000; i++ {
+			total += resolveAttack(&attacker, &target, Move{Power: DefaultMovePower}, &random).damage
+		}
+		return total / 1000
+	}
+	base := newTestCombatant("base")
+	strong := newTestCombatant("strong")
+	strong.Stats.Strength = 100
+	tough := newTestCombatant("tough")
+	tough.Stats.Toughness = 100
+	arcane := newTestCombatant("arcane")
+	arcane.Stats.Arcana = 100
+	warded := newTestCombatant("warded")
+	warded.Stats.Aura = 100
+
+	assert.Greater(t, averageDamage(strong, base), averageDamage(base, base))
+	assert.Less(t, averageDamage(base, tough), averageDamage(base, base))
+	// Arcane spirits attack with arcana, which aura defends against.
+	assert.Greater(t, averageDamage(arcane, base), averageDamage(base, base))
+	assert.Equal(t, averageDamage(arcane, tough), averageDamage(arcane, base))
+	assert.Less(t, averageDamage(arcane, warded), averageDamage(arcane, base))
+}
+
+func TestNewCombatant_MoveDefinitions(t *testing.T) {
+	id, strikeId, healId, oldId := "spirit_1", "strike", "heal", "old"
+	physical, status, power, accuracy := models.MoveCategoryPhysical, models.MoveCategoryStatus, 90, 85
+	stat := func(value int) *int { return &value }
+	spirit := models.Spirit{
+		ID:       &id,
+		Strength: stat(50), Arcana: stat(50), Toughness: stat(50), Aura: stat(50),
+		Agility: stat(50), Luck: stat(50), HitPoints: stat(100),
+		Moves: []*models.Move{
+			{ID: &strikeId, Category: &physical, Power: &power, Accuracy: &accuracy},
+			{ID: &healId, Category: &status, Power: stat(0)},
+			{ID: &oldId},
+		},
+	}
+
+	combatant, err := NewCombatant(spirit)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Move{
+		{ID: "strike", Power: 90, Category: models.MoveCategoryPhysical, Accuracy: 85},
+		{ID: "old", Power: DefaultMovePower},
+	}, combatant.Moves)
+}
+
+// Spirits store types as the spirit data model returned them, which may
+// differ from the type chart's names in case or spacing.
+func TestNewCombatant_CanonicalizesTypes(t *testing.T) {
+	id, moveId, primaryType, secondaryType, moveType := "spirit_1", "move_1", "flame", "stone ", " WAVE"
+	stat := func(value int) *int { return &value }
+	spirit := models.Spirit{
+		ID: &id, PrimaryType: &primaryType, SecondaryType: &secondaryType,
+		Strength: stat(50), Arcana: stat(50), Toughness: stat(50), Aura: stat(50),
+		Agility: stat(50), Luck: stat(50), HitPoints: stat(100),
+		Moves: []*models.Move{{ID: &moveId, Type: &moveType}},
+	}
+
+	combatant, err := NewCombatant(spirit)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Flame", combatant.PrimaryType)
+	assert.Equal(t, "Stone", combatant.SecondaryType)
+	assert.Equal(t, "Wave", combatant.Moves[0].Type)
+	attacker := newTestCombatant("attacker")
+	random := rng{state: 7}
+	result := resolveAttack(&attacker, &combatant, combatant.Moves[0], &random)
+	assert.Equal(t, 4.0, result.effectiveness)
+}
+
+func TestResolveAttack_UsesCategory(t *testing.T) {
+	averageDamage := func(attacker, target Combatant, category string) int {
+		random := rng{state: 7}
+		total := 0
+		for i := 0; i < 1000; i++ {
+			total += resolveAttack(&attacker, &target, Move{Power: DefaultMovePower, Category: category}, &random).damage
+		}
+		return total / 1000
+	}
+	arcane := newTestCombatant("arcane")
+	arcane.Stats.Arcana = 100
+	base := newTestCombatant("base")
+
+	// Physical moves attack with strength even when arcana is higher.
+	assert.Equal(t, averageDamage(base, base, ""), averageDamage(arcane, base, models.MoveCategoryPhysical))
+	assert.Equal(t, averageDamage(arcane, base, ""), averageDamage(arcane, base, models.MoveCategoryArcane))
+	assert.Greater(t, averageDamage(arcane, base, models.MoveCategoryArcane), averageDamage(arcane, base, models.MoveCategoryPhysical))
+}
+
+func TestResolveAttack_DodgesAndCriticalHits(t *testing.T) {
+	count := func(attacker, target Combatant) (dodged, critical int) {
+		random := rng{state: 7}
+		for i := 0; i < 1000; i++ {
+			result := resolveAttack(&attacker, &target, Move{Power: DefaultMovePower}, &random)
+			if result.dodged {
+				dodged++
+			}
+			if result.critical {
+				critical++
+			}
+		}
+		return dodged, critical
+	}
+	base := newTestCombatant("base")
+	base.Stats.Luck = 0
+	lucky := newTestCombatant("lucky")
+	lucky.Stats.Luck = 250
+	agile := newTestCombatant("agile")
+	agile.Stats.Agility = 150
+
+	dodged, critical := count(base, base)
+	assert.Equal(t, 0, dodged)
+	assert.Equal(t, 0, critical)
+	_, critical = count(lucky, base)
+	assert.InDelta(t, 500, critical, 60)
+	dodged, _ = count(base, agile)
+	assert.InDelta(t, 300, dodged, 60)
+}
+
+func TestResolveAttack_Accuracy(t *testing.T) {
+	misses := func(accuracy int) int {
+		attacker, target := newTestCombatant("attacker"), newTestCombatant("target")
+		random := rng{state: 7}
+		missed := 0
+		for i := 0; i < 1000; i++ {
+			if resolveAttack(&attacker, &target, Move{Power: DefaultMovePower, Accuracy: accuracy}, &random).missed {
+				missed++
+			}
+		}
+		return missed
+	}
+
+	assert.InDelta(t, 300, misses(70), 60)
+	assert.Equal(t, 0, misses(100))
+	// Moves stored without an accuracy always hit and draw the same numbers
+	// as moves that always hit.
+	assert.Equal(t, 0, misses(0))
+	attacker, target := newTestCombatant("attacker"), newTestCombatant("target")
+	legacy, exact := rng{state: 7}, rng{state: 7}
+	assert.Equal(t,
+		resolveAttack(&attacker, &target, Move{Power: DefaultMovePower, Accuracy: 100}, &exact),
+		resolveAttack(&attacker, &target, Move{Power: DefaultMovePower}, &legacy))
+	assert.Equal(t, exact, legacy)
+}
+
+func TestApply_Dodge(t *testing.T) {
+	playerOne, playerTwo := newTestTeam("a", 1), newTestTeam("b", 1)
+	playerTwo[0].Stats.Agility = 150
+	dodges := 0
+	for seed := int64(0); seed < 50; seed++ {
+		state, err := NewBattle(playerOne, playerTwo, seed)
+		assert.NoError(t, err)
+
+		_, events, err := Apply(state, attack(PlayerOne, Frontline, Frontline))
+
+		assert.NoError(t, err)
+		assert.Contains(t, []EventType{EventDamage, EventDodge}, events[0].Type)
+		if events[0].Type == EventDodge {
+			dodges++
+		}
+	}
+	assert.NotZero(t, dodges)
+}
+
+func TestApply_Miss(t *testing.T) {
+	playerOne, playerTwo := newTestTeam("a", 1), newTestTeam("b", 1)
+	playerOne[0].Moves[0].Accuracy = 1
+	state, err := NewBattle(playerOne, playerTwo, 42)
+	assert.NoError(t, err)
+
+	next, events, err := Apply(state, attack(PlayerOne, Frontline, Frontline))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Event{{Type: EventMiss, Position: Position{Side: PlayerTwo, Slot: Frontline}, MoveID: "move_1"}}, events)
+	assert.Equal(t, state.At(Position{Side: PlayerTwo, Slot: Frontline}).HitPoints, next.At(Position{Side: PlayerTwo, Slot: Frontline}).HitPoints)
+}
+
+func TestResolveAttack_AppliesTypeEffectiveness(t *testing.T) {
+	damage := func(move Move, primaryType, secondaryType string) attackResult {
+		attacker := newTestCombatant("attacker")
+		attacker.Stats.Luck = 0
+		target := newTestCombatant("target")
+		target.PrimaryType, target.SecondaryType = primaryType, secondaryType
+		random := rng{state: 7}
+		return resolveAttack(&attacker, &target, move, &random)
+	}
+	wave := Move{Type: "Wave", Power: DefaultMovePower}
+	neutral := damage(wave, "Sky", "None")
+
+	superEffective := damage(wave, "Flame", "None")
+	assert.Equal(t, 2.0, superEffective.effectiveness)
+	assert.Equal(t, neutral.damage*2, superEffective.damage)
+	doubled := damage(wave, "Flame", "Stone")
+	assert.Equal(t, 4.0, doubled.effectiveness)
+	assert.Equal(t, neutral.damage*4, doubled.damage)
+	resisted := damage(wave, "Wave", "None")
+	assert.Equal(t, 0.5, resisted.effectiveness)
+	assert.Equal(t, neutral.damage/2, resisted.damage)
+	// The basic strike has no type.
+	assert.Equal(t, 1.0, damage(Move{Power: DefaultMovePower}, "Flame", "None").effectiveness)
+
+	immune := damage(Move{Type: "Spark", Power: DefaultMovePower}, "Stone", "None")
+	assert.Equal(t, attackResult{}, immune)
+}
+
+func TestApply_Immune(t *testing.T) {
+	playerOne, playerTwo := newTestTeam("a", 1), newTestTeam("b", 1)
+	playerOne[0].Moves[0].Type = "Spark"
+	playerTwo[0].PrimaryType = "Stone"
+	state, err := NewBattle(playerOne, playerTwo, 42)
+	assert.NoError(t, err)
+
+	next, events, err := Apply(state, attack(PlayerOne, Frontline, Frontline))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Event{{Type: EventImmune, Position: Position{Side: PlayerTwo, Slot: Frontline}, MoveID: "move_1"}}, events)
+	assert.Equal(t, 100, next.At(Position{Side: PlayerTwo, Slot: Frontline}).HitPoints)
+	// Immunity draws nothing from the generator.
+	assert.Equal(t, state.RNGState, next.RNGState)
+	assert.Equal(t, PlayerTwo, next.Turn)
+}
+
+func TestApply_Rotate(t *testing.T) {
+	state := newTestBattle(t)
+
+	next, events, err := Apply(state, Action{Type: ActionRotate, Player: PlayerOne})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Event{{Type: EventRotate, Position: Position{Side: PlayerOne}}}, events)
+	assert.Equal(t, "a1", next.At(Position{Side: PlayerOne, Slot: Frontline}).SpiritID)
+	assert.Equal(t, "a2", next.At(Position{Side: PlayerOne, Slot: MiddleLeft}).SpiritID)
+	assert.Equal(t, "a0", next.At(Position{Side: PlayerOne, Slot: MiddleRight}).SpiritID)
+	assert.Equal(t, "b0", next.At(Position{Side: PlayerTwo, Slot: Frontline}).SpiritID)
+	assert.Equal(t, PlayerTwo, next.Turn)
+}
+
+func TestApply_Swap(t *testing.T) {
+	state := newTestBattle(t)
+	state.Positions[PlayerOne][Frontline].HitPoints = 0
+
+	next, events, err := Apply(state, Action{Type: ActionSwap, Player: PlayerOne, Swap: &SwapFields{SwapInSlot: BenchCenter, SwapOutSlot: Frontline}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Event{{Type: EventSwap, Position: Position{Side: PlayerOne, Slot: Frontline}}}, events)
+	assert.Equal(t, "a4", next.At(Position{Side: PlayerOne, Slot: Frontline}).SpiritID)
+	assert.Equal(t, "a0", next.At(Position{Side: PlayerOne, Slot: BenchCenter}).SpiritID)
+	assert.Equal(t, PlayerTwo, next.Turn)
+}
+
+func TestApply_InvalidActions(t *testing.T) {
+	state := newTestBattle(t)
+	state.Positions[PlayerOne][MiddleRight].HitPoints = 0
+	state.Positions[PlayerOne][BenchRight].HitPoints = 0
+	state.Positions[PlayerTwo][MiddleRight].HitPoints = 0
+	state.Positions[PlayerTwo][BenchLeft] = nil
+	over := newTestBattle(t)
+	winner := PlayerTwo
+	over.Winner = &winner
+
+	tests := []struct {
+		name    string
+		state   State
+		action  Action
+		wantErr error
+	}{
+		{name: "Battle is over", state: over, action: attack(PlayerOne, Frontline, Frontline), wantErr: ErrBattleOver},
+		{name: "Not your turn", state: state, action: attack(PlayerTwo, Frontline, Frontline), wantErr: ErrNotYourTurn},
+		{name: "Unknown player", state: state, action: Action{Type: ActionRotate, Player: Side(2)}, wantErr: ErrInvalidAction},
+		{name: "Unknown action", state: state, action: Action{Type: "Dance", Player: PlayerOne}, wantErr: ErrInvalidAction},
+		{name: "Move without fields", state: state, action: Action{Type: ActionMove, Player: PlayerOne}, wantErr: ErrInvalidAction},
+		{name: "Attack from the bench", state: state, action: attack(PlayerOne, BenchLeft, Frontline), wantErr: ErrInvalidAction},
+		{name: "Attack the bench", state: state, action: attack(PlayerOne, Frontline, BenchCenter), wantErr: ErrInvalidAction},
+		{name: "Fainted attacker", state: state, action: attack(PlayerOne, MiddleRight, Frontline), wantErr: ErrInvalidAction},
+		{name: "Fainted target", state: state, action: attack(PlayerOne, Frontline, MiddleRight), wantErr: ErrInvalidAction},
+		{name: "Invalid slot", state: state, action: attack(PlayerOne, Slot(9), Frontline), wantErr: ErrInvalidAction},
+		{
+			name:    "Unknown move",
+			state:   state,
+			action:  Action{Type: ActionMove, Player: PlayerOne, Move: &MoveFields{AttackerSlot: Frontline, TargetSlot: Frontline, MoveID: "move_2"}},
+			wantErr: ErrInvalidAction,
+		},
+		{name: "Swap without fields", state: state, action: Action{Type: ActionSwap, Player: PlayerOne}, wantErr: ErrInvalidAction},
+		{
+			name:    "Swap in from the arena",
+			state:   state,
+			action:  Action{Type: ActionSwap, Player: PlayerOne, Swap: &SwapFields{SwapInSlot: MiddleLeft, SwapOutSlot: Frontline}},
+			wantErr: ErrInvalidAction,
+		},
+		{
+			name:    "Swap out to the bench",
+			state:   state,
+			action:  Action{Type: ActionSwap, Player: PlayerOne, Swap: &SwapFields{SwapInSlot: BenchLeft, SwapOutSlot: BenchCenter}},
+			wantErr: ErrInvalidAction,
+		},
+		{
+			name:    "Swap in a fainted spirit",
+			state:   state,
+			action:  Action{Type: ActionSwap, Player: PlayerOne, Swap: &SwapFields{SwapInSlot: BenchRight, SwapOutSlot: Frontline}},
+			wantErr: ErrInvalidAction,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Apply(tt.state, tt.action)
+
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestApply_Surrender(t *testing.T) {
+	state := newTestBattle(t)
+
+	// Players may surrender during the other player's turn.
+	next, events, err := Apply(state, Action{Type: ActionSurrender, Player: PlayerTwo})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Event{
+		{Type: EventSurrender, Position: Position{Side: PlayerTwo}},
+		{Type: EventWin, Position: Position{Side: PlayerOne}},
+	}, events)
+	assert.Equal(t, PlayerOne, *next.Winner)
+	assert.Nil(t, state.Winner)
+	_, _, err = Apply(next, Action{Type: ActionRotate, Player: PlayerOne})
+	assert.ErrorIs(t, err, ErrBattleOver)
+}
+
+func TestApply_WinsWhenEveryOpposingSpiritFaints(t *testing.T) {
+	state, err := NewBattle(newTestTeam("a", 1), newTestTeam("b", 2), 42)
+	assert.NoError(t, err)
+	state.Positions[PlayerTwo][MiddleLeft].HitPoints = 0
+	state.Positions[PlayerTwo][Frontline].HitPoints = 1
+
+	next, events, err := Apply(state, attack(PlayerOne, Frontline, Frontline))
+
+	assert.NoError(t, err)
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, EventDamage, events[0].Type)
+		assert.Equal(t, Event{Type: EventFaint, Position: Position{Side: PlayerTwo, Slot: Frontline}}, events[1])
+		assert.Equal(t, Event{Type: EventWin, Position: Position{Side: PlayerOne}}, events[2])
+	}
+	assert.Equal(t, 0, next.At(Position{Side: PlayerTwo, Slot: Frontline}).HitPoints)
+	assert.Equal(t, PlayerOne, *next.Winner)
+}
+
+func TestAction_JSON(t *testing.T) {
+	action := attack(PlayerTwo, MiddleLeft, Frontline)
+
+	encoded, err := json.Marshal(action)
+	assert.NoError(t, err)
+	var decoded Action
+	assert.NoError(t, json.Unmarshal(encoded, &decoded))
+
+	assert.JSONEq(t, `{"type":"Move","player":"PlayerTwo","move":{"attackerSlot":"Middle-Left","targetSlot":"Frontline-Center","moveId":"move_1"}}`, string(encoded))
+	assert.Equal(t, action, decoded)
+	assert.Error(t, json.Unmarshal([]byte(`{"type":"Move","player":"PlayerThree"}`), &decoded))
+	assert.Error(t, json.Unmarshal([]byte(`{"type":"Swap","swap":{"swapInSlot":"Bench-Middle"}}`), &decoded))
+}
@@ -0,0 +1,229 @@
+package battle_engine
+
+import (
+	"fmt"
+
+	"spirit-snap/server/logic/spirit_types"
+	"spirit-snap/server/models"
+)
+
+// DefaultMovePower is the power of moves stored without one, and of the
+// basic strike every spirit can attack with.
+const DefaultMovePower = 40
+
+// Stats are the battle stats of a spirit.
+type Stats struct {
+	Strength  int `json:"strength"`
+	Arcana    int `json:"arcana"`
+	Toughness int `json:"toughness"`
+	Aura      int `json:"aura"`
+	Agility   int `json:"agility"`
+	Luck      int `json:"luck"`
+	HitPoints int `json:"hitPoints"`
+}
+
+// Move is an attack a combatant can use.
+type Move struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Power int    `json:"power"`
+	// models.MoveCategoryPhysical or models.MoveCategoryArcane. Empty for
+	// moves stored without a category.
+	Category string `json:"category,omitempty"`
+	// Percent chance of the move hitting. 0 for moves stored without an
+	// accuracy, which always hit.
+	Accuracy int `json:"accuracy,omitempty"`
+}
+
+// Combatant is a spirit taking part in a battle.
+type Combatant struct {
+	SpiritID      string `json:"spiritId"`
+	Name          string `json:"name"`
+	PrimaryType   string `json:"primaryType"`
+	SecondaryType string `json:"secondaryType"`
+	Stats         Stats  `json:"stats"`
+	Moves         []Move `json:"moves"`
+	// Remaining hit points, from Stats.HitPoints down to 0.
+	HitPoints int `json:"currentHitPoints"`
+}
+
+// NewCombatant creates a combatant at full health from a spirit. Spirits
+// missing any battle stat cannot battle. Moves keep their power, category and
+// accuracy. Status moves are left out, since battles do not apply move
+// effects yet. Type names are stored as the spirit data model returned them,
+// so the types of the spirit and its moves are canonicalized with
+// spirit_types.Parse, and names that are not types are kept as they are.
+func NewCombatant(spirit models.Spirit) (Combatant, error) {
+	if spirit.ID == nil {
+		return Combatant{}, fmt.Errorf("spirit has no ID")
+	}
+	stats := map[string]*int{
+		"strength":  spirit.Strength,
+		"arcana":    spirit.Arcana,
+		"toughness": spirit.Toughness,
+		"aura":      spirit.Aura,
+		"agility":   spirit.Agility,
+		"luck":      spirit.Luck,
+		"hitPoints": spirit.HitPoints,
+	}
+	for name, value := range stats {
+		if value == nil {
+			return Combatant{}, fmt.Errorf("spirit %s has no %s", *spirit.ID, name)
+		}
+		if *value < 0 {
+			return Combatant{}, fmt.Errorf("spirit %s has negative %s", *spirit.ID, name)
+		}
+	}
+	if *spirit.HitPoints == 0 {
+		return Combatant{}, fmt.Errorf("spirit %s has no hit points", *spirit.ID)
+	}
+
+	combatant := Combatant{
+		SpiritID:      *spirit.ID,
+		Name:          valueOrEmpty(spirit.Name),
+		PrimaryType:   canonicalType(spirit.PrimaryType),
+		SecondaryType: canonicalType(spirit.SecondaryType),
+		Stats: Stats{
+			Strength:  *spirit.Strength,
+			Arcana:    *spirit.Arcana,
+			Toughness: *spirit.Toughness,
+			Aura:      *spirit.Aura,
+			Agility:   *spirit.Agility,
+			Luck:      *spirit.Luck,
+			HitPoints: *spirit.HitPoints,
+		},
+		HitPoints: *spirit.HitPoints,
+	}
+	for _, move := range spirit.Moves {
+		if move == nil || move.ID == nil {
+			continue
+		}
+		category := valueOrEmpty(move.Category)
+		if category == models.MoveCategoryStatus {
+			continue
+		}
+		power := DefaultMovePower
+		if move.Power != nil && *move.Power > 0 {
+			power = *move.Power
+		}
+		accuracy := 0
+		if move.Accuracy != nil {
+			accuracy = *move.Accuracy
+		}
+		combatant.Moves = append(combatant.Moves, Move{
+			ID:       *move.ID,
+			Name:     valueOrEmpty(move.Name),
+			Type:     canonicalType(move.Type),
+			Power:    power,
+			Category: category,
+			Accuracy: accuracy,
+		})
+	}
+	return combatant, nil
+}
+
+// Fainted reports whether the combatant has no hit points left.
+func (c *Combatant) Fainted() bool {
+	return c.HitPoints <= 0
+}
+
+// Returns the move with the given ID. An empty ID is the basic strike, which
+// every combatant can use.
+func (c *Combatant) move(moveId string) (Move, bool) {
+	if moveId == "" {
+		return Move{Name: "Strike", Power: DefaultMovePower}, true
+	}
+	for _, move := range c.Moves {
+		if move.ID == moveId {
+			return move, true
+		}
+	}
+	return Move{}, false
+}
+
+// Outcome of one attack.
+type attackResult struct {
+	damage   int
+	critical bool
+	missed   bool
+	dodged   bool
+	// Multiplier of the damage for the move's type against the target's
+	// types. The target is immune when it is 0.
+	effectiveness float64
+}
+
+// Works out the result of attacker using move on target, drawing from rng.
+//
+// Physical moves attack with strength against the target's toughness and
+// arcane moves with arcana against its aura. Moves without a category, and
+// the basic strike, attack with the higher of strength and arcana. The
+// damage is
+//
+//	power * attack / defense / 2 + 2
+//
+// scaled by a random 85-100% and by the effectiveness of the move's type
+// against the target's types, from spirit_types. A move with an accuracy
+// below 100 misses with the remaining chance; moves without an accuracy
+// always hit and draw nothing for it, so battles stored before moves had one
+// replay the same. The target dodges with a chance of half the amount its
+// agility exceeds the attacker's, up to 30%.
+// Otherwise the attack is critical, for 1.5 times the damage, with a chance
+// of a fifth of the attacker's luck, up to 50%. Attacks that land deal at
+// least 1 damage unless the target is immune, which is decided before
+// anything is drawn from rng.
+func resolveAttack(attacker, target *Combatant, move Move, rng *rng) attackResult {
+	effectiveness := spirit_types.Multiplier(spirit_types.Type(move.Type), spirit_types.Type(target.PrimaryType), spirit_types.Type(target.SecondaryType))
+	if effectiveness == spirit_types.Immune {
+		return attackResult{effectiveness: effectiveness}
+	}
+
+	arcane := attacker.Stats.Arcana > attacker.Stats.Strength
+	switch move.Category {
+	case models.MoveCategoryPhysical:
+		arcane = false
+	case models.MoveCategoryArcane:
+		arcane = true
+	}
+	attack, defense := attacker.Stats.Strength, target.Stats.Toughness
+	if arcane {
+		attack, defense = attacker.Stats.Arcana, target.Stats.Aura
+	}
+	defense = max(defense, 1)
+
+	if move.Accuracy > 0 && move.Accuracy < 100 && rng.intn(100) >= move.Accuracy {
+		return attackResult{missed: true, effectiveness: effectiveness}
+	}
+	dodgeChance := min(max((target.Stats.Agility-attacker.Stats.Agility)/2, 0), 30)
+	if rng.intn(100) < dodgeChance {
+		return attackResult{dodged: true, effectiveness: effectiveness}
+	}
+
+	damage := move.Power*attack/defense/2 + 2
+	damage = damage * (85 + rng.intn(16)) / 100
+	// Multipliers are powers of two, so this is exact.
+	damage = int(float64(damage) * effectiveness)
+	result := attackResult{effectiveness: effectiveness}
+	if rng.intn(100) < min(attacker.Stats.Luck/5, 50) {
+		result.critical = true
+		damage = damage * 3 / 2
+	}
+	result.damage = max(damage, 1)
+	return result
+}
+
+// Returns the canonical name of a type, e.g. "Flame" for "flame ", so that
+// the type chart applies to it.
+func canonicalType(name *string) string {
+	if t, ok := spirit_types.Parse(valueOrEmpty(name)); ok {
+		return string(t)
+	}
+	return valueOrEmpty(name)
+}
+
+func valueOrEmpty(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
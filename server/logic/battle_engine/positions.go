@@ -0,0 +1,122 @@
+package battle_engine
+
+import "fmt"
+
+// Side is one of the two players of a battle.
+type Side int
+
+const (
+	PlayerOne Side = iota
+	PlayerTwo
+)
+
+// Opponent returns the other side.
+func (s Side) Opponent() Side {
+	return 1 - s
+}
+
+func (s Side) String() string {
+	switch s {
+	case PlayerOne:
+		return "PlayerOne"
+	case PlayerTwo:
+		return "PlayerTwo"
+	}
+	return fmt.Sprintf("Side(%d)", int(s))
+}
+
+func (s Side) MarshalText() ([]byte, error) {
+	if s != PlayerOne && s != PlayerTwo {
+		return nil, fmt.Errorf("invalid side %d", int(s))
+	}
+	return []byte(s.String()), nil
+}
+
+func (s *Side) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "PlayerOne":
+		*s = PlayerOne
+	case "PlayerTwo":
+		*s = PlayerTwo
+	default:
+		return fmt.Errorf("unknown side %q", text)
+	}
+	return nil
+}
+
+// Slot is one of the six places a side's spirits stand in. The frontline and
+// middle slots are in the arena, where spirits can attack and be attacked.
+// Spirits on the bench wait to be swapped in.
+type Slot int
+
+const (
+	Frontline Slot = iota
+	MiddleLeft
+	MiddleRight
+	BenchLeft
+	BenchCenter
+	BenchRight
+
+	// Number of slots on each side.
+	SlotCount = 6
+)
+
+// Names of the slots, matching the positions of the client with their
+// Top- or Bottom- prefix removed.
+var slotNames = [SlotCount]string{
+	Frontline:   "Frontline-Center",
+	MiddleLeft:  "Middle-Left",
+	MiddleRight: "Middle-Right",
+	BenchLeft:   "Bench-Left",
+	BenchCenter: "Bench-Center",
+	BenchRight:  "Bench-Right",
+}
+
+// InArena reports whether spirits in the slot can attack and be attacked.
+func (s Slot) InArena() bool {
+	return s == Frontline || s == MiddleLeft || s == MiddleRight
+}
+
+// OnBench reports whether the slot is one of the bench slots.
+func (s Slot) OnBench() bool {
+	return s == BenchLeft || s == BenchCenter || s == BenchRight
+}
+
+func (s Slot) valid() bool {
+	return s >= 0 && s < SlotCount
+}
+
+func (s Slot) String() string {
+	if !s.valid() {
+		return fmt.Sprintf("Slot(%d)", int(s))
+	}
+	return slotNames[s]
+}
+
+func (s Slot) MarshalText() ([]byte, error) {
+	if !s.valid() {
+		return nil, fmt.Errorf("invalid slot %d", int(s))
+	}
+	return []byte(slotNames[s]), nil
+}
+
+func (s *Slot) UnmarshalText(text []byte) error {
+	for slot, name := range slotNames {
+		if name == string(text) {
+			*s = Slot(slot)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown slot %q", text)
+}
+
+// Position is a slot on one side of the arena. Together the two sides have
+// 12 positions.
+type Position struct {
+	Side Side `json:"side"`
+	Slot Slot `json:"slot"`
+}
+
+func (p Position) String() string {
+	return p.Side.String() + "-" + p.Slot.String()
+}
@@ -0,0 +1,22 @@
+package battle_engine
+
+// rng is a SplitMix64 generator. Its state is a single number that is kept in
+// State, so applying an action is a pure function of the state and the
+// action, and a battle replays identically from the same seed.
+type rng struct {
+	state uint64
+}
+
+func (r *rng) next() uint64 {
+	r.state += 0x9e3779b97f4a7c15
+	z := r.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// Returns a number in [0, n). The modulo bias is negligible for the small n
+// the engine uses.
+func (r *rng) intn(n int) int {
+	return int(r.next() % uint64(n))
+}
@@ -0,0 +1,779 @@
+// Stores battles between players and runs their actions through the battle
+// engine, so that the server decides every outcome.
+//
+// Each battle is a document in the top level "battles" collection and the
+// actions taken in it are an append-only log in its "actions" subcollection.
+// The state of a battle is never stored: it is rebuilt by replaying the log
+// from the starting teams and seed, which also makes every battle
+// reproducible.
+package battle_manager
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"spirit-snap/server/logic/battle_engine"
+	"spirit-snap/server/models"
+	"spirit-snap/server/wrappers/datastore"
+)
+
+const battlesCollection = "battles"
+
+// Number of log entries read per query when replaying a battle.
+const actionBatchSize = 500
+
+// Status is whether a battle is still being played.
+type Status string
+
+const (
+	// Waiting for player two to accept the battle with their team.
+	StatusInvited  Status = "invited"
+	StatusActive   Status = "active"
+	StatusFinished Status = "finished"
+	// Player two declined the battle, or player one withdrew it, before it
+	// started.
+	StatusDeclined Status = "declined"
+)
+
+var (
+	// ErrNotFound is returned when a battle does not exist or the user is not
+	// one of its players.
+	ErrNotFound = errors.New("battle not found")
+	// ErrInvalidBattle is returned when a battle cannot be created from the
+	// requested teams.
+	ErrInvalidBattle = errors.New("invalid battle")
+	// ErrConflict is returned when another action was logged after the state
+	// the submitted action was checked against. The client should fetch the
+	// battle again.
+	ErrConflict = errors.New("battle was changed by another action")
+	// ErrNotInvited is returned when accepting or declining a battle that is
+	// not waiting for the user to accept it.
+	ErrNotInvited = errors.New("battle is not waiting to be accepted")
+	// ErrNotStarted is returned when playing a battle that was never
+	// accepted.
+	ErrNotStarted = errors.New("battle has not started")
+)
+
+type DatastoreInterface interface {
+	AddDocument(ctx context.Context, collectionName string, data interface{}) (string, error)
+	CreateDocument(ctx context.Context, collectionName string, id string, data interface{}) error
+	GetDocument(ctx context.Context, collectionName string, id string) (map[string]interface{}, error)
+	GetDocumentsByIds(ctx context.Context, collectionName string, ids []string) ([]map[string]interface{}, error)
+	UpdateDocument(ctx context.Context, collectionName string, id string, updates map[string]interface{}) error
+	UpdateDocumentIf(ctx context.Context, collectionName string, id string, field string, expected interface{}, updates map[string]interface{}) error
+	RunQuery(ctx context.Context, query datastore.Query) (*datastore.PageResult, error)
+}
+
+// Battle is a battle as reported to clients.
+type Battle struct {
+	ID              string `json:"id"`
+	PlayerOneUserID string `json:"playerOneUserId"`
+	PlayerTwoUserID string `json:"playerTwoUserId"`
+	PlayerOneTeamID string `json:"playerOneTeamId"`
+	PlayerTwoTeamID string `json:"playerTwoTeamId"`
+	Status          Status `json:"status"`
+	// User whose turn it is. Nil unless the battle is active.
+	CurrentTurnUserID *string `json:"currentTurnUserId"`
+	// Nil until the battle is finished.
+	WinnerUserID *string `json:"winnerUserId"`
+	// Number of actions in the log.
+	ActionCount int       `json:"actionCount"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	// Positions and hit points of the spirits. Not set in listings, nor
+	// before the battle starts.
+	State *battle_engine.State `json:"state,omitempty"`
+}
+
+// LoggedAction is an entry of a battle's action log.
+type LoggedAction struct {
+	// Position in the log, starting at 1.
+	Sequence  int                  `json:"sequence"`
+	UserID    string               `json:"userId"`
+	Action    battle_engine.Action `json:"action"`
+	CreatedAt time.Time            `json:"createdAt"`
+}
+
+// Update is an entry of a battle's log together with its outcome, for showing
+// players what happened since they last looked.
+type Update struct {
+	LoggedAction
+	// What the action caused, in order.
+	Events []battle_engine.Event `json:"events"`
+	// The battle right after the action.
+	Battle Battle `json:"battle"`
+}
+
+// TeamRequest is a team of spirits from its owner's collection.
+type TeamRequest struct {
+	// ID of the team on the client.
+	ID string `json:"id"`
+	// Spirits in the order they are placed: frontline, middle left, middle
+	// right, then the bench.
+	SpiritIDs []string `json:"spiritIds"`
+}
+
+// CreateRequest describes a new battle. The user creating it is player one.
+type CreateRequest struct {
+	PlayerOneTeam TeamRequest `json:"playerOneTeam"`
+	// The opponent. Empty for a battle against oneself, where the creating
+	// user plays both sides.
+	PlayerTwoUserID string `json:"playerTwoUserId"`
+	// Only for a battle against oneself. An opponent picks their own team
+	// when accepting the battle.
+	PlayerTwoTeam TeamRequest `json:"playerTwoTeam"`
+}
+
+// BattlePage is a page of a user's battles.
+type BattlePage struct {
+	Battles []Battle
+	// Cursor values of the last battle on the page. Pass them back as
+	// startAfter to fetch the next page.
+	LastCursor []interface{}
+	HasMore    bool
+}
+
+type BattleManager struct {
+	DatastoreClient DatastoreInterface
+
+	// Returns the current time. Replaced in tests.
+	now func() time.Time
+	// Returns the seed of a new battle. Replaced in tests.
+	newSeed func() (int64, error)
+
+	mu sync.Mutex
+	// Channels of the subscribers to each battle, by battle ID.
+	subscribers map[string]map[chan struct{}]struct{}
+}
+
+func NewBattleManager(ds DatastoreInterface) *BattleManager {
+	return &BattleManager{
+		DatastoreClient: ds,
+		now:             func() time.Time { return time.Now().UTC() },
+		newSeed:         randomSeed,
+		subscribers:     make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+func randomSeed() (int64, error) {
+	var seed [8]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(seed[:])), nil
+}
+
+// Create starts a battle against oneself, or invites another user to a
+// battle that starts once they accept it with a team of their own. Spirits
+// are only ever read from the collection of the user who picked them, so
+// their stats cannot be made up by the client and other users' spirits
+// cannot be looked at.
+//
+// Returns:
+//   - The new battle. It has a state if it is against oneself.
+//   - ErrInvalidBattle if a team is empty, too large, repeats a spirit or
+//     has a spirit that is missing or cannot battle, or if a team is picked
+//     for an opponent. Another error if the battle cannot be stored.
+func (bm *BattleManager) Create(userId *string, request CreateRequest) (Battle, error) {
+	ctx := context.Background()
+	playerTwoUserId := request.PlayerTwoUserID
+	if playerTwoUserId == "" {
+		playerTwoUserId = *userId
+	}
+	if strings.Contains(playerTwoUserId, "/") {
+		return Battle{}, fmt.Errorf("%w: invalid user ID %q", ErrInvalidBattle, playerTwoUserId)
+	}
+	invite := playerTwoUserId != *userId
+	if invite && (request.PlayerTwoTeam.ID != "" || len(request.PlayerTwoTeam.SpiritIDs) > 0) {
+		return Battle{}, fmt.Errorf("%w: the opponent picks their own team when accepting the battle", ErrInvalidBattle)
+	}
+	playerOneTeam, err := bm.loadTeam(ctx, *userId, request.PlayerOneTeam)
+	if err != nil {
+		return Battle{}, err
+	}
+
+	now := bm.now().Format(timestampFormat)
+	doc := battleDocument{
+		PlayerUserIDs:   []string{*userId, playerTwoUserId},
+		PlayerOneUserID: *userId,
+		PlayerTwoUserID: playerTwoUserId,
+		PlayerOneTeamID: request.PlayerOneTeam.ID,
+		Status:          string(StatusInvited),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	for _, combatant := range playerOneTeam {
+		doc.PlayerOneTeam = append(doc.PlayerOneTeam, newCombatantDocument(combatant))
+	}
+	if invite {
+		doc.ID, err = bm.DatastoreClient.AddDocument(ctx, battlesCollection, doc)
+		if err != nil {
+			return Battle{}, err
+		}
+		return doc.battle(), nil
+	}
+
+	playerTwoTeam, err := bm.loadTeam(ctx, *userId, request.PlayerTwoTeam)
+	if err != nil {
+		return Battle{}, err
+	}
+	state, err := bm.start(&doc, request.PlayerTwoTeam.ID, playerTwoTeam)
+	if err != nil {
+		return Battle{}, err
+	}
+	doc.ID, err = bm.DatastoreClient.AddDocument(ctx, battlesCollection, doc)
+	if err != nil {
+		return Battle{}, err
+	}
+	battle := doc.battle()
+	battle.State = &state
+	return battle, nil
+}
+
+// Accept starts a battle the user was invited to, with a team from their own
+// collection.
+//
+// Returns:
+//   - The battle, with its state.
+//   - ErrNotFound if the battle does not exist or the user does not play in
+//     it. ErrNotInvited if the user is not the invited player or the battle
+//     was already accepted or declined. ErrInvalidBattle if the team cannot
+//     battle, as for Create.
+func (bm *BattleManager) Accept(userId *string, battleId string, team TeamRequest) (Battle, error) {
+	ctx := context.Background()
+	doc, err := bm.getDocument(ctx, *userId, battleId)
+	if err != nil {
+		return Battle{}, err
+	}
+	if doc.Status != string(StatusInvited) || doc.PlayerTwoUserID != *userId {
+		return Battle{}, ErrNotInvited
+	}
+	playerTwoTeam, err := bm.loadTeam(ctx, *userId, team)
+	if err != nil {
+		return Battle{}, err
+	}
+	state, err := bm.start(&doc, team.ID, playerTwoTeam)
+	if err != nil {
+		return Battle{}, err
+	}
+	doc.UpdatedAt = bm.now().Format(timestampFormat)
+
+	encoded, err := datastore.EncodeDocument(doc)
+	if err != nil {
+		return Battle{}, err
+	}
+	updates := doc.summaryUpdates()
+	for _, field := range []string{"playerTwoTeamId", "playerTwoTeam", "seed"} {
+		updates[field] = encoded[field]
+	}
+	// Only the first of two concurrent accepts starts the battle.
+	err = bm.DatastoreClient.UpdateDocumentIf(ctx, battlesCollection, battleId, "status", string(StatusInvited), updates)
+	if errors.Is(err, datastore.ErrConditionFailed) {
+		return Battle{}, ErrNotInvited
+	}
+	if err != nil {
+		return Battle{}, err
+	}
+	battle := doc.battle()
+	battle.State = &state
+	return battle, nil
+}
+
+// Decline ends a battle that is waiting to be accepted. Player two declines
+// the invitation and player one withdraws it.
+//
+// Returns:
+//   - The declined battle.
+//   - ErrNotFound if the battle does not exist or the user does not play in
+//     it. ErrNotInvited if it was already accepted or declined.
+func (bm *BattleManager) Decline(userId *string, battleId string) (Battle, error) {
+	ctx := context.Background()
+	doc, err := bm.getDocument(ctx, *userId, battleId)
+	if err != nil {
+		return Battle{}, err
+	}
+	if doc.Status != string(StatusInvited) {
+		return Battle{}, ErrNotInvited
+	}
+	doc.Status = string(StatusDeclined)
+	doc.UpdatedAt = bm.now().Format(timestampFormat)
+	err = bm.DatastoreClient.UpdateDocumentIf(ctx, battlesCollection, battleId, "status", string(StatusInvited), map[string]interface{}{
+		"status":    doc.Status,
+		"updatedAt": doc.UpdatedAt,
+	})
+	if errors.Is(err, datastore.ErrConditionFailed) {
+		return Battle{}, ErrNotInvited
+	}
+	if err != nil {
+		return Battle{}, err
+	}
+	return doc.battle(), nil
+}
+
+// Adds player two's team and a new seed to a battle and returns its starting
+// state.
+func (bm *BattleManager) start(doc *battleDocument, teamId string, playerTwoTeam []battle_engine.Combatant) (battle_engine.State, error) {
+	seed, err := bm.newSeed()
+	if err != nil {
+		return battle_engine.State{}, fmt.Errorf("failed to generate seed: %v", err)
+	}
+	var playerOneTeam []battle_engine.Combatant
+	for _, combatant := range doc.PlayerOneTeam {
+		playerOneTeam = append(playerOneTeam, combatant.combatant())
+	}
+	state, err := battle_engine.NewBattle(playerOneTeam, playerTwoTeam, seed)
+	if err != nil {
+		return battle_engine.State{}, fmt.Errorf("%w: %v", ErrInvalidBattle, err)
+	}
+	doc.PlayerTwoTeamID = teamId
+	doc.PlayerTwoTeam = nil
+	for _, combatant := range playerTwoTeam {
+		doc.PlayerTwoTeam = append(doc.PlayerTwoTeam, newCombatantDocument(combatant))
+	}
+	doc.Seed = seed
+	doc.setSummary(state, 0)
+	return state, nil
+}
+
+// Reads the spirits of a team from the owner's collection.
+func (bm *BattleManager) loadTeam(ctx context.Context, ownerId string, team TeamRequest) ([]battle_engine.Combatant, error) {
+	if len(team.SpiritIDs) == 0 || len(team.SpiritIDs) > battle_engine.SlotCount {
+		return nil, fmt.Errorf("%w: team %q must have from 1 to %d spirits", ErrInvalidBattle, team.ID, battle_engine.SlotCount)
+	}
+	for i, spiritId := range team.SpiritIDs {
+		if spiritId == "" || strings.Contains(spiritId, "/") || slices.Contains(team.SpiritIDs[:i], spiritId) {
+			return nil, fmt.Errorf("%w: team %q has an invalid or repeated spirit ID", ErrInvalidBattle, team.ID)
+		}
+	}
+
+	var combatants []battle_engine.Combatant
+	for _, spiritId := range team.SpiritIDs {
+		raw, err := bm.DatastoreClient.GetDocument(ctx, "users/"+ownerId+"/spirits", spiritId)
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil, fmt.Errorf("%w: spirit %s is not in your collection", ErrInvalidBattle, spiritId)
+		}
+		if err != nil {
+			return nil, err
+		}
+		spiritDoc := models.DecodeSpiritDocument(raw)
+		spirit := models.Spirit{
+			ID:            &spiritDoc.ID,
+			Name:          &spiritDoc.Name,
+			PrimaryType:   &spiritDoc.PrimaryType,
+			SecondaryType: &spiritDoc.SecondaryType,
+			Strength:      spiritDoc.Strength,
+			Arcana:        spiritDoc.Arcana,
+			Toughness:     spiritDoc.Toughness,
+			Aura:          spiritDoc.Aura,
+			Agility:       spiritDoc.Agility,
+			Luck:          spiritDoc.Luck,
+			HitPoints:     spiritDoc.HitPoints,
+		}
+		if len(spiritDoc.MoveIDs) > 0 {
+			// Like BuildSpirit, a spirit whose moves cannot be read battles
+			// with the basic strike only.
+			moveDocs, err := bm.DatastoreClient.GetDocumentsByIds(ctx, "moves", spiritDoc.MoveIDs)
+			if err != nil {
+				log.Printf("Error reading moves of spirit %s: %s", spiritId, err)
+			}
+			for _, moveDoc := range moveDocs {
+				spirit.Moves = append(spirit.Moves, models.BuildMovefromDocData(moveDoc))
+			}
+		}
+		combatant, err := battle_engine.NewCombatant(spirit)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidBattle, err)
+		}
+		combatants = append(combatants, combatant)
+	}
+	return combatants, nil
+}
+
+// Get returns one of the user's battles with its current state.
+func (bm *BattleManager) Get(userId *string, battleId string) (Battle, error) {
+	ctx := context.Background()
+	doc, err := bm.getDocument(ctx, *userId, battleId)
+	if err != nil {
+		return Battle{}, err
+	}
+	if !doc.started() {
+		return doc.battle(), nil
+	}
+	actions, err := bm.readLog(ctx, battleId, 0)
+	if err != nil {
+		return Battle{}, err
+	}
+	state, err := replay(doc, actions, nil)
+	if err != nil {
+		return Battle{}, err
+	}
+	// The summary can lag behind the log if it failed to update, so it is
+	// recomputed from the replayed state.
+	doc.setSummary(state, len(actions))
+	if len(actions) > 0 {
+		doc.UpdatedAt = actions[len(actions)-1].CreatedAt.Format(timestampFormat)
+	}
+	battle := doc.battle()
+	battle.State = &state
+	return battle, nil
+}
+
+// Updates returns the entries of a battle's log after the given sequence
+// number, in order, with the events each one caused and the battle after it.
+// Clients that were disconnected pass the last sequence number they saw to
+// catch up.
+//
+// Returns:
+//   - The battle with its current state.
+//   - The updates after the sequence number.
+//   - ErrNotFound if the battle does not exist or the user does not play in
+//     it. ErrNotStarted if it was never accepted.
+func (bm *BattleManager) Updates(userId *string, battleId string, after int) (Battle, []Update, error) {
+	ctx := context.Background()
+	doc, err := bm.getDocument(ctx, *userId, battleId)
+	if err != nil {
+		return Battle{}, nil, err
+	}
+	if !doc.started() {
+		return Battle{}, nil, ErrNotStarted
+	}
+	actions, err := bm.readLog(ctx, battleId, 0)
+	if err != nil {
+		return Battle{}, nil, err
+	}
+	updates := []Update{}
+	state, err := replay(doc, actions, func(logged LoggedAction, state battle_engine.State, events []battle_engine.Event) {
+		if logged.Sequence <= after {
+			return
+		}
+		doc.setSummary(state, logged.Sequence)
+		doc.UpdatedAt = logged.CreatedAt.Format(timestampFormat)
+		battle := doc.battle()
+		battle.State = &state
+		updates = append(updates, Update{LoggedAction: logged, Events: events, Battle: battle})
+	})
+	if err != nil {
+		return Battle{}, nil, err
+	}
+	doc.setSummary(state, len(actions))
+	if len(actions) > 0 {
+		doc.UpdatedAt = actions[len(actions)-1].CreatedAt.Format(timestampFormat)
+	}
+	battle := doc.battle()
+	battle.State = &state
+	return battle, updates, nil
+}
+
+// Subscribe returns a channel that receives a value whenever an action is
+// logged in a battle by this BattleManager. Notifications are coalesced, so
+// after receiving one, read every update since the last one seen. Actions
+// logged by other servers are not notified.
+//
+// Returns:
+//   - The channel.
+//   - A function that ends the subscription. It must be called once the
+//     channel is no longer read.
+func (bm *BattleManager) Subscribe(battleId string) (<-chan struct{}, func()) {
+	changed := make(chan struct{}, 1)
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	if bm.subscribers[battleId] == nil {
+		bm.subscribers[battleId] = make(map[chan struct{}]struct{})
+	}
+	bm.subscribers[battleId][changed] = struct{}{}
+	return changed, func() {
+		bm.mu.Lock()
+		defer bm.mu.Unlock()
+		delete(bm.subscribers[battleId], changed)
+		if len(bm.subscribers[battleId]) == 0 {
+			delete(bm.subscribers, battleId)
+		}
+	}
+}
+
+// Notifies the subscribers to a battle without waiting for them.
+func (bm *BattleManager) notify(battleId string) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	for changed := range bm.subscribers[battleId] {
+		select {
+		case changed <- struct{}{}:
+		default:
+			// A notification is already pending.
+		}
+	}
+}
+
+// Actions returns the entries of a battle's log after the given sequence
+// number, in order. Pass 0 for the whole log.
+func (bm *BattleManager) Actions(userId *string, battleId string, after int) ([]LoggedAction, error) {
+	ctx := context.Background()
+	if _, err := bm.getDocument(ctx, *userId, battleId); err != nil {
+		return nil, err
+	}
+	return bm.readLog(ctx, battleId, after)
+}
+
+// Submit validates an action against the current state and appends it to the
+// log. The user acts as the player whose turn it is, or as the side they
+// play when it is not their turn, which the engine only allows for Surrender.
+// Action.Player is ignored.
+//
+// Returns:
+//   - The battle with the state after the action.
+//   - The events the action caused.
+//   - ErrNotFound, ErrNotStarted, ErrConflict, or an error wrapping one of
+//     the errors of battle_engine if the action cannot be taken.
+func (bm *BattleManager) Submit(userId *string, battleId string, action battle_engine.Action) (Battle, []battle_engine.Event, error) {
+	ctx := context.Background()
+	doc, err := bm.getDocument(ctx, *userId, battleId)
+	if err != nil {
+		return Battle{}, nil, err
+	}
+	if !doc.started() {
+		return Battle{}, nil, ErrNotStarted
+	}
+	actions, err := bm.readLog(ctx, battleId, 0)
+	if err != nil {
+		return Battle{}, nil, err
+	}
+	state, err := replay(doc, actions, nil)
+	if err != nil {
+		return Battle{}, nil, err
+	}
+
+	action.Player = doc.sideOf(*userId, state.Turn)
+	next, events, err := battle_engine.Apply(state, action)
+	if err != nil {
+		return Battle{}, nil, err
+	}
+
+	sequence := len(actions) + 1
+	now := bm.now()
+	err = bm.DatastoreClient.CreateDocument(ctx, actionsCollection(battleId), actionDocumentID(sequence), newActionDocument(sequence, *userId, action, now))
+	if errors.Is(err, datastore.ErrAlreadyExists) {
+		return Battle{}, nil, ErrConflict
+	}
+	if err != nil {
+		return Battle{}, nil, err
+	}
+	bm.notify(battleId)
+
+	doc.setSummary(next, sequence)
+	doc.UpdatedAt = now.Format(timestampFormat)
+	// The action is already logged, so a failed update only leaves listings
+	// out of date until the next action.
+	if err := bm.DatastoreClient.UpdateDocument(ctx, battlesCollection, battleId, doc.summaryUpdates()); err != nil {
+		log.Printf("Error updating summary of battle %s: %s", battleId, err)
+	}
+	battle := doc.battle()
+	battle.State = &next
+	return battle, events, nil
+}
+
+// List returns a page of the user's battles, newest first. An empty status
+// lists battles of every status.
+func (bm *BattleManager) List(userId *string, status Status, limit int, startAfter []interface{}) (*BattlePage, error) {
+	query := datastore.NewQuery(battlesCollection).
+		Where("playerUserIds", datastore.ArrayContains, *userId).
+		OrderBy("createdAt", datastore.Desc).
+		OrderBy(datastore.DocumentID, datastore.Desc).
+		Limit(limit)
+	if status != "" {
+		query = query.Where("status", datastore.Equal, string(status))
+	}
+	if len(startAfter) > 0 {
+		query = query.StartAfter(startAfter...)
+	}
+	result, err := bm.DatastoreClient.RunQuery(context.Background(), query)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &BattlePage{Battles: []Battle{}, LastCursor: result.LastCursor, HasMore: result.HasMore}
+	for _, raw := range result.Documents {
+		doc, err := decodeBattleDocument(raw)
+		if err != nil {
+			log.Printf("Skipping malformed battle %v: %s", raw["id"], err)
+			continue
+		}
+		page.Battles = append(page.Battles, doc.battle())
+	}
+	return page, nil
+}
+
+// Rebuilds the state of a battle by applying its logged actions to its
+// starting teams, in order. onAction, if not nil, is called with the state
+// and events after each action.
+func replay(doc battleDocument, actions []LoggedAction, onAction func(LoggedAction, battle_engine.State, []battle_engine.Event)) (battle_engine.State, error) {
+	var playerOneTeam, playerTwoTeam []battle_engine.Combatant
+	for _, combatant := range doc.PlayerOneTeam {
+		playerOneTeam = append(playerOneTeam, combatant.combatant())
+	}
+	for _, combatant := range doc.PlayerTwoTeam {
+		playerTwoTeam = append(playerTwoTeam, combatant.combatant())
+	}
+	state, err := battle_engine.NewBattle(playerOneTeam, playerTwoTeam, doc.Seed)
+	if err != nil {
+		return battle_engine.State{}, fmt.Errorf("battle %s cannot be replayed: %v", doc.ID, err)
+	}
+	for i, logged := range actions {
+		if logged.Sequence != i+1 {
+			return battle_engine.State{}, fmt.Errorf("battle %s is missing action %d", doc.ID, i+1)
+		}
+		var events []battle_engine.Event
+		state, events, err = battle_engine.Apply(state, logged.Action)
+		if err != nil {
+			return battle_engine.State{}, fmt.Errorf("battle %s cannot replay action %d: %v", doc.ID, logged.Sequence, err)
+		}
+		if onAction != nil {
+			onAction(logged, state, events)
+		}
+	}
+	return state, nil
+}
+
+// Returns a battle the user plays in.
+func (bm *BattleManager) getDocument(ctx context.Context, userId string, battleId string) (battleDocument, error) {
+	if battleId == "" {
+		return battleDocument{}, ErrNotFound
+	}
+	raw, err := bm.DatastoreClient.GetDocument(ctx, battlesCollection, battleId)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return battleDocument{}, ErrNotFound
+	}
+	if err != nil {
+		return battleDocument{}, err
+	}
+	doc, err := decodeBattleDocument(raw)
+	if err != nil {
+		return battleDocument{}, err
+	}
+	// Reporting other users' battles as missing avoids revealing that they
+	// exist.
+	if !slices.Contains(doc.PlayerUserIDs, userId) {
+		return battleDocument{}, ErrNotFound
+	}
+	return doc, nil
+}
+
+// Reads the log entries of a battle after a sequence number.
+func (bm *BattleManager) readLog(ctx context.Context, battleId string, after int) ([]LoggedAction, error) {
+	query := datastore.NewQuery(actionsCollection(battleId)).
+		Where("sequence", datastore.GreaterThan, after).
+		OrderBy("sequence", datastore.Asc).
+		Limit(actionBatchSize)
+	actions := []LoggedAction{}
+	for {
+		result, err := bm.DatastoreClient.RunQuery(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		for _, raw := range result.Documents {
+			var doc actionDocument
+			if err := datastore.DecodeDocument(raw, &doc); err != nil {
+				return nil, fmt.Errorf("malformed action %v of battle %s: %v", raw["id"], battleId, err)
+			}
+			action, err := doc.action()
+			if err != nil {
+				return nil, fmt.Errorf("malformed action %v of battle %s: %v", raw["id"], battleId, err)
+			}
+			createdAt, _ := time.Parse(timestampFormat, doc.CreatedAt)
+			actions = append(actions, LoggedAction{Sequence: doc.Sequence, UserID: doc.UserID, Action: action, CreatedAt: createdAt})
+		}
+		if !result.HasMore {
+			return actions, nil
+		}
+		query = query.StartAfter(result.LastCursor...)
+	}
+}
+
+func actionsCollection(battleId string) string {
+	return battlesCollection + "/" + battleId + "/actions"
+}
+
+func decodeBattleDocument(raw map[string]interface{}) (battleDocument, error) {
+	var doc battleDocument
+	if err := datastore.DecodeDocument(raw, &doc); err != nil {
+		return doc, fmt.Errorf("malformed battle %v: %v", raw["id"], err)
+	}
+	return doc, nil
+}
+
+// Returns the side a user acts as. Users who play both sides act as the side
+// whose turn it is.
+func (doc *battleDocument) sideOf(userId string, turn battle_engine.Side) battle_engine.Side {
+	if doc.userIdOf(turn) == userId {
+		return turn
+	}
+	return turn.Opponent()
+}
+
+// Reports whether both teams are in the battle.
+func (doc *battleDocument) started() bool {
+	return doc.Status == string(StatusActive) || doc.Status == string(StatusFinished)
+}
+
+func (doc *battleDocument) userIdOf(side battle_engine.Side) string {
+	if side == battle_engine.PlayerOne {
+		return doc.PlayerOneUserID
+	}
+	return doc.PlayerTwoUserID
+}
+
+// Updates the summary fields to describe a state.
+func (doc *battleDocument) setSummary(state battle_engine.State, actionCount int) {
+	doc.ActionCount = actionCount
+	if state.Winner != nil {
+		doc.Status = string(StatusFinished)
+		doc.WinnerUserID = doc.userIdOf(*state.Winner)
+		doc.CurrentTurnUserID = ""
+	} else {
+		doc.Status = string(StatusActive)
+		doc.WinnerUserID = ""
+		doc.CurrentTurnUserID = doc.userIdOf(state.Turn)
+	}
+}
+
+func (doc *battleDocument) summaryUpdates() map[string]interface{} {
+	return map[string]interface{}{
+		"status":            doc.Status,
+		"currentTurnUserId": doc.CurrentTurnUserID,
+		"winnerUserId":      doc.WinnerUserID,
+		"actionCount":       doc.ActionCount,
+		"updatedAt":         doc.UpdatedAt,
+	}
+}
+
+func (doc *battleDocument) battle() Battle {
+	createdAt, _ := time.Parse(timestampFormat, doc.CreatedAt)
+	updatedAt, _ := time.Parse(timestampFormat, doc.UpdatedAt)
+	return Battle{
+		ID:                doc.ID,
+		PlayerOneUserID:   doc.PlayerOneUserID,
+		PlayerTwoUserID:   doc.PlayerTwoUserID,
+		PlayerOneTeamID:   doc.PlayerOneTeamID,
+		PlayerTwoTeamID:   doc.PlayerTwoTeamID,
+		Status:            Status(doc.Status),
+		CurrentTurnUserID: optionalString(doc.CurrentTurnUserID),
+		WinnerUserID:      optionalString(doc.WinnerUserID),
+		ActionCount:       doc.ActionCount,
+		CreatedAt:         createdAt,
+		UpdatedAt:         updatedAt,
+	}
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,511 @@
+package battle_manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"spirit-snap/server/logic/battle_engine"
+	"spirit-snap/server/models"
+	"spirit-snap/server/wrappers/datastore"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testTime = time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+// Adds spirits with the given IDs to a user's collection.
+func addSpirits(t *testing.T, ds *datastore.MemoryClient, userId string, ids ...string) {
+	for _, id := range ids {
+		err := ds.CreateDocument(context.Background(), "users/"+userId+"/spirits", id, map[string]interface{}{
+			"schemaVersion": models.CurrentSpiritSchemaVersion,
+			"name":          "Spirit " + id,
+			"primaryType":   "Air",
+			"secondaryType": "None",
+			"strength":      50, "arcana": 40, "toughness": 50, "aura": 50, "agility": 50, "luck": 50, "hitPoints": 100,
+			"moveIds": []string{"move_1"},
+		})
+		assert.NoError(t, err)
+	}
+}
+
+func newTestManager(t *testing.T) (*BattleManager, *datastore.MemoryClient) {
+	ds := datastore.NewMemoryClient()
+	assert.NoError(t, ds.CreateDocument(context.Background(), "moves", "move_1", map[string]interface{}{"name": "Gust", "type": "Air"}))
+	addSpirits(t, ds, "alice", "a1", "a2", "a3")
+	addSpirits(t, ds, "bob", "b1", "b2")
+	bm := NewBattleManager(ds)
+	bm.now = func() time.Time { return testTime }
+	bm.newSeed = func() (int64, error) { return 42, nil }
+	return bm, ds
+}
+
+// Creates a battle between alice and bob, who accepts it.
+func createTestBattle(t *testing.T, bm *BattleManager) Battle {
+	alice, bob := "alice", "bob"
+	invited, err := bm.Create(&alice, CreateRequest{
+		PlayerOneTeam:   TeamRequest{ID: "team_a", SpiritIDs: []string{"a1", "a2", "a3"}},
+		PlayerTwoUserID: "bob",
+	})
+	assert.NoError(t, err)
+	battle, err := bm.Accept(&bob, invited.ID, TeamRequest{ID: "team_b", SpiritIDs: []string{"b2", "b1"}})
+	assert.NoError(t, err)
+	return battle
+}
+
+func strike(attacker, target battle_engine.Slot) battle_engine.Action {
+	return battle_engine.Action{Type: battle_engine.ActionMove, Move: &battle_engine.MoveFields{AttackerSlot: attacker, TargetSlot: target, MoveID: "move_1"}}
+}
+
+func TestCreate(t *testing.T) {
+	bm, ds := newTestManager(t)
+	alice, bob := "alice", "bob"
+
+	battle, err := bm.Create(&alice, CreateRequest{
+		PlayerOneTeam:   TeamRequest{ID: "team_a", SpiritIDs: []string{"a1", "a2"}},
+		PlayerTwoUserID: "bob",
+	})
+
+	// The battle waits for bob to pick a team.
+	assert.NoError(t, err)
+	assert.NotEmpty(t, battle.ID)
+	assert.Equal(t, "alice", battle.PlayerOneUserID)
+	assert.Equal(t, "bob", battle.PlayerTwoUserID)
+	assert.Equal(t, "team_a", battle.PlayerOneTeamID)
+	assert.Empty(t, battle.PlayerTwoTeamID)
+	assert.Equal(t, StatusInvited, battle.Status)
+	assert.Nil(t, battle.CurrentTurnUserID)
+	assert.Nil(t, battle.State)
+	assert.Equal(t, testTime, battle.CreatedAt)
+
+	doc, err := ds.GetDocument(context.Background(), "battles", battle.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"alice", "bob"}, doc["playerUserIds"])
+	assert.Nil(t, doc["playerTwoTeam"])
+
+	fetched, err := bm.Get(&bob, battle.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, battle, fetched)
+	_, _, err = bm.Submit(&alice, battle.ID, battle_engine.Action{Type: battle_engine.ActionRotate})
+	assert.ErrorIs(t, err, ErrNotStarted)
+	_, _, err = bm.Updates(&bob, battle.ID, 0)
+	assert.ErrorIs(t, err, ErrNotStarted)
+}
+
+func TestCreate_AgainstOneself(t *testing.T) {
+	bm, _ := newTestManager(t)
+	userId := "alice"
+
+	battle, err := bm.Create(&userId, CreateRequest{
+		PlayerOneTeam: TeamRequest{ID: "team_1", SpiritIDs: []string{"a1"}},
+		PlayerTwoTeam: TeamRequest{ID: "team_2", SpiritIDs: []string{"a2", "a3"}},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", battle.PlayerTwoUserID)
+	assert.Equal(t, "team_2", battle.PlayerTwoTeamID)
+	assert.Equal(t, StatusActive, battle.Status)
+	assert.NotNil(t, battle.State)
+}
+
+func TestCreate_InvalidTeams(t *testing.T) {
+	bm, _ := newTestManager(t)
+	userId := "alice"
+
+	tests := []struct {
+		name            string
+		playerOneTeam   []string
+		playerTwoUserId string
+		playerTwoTeam   TeamRequest
+	}{
+		{name: "Empty team", playerTwoUserId: "bob"},
+		{name: "Too many spirits", playerOneTeam: []string{"1", "2", "3", "4", "5", "6", "7"}, playerTwoUserId: "bob"},
+		{name: "Repeated spirit", playerOneTeam: []string{"a1", "a1"}, playerTwoUserId: "bob"},
+		{name: "Another user's spirit", playerOneTeam: []string{"a1", "b1"}, playerTwoUserId: "bob"},
+		{name: "Team picked for the opponent", playerOneTeam: []string{"a1"}, playerTwoUserId: "bob", playerTwoTeam: TeamRequest{ID: "team_b", SpiritIDs: []string{"b1"}}},
+		{name: "Another user's spirit against oneself", playerOneTeam: []string{"a1"}, playerTwoTeam: TeamRequest{ID: "team_b", SpiritIDs: []string{"b1"}}},
+		{name: "Invalid user ID", playerOneTeam: []string{"a1"}, playerTwoUserId: "bob/spirits/b1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := bm.Create(&userId, CreateRequest{
+				PlayerOneTeam:   TeamRequest{ID: "team_a", SpiritIDs: tt.playerOneTeam},
+				PlayerTwoUserID: tt.playerTwoUserId,
+				PlayerTwoTeam:   tt.playerTwoTeam,
+			})
+
+			assert.ErrorIs(t, err, ErrInvalidBattle)
+		})
+	}
+}
+
+func TestAccept(t *testing.T) {
+	bm, ds := newTestManager(t)
+
+	battle := createTestBattle(t, bm)
+
+	assert.Equal(t, "team_b", battle.PlayerTwoTeamID)
+	assert.Equal(t, StatusActive, battle.Status)
+	assert.Equal(t, "alice", *battle.CurrentTurnUserID)
+	assert.Nil(t, battle.WinnerUserID)
+	frontline := battle.State.At(battle_engine.Position{Side: battle_engine.PlayerTwo, Slot: battle_engine.Frontline})
+	assert.Equal(t, "b2", frontline.SpiritID)
+	assert.Equal(t, battle_engine.Stats{Strength: 50, Arcana: 40, Toughness: 50, Aura: 50, Agility: 50, Luck: 50, HitPoints: 100}, frontline.Stats)
+	assert.Equal(t, []battle_engine.Move{{ID: "move_1", Name: "Gust", Type: "Air", Power: battle_engine.DefaultMovePower}}, frontline.Moves)
+
+	doc, err := ds.GetDocument(context.Background(), "battles", battle.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), doc["seed"])
+	assert.Equal(t, "active", doc["status"])
+	alice := "alice"
+	replayed, err := bm.Get(&alice, battle.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, battle.State, replayed.State)
+}
+
+func TestAccept_Rejected(t *testing.T) {
+	bm, ds := newTestManager(t)
+	assert.NoError(t, ds.CreateDocument(context.Background(), "users/bob/spirits", "fragile", map[string]interface{}{
+		"schemaVersion": models.CurrentSpiritSchemaVersion, "hitPoints": 0,
+		"strength": 50, "arcana": 40, "toughness": 50, "aura": 50, "agility": 50, "luck": 50,
+	}))
+	alice, bob, eve := "alice", "bob", "eve"
+	invited, err := bm.Create(&alice, CreateRequest{PlayerOneTeam: TeamRequest{ID: "team_a", SpiritIDs: []string{"a1"}}, PlayerTwoUserID: "bob"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		userId    *string
+		spiritIds []string
+		expected  error
+	}{
+		{name: "Missing spirit", userId: &bob, spiritIds: []string{"b1", "b3"}, expected: ErrInvalidBattle},
+		{name: "Another user's spirit", userId: &bob, spiritIds: []string{"a1"}, expected: ErrInvalidBattle},
+		{name: "Spirit without hit points", userId: &bob, spiritIds: []string{"fragile"}, expected: ErrInvalidBattle},
+		{name: "Accepted by player one", userId: &alice, spiritIds: []string{"a2"}, expected: ErrNotInvited},
+		{name: "Another user's battle", userId: &eve, spiritIds: []string{"b1"}, expected: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := bm.Accept(tt.userId, invited.ID, TeamRequest{ID: "team_b", SpiritIDs: tt.spiritIds})
+
+			assert.ErrorIs(t, err, tt.expected)
+		})
+	}
+
+	// A battle can only be accepted once.
+	_, err = bm.Accept(&bob, invited.ID, TeamRequest{ID: "team_b", SpiritIDs: []string{"b1"}})
+	assert.NoError(t, err)
+	_, err = bm.Accept(&bob, invited.ID, TeamRequest{ID: "team_b", SpiritIDs: []string{"b2"}})
+	assert.ErrorIs(t, err, ErrNotInvited)
+}
+
+func TestDecline(t *testing.T) {
+	bm, _ := newTestManager(t)
+	alice, bob := "alice", "bob"
+	invited, err := bm.Create(&alice, CreateRequest{PlayerOneTeam: TeamRequest{ID: "team_a", SpiritIDs: []string{"a1"}}, PlayerTwoUserID: "bob"})
+	assert.NoError(t, err)
+
+	declined, err := bm.Decline(&bob, invited.ID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, StatusDeclined, declined.Status)
+	_, err = bm.Accept(&bob, invited.ID, TeamRequest{ID: "team_b", SpiritIDs: []string{"b1"}})
+	assert.ErrorIs(t, err, ErrNotInvited)
+	_, err = bm.Decline(&alice, invited.ID)
+	assert.ErrorIs(t, err, ErrNotInvited)
+	page, err := bm.List(&alice, StatusDeclined, 10, nil)
+	assert.NoError(t, err)
+	assert.Len(t, page.Battles, 1)
+}
+
+func TestSubmit(t *testing.T) {
+	bm, ds := newTestManager(t)
+	battle := createTestBattle(t, bm)
+	alice, bob := "alice", "bob"
+
+	// Setup
+	afterAlice, events, err := bm.Submit(&alice, battle.ID, strike(battle_engine.Frontline, battle_engine.Frontline))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, events)
+
+	// Execute
+	afterBob, _, err := bm.Submit(&bob, battle.ID, battle_engine.Action{Type: battle_engine.ActionRotate})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", *afterAlice.CurrentTurnUserID)
+	assert.Equal(t, 1, afterAlice.ActionCount)
+	assert.Equal(t, "alice", *afterBob.CurrentTurnUserID)
+	assert.Equal(t, 2, afterBob.ActionCount)
+	assert.Equal(t, "b1", afterBob.State.At(battle_engine.Position{Side: battle_engine.PlayerTwo, Slot: battle_engine.Frontline}).SpiritID)
+
+	logged, err := ds.GetDocument(context.Background(), "battles/"+battle.ID+"/actions", "00000001")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"id":           "00000001",
+		"sequence":     int64(1),
+		"userId":       "alice",
+		"type":         "Move",
+		"player":       "PlayerOne",
+		"attackerSlot": "Frontline-Center",
+		"targetSlot":   "Frontline-Center",
+		"moveId":       "move_1",
+		"createdAt":    "2024-01-15T10:30:00.000000Z",
+	}, logged)
+	doc, err := ds.GetDocument(context.Background(), "battles", battle.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", doc["currentTurnUserId"])
+	assert.Equal(t, int64(2), doc["actionCount"])
+}
+
+func TestSubmit_RejectsActionsOutOfTurn(t *testing.T) {
+	bm, _ := newTestManager(t)
+	battle := createTestBattle(t, bm)
+	bob, eve := "bob", "eve"
+
+	_, _, err := bm.Submit(&bob, battle.ID, strike(battle_engine.Frontline, battle_engine.Frontline))
+	assert.ErrorIs(t, err, battle_engine.ErrNotYourTurn)
+	_, _, err = bm.Submit(&eve, battle.ID, battle_engine.Action{Type: battle_engine.ActionRotate})
+	assert.ErrorIs(t, err, ErrNotFound)
+	// The player is set from the user, not from the request.
+	action := strike(battle_engine.Frontline, battle_engine.Frontline)
+	action.Player = battle_engine.PlayerOne
+	_, _, err = bm.Submit(&bob, battle.ID, action)
+	assert.ErrorIs(t, err, battle_engine.ErrNotYourTurn)
+
+	stored, err := bm.Get(&bob, battle.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, stored.ActionCount)
+}
+
+func TestSubmit_Surrender(t *testing.T) {
+	bm, _ := newTestManager(t)
+	battle := createTestBattle(t, bm)
+	alice, bob := "alice", "bob"
+
+	// Players may surrender during the other player's turn.
+	finished, _, err := bm.Submit(&bob, battle.ID, battle_engine.Action{Type: battle_engine.ActionSurrender})
+
+	assert.NoError(t, err)
+	assert.Equal(t, StatusFinished, finished.Status)
+	assert.Equal(t, "alice", *finished.WinnerUserID)
+	assert.Nil(t, finished.CurrentTurnUserID)
+	_, _, err = bm.Submit(&alice, battle.ID, battle_engine.Action{Type: battle_engine.ActionRotate})
+	assert.ErrorIs(t, err, battle_engine.ErrBattleOver)
+}
+
+func TestSubmit_AgainstOneself(t *testing.T) {
+	bm, _ := newTestManager(t)
+	alice := "alice"
+	battle, err := bm.Create(&alice, CreateRequest{
+		PlayerOneTeam: TeamRequest{ID: "team_1", SpiritIDs: []string{"a1"}},
+		PlayerTwoTeam: TeamRequest{ID: "team_2", SpiritIDs: []string{"a2"}},
+	})
+	assert.NoError(t, err)
+
+	// The user plays whichever side's turn it is.
+	_, _, err = bm.Submit(&alice, battle.ID, battle_engine.Action{Type: battle_engine.ActionRotate})
+	assert.NoError(t, err)
+	updated, _, err := bm.Submit(&alice, battle.ID, battle_engine.Action{Type: battle_engine.ActionRotate})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, updated.State.TurnNumber)
+	assert.Equal(t, battle_engine.PlayerOne, updated.State.Turn)
+}
+
+// Logs a competing action just before the submitted one is logged, the way
+// a concurrent request would.
+type racingDatastore struct {
+	*datastore.MemoryClient
+}
+
+func (r racingDatastore) CreateDocument(ctx context.Context, collectionName string, id string, data interface{}) error {
+	r.MemoryClient.CreateDocument(ctx, collectionName, id, data)
+	return r.MemoryClient.CreateDocument(ctx, collectionName, id, data)
+}
+
+func TestSubmit_Conflict(t *testing.T) {
+	bm, ds := newTestManager(t)
+	battle := createTestBattle(t, bm)
+	bm.DatastoreClient = racingDatastore{ds}
+	alice := "alice"
+
+	_, _, err := bm.Submit(&alice, battle.ID, battle_engine.Action{Type: battle_engine.ActionRotate})
+
+	assert.ErrorIs(t, err, ErrConflict)
+}
+
+func TestGet_ReplaysTheLog(t *testing.T) {
+	bm, ds := newTestManager(t)
+	battle := createTestBattle(t, bm)
+	alice, bob := "alice", "bob"
+	var last Battle
+	for turn := 0; turn < 6; turn++ {
+		userId := []*string{&alice, &bob}[turn%2]
+		updated, _, err := bm.Submit(userId, battle.ID, strike(battle_engine.Frontline, battle_engine.Frontline))
+		if errors.Is(err, battle_engine.ErrInvalidAction) {
+			// The frontline spirit fainted, so the turn is spent rotating.
+			updated, _, err = bm.Submit(userId, battle.ID, battle_engine.Action{Type: battle_engine.ActionRotate})
+		}
+		assert.NoError(t, err, fmt.Sprintf("turn %d", turn))
+		last = updated
+	}
+	// A summary that failed to update does not change the state.
+	assert.NoError(t, ds.UpdateDocument(context.Background(), "battles", battle.ID, map[string]interface{}{"actionCount": 1}))
+
+	replayed, err := bm.Get(&bob, battle.ID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, last.State, replayed.State)
+	assert.Equal(t, 6, replayed.ActionCount)
+	assert.Equal(t, *last.CurrentTurnUserID, *replayed.CurrentTurnUserID)
+}
+
+func TestGet_NotFound(t *testing.T) {
+	bm, _ := newTestManager(t)
+	battle := createTestBattle(t, bm)
+	eve := "eve"
+
+	_, err := bm.Get(&eve, battle.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = bm.Get(&eve, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = bm.Get(&eve, "")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestActions(t *testing.T) {
+	bm, _ := newTestManager(t)
+	battle := createTestBattle(t, bm)
+	alice, bob := "alice", "bob"
+	_, _, err := bm.Submit(&alice, battle.ID, battle_engine.Action{Type: battle_engine.ActionRotate})
+	assert.NoError(t, err)
+	_, _, err = bm.Submit(&bob, battle.ID, battle_engine.Action{
+		Type: battle_engine.ActionSwap,
+		Swap: &battle_engine.SwapFields{SwapInSlot: battle_engine.BenchLeft, SwapOutSlot: battle_engine.MiddleRight},
+	})
+	assert.ErrorIs(t, err, battle_engine.ErrInvalidAction)
+	_, _, err = bm.Submit(&bob, battle.ID, strike(battle_engine.Frontline, battle_engine.MiddleLeft))
+	assert.NoError(t, err)
+
+	all, err := bm.Actions(&bob, battle.ID, 0)
+	assert.NoError(t, err)
+	later, err := bm.Actions(&bob, battle.ID, 1)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []LoggedAction{
+		{Sequence: 1, UserID: "alice", Action: battle_engine.Action{Type: battle_engine.ActionRotate, Player: battle_engine.PlayerOne}, CreatedAt: testTime},
+		{Sequence: 2, UserID: "bob", Action: battle_engine.Action{
+			Type:   battle_engine.ActionMove,
+			Player: battle_engine.PlayerTwo,
+			Move:   &battle_engine.MoveFields{AttackerSlot: battle_engine.Frontline, TargetSlot: battle_engine.MiddleLeft, MoveID: "move_1"},
+		}, CreatedAt: testTime},
+	}, all)
+	assert.Equal(t, all[1:], later)
+	_, err = bm.Actions(new(string), battle.ID, 0)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestUpdates(t *testing.T) {
+	bm, _ := newTestManager(t)
+	battle := createTestBattle(t, bm)
+	alice, bob := "alice", "bob"
+	_, _, err := bm.Submit(&alice, battle.ID, battle_engine.Action{Type: battle_engine.ActionRotate})
+	assert.NoError(t, err)
+	afterStrike, strikeEvents, err := bm.Submit(&bob, battle.ID, strike(battle_engine.Frontline, battle_engine.MiddleLeft))
+	assert.NoError(t, err)
+	afterSurrender, surrenderEvents, err := bm.Submit(&bob, battle.ID, battle_engine.Action{Type: battle_engine.ActionSurrender})
+	assert.NoError(t, err)
+
+	current, updates, err := bm.Updates(&alice, battle.ID, 1)
+
+	assert.NoError(t, err)
+	assert.Equal(t, afterSurrender.State, current.State)
+	assert.Equal(t, StatusFinished, current.Status)
+	assert.Len(t, updates, 2)
+	assert.Equal(t, 2, updates[0].Sequence)
+	assert.Equal(t, "bob", updates[0].UserID)
+	assert.Equal(t, strikeEvents, updates[0].Events)
+	assert.Equal(t, afterStrike.State, updates[0].Battle.State)
+	assert.Equal(t, 2, updates[0].Battle.ActionCount)
+	assert.Equal(t, surrenderEvents, updates[1].Events)
+	assert.Equal(t, "alice", *updates[1].Battle.WinnerUserID)
+
+	_, updates, err = bm.Updates(&alice, battle.ID, 3)
+	assert.NoError(t, err)
+	assert.Empty(t, updates)
+	_, _, err = bm.Updates(new(string), battle.ID, 0)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestSubscribe(t *testing.T) {
+	bm, _ := newTestManager(t)
+	battle := createTestBattle(t, bm)
+	other := createTestBattle(t, bm)
+	alice := "alice"
+	changed, unsubscribe := bm.Subscribe(battle.ID)
+
+	// Actions in other battles are not notified.
+	_, _, err := bm.Submit(&alice, other.ID, battle_engine.Action{Type: battle_engine.ActionRotate})
+	assert.NoError(t, err)
+	assert.Empty(t, changed)
+
+	// Notifications that are not read yet are coalesced.
+	_, _, err = bm.Submit(&alice, battle.ID, battle_engine.Action{Type: battle_engine.ActionRotate})
+	assert.NoError(t, err)
+	_, _, err = bm.Submit(&alice, battle.ID, battle_engine.Action{Type: battle_engine.ActionSurrender})
+	assert.NoError(t, err)
+	assert.Len(t, changed, 1)
+
+	// Rejected actions are not notified.
+	<-changed
+	_, _, err = bm.Submit(&alice, battle.ID, battle_engine.Action{Type: battle_engine.ActionRotate})
+	assert.ErrorIs(t, err, battle_engine.ErrBattleOver)
+	assert.Empty(t, changed)
+
+	unsubscribe()
+	assert.Empty(t, bm.subscribers)
+}
+
+func TestList(t *testing.T) {
+	bm, _ := newTestManager(t)
+	alice, bob := "alice", "bob"
+	var ids []string
+	for i := 0; i < 3; i++ {
+		bm.now = func() time.Time { return testTime.Add(time.Duration(i) * time.Hour) }
+		ids = append(ids, createTestBattle(t, bm).ID)
+	}
+	_, _, err := bm.Submit(&bob, ids[1], battle_engine.Action{Type: battle_engine.ActionSurrender})
+	assert.NoError(t, err)
+
+	first, err := bm.List(&alice, "", 2, nil)
+	assert.NoError(t, err)
+	second, err := bm.List(&alice, "", 2, first.LastCursor)
+	assert.NoError(t, err)
+	active, err := bm.List(&bob, StatusActive, 10, nil)
+	assert.NoError(t, err)
+	finished, err := bm.List(&bob, StatusFinished, 10, nil)
+	assert.NoError(t, err)
+	none, err := bm.List(new(string), "", 10, nil)
+	assert.NoError(t, err)
+
+	battleIds := func(page *BattlePage) []string {
+		var ids []string
+		for _, battle := range page.Battles {
+			assert.Nil(t, battle.State)
+			ids = append(ids, battle.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []string{ids[2], ids[1]}, battleIds(first))
+	assert.True(t, first.HasMore)
+	assert.Equal(t, []string{ids[0]}, battleIds(second))
+	assert.False(t, second.HasMore)
+	assert.Equal(t, []string{ids[2], ids[0]}, battleIds(active))
+	assert.Equal(t, []string{ids[1]}, battleIds(finished))
+	assert.Equal(t, "alice", *finished.Battles[0].WinnerUserID)
+	assert.Empty(t, none.Battles)
+}
@@ -0,0 +1,177 @@
+package battle_manager
+
+import (
+	"fmt"
+	"time"
+
+	"spirit-snap/server/logic/battle_engine"
+)
+
+// Layout of the stored timestamps. Unlike time.RFC3339Nano it has a fixed
+// width, so the strings sort in time order and can be used in page cursors.
+const timestampFormat = "2006-01-02T15:04:05.000000Z"
+
+// battleDocument is the schema of a battle in the "battles" collection. The
+// players, their starting teams and the seed are enough to rebuild the state
+// from the action log. The remaining fields summarize the state after the
+// latest action, for listing battles without replaying them.
+//
+// Until an invited player accepts the battle, it has no player two team and
+// no seed.
+type battleDocument struct {
+	ID string `firestore:"-" datastore:"id"`
+	// Both players, so a user's battles can be found with one query. The same
+	// user appears twice in a battle against themselves.
+	PlayerUserIDs   []string            `firestore:"playerUserIds"`
+	PlayerOneUserID string              `firestore:"playerOneUserId"`
+	PlayerTwoUserID string              `firestore:"playerTwoUserId"`
+	PlayerOneTeamID string              `firestore:"playerOneTeamId"`
+	PlayerTwoTeamID string              `firestore:"playerTwoTeamId"`
+	PlayerOneTeam   []combatantDocument `firestore:"playerOneTeam"`
+	PlayerTwoTeam   []combatantDocument `firestore:"playerTwoTeam"`
+	Seed            int64               `firestore:"seed"`
+
+	Status            string `firestore:"status"`
+	CurrentTurnUserID string `firestore:"currentTurnUserId,omitempty"`
+	WinnerUserID      string `firestore:"winnerUserId,omitempty"`
+	ActionCount       int    `firestore:"actionCount"`
+	// Timestamps in timestampFormat. Battles are listed newest first by
+	// createdAt.
+	CreatedAt string `firestore:"createdAt"`
+	UpdatedAt string `firestore:"updatedAt"`
+}
+
+// A spirit as it entered the battle, at full health.
+type combatantDocument struct {
+	SpiritID      string         `firestore:"spiritId"`
+	Name          string         `firestore:"name"`
+	PrimaryType   string         `firestore:"primaryType"`
+	SecondaryType string         `firestore:"secondaryType"`
+	Strength      int            `firestore:"strength"`
+	Arcana        int            `firestore:"arcana"`
+	Toughness     int            `firestore:"toughness"`
+	Aura          int            `firestore:"aura"`
+	Agility       int            `firestore:"agility"`
+	Luck          int            `firestore:"luck"`
+	HitPoints     int            `firestore:"hitPoints"`
+	Moves         []moveDocument `firestore:"moves"`
+}
+
+type moveDocument struct {
+	ID       string `firestore:"id"`
+	Name     string `firestore:"name"`
+	Type     string `firestore:"type"`
+	Power    int    `firestore:"power"`
+	Category string `firestore:"category,omitempty"`
+	Accuracy int    `firestore:"accuracy,omitempty"`
+}
+
+// actionDocument is the schema of an entry of the action log, in the
+// battles/{battleId}/actions collection. Entries are only ever created, with
+// the zero padded sequence number as their ID, so two actions can never be
+// logged with the same number. Sides and slots are stored by name.
+type actionDocument struct {
+	Sequence     int    `firestore:"sequence"`
+	UserID       string `firestore:"userId"`
+	Type         string `firestore:"type"`
+	Player       string `firestore:"player"`
+	AttackerSlot string `firestore:"attackerSlot,omitempty"`
+	TargetSlot   string `firestore:"targetSlot,omitempty"`
+	MoveID       string `firestore:"moveId,omitempty"`
+	SwapInSlot   string `firestore:"swapInSlot,omitempty"`
+	SwapOutSlot  string `firestore:"swapOutSlot,omitempty"`
+	CreatedAt    string `firestore:"createdAt"`
+}
+
+func actionDocumentID(sequence int) string {
+	return fmt.Sprintf("%08d", sequence)
+}
+
+func newCombatantDocument(combatant battle_engine.Combatant) combatantDocument {
+	doc := combatantDocument{
+		SpiritID:      combatant.SpiritID,
+		Name:          combatant.Name,
+		PrimaryType:   combatant.PrimaryType,
+		SecondaryType: combatant.SecondaryType,
+		Strength:      combatant.Stats.Strength,
+		Arcana:        combatant.Stats.Arcana,
+		Toughness:     combatant.Stats.Toughness,
+		Aura:          combatant.Stats.Aura,
+		Agility:       combatant.Stats.Agility,
+		Luck:          combatant.Stats.Luck,
+		HitPoints:     combatant.Stats.HitPoints,
+	}
+	for _, move := range combatant.Moves {
+		doc.Moves = append(doc.Moves, moveDocument(move))
+	}
+	return doc
+}
+
+func (doc combatantDocument) combatant() battle_engine.Combatant {
+	combatant := battle_engine.Combatant{
+		SpiritID:      doc.SpiritID,
+		Name:          doc.Name,
+		PrimaryType:   doc.PrimaryType,
+		SecondaryType: doc.SecondaryType,
+		Stats: battle_engine.Stats{
+			Strength:  doc.Strength,
+			Arcana:    doc.Arcana,
+			Toughness: doc.Toughness,
+			Aura:      doc.Aura,
+			Agility:   doc.Agility,
+			Luck:      doc.Luck,
+			HitPoints: doc.HitPoints,
+		},
+		HitPoints: doc.HitPoints,
+	}
+	for _, move := range doc.Moves {
+		combatant.Moves = append(combatant.Moves, battle_engine.Move(move))
+	}
+	return combatant
+}
+
+func newActionDocument(sequence int, userId string, action battle_engine.Action, now time.Time) actionDocument {
+	doc := actionDocument{
+		Sequence:  sequence,
+		UserID:    userId,
+		Type:      string(action.Type),
+		Player:    action.Player.String(),
+		CreatedAt: now.Format(timestampFormat),
+	}
+	if action.Move != nil {
+		doc.AttackerSlot = action.Move.AttackerSlot.String()
+		doc.TargetSlot = action.Move.TargetSlot.String()
+		doc.MoveID = action.Move.MoveID
+	}
+	if action.Swap != nil {
+		doc.SwapInSlot = action.Swap.SwapInSlot.String()
+		doc.SwapOutSlot = action.Swap.SwapOutSlot.String()
+	}
+	return doc
+}
+
+func (doc actionDocument) action() (battle_engine.Action, error) {
+	action := battle_engine.Action{Type: battle_engine.ActionType(doc.Type)}
+	if err := action.Player.UnmarshalText([]byte(doc.Player)); err != nil {
+		return action, err
+	}
+	switch action.Type {
+	case battle_engine.ActionMove:
+		action.Move = &battle_engine.MoveFields{MoveID: doc.MoveID}
+		if err := action.Move.AttackerSlot.UnmarshalText([]byte(doc.AttackerSlot)); err != nil {
+			return action, err
+		}
+		if err := action.Move.TargetSlot.UnmarshalText([]byte(doc.TargetSlot)); err != nil {
+			return action, err
+		}
+	case battle_engine.ActionSwap:
+		action.Swap = &battle_engine.SwapFields{}
+		if err := action.Swap.SwapInSlot.UnmarshalText([]byte(doc.SwapInSlot)); err != nil {
+			return action, err
+		}
+		if err := action.Swap.SwapOutSlot.UnmarshalText([]byte(doc.SwapOutSlot)); err != nil {
+			return action, err
+		}
+	}
+	return action, nil
+}
@@ -12,8 +12,9 @@ type StorageInterface interface {
 }
 
 type CollectionDatastoreInterface interface {
-	GetCollection(ctx context.Context, collectionName string, limit int, sortField string, sortDirection datastore.Direction, startAfter []interface{}) (*datastore.PageResult, error)
+	RunQuery(ctx context.Context, query datastore.Query) (*datastore.PageResult, error)
 	GetDocumentsByIds(ctx context.Context, collectionName string, ids []string) ([]map[string]interface{}, error)
+	GetDocumentsFilteredByValue(ctx context.Context, collectionName string, fieldName string, value any) ([]map[string]interface{}, error)
 }
 
 type CollectionFetcher struct {
@@ -28,19 +29,45 @@ func NewCollectionFetcher(storage StorageInterface, ds CollectionDatastoreInterf
 	}
 }
 
-func (sp *CollectionFetcher) Fetch(userId *string, limit int, startAfter []interface{}) ([]models.Spirit, error) {
+// SpiritPage is a single page of a user's spirit collection.
+type SpiritPage struct {
+	Spirits []models.Spirit
+	// Cursor values of the last spirit on the page. Pass them back as
+	// startAfter to fetch the next page.
+	LastCursor []interface{}
+	HasMore    bool
+}
+
+// Fetch returns a page of the user's spirits, newest first. Spirits with the
+// same imageTimestamp are ordered by document ID, so the cursor of a page is
+// the timestamp and ID of its last spirit. A cursor of any other length
+// returns an error wrapping ErrInvalidQuery.
+func (sp *CollectionFetcher) Fetch(userId *string, limit int, startAfter []interface{}) (*SpiritPage, error) {
 	ctx := context.Background()
 
-	// Get spirits collection with pagination
-	result, err := sp.DatastoreClient.GetCollection(ctx, fmt.Sprintf("users/%s/spirits", *userId), limit, "imageTimestamp", datastore.Desc, startAfter)
+	query := datastore.NewQuery(fmt.Sprintf("users/%s/spirits", *userId)).
+		OrderBy("imageTimestamp", datastore.Desc).
+		OrderBy(datastore.DocumentID, datastore.Desc).
+		Limit(limit)
+	if len(startAfter) > 0 {
+		if len(startAfter) != len(query.Orders) {
+			return nil, fmt.Errorf("%w: page cursor has %d values, expected %d", ErrInvalidQuery, len(startAfter), len(query.Orders))
+		}
+		query = query.StartAfter(startAfter...)
+	}
+	result, err := sp.DatastoreClient.RunQuery(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 
 	// Get download URLs for each spirit's images
-	var spirits []models.Spirit
+	spirits := []models.Spirit{}
 	for _, doc := range result.Documents {
-		spirits = append(spirits, models.BuildSpiritfromDocData(ctx, sp.StorageClient, doc, sp.DatastoreClient))
+		spirits = append(spirits, models.BuildSpirit(ctx, sp.StorageClient, models.DecodeSpiritDocument(doc), sp.DatastoreClient))
 	}
-	return spirits, nil
+	return &SpiritPage{
+		Spirits:    spirits,
+		LastCursor: result.LastCursor,
+		HasMore:    result.HasMore,
+	}, nil
 }
@@ -2,6 +2,7 @@ package collection_fetcher
 
 import (
 	"context"
+	"spirit-snap/server/models"
 	"spirit-snap/server/wrappers/datastore"
 	"testing"
 
@@ -22,11 +23,28 @@ type MockDatastoreClient struct {
 	mock.Mock
 }
 
-func (m *MockDatastoreClient) GetCollection(ctx context.Context, collectionName string, limit int, sortField string, sortDirection datastore.Direction, startAfter []interface{}) (*datastore.PageResult, error) {
-	args := m.Called(ctx, collectionName, limit, sortField, sortDirection, startAfter)
+// The query Fetch runs for a page of testUser123's spirits.
+func fetchQuery(limit int, startAfter ...interface{}) datastore.Query {
+	query := datastore.NewQuery("users/testUser123/spirits").
+		OrderBy("imageTimestamp", datastore.Desc).
+		OrderBy(datastore.DocumentID, datastore.Desc).
+		Limit(limit)
+	if len(startAfter) > 0 {
+		query = query.StartAfter(startAfter...)
+	}
+	return query
+}
+
+func (m *MockDatastoreClient) RunQuery(ctx context.Context, query datastore.Query) (*datastore.PageResult, error) {
+	args := m.Called(ctx, query)
 	return args.Get(0).(*datastore.PageResult), args.Error(1)
 }
 
+func (m *MockDatastoreClient) GetDocumentsFilteredByValue(ctx context.Context, collectionName string, fieldName string, value any) ([]map[string]interface{}, error) {
+	args := m.Called(ctx, collectionName, fieldName, value)
+	return args.Get(0).([]map[string]interface{}), args.Error(1)
+}
+
 func (m *MockDatastoreClient) GetDocumentsByIds(ctx context.Context, collectionName string, ids []string) ([]map[string]interface{}, error) {
 	args := m.Called(ctx, collectionName, ids)
 	return args.Get(0).([]map[string]interface{}), args.Error(1)
@@ -39,7 +57,7 @@ func TestCollectionFetcher_Fetch(t *testing.T) {
 
 	userId := "testUser123"
 	limit := 10
-	startAfter := []interface{}{"lastDoc"}
+	startAfter := []interface{}{"2024-01-16T08:00:00Z", "lastDoc"}
 
 	moveID1 := "move1"
 	moveID2 := "move2"
@@ -66,15 +84,10 @@ func TestCollectionFetcher_Fetch(t *testing.T) {
 		"toughness":              55,
 	}
 
-	mockDatastore.On("GetCollection",
-		mock.Anything,
-		"users/testUser123/spirits",
-		limit,
-		"imageTimestamp",
-		datastore.Desc,
-		startAfter,
-	).Return(&datastore.PageResult{
-		Documents: []map[string]interface{}{testSpirit},
+	mockDatastore.On("RunQuery", mock.Anything, fetchQuery(limit, startAfter...)).Return(&datastore.PageResult{
+		Documents:  []map[string]interface{}{testSpirit},
+		LastCursor: []interface{}{"2024-01-15T10:30:00Z", "spirit1"},
+		HasMore:    true,
 	}, nil)
 
 	moveDocs := []map[string]interface{}{
@@ -105,9 +118,12 @@ func TestCollectionFetcher_Fetch(t *testing.T) {
 		"generated/path",
 	).Return("http://generated-url", nil)
 
-	spirits, err := fetcher.Fetch(&userId, limit, startAfter)
+	page, err := fetcher.Fetch(&userId, limit, startAfter)
 
 	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"2024-01-15T10:30:00Z", "spirit1"}, page.LastCursor)
+	assert.True(t, page.HasMore)
+	spirits := page.Spirits
 	assert.Len(t, spirits, 1)
 
 	expectedID := "spirit1"
@@ -162,9 +178,12 @@ func TestCollectionFetcher_FetchWithNilPaths(t *testing.T) {
 
 	userId := "testUser123"
 	limit := 10
-	startAfter := []interface{}{"lastDoc"}
+	startAfter := []interface{}{"2024-01-16T08:00:00Z", "lastDoc"}
 
+	// A current document, so the spirit migrations leave the empty fields as
+	// they are.
 	testSpirit := map[string]interface{}{
+		"schemaVersion":          int64(models.CurrentSpiritSchemaVersion),
 		"id":                     nil,
 		"name":                   nil,
 		"description":            nil,
@@ -186,20 +205,15 @@ func TestCollectionFetcher_FetchWithNilPaths(t *testing.T) {
 		"toughness":              nil,
 	}
 
-	mockDatastore.On("GetCollection",
-		mock.Anything,
-		"users/testUser123/spirits",
-		limit,
-		"imageTimestamp",
-		datastore.Desc,
-		startAfter,
-	).Return(&datastore.PageResult{
+	mockDatastore.On("RunQuery", mock.Anything, fetchQuery(limit, startAfter...)).Return(&datastore.PageResult{
 		Documents: []map[string]interface{}{testSpirit},
 	}, nil)
 
-	spirits, err := fetcher.Fetch(&userId, limit, startAfter)
+	page, err := fetcher.Fetch(&userId, limit, startAfter)
 
 	assert.NoError(t, err)
+	assert.False(t, page.HasMore)
+	spirits := page.Spirits
 	assert.Len(t, spirits, 1)
 	assert.Nil(t, spirits[0].ID)
 	assert.Nil(t, spirits[0].Name)
@@ -224,3 +238,66 @@ func TestCollectionFetcher_FetchWithNilPaths(t *testing.T) {
 	mockDatastore.AssertExpectations(t)
 	mockStorage.AssertNotCalled(t, "GetDownloadURL")
 }
+
+func TestCollectionFetcher_FetchEmptyCollection(t *testing.T) {
+	mockStorage := &MockStorageClient{}
+	mockDatastore := &MockDatastoreClient{}
+	fetcher := NewCollectionFetcher(mockStorage, mockDatastore)
+
+	userId := "testUser123"
+
+	mockDatastore.On("RunQuery", mock.Anything, fetchQuery(10)).Return(&datastore.PageResult{}, nil)
+
+	page, err := fetcher.Fetch(&userId, 10, nil)
+
+	assert.NoError(t, err)
+	assert.NotNil(t, page.Spirits)
+	assert.Empty(t, page.Spirits)
+	assert.Nil(t, page.LastCursor)
+	assert.False(t, page.HasMore)
+}
+
+func TestCollectionFetcher_FetchRejectsOldCursors(t *testing.T) {
+	mockDatastore := &MockDatastoreClient{}
+	fetcher := NewCollectionFetcher(&MockStorageClient{}, mockDatastore)
+	userId := "testUser123"
+
+	// Cursors issued before pages were ordered by document ID only hold the
+	// timestamp.
+	page, err := fetcher.Fetch(&userId, 10, []interface{}{"2024-01-15T10:30:00Z"})
+
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+	assert.Nil(t, page)
+	mockDatastore.AssertNotCalled(t, "RunQuery")
+}
+
+// Spirits processed in the same instant are neither skipped nor repeated
+// across pages.
+func TestCollectionFetcher_FetchPagesThroughEqualTimestamps(t *testing.T) {
+	ctx := context.Background()
+	ds := datastore.NewMemoryClient()
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		assert.NoError(t, ds.CreateDocument(ctx, "users/testUser123/spirits", id, map[string]interface{}{
+			"schemaVersion":  int64(models.CurrentSpiritSchemaVersion),
+			"imageTimestamp": "2024-01-15T10:30:00Z",
+		}))
+	}
+	fetcher := NewCollectionFetcher(&MockStorageClient{}, ds)
+	userId := "testUser123"
+
+	var ids []string
+	var cursor []interface{}
+	for {
+		page, err := fetcher.Fetch(&userId, 2, cursor)
+		assert.NoError(t, err)
+		for _, spirit := range page.Spirits {
+			ids = append(ids, *spirit.ID)
+		}
+		if !page.HasMore {
+			break
+		}
+		cursor = page.LastCursor
+	}
+
+	assert.Equal(t, []string{"e", "d", "c", "b", "a"}, ids)
+}
@@ -0,0 +1,200 @@
+package collection_fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"spirit-snap/server/models"
+	"spirit-snap/server/wrappers/datastore"
+	"strings"
+)
+
+// ErrInvalidQuery is returned by Search when the query cannot be run. Errors
+// wrapping it are caused by the caller's input.
+var ErrInvalidQuery = errors.New("invalid spirit query")
+
+// StatFields are the numeric spirit fields that can be filtered and sorted on.
+var StatFields = []string{
+	"agility",
+	"arcana",
+	"aura",
+	"charisma",
+	"endurance",
+	"height",
+	"weight",
+	"intimidation",
+	"luck",
+	"strength",
+	"toughness",
+	"hitPoints",
+}
+
+// Firestore limits array-contains-any filters to 30 values. Move types with
+// more moves than this are filtered in memory instead.
+const maxArrayContainsAnyValues = 30
+
+// Name filtering happens in memory, so a single search scans at most this many
+// batches before returning a short page with HasMore set.
+const maxSearchBatches = 10
+
+// StatRange restricts a stat to an inclusive range. A nil bound is open.
+type StatRange struct {
+	Stat string
+	Min  *int
+	Max  *int
+}
+
+// SpiritQuery describes a search over a user's spirit collection. Zero valued
+// fields do not filter.
+type SpiritQuery struct {
+	PrimaryType   string
+	SecondaryType string
+	StatRanges    []StatRange
+	// Case-insensitive substring of the spirit's name.
+	NameContains string
+	// Only return spirits with at least one move of this type.
+	MoveType string
+
+	// Field to sort by, either "imageTimestamp" or one of StatFields.
+	// Defaults to "imageTimestamp".
+	SortField string
+	// Defaults to descending.
+	SortDirection datastore.Direction
+
+	Limit int
+	// Cursor returned in SpiritPage.LastCursor by a previous search with the
+	// same filters and sort.
+	StartAfter []interface{}
+}
+
+func (q SpiritQuery) validate() error {
+	if q.Limit <= 0 {
+		return fmt.Errorf("%w: limit must be positive", ErrInvalidQuery)
+	}
+	if q.SortField != "" && q.SortField != "imageTimestamp" && !slices.Contains(StatFields, q.SortField) {
+		return fmt.Errorf("%w: cannot sort by %q", ErrInvalidQuery, q.SortField)
+	}
+	if q.SortDirection != datastore.Asc && q.SortDirection != datastore.Desc {
+		return fmt.Errorf("%w: unknown sort direction %d", ErrInvalidQuery, q.SortDirection)
+	}
+	for _, statRange := range q.StatRanges {
+		if !slices.Contains(StatFields, statRange.Stat) {
+			return fmt.Errorf("%w: cannot filter by %q", ErrInvalidQuery, statRange.Stat)
+		}
+		if statRange.Min != nil && statRange.Max != nil && *statRange.Min > *statRange.Max {
+			return fmt.Errorf("%w: %s minimum is greater than its maximum", ErrInvalidQuery, statRange.Stat)
+		}
+	}
+	return nil
+}
+
+// Search returns a page of the user's spirits matching the query.
+//
+// Type and stat filters are run by the datastore. Name filtering happens in
+// memory, so a page may hold fewer than Limit spirits even though HasMore is
+// set. Clients should keep paging until HasMore is false.
+func (sp *CollectionFetcher) Search(userId *string, query SpiritQuery) (*SpiritPage, error) {
+	ctx := context.Background()
+	if query.SortField == "" {
+		query.SortField = "imageTimestamp"
+	}
+	if query.SortDirection == 0 {
+		query.SortDirection = datastore.Desc
+	}
+	if err := query.validate(); err != nil {
+		return nil, err
+	}
+
+	dsQuery := datastore.NewQuery(fmt.Sprintf("users/%s/spirits", *userId))
+	if query.PrimaryType != "" {
+		dsQuery = dsQuery.Where("primaryType", datastore.Equal, query.PrimaryType)
+	}
+	if query.SecondaryType != "" {
+		dsQuery = dsQuery.Where("secondaryType", datastore.Equal, query.SecondaryType)
+	}
+
+	// Firestore implicitly orders by every field with a range filter, so those
+	// orders are added explicitly to keep the cursor in sync with the query.
+	// The document ID comes last as a tie-breaker for equal values.
+	dsQuery = dsQuery.OrderBy(query.SortField, query.SortDirection)
+	for _, statRange := range query.StatRanges {
+		if statRange.Min != nil {
+			dsQuery = dsQuery.Where(statRange.Stat, datastore.GreaterThanOrEqual, *statRange.Min)
+		}
+		if statRange.Max != nil {
+			dsQuery = dsQuery.Where(statRange.Stat, datastore.LessThanOrEqual, *statRange.Max)
+		}
+		if (statRange.Min != nil || statRange.Max != nil) && statRange.Stat != query.SortField && !hasOrder(dsQuery, statRange.Stat) {
+			dsQuery = dsQuery.OrderBy(statRange.Stat, datastore.Asc)
+		}
+	}
+	dsQuery = dsQuery.OrderBy(datastore.DocumentID, query.SortDirection)
+
+	var moveIds map[string]bool
+	if query.MoveType != "" {
+		moveDocs, err := sp.DatastoreClient.GetDocumentsFilteredByValue(ctx, "moves", "type", query.MoveType)
+		if err != nil {
+			return nil, err
+		}
+		if len(moveDocs) == 0 {
+			return &SpiritPage{Spirits: []models.Spirit{}}, nil
+		}
+		var ids []interface{}
+		moveIds = make(map[string]bool)
+		for _, doc := range moveDocs {
+			if id, ok := doc["id"].(string); ok {
+				ids = append(ids, id)
+				moveIds[id] = true
+			}
+		}
+		if len(ids) <= maxArrayContainsAnyValues {
+			dsQuery = dsQuery.Where("moveIds", datastore.ArrayContainsAny, ids)
+			moveIds = nil
+		}
+	}
+	nameContains := strings.ToLower(query.NameContains)
+	matches := func(doc models.SpiritDocument) bool {
+		if nameContains != "" && !strings.Contains(strings.ToLower(doc.Name), nameContains) {
+			return false
+		}
+		if moveIds != nil && !slices.ContainsFunc(doc.MoveIDs, func(id string) bool { return moveIds[id] }) {
+			return false
+		}
+		return true
+	}
+
+	// Batches never ask for more documents than are still needed, so every
+	// scanned document fits on the page and the cursor of the last batch is
+	// also the cursor of the page.
+	page := &SpiritPage{Spirits: []models.Spirit{}}
+	cursor := query.StartAfter
+	for batch := 0; batch < maxSearchBatches && len(page.Spirits) < query.Limit; batch++ {
+		batchQuery := dsQuery.Limit(query.Limit - len(page.Spirits))
+		if len(cursor) > 0 {
+			batchQuery = batchQuery.StartAfter(cursor...)
+		}
+		result, err := sp.DatastoreClient.RunQuery(ctx, batchQuery)
+		if err != nil {
+			return nil, err
+		}
+		for _, data := range result.Documents {
+			if doc := models.DecodeSpiritDocument(data); matches(doc) {
+				page.Spirits = append(page.Spirits, models.BuildSpirit(ctx, sp.StorageClient, doc, sp.DatastoreClient))
+			}
+		}
+		if result.LastCursor != nil {
+			cursor = result.LastCursor
+		}
+		page.LastCursor = cursor
+		page.HasMore = result.HasMore
+		if !result.HasMore {
+			break
+		}
+	}
+	return page, nil
+}
+
+func hasOrder(query datastore.Query, field string) bool {
+	return slices.ContainsFunc(query.Orders, func(order datastore.Order) bool { return order.Field == field })
+}
@@ -0,0 +1,138 @@
+package collection_fetcher
+
+import (
+	"spirit-snap/server/wrappers/datastore"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestCollectionFetcher_SearchBuildsQuery(t *testing.T) {
+	mockStorage := &MockStorageClient{}
+	mockDatastore := &MockDatastoreClient{}
+	fetcher := NewCollectionFetcher(mockStorage, mockDatastore)
+
+	userId := "testUser123"
+
+	mockDatastore.On("GetDocumentsFilteredByValue", mock.Anything, "moves", "type", "Flame").
+		Return([]map[string]interface{}{{"id": "move1"}, {"id": "move2"}}, nil)
+
+	expectedQuery := datastore.NewQuery("users/testUser123/spirits").
+		Where("primaryType", datastore.Equal, "Flame").
+		Where("secondaryType", datastore.Equal, "Stone").
+		OrderBy("strength", datastore.Asc).
+		Where("strength", datastore.GreaterThanOrEqual, 70).
+		Where("agility", datastore.LessThanOrEqual, 40).
+		OrderBy("agility", datastore.Asc).
+		OrderBy(datastore.DocumentID, datastore.Asc).
+		Where("moveIds", datastore.ArrayContainsAny, []interface{}{"move1", "move2"}).
+		Limit(5).
+		StartAfter(int64(71), int64(12), "spiritX")
+	mockDatastore.On("RunQuery", mock.Anything, expectedQuery).Return(&datastore.PageResult{
+		Documents:  []map[string]interface{}{{"id": "spirit1", "name": "Cinderhorn", "strength": 75, "agility": 20}},
+		LastCursor: []interface{}{75, 20, "spirit1"},
+		HasMore:    false,
+	}, nil)
+
+	page, err := fetcher.Search(&userId, SpiritQuery{
+		PrimaryType:   "Flame",
+		SecondaryType: "Stone",
+		StatRanges: []StatRange{
+			{Stat: "strength", Min: intPtr(70)},
+			{Stat: "agility", Max: intPtr(40)},
+		},
+		MoveType:      "Flame",
+		SortField:     "strength",
+		SortDirection: datastore.Asc,
+		Limit:         5,
+		StartAfter:    []interface{}{int64(71), int64(12), "spiritX"},
+	})
+
+	assert.NoError(t, err)
+	assert.Len(t, page.Spirits, 1)
+	assert.Equal(t, "spirit1", *page.Spirits[0].ID)
+	assert.Equal(t, []interface{}{75, 20, "spirit1"}, page.LastCursor)
+	assert.False(t, page.HasMore)
+	mockDatastore.AssertExpectations(t)
+}
+
+func TestCollectionFetcher_SearchFiltersNameAcrossBatches(t *testing.T) {
+	mockStorage := &MockStorageClient{}
+	mockDatastore := &MockDatastoreClient{}
+	fetcher := NewCollectionFetcher(mockStorage, mockDatastore)
+
+	userId := "testUser123"
+	baseQuery := datastore.NewQuery("users/testUser123/spirits").
+		OrderBy("imageTimestamp", datastore.Desc).
+		OrderBy(datastore.DocumentID, datastore.Desc)
+
+	// The first batch asks for the full page and only one document matches.
+	mockDatastore.On("RunQuery", mock.Anything, baseQuery.Limit(2)).Return(&datastore.PageResult{
+		Documents: []map[string]interface{}{
+			{"id": "a", "name": "Ember Fox"},
+			{"id": "b", "name": "Tidecaller"},
+		},
+		LastCursor: []interface{}{"t2", "b"},
+		HasMore:    true,
+	}, nil).Once()
+	// The second batch only asks for the remaining spirit.
+	mockDatastore.On("RunQuery", mock.Anything, baseQuery.Limit(1).StartAfter("t2", "b")).Return(&datastore.PageResult{
+		Documents:  []map[string]interface{}{{"id": "c", "name": "emberwing"}},
+		LastCursor: []interface{}{"t3", "c"},
+		HasMore:    true,
+	}, nil).Once()
+
+	page, err := fetcher.Search(&userId, SpiritQuery{NameContains: "EMBER", Limit: 2})
+
+	assert.NoError(t, err)
+	assert.Len(t, page.Spirits, 2)
+	assert.Equal(t, "a", *page.Spirits[0].ID)
+	assert.Equal(t, "c", *page.Spirits[1].ID)
+	assert.Equal(t, []interface{}{"t3", "c"}, page.LastCursor)
+	assert.True(t, page.HasMore)
+	mockDatastore.AssertExpectations(t)
+}
+
+func TestCollectionFetcher_SearchUnknownMoveType(t *testing.T) {
+	mockStorage := &MockStorageClient{}
+	mockDatastore := &MockDatastoreClient{}
+	fetcher := NewCollectionFetcher(mockStorage, mockDatastore)
+
+	userId := "testUser123"
+	mockDatastore.On("GetDocumentsFilteredByValue", mock.Anything, "moves", "type", "Bogus").
+		Return([]map[string]interface{}{}, nil)
+
+	page, err := fetcher.Search(&userId, SpiritQuery{MoveType: "Bogus", Limit: 10})
+
+	assert.NoError(t, err)
+	assert.Empty(t, page.Spirits)
+	assert.False(t, page.HasMore)
+	mockDatastore.AssertNotCalled(t, "RunQuery", mock.Anything, mock.Anything)
+}
+
+func TestCollectionFetcher_SearchInvalidQuery(t *testing.T) {
+	fetcher := NewCollectionFetcher(&MockStorageClient{}, &MockDatastoreClient{})
+	userId := "testUser123"
+
+	tests := []struct {
+		name  string
+		query SpiritQuery
+	}{
+		{name: "Missing limit", query: SpiritQuery{}},
+		{name: "Unknown sort field", query: SpiritQuery{SortField: "name", Limit: 10}},
+		{name: "Unknown stat", query: SpiritQuery{StatRanges: []StatRange{{Stat: "mana", Min: intPtr(1)}}, Limit: 10}},
+		{name: "Inverted range", query: SpiritQuery{StatRanges: []StatRange{{Stat: "luck", Min: intPtr(50), Max: intPtr(10)}}, Limit: 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := fetcher.Search(&userId, tt.query)
+			assert.ErrorIs(t, err, ErrInvalidQuery)
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package image_processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math/bits"
+	"spirit-snap/server/models"
+	"spirit-snap/server/wrappers/datastore"
+	"strconv"
+
+	"golang.org/x/image/draw"
+)
+
+// ErrDuplicatePhoto is returned when a photo is too similar to one the user
+// has already turned into a spirit. Errors wrapping it are
+// *DuplicatePhotoError values.
+var ErrDuplicatePhoto = errors.New("duplicate photo")
+
+// DuplicatePhotoError names the spirit that an uploaded photo duplicates.
+type DuplicatePhotoError struct {
+	// ID of the user's spirit whose photo matched.
+	SpiritID string
+	// Number of bits in which the photo hashes differ.
+	Distance int
+}
+
+func (e *DuplicatePhotoError) Error() string {
+	return fmt.Sprintf("%s: this photo was already used for spirit %s, try snapping something new", ErrDuplicatePhoto, e.SpiritID)
+}
+
+func (e *DuplicatePhotoError) Unwrap() error {
+	return ErrDuplicatePhoto
+}
+
+// PhotoHash is a 64 bit difference hash (dHash) of a photo. Each bit records
+// whether a pixel of a tiny grayscale copy of the photo is brighter than its
+// right neighbour, so photos of the same scene get hashes that differ in few
+// bits even after resizing, recompression or small changes in exposure.
+type PhotoHash uint64
+
+// ComputePhotoHash returns the hash of img.
+func ComputePhotoHash(img image.Image) PhotoHash {
+	// One extra column so that each row has 8 neighbouring pairs.
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+	var hash PhotoHash
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y > small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// Distance returns the Hamming distance between two hashes, from 0 for
+// identical photos to 64.
+func (h PhotoHash) Distance(other PhotoHash) int {
+	return bits.OnesCount64(uint64(h ^ other))
+}
+
+// String returns the hash as 16 hex digits, which is how it is stored.
+// Firestore integers are signed, so a string keeps every bit intact.
+func (h PhotoHash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// ParsePhotoHash parses a hash returned by PhotoHash.String.
+func ParsePhotoHash(s string) (PhotoHash, error) {
+	value, err := strconv.ParseUint(s, 16, 64)
+	if err != nil || len(s) != 16 {
+		return 0, fmt.Errorf("invalid photo hash %q", s)
+	}
+	return PhotoHash(value), nil
+}
+
+// DuplicateAction is what happens to a photo that duplicates an earlier one.
+type DuplicateAction string
+
+const (
+	// Photos are hashed but never compared.
+	DuplicateActionOff DuplicateAction = "off"
+	// The spirit is created and marked with the spirit it duplicates.
+	DuplicateActionFlag DuplicateAction = "flag"
+	// The photo is rejected with a *DuplicatePhotoError before any spirit data
+	// or image is generated.
+	DuplicateActionReject DuplicateAction = "reject"
+)
+
+// DuplicateCheck configures how uploads are compared against the photos of
+// the user's existing spirits.
+//
+// A photo is only compared against spirits that have been saved, so two
+// near-identical photos uploaded at the same time are both accepted and
+// neither is flagged as the other's duplicate.
+type DuplicateCheck struct {
+	Action DuplicateAction
+	// Photos whose hashes differ in at most this many bits are duplicates.
+	MaxDistance int
+	// Number of the user's most recent spirits that are compared against.
+	RecentSpirits int
+}
+
+// DefaultDuplicateCheck flags photos of the same object taken moments apart
+// while letting different objects of the same kind through. Nothing is
+// rejected unless Action is set to DuplicateActionReject.
+func DefaultDuplicateCheck() DuplicateCheck {
+	return DuplicateCheck{
+		Action:        DuplicateActionFlag,
+		MaxDistance:   8,
+		RecentSpirits: 200,
+	}
+}
+
+// Returns the hash of a photo that has been through SanitizePhoto, so that
+// rotated copies of the same photo get the same hash.
+func hashPhoto(photo *Image) (PhotoHash, error) {
+	decoded, _, err := image.Decode(bytes.NewReader(photo.Data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode photo for hashing: %v", err)
+	}
+	return ComputePhotoHash(decoded), nil
+}
+
+// Returns the duplicate of a photo with the given hash among the user's most
+// recent spirits, or nil if there is none. If several spirits match, the
+// closest one is returned. Spirits created before photos were hashed are
+// never duplicates.
+func (ip *ImageProcessor) findDuplicate(ctx context.Context, userId string, hash PhotoHash) (*DuplicatePhotoError, error) {
+	if ip.DuplicateCheck.Action == DuplicateActionOff || ip.DuplicateCheck.Action == "" || ip.DuplicateCheck.RecentSpirits <= 0 {
+		return nil, nil
+	}
+	query := datastore.NewQuery("users/"+userId+"/spirits").
+		OrderBy("imageTimestamp", datastore.Desc).
+		Select("photoHash").
+		Limit(ip.DuplicateCheck.RecentSpirits)
+	result, err := ip.DatastoreClient.RunQuery(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query recent photo hashes: %w", err)
+	}
+
+	var duplicate *DuplicatePhotoError
+	for _, doc := range result.Documents {
+		stored := models.GetOptionalStringField(doc, "photoHash")
+		spiritId := models.GetOptionalStringField(doc, "id")
+		if stored == nil || spiritId == nil {
+			continue
+		}
+		storedHash, err := ParsePhotoHash(*stored)
+		if err != nil {
+			continue
+		}
+		distance := hash.Distance(storedHash)
+		if distance <= ip.DuplicateCheck.MaxDistance && (duplicate == nil || distance < duplicate.Distance) {
+			duplicate = &DuplicatePhotoError{SpiritID: *spiritId, Distance: distance}
+		}
+	}
+	return duplicate, nil
+}
@@ -0,0 +1,194 @@
+package image_processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"spirit-snap/server/models"
+	"spirit-snap/server/wrappers/datastore"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns a photo with a bright disc on a gradient background.
+func drawDisc(width, height, centerX, centerY, radius int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dx, dy := x-centerX, y-centerY
+			if dx*dx+dy*dy <= radius*radius {
+				img.Set(x, y, color.RGBA{R: 230, G: 200, B: 60, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{R: uint8(x * 100 / width), G: 40, B: uint8(y * 120 / height), A: 255})
+			}
+		}
+	}
+	return img
+}
+
+func encodeJPEGPhoto(img image.Image, quality int) []byte {
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	return buf.Bytes()
+}
+
+func TestComputePhotoHash(t *testing.T) {
+	original := drawDisc(400, 300, 120, 150, 80)
+	hash := ComputePhotoHash(original)
+
+	// Resized and recompressed copies of the same photo.
+	smaller := resize(original, 160)
+	recompressed, _, err := image.Decode(bytes.NewReader(encodeJPEGPhoto(original, 40)))
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, hash.Distance(ComputePhotoHash(smaller)), 4)
+	assert.LessOrEqual(t, hash.Distance(ComputePhotoHash(recompressed)), 4)
+
+	// A different scene.
+	different := applyOrientation(original, orientationFlipHorizontal)
+	assert.Greater(t, hash.Distance(ComputePhotoHash(different)), DefaultDuplicateCheck().MaxDistance)
+}
+
+func TestPhotoHash_String(t *testing.T) {
+	hash := PhotoHash(0xfedcba9876543210)
+
+	parsed, err := ParsePhotoHash(hash.String())
+
+	assert.NoError(t, err)
+	assert.Equal(t, "fedcba9876543210", hash.String())
+	assert.Equal(t, hash, parsed)
+	for _, invalid := range []string{"", "xyz", "fedcba98", "0fedcba9876543210"} {
+		_, err := ParsePhotoHash(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestPhotoHash_Distance(t *testing.T) {
+	assert.Equal(t, 0, PhotoHash(0b1011).Distance(0b1011))
+	assert.Equal(t, 2, PhotoHash(0b1011).Distance(0b1110))
+	assert.Equal(t, 64, PhotoHash(0).Distance(^PhotoHash(0)))
+}
+
+// Returns an ImageProcessor whose datastore holds spirits with the given
+// photo hashes, keyed by spirit ID. The document added for the new spirit is
+// stored in added.
+func newDuplicateTestProcessor(t *testing.T, hashes map[string]string, added *models.SpiritDocument) *ImageProcessor {
+	mockDatastore := &MockDatastoreClient{
+		RunQueryFunc: func(ctx context.Context, query datastore.Query) (*datastore.PageResult, error) {
+			assert.Equal(t, "users/test_user_id/spirits", query.Collection)
+			assert.Equal(t, []string{"photoHash"}, query.Fields)
+			result := &datastore.PageResult{}
+			for id, hash := range hashes {
+				result.Documents = append(result.Documents, map[string]interface{}{"id": id, "photoHash": hash})
+			}
+			// Spirits created before photos were hashed.
+			result.Documents = append(result.Documents, map[string]interface{}{"id": "unhashed"})
+			return result, nil
+		},
+		AddDocumentFunc: func(ctx context.Context, collectionName string, data interface{}) (string, error) {
+			*added = data.(models.SpiritDocument)
+			return "new_id", nil
+		},
+	}
+	spiritDataGenerator := &MockSpiritDataGenerator{
+		GenerateSpiritDataFunc: func(base64Image *string) (*SpiritData, error) {
+			return &SpiritData{Name: "Glimmering Griffon", PrimaryType: "Sky", SecondaryType: "None"}, nil
+		},
+	}
+	imageGenerator := &MockImageGenerator{
+		GenerateImageFunc: func(prompt *string) (*GeneratedImage, error) {
+			return &GeneratedImage{Data: encodeTestImage(MimeTypePNG), MimeType: MimeTypePNG, Provider: "imagen"}, nil
+		},
+	}
+	return NewImageProcessor(&MockStorageClient{}, mockDatastore, spiritDataGenerator, imageGenerator, nil)
+}
+
+func TestProcess_RejectsDuplicatePhoto(t *testing.T) {
+	// Setup
+	scene := drawDisc(400, 300, 120, 150, 80)
+	existing := ComputePhotoHash(scene)
+	userId := "test_user_id"
+	var added models.SpiritDocument
+	ip := newDuplicateTestProcessor(t, map[string]string{
+		"other_id":   (^existing).String(),
+		"earlier_id": existing.String(),
+	}, &added)
+	ip.DuplicateCheck.Action = DuplicateActionReject
+	ip.SpiritDataGenerator = &MockSpiritDataGenerator{
+		GenerateSpiritDataFunc: func(base64Image *string) (*SpiritData, error) {
+			t.Fatal("a duplicate photo should not be analyzed")
+			return nil, nil
+		},
+	}
+
+	// Execute
+	// The same scene, snapped again at a lower quality.
+	_, err := ip.Process(encodeJPEGPhoto(scene, 60), MimeTypeJPEG, &userId)
+
+	// Assert
+	assert.ErrorIs(t, err, ErrDuplicatePhoto)
+	var duplicateErr *DuplicatePhotoError
+	assert.True(t, errors.As(err, &duplicateErr))
+	assert.Equal(t, "earlier_id", duplicateErr.SpiritID)
+	assert.Equal(t, models.SpiritDocument{}, added)
+}
+
+func TestProcess_FlagsDuplicatePhoto(t *testing.T) {
+	// Setup
+	scene := drawDisc(400, 300, 120, 150, 80)
+	userId := "test_user_id"
+	var added models.SpiritDocument
+	// Duplicates are flagged by default.
+	ip := newDuplicateTestProcessor(t, map[string]string{"earlier_id": ComputePhotoHash(scene).String()}, &added)
+
+	// Execute
+	spirit, err := ip.Process(encodeJPEGPhoto(scene, 90), MimeTypeJPEG, &userId)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "earlier_id", *spirit.DuplicateOfSpiritID)
+	assert.Equal(t, "earlier_id", added.DuplicateOfSpiritID)
+	assert.Len(t, added.PhotoHash, 16)
+}
+
+func TestProcess_StoresHashOfNewPhoto(t *testing.T) {
+	// Setup
+	scene := drawDisc(400, 300, 120, 150, 80)
+	userId := "test_user_id"
+	var added models.SpiritDocument
+	ip := newDuplicateTestProcessor(t, map[string]string{"earlier_id": ComputePhotoHash(applyOrientation(scene, orientationFlipHorizontal)).String()}, &added)
+
+	// Execute
+	spirit, err := ip.Process(encodeJPEGPhoto(scene, 90), MimeTypeJPEG, &userId)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Nil(t, spirit.DuplicateOfSpiritID)
+	assert.Empty(t, added.DuplicateOfSpiritID)
+	stored, err := ParsePhotoHash(added.PhotoHash)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, stored.Distance(ComputePhotoHash(scene)), 4)
+}
+
+func TestProcess_DuplicateCheckOff(t *testing.T) {
+	// Setup
+	scene := drawDisc(400, 300, 120, 150, 80)
+	userId := "test_user_id"
+	var added models.SpiritDocument
+	ip := newDuplicateTestProcessor(t, nil, &added)
+	ip.DatastoreClient.(*MockDatastoreClient).RunQueryFunc = func(ctx context.Context, query datastore.Query) (*datastore.PageResult, error) {
+		t.Fatal("hashes should not be queried when the check is off")
+		return nil, nil
+	}
+	ip.DuplicateCheck.Action = DuplicateActionOff
+
+	// Execute
+	_, err := ip.Process(encodeJPEGPhoto(scene, 90), MimeTypeJPEG, &userId)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NotEmpty(t, added.PhotoHash)
+}
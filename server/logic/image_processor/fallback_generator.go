@@ -0,0 +1,106 @@
+package image_processor
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// APIError is returned by generators when a provider responds with an
+// unsuccessful status code.
+type APIError struct {
+	// Name of the API, used in the error message.
+	Service    string
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s request failed with status %d: %s", e.Service, e.StatusCode, e.Body)
+}
+
+// Retryable reports whether the request may succeed if it is sent again, i.e.
+// the provider is rate limiting or failing on its side.
+func (e *APIError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+func isRetryable(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Retryable()
+}
+
+// RetryPolicy controls how often a generator is retried before falling back to
+// the next one.
+type RetryPolicy struct {
+	// Number of attempts per generator, including the first one.
+	MaxAttempts int
+	// Delay before the first retry. It doubles with each retry up to
+	// MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Second,
+		MaxBackoff:     8 * time.Second,
+	}
+}
+
+// Returns the delay before the given retry, counting from 1. Half of the delay
+// is random so that concurrent requests do not retry in lockstep.
+func (p RetryPolicy) backoff(retry int, random func() float64) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < retry && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay/2 + time.Duration(random()*float64(delay/2))
+}
+
+// FallbackImageGenerator tries a list of generators in order. Each generator is
+// retried with exponential backoff while it fails with a retryable error, then
+// the next generator is tried.
+type FallbackImageGenerator struct {
+	Generators  []ImageGenerator
+	RetryPolicy RetryPolicy
+
+	// Replaced in tests.
+	sleep  func(time.Duration)
+	random func() float64
+}
+
+func NewFallbackImageGenerator(policy RetryPolicy, generators ...ImageGenerator) *FallbackImageGenerator {
+	return &FallbackImageGenerator{
+		Generators:  generators,
+		RetryPolicy: policy,
+		sleep:       time.Sleep,
+		random:      rand.Float64,
+	}
+}
+
+func (g *FallbackImageGenerator) GenerateImage(prompt *string) (*GeneratedImage, error) {
+	var errs []error
+	for _, generator := range g.Generators {
+		for attempt := 1; ; attempt++ {
+			image, err := generator.GenerateImage(prompt)
+			if err == nil {
+				return image, nil
+			}
+			log.Printf("Image generator %T failed on attempt %d: %s", generator, attempt, err)
+			if !isRetryable(err) || attempt >= g.RetryPolicy.MaxAttempts {
+				errs = append(errs, err)
+				break
+			}
+			g.sleep(g.RetryPolicy.backoff(attempt, g.random))
+		}
+	}
+	return nil, fmt.Errorf("all image generators failed: %w", errors.Join(errs...))
+}
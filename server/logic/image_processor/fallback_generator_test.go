@@ -0,0 +1,175 @@
+package image_processor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+// Serves Imagen, Flux Pro and Flux Schnell requests with the status codes
+// queued for each provider. A provider answers successfully once its queue is
+// empty.
+type fakeProviders struct {
+	statuses map[string][]int
+	requests map[string]int
+}
+
+func (f *fakeProviders) RoundTrip(req *http.Request) (*http.Response, error) {
+	provider := ""
+	switch {
+	case strings.Contains(req.URL.Host, "aiplatform.googleapis.com"):
+		provider = "imagen"
+	case strings.HasSuffix(req.URL.Path, "/flux-1.1-pro/predictions"):
+		provider = "flux-pro"
+	case strings.HasSuffix(req.URL.Path, "/flux-schnell/predictions"):
+		provider = "flux-schnell"
+	case req.URL.String() == "https://mockdownloadurl.com":
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString("webp bytes")), Header: make(http.Header)}, nil
+	default:
+		return nil, fmt.Errorf("unknown URL: %s", req.URL.String())
+	}
+	f.requests[provider]++
+
+	if queued := f.statuses[provider]; len(queued) > 0 {
+		f.statuses[provider] = queued[1:]
+		return &http.Response{StatusCode: queued[0], Body: io.NopCloser(bytes.NewBufferString("error")), Header: make(http.Header)}, nil
+	}
+	body := map[string]string{
+		"imagen":       `{"predictions": [{"bytesBase64Encoded": "` + base64.StdEncoding.EncodeToString([]byte("png bytes")) + `"}]}`,
+		"flux-pro":     `{"output": "https://mockdownloadurl.com"}`,
+		"flux-schnell": `{"output": ["https://mockdownloadurl.com"]}`,
+	}[provider]
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(body)), Header: make(http.Header)}, nil
+}
+
+func newTestFallbackGenerator(providers *fakeProviders) (*FallbackImageGenerator, *[]time.Duration) {
+	httpClient := &http.Client{Transport: providers}
+	imagen := &ImagenGenerator{
+		ProjectID:   "test-project",
+		Model:       "imagen-test",
+		TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "test-token"}),
+		Regions:     NewRegionRouter(DefaultRegionRouterConfig()),
+		HttpClient:  httpClient,
+	}
+	generator := NewFallbackImageGenerator(
+		RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second, MaxBackoff: 3 * time.Second},
+		imagen,
+		NewFluxProGenerator("test-token", httpClient),
+		NewFluxSchnellGenerator("test-token", httpClient),
+	)
+	var sleeps []time.Duration
+	generator.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	generator.random = func() float64 { return 1 }
+	return generator, &sleeps
+}
+
+func TestFallbackImageGenerator_RetriesThenSucceeds(t *testing.T) {
+	// Setup
+	providers := &fakeProviders{
+		statuses: map[string][]int{"imagen": {http.StatusTooManyRequests, http.StatusServiceUnavailable}},
+		requests: map[string]int{},
+	}
+	generator, sleeps := newTestFallbackGenerator(providers)
+	prompt := "A griffon"
+
+	// Execute
+	image, err := generator.GenerateImage(&prompt)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "imagen", image.Provider)
+	assert.Equal(t, "image/png", image.MimeType)
+	assert.Equal(t, 3, providers.requests["imagen"])
+	assert.Zero(t, providers.requests["flux-pro"])
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, *sleeps)
+}
+
+func TestFallbackImageGenerator_FallsBackAfterRetries(t *testing.T) {
+	// Setup
+	providers := &fakeProviders{
+		statuses: map[string][]int{
+			"imagen":   {http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests},
+			"flux-pro": {http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable},
+		},
+		requests: map[string]int{},
+	}
+	generator, sleeps := newTestFallbackGenerator(providers)
+	prompt := "A griffon"
+
+	// Execute
+	image, err := generator.GenerateImage(&prompt)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "flux-schnell", image.Provider)
+	assert.Equal(t, "image/webp", image.MimeType)
+	assert.Equal(t, 3, providers.requests["imagen"])
+	assert.Equal(t, 3, providers.requests["flux-pro"])
+	assert.Equal(t, 1, providers.requests["flux-schnell"])
+	assert.Len(t, *sleeps, 4)
+}
+
+func TestFallbackImageGenerator_DoesNotRetryClientErrors(t *testing.T) {
+	// Setup
+	providers := &fakeProviders{
+		statuses: map[string][]int{"imagen": {http.StatusBadRequest}},
+		requests: map[string]int{},
+	}
+	generator, sleeps := newTestFallbackGenerator(providers)
+	prompt := "A griffon"
+
+	// Execute
+	image, err := generator.GenerateImage(&prompt)
+
+	// Assert
+	// A rejected prompt is not retried but another provider may accept it.
+	assert.NoError(t, err)
+	assert.Equal(t, "flux-pro", image.Provider)
+	assert.Equal(t, 1, providers.requests["imagen"])
+	assert.Empty(t, *sleeps)
+}
+
+func TestFallbackImageGenerator_AllFail(t *testing.T) {
+	// Setup
+	providers := &fakeProviders{
+		statuses: map[string][]int{
+			"imagen":       {http.StatusForbidden},
+			"flux-pro":     {http.StatusForbidden},
+			"flux-schnell": {http.StatusForbidden},
+		},
+		requests: map[string]int{},
+	}
+	generator, _ := newTestFallbackGenerator(providers)
+	prompt := "A griffon"
+
+	// Execute
+	_, err := generator.GenerateImage(&prompt)
+
+	// Assert
+	var apiErr *APIError
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Contains(t, err.Error(), "Google Imagen API request failed with status 403")
+	assert.Contains(t, err.Error(), "Replicate API request failed with status 403")
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	noJitter := func() float64 { return 0 }
+	fullJitter := func() float64 { return 1 }
+
+	assert.Equal(t, 500*time.Millisecond, policy.backoff(1, noJitter))
+	assert.Equal(t, time.Second, policy.backoff(1, fullJitter))
+	assert.Equal(t, 4*time.Second, policy.backoff(3, fullJitter))
+	// Capped at MaxBackoff.
+	assert.Equal(t, 5*time.Second, policy.backoff(4, fullJitter))
+	assert.Equal(t, 5*time.Second, policy.backoff(100, fullJitter))
+}
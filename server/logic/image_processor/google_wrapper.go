@@ -7,16 +7,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"os"
+	"time"
 
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
 
 // Regions currently serving imagen 3. Generated from inspecting the drop down list on:
 // https://console.cloud.google.com/vertex-ai/studio/vision?project=spirit-snap&inv=1&invt=AbmsiA
-var regions = []string{
+var DefaultImagenRegions = []string{
 	"us-central1",
 	"northamerica-northeast1",
 	"southamerica-east1",
@@ -48,24 +48,41 @@ var regions = []string{
 	"me-west1",
 }
 
-var currentRegionIndex = 0
-
-func getNextRegion() string {
-	region := regions[currentRegionIndex]
-	currentRegionIndex = (currentRegionIndex + 1) % len(regions)
-	return region
-}
-
 // Model Documentation: https://cloud.google.com/vertex-ai/generative-ai/docs/model-reference/imagen-api#model-versions
-// var modelName = "imagen-3.0-fast-generate-001"
-var modelName = "imagen-3.0-generate-001"
+// "imagen-3.0-fast-generate-001" is faster and cheaper at the cost of quality.
+const DefaultImagenModel = "imagen-3.0-generate-001"
+
+// ImagenGenerator generates images with Google Imagen on Vertex AI. Requests
+// are spread across regions to stay within per-region quotas.
+type ImagenGenerator struct {
+	ProjectID string
+	Model     string
+	// Source of the OAuth2 access tokens sent with each request.
+	TokenSource oauth2.TokenSource
+	Regions     *RegionRouter
+	HttpClient  *http.Client
+}
 
-func googleImagenGenerateImage(prompt *string, httpClient *http.Client) ([]byte, error) {
-	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT_ID")
+// NewImagenGenerator creates an ImagenGenerator that authenticates with the
+// given service account credentials.
+func NewImagenGenerator(ctx context.Context, projectID string, model string, credentialsJSON []byte, regions *RegionRouter, httpClient *http.Client) (*ImagenGenerator, error) {
 	if projectID == "" {
 		return nil, fmt.Errorf("Google Cloud project ID not set")
 	}
+	creds, err := google.CredentialsFromJSON(ctx, credentialsJSON, "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse service account credentials: %w", err)
+	}
+	return &ImagenGenerator{
+		ProjectID:   projectID,
+		Model:       model,
+		TokenSource: creds.TokenSource,
+		Regions:     regions,
+		HttpClient:  httpClient,
+	}, nil
+}
 
+func (g *ImagenGenerator) GenerateImage(prompt *string) (*GeneratedImage, error) {
 	requestBody := map[string]interface{}{
 		"instances": []map[string]interface{}{
 			{
@@ -89,33 +106,38 @@ func googleImagenGenerateImage(prompt *string, httpClient *http.Client) ([]byte,
 	if err != nil {
 		return nil, err
 	}
-	location := getNextRegion()
-	url := fmt.Sprintf("https://%s-aiplatform.googleapis.com/v1/projects/%s/locations/%s/publishers/google/models/%s:predict",
-		location, projectID, location, modelName)
+	token, err := g.TokenSource.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve access token: %w", err)
+	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	location, err := g.Regions.Acquire()
 	if err != nil {
 		return nil, err
 	}
+	url := fmt.Sprintf("https://%s-aiplatform.googleapis.com/v1/projects/%s/locations/%s/publishers/google/models/%s:predict",
+		location, g.ProjectID, location, g.Model)
 
-	// Get access token using gcloud
-	accessToken, err := GetAccessToken()
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
+		g.Regions.Report(location, 0, 0)
 		return nil, err
 	}
-
-	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := httpClient.Do(req)
+	start := time.Now()
+	resp, err := g.HttpClient.Do(req)
 	if err != nil {
+		g.Regions.Report(location, 0, time.Since(start))
 		return nil, err
 	}
 	defer resp.Body.Close()
+	g.Regions.Report(location, resp.StatusCode, time.Since(start))
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("Google Imagen API request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		return nil, &APIError{Service: "Google Imagen API", StatusCode: resp.StatusCode, Body: string(bodyBytes)}
 	}
 
 	bodyBytes, err := io.ReadAll(resp.Body)
@@ -132,31 +154,7 @@ func googleImagenGenerateImage(prompt *string, httpClient *http.Client) ([]byte,
 	if err != nil {
 		return nil, fmt.Errorf("unexpected Google Imagen API response: %s", err)
 	}
-	return imageData, nil
-}
-
-// GetAccessToken retrieves an OAuth2 access token using the Service Account credentials
-func GetAccessToken() (string, error) {
-	jsonCredentials := os.Getenv("FIREBASE_CREDENTIALS_JSON")
-	if jsonCredentials == "" {
-		log.Fatal("FIREBASE_CREDENTIALS_JSON environment variable is not set")
-	}
-
-	// Parse the credentials
-	ctx := context.Background()
-	creds, err := google.CredentialsFromJSON(ctx, []byte(jsonCredentials), "https://www.googleapis.com/auth/cloud-platform")
-	if err != nil {
-		return "", fmt.Errorf("failed to parse service account credentials: %w", err)
-	}
-
-	// Retrieve the token
-	tokenSource := creds.TokenSource
-	token, err := tokenSource.Token()
-	if err != nil {
-		return "", fmt.Errorf("failed to retrieve access token: %w", err)
-	}
-
-	return token.AccessToken, nil
+	return &GeneratedImage{Data: imageData, MimeType: "image/png", Provider: "imagen"}, nil
 }
 
 func getImageFromGoogleResponse(result map[string]interface{}) ([]byte, error) {
@@ -0,0 +1,73 @@
+package image_processor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+func TestImagenGenerator_GenerateImage(t *testing.T) {
+	// Setup
+	var req *http.Request
+	imageData := base64.StdEncoding.EncodeToString([]byte("png bytes"))
+	generator := &ImagenGenerator{
+		ProjectID:   "test-project",
+		Model:       "imagen-test",
+		TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "test-token"}),
+		Regions:     NewRegionRouter(DefaultRegionRouterConfig()),
+		HttpClient: &http.Client{Transport: &MockRoundTripper{
+			RoundTripFunc: func(r *http.Request) (*http.Response, error) {
+				req = r
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewBufferString(`{"predictions": [{"bytesBase64Encoded": "` + imageData + `"}]}`)),
+					Header:     make(http.Header),
+				}, nil
+			},
+		}},
+	}
+	prompt := "A griffon"
+
+	// Execute
+	image, err := generator.GenerateImage(&prompt)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("png bytes"), image.Data)
+	assert.Equal(t, "image/png", image.MimeType)
+	assert.True(t, strings.HasPrefix(req.URL.Path, "/v1/projects/test-project/locations/"), req.URL.String())
+	assert.True(t, strings.HasSuffix(req.URL.Path, "/publishers/google/models/imagen-test:predict"), req.URL.String())
+	assert.Equal(t, "Bearer test-token", req.Header.Get("Authorization"))
+}
+
+func TestImagenGenerator_Error(t *testing.T) {
+	// Setup
+	generator := &ImagenGenerator{
+		ProjectID:   "test-project",
+		Model:       "imagen-test",
+		TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "test-token"}),
+		Regions:     NewRegionRouter(DefaultRegionRouterConfig()),
+		HttpClient: &http.Client{Transport: &MockRoundTripper{
+			RoundTripFunc: func(r *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusTooManyRequests,
+					Body:       io.NopCloser(bytes.NewBufferString("quota exceeded")),
+					Header:     make(http.Header),
+				}, nil
+			},
+		}},
+	}
+	prompt := "A griffon"
+
+	// Execute
+	_, err := generator.GenerateImage(&prompt)
+
+	// Assert
+	assert.EqualError(t, err, "Google Imagen API request failed with status 429: quota exceeded")
+}
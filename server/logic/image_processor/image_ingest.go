@@ -0,0 +1,191 @@
+package image_processor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/url"
+	"strings"
+
+	"github.com/gen2brain/heic"
+	_ "golang.org/x/image/webp"
+)
+
+// ErrInvalidImage is returned when an uploaded photo cannot be read. Errors
+// wrapping it are caused by the client's input and are *UploadError values.
+var ErrInvalidImage = errors.New("invalid image")
+
+// MIME types of the image formats that can be recognized from their content.
+const (
+	MimeTypeJPEG = "image/jpeg"
+	MimeTypePNG  = "image/png"
+	MimeTypeGIF  = "image/gif"
+	MimeTypeWebP = "image/webp"
+	MimeTypeHEIC = "image/heic"
+	MimeTypeAVIF = "image/avif"
+)
+
+// File extensions of the image formats that can be recognized.
+var fileExtensions = map[string]string{
+	MimeTypeJPEG: "jpeg",
+	MimeTypePNG:  "png",
+	MimeTypeGIF:  "gif",
+	MimeTypeWebP: "webp",
+	MimeTypeHEIC: "heic",
+	MimeTypeAVIF: "avif",
+}
+
+// Formats that images can be transcoded to for storage.
+var storageFormats = map[string]bool{
+	MimeTypeJPEG: true,
+	MimeTypePNG:  true,
+}
+
+// Quality used when transcoding to JPEG.
+const jpegQuality = 90
+
+// Image is an image whose format has been detected from its content.
+type Image struct {
+	Data     []byte
+	MimeType string
+}
+
+// Extension returns the file extension for the image's format.
+func (img *Image) Extension() string {
+	return fileExtensions[img.MimeType]
+}
+
+// Decode decodes the image. HEIC photos are decoded by libheif compiled to
+// WebAssembly, which applies their rotation and mirroring. AVIF has no
+// decoder, so AVIF images fail to decode.
+func (img *Image) Decode() (image.Image, error) {
+	if img.MimeType == MimeTypeHEIC {
+		// The heic package only registers the "heic" brand with the image
+		// package, while phones also write "mif1" and others.
+		return heic.Decode(bytes.NewReader(img.Data))
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(img.Data))
+	return decoded, err
+}
+
+// DecodeConfig reads the image's dimensions without decoding it.
+func (img *Image) DecodeConfig() (image.Config, error) {
+	if img.MimeType == MimeTypeHEIC {
+		return heic.DecodeConfig(bytes.NewReader(img.Data))
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(img.Data))
+	return config, err
+}
+
+// DataURL returns the image as a base64 data URL.
+func (img *Image) DataURL() string {
+	return "data:" + img.MimeType + ";base64," + base64.StdEncoding.EncodeToString(img.Data)
+}
+
+// ParseDataURL decodes a data URL such as "data:image/png;base64,iVBORw0...".
+// The media type in the URL is ignored since clients often get it wrong; the
+// format is detected from the data instead. Bare base64 without the "data:"
+// prefix is accepted for older clients.
+func ParseDataURL(dataURL string) (*Image, error) {
+	var data []byte
+	var err error
+	if rest, ok := strings.CutPrefix(dataURL, "data:"); ok {
+		header, payload, found := strings.Cut(rest, ",")
+		if !found {
+			return nil, newUploadError(UploadErrorInvalidDataURL, "data URL has no data")
+		}
+		if strings.HasSuffix(header, ";base64") {
+			data, err = decodeBase64(payload)
+		} else {
+			var unescaped string
+			unescaped, err = url.PathUnescape(payload)
+			data = []byte(unescaped)
+		}
+	} else {
+		data, err = decodeBase64(dataURL)
+	}
+	if err != nil {
+		return nil, newUploadError(UploadErrorInvalidDataURL, "%v", err)
+	}
+	return DetectImage(data)
+}
+
+// Accepts both padded and unpadded base64, ignoring line breaks.
+func decodeBase64(payload string) ([]byte, error) {
+	payload = strings.NewReplacer("\n", "", "\r", "", " ", "").Replace(payload)
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+}
+
+// DetectImage identifies the format of data from its magic bytes.
+func DetectImage(data []byte) (*Image, error) {
+	mimeType := sniffImageType(data)
+	if mimeType == "" {
+		return nil, newUploadError(UploadErrorUnsupportedFormat, "unrecognized image format")
+	}
+	return &Image{Data: data, MimeType: mimeType}, nil
+}
+
+func sniffImageType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return MimeTypeJPEG
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return MimeTypePNG
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return MimeTypeGIF
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return MimeTypeWebP
+	case len(data) >= 12 && string(data[4:8]) == "ftyp":
+		// HEIF based formats start with an ftyp box naming the major brand.
+		switch string(data[8:12]) {
+		case "heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1":
+			return MimeTypeHEIC
+		case "avif", "avis":
+			return MimeTypeAVIF
+		}
+	}
+	return ""
+}
+
+// Transcoder converts images to a single storage format so that every stored
+// object can be served the same way.
+type Transcoder struct {
+	// MIME type to store images as, either "image/jpeg" or "image/png". Empty
+	// keeps images in the format they arrive in.
+	StorageFormat string
+}
+
+func NewTranscoder(storageFormat string) (*Transcoder, error) {
+	if storageFormat != "" && !storageFormats[storageFormat] {
+		return nil, fmt.Errorf("unsupported storage format %q", storageFormat)
+	}
+	return &Transcoder{StorageFormat: storageFormat}, nil
+}
+
+// Transcode returns img in the storage format. Images that are already in
+// that format are returned unchanged.
+func (t *Transcoder) Transcode(img *Image) (*Image, error) {
+	if t == nil || t.StorageFormat == "" || img.MimeType == t.StorageFormat {
+		return img, nil
+	}
+	decoded, err := img.Decode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", img.MimeType, err)
+	}
+	var buf bytes.Buffer
+	switch t.StorageFormat {
+	case MimeTypeJPEG:
+		err = jpeg.Encode(&buf, decoded, &jpeg.Options{Quality: jpegQuality})
+	case MimeTypePNG:
+		err = png.Encode(&buf, decoded)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %v", t.StorageFormat, err)
+	}
+	return &Image{Data: buf.Bytes(), MimeType: t.StorageFormat}, nil
+}
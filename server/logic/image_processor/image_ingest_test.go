@@ -0,0 +1,174 @@
+package image_processor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A 1x1 lossless WebP image.
+const testWebP = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+// Returns a small image encoded in the given format.
+func encodeTestImage(mimeType string) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 60), G: uint8(y * 60), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	switch mimeType {
+	case MimeTypeJPEG:
+		jpeg.Encode(&buf, img, nil)
+	case MimeTypePNG:
+		png.Encode(&buf, img)
+	case MimeTypeGIF:
+		gif.Encode(&buf, img, nil)
+	case MimeTypeWebP:
+		data, _ := base64.StdEncoding.DecodeString(testWebP)
+		return data
+	}
+	return buf.Bytes()
+}
+
+func testDataURL(mimeType string) string {
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(encodeTestImage(mimeType))
+}
+
+func TestParseDataURL(t *testing.T) {
+	jpegData := encodeTestImage(MimeTypeJPEG)
+	encoded := base64.StdEncoding.EncodeToString(jpegData)
+	heic := append([]byte{0, 0, 0, 24}, []byte("ftypheic\x00\x00\x00\x00mif1heic")...)
+
+	tests := []struct {
+		name     string
+		dataURL  string
+		mimeType string
+		data     []byte
+	}{
+		{"jpeg", "data:image/jpeg;base64," + encoded, MimeTypeJPEG, jpegData},
+		{"non-standard jpg media type", "data:image/jpg;base64," + encoded, MimeTypeJPEG, jpegData},
+		{"wrong media type", "data:image/png;base64," + encoded, MimeTypeJPEG, jpegData},
+		{"bare base64", encoded, MimeTypeJPEG, jpegData},
+		{"unpadded base64", "data:image/jpeg;base64," + base64.RawStdEncoding.EncodeToString(jpegData), MimeTypeJPEG, jpegData},
+		{"media type parameters", "data:image/jpeg;name=photo.jpg;base64," + encoded, MimeTypeJPEG, jpegData},
+		{"png", testDataURL(MimeTypePNG), MimeTypePNG, encodeTestImage(MimeTypePNG)},
+		{"gif", testDataURL(MimeTypeGIF), MimeTypeGIF, encodeTestImage(MimeTypeGIF)},
+		{"webp", testDataURL(MimeTypeWebP), MimeTypeWebP, encodeTestImage(MimeTypeWebP)},
+		{"heic", "data:image/heic;base64," + base64.StdEncoding.EncodeToString(heic), MimeTypeHEIC, heic},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := ParseDataURL(tt.dataURL)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.mimeType, img.MimeType)
+			assert.Equal(t, tt.data, img.Data)
+		})
+	}
+}
+
+func TestParseDataURL_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		dataURL string
+	}{
+		{"empty", ""},
+		{"no data", "data:image/jpeg;base64"},
+		{"bad base64", "data:image/jpeg;base64,!!!"},
+		{"not an image", "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString([]byte("test_base64_image_data"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseDataURL(tt.dataURL)
+
+			assert.ErrorIs(t, err, ErrInvalidImage)
+		})
+	}
+}
+
+func TestImage_DataURL(t *testing.T) {
+	img := &Image{Data: []byte("abc"), MimeType: MimeTypePNG}
+
+	assert.Equal(t, "data:image/png;base64,YWJj", img.DataURL())
+	assert.Equal(t, "png", img.Extension())
+}
+
+func TestTranscoder_Transcode(t *testing.T) {
+	transcoder, err := NewTranscoder(MimeTypeJPEG)
+	assert.NoError(t, err)
+
+	for _, mimeType := range []string{MimeTypePNG, MimeTypeGIF, MimeTypeWebP} {
+		t.Run(mimeType, func(t *testing.T) {
+			img, err := transcoder.Transcode(&Image{Data: encodeTestImage(mimeType), MimeType: mimeType})
+
+			assert.NoError(t, err)
+			assert.Equal(t, MimeTypeJPEG, img.MimeType)
+			_, err = jpeg.Decode(bytes.NewReader(img.Data))
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestTranscoder_KeepsImages(t *testing.T) {
+	pngTranscoder, _ := NewTranscoder(MimeTypePNG)
+	photo := &Image{Data: encodeTestImage(MimeTypePNG), MimeType: MimeTypePNG}
+
+	tests := []struct {
+		name       string
+		transcoder *Transcoder
+		img        *Image
+	}{
+		{"no transcoder", nil, photo},
+		{"no storage format", &Transcoder{}, photo},
+		{"already in storage format", pngTranscoder, photo},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := tt.transcoder.Transcode(tt.img)
+
+			assert.NoError(t, err)
+			assert.Same(t, tt.img, img)
+		})
+	}
+}
+
+func TestTranscoder_HEIC(t *testing.T) {
+	transcoder, _ := NewTranscoder(MimeTypeJPEG)
+	heic, err := os.ReadFile("testdata/photo.heic")
+	assert.NoError(t, err)
+
+	img, err := transcoder.Transcode(&Image{Data: heic, MimeType: MimeTypeHEIC})
+
+	assert.NoError(t, err)
+	assert.Equal(t, MimeTypeJPEG, img.MimeType)
+	config, err := jpeg.DecodeConfig(bytes.NewReader(img.Data))
+	assert.NoError(t, err)
+	assert.Equal(t, 512, config.Width)
+}
+
+func TestTranscoder_CorruptImage(t *testing.T) {
+	transcoder, _ := NewTranscoder(MimeTypePNG)
+	// Starts like a JPEG but is truncated.
+	corrupt := &Image{Data: encodeTestImage(MimeTypeJPEG)[:20], MimeType: MimeTypeJPEG}
+
+	_, err := transcoder.Transcode(corrupt)
+
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrInvalidImage)
+}
+
+func TestNewTranscoder_UnsupportedFormat(t *testing.T) {
+	_, err := NewTranscoder(MimeTypeWebP)
+
+	assert.Error(t, err)
+}
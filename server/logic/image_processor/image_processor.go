@@ -3,12 +3,12 @@ package image_processor
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
+	"log"
 	"math/rand"
-	"net/http"
+	"spirit-snap/server/logic/spirit_types"
 	"spirit-snap/server/models"
-	"strings"
+	"spirit-snap/server/wrappers/datastore"
 	"time"
 )
 
@@ -35,9 +35,42 @@ type SpiritData struct {
 }
 
 type ImageProcessor struct {
-	StorageClient   StorageInterface
-	DatastoreClient DatastoreInterface
-	HttpClient      *http.Client
+	StorageClient       StorageInterface
+	DatastoreClient     DatastoreInterface
+	SpiritDataGenerator SpiritDataGenerator
+	ImageGenerator      ImageGenerator
+	// Converts the photo and generated image before they are stored. May be
+	// nil to store images as they are.
+	Transcoder *Transcoder
+	// Sizes that generated images are resized to for clients that do not need
+	// the full image.
+	RenditionSizes []int
+	// Uploaded photos are scaled down to at most this many pixels along their
+	// longest side before they are analyzed or stored.
+	MaxPhotoDimension int
+	// How photos that duplicate one of the user's earlier photos are handled.
+	DuplicateCheck DuplicateCheck
+}
+
+// SpiritDataGenerator creates a spirit's data from a photo. Implementations
+// wrap a vision capable language model.
+type SpiritDataGenerator interface {
+	GenerateSpiritData(base64Image *string) (*SpiritData, error)
+}
+
+// GeneratedImage is an image created by an ImageGenerator.
+type GeneratedImage struct {
+	Data []byte
+	// MIME type of Data, e.g. "image/png".
+	MimeType string
+	// Name of the service that created the image, e.g. "imagen".
+	Provider string
+}
+
+// ImageGenerator creates a spirit's image from a text prompt. Implementations
+// wrap an image generation model.
+type ImageGenerator interface {
+	GenerateImage(prompt *string) (*GeneratedImage, error)
 }
 
 // StorageInterface defines an interface for interacting with Storeage Wrapper.
@@ -52,18 +85,20 @@ type DatastoreInterface interface {
 	AddDocument(ctx context.Context, collectionName string, data interface{}) (string, error)
 	GetDocumentsByIds(ctx context.Context, collectionName string, ids []string) ([]map[string]interface{}, error)
 	GetDocumentsFilteredByValue(ctx context.Context, collectionName string, fieldName string, value any) ([]map[string]interface{}, error)
+	RunQuery(ctx context.Context, query datastore.Query) (*datastore.PageResult, error)
 	Close() error
 }
 
-func NewImageProcessor(storage StorageInterface, ds DatastoreInterface, rt http.RoundTripper) *ImageProcessor {
-	// To idiomatically mock HTTP clients, you mock the connectivity component i.e. the RoundTripper which makes the network calls.
-	httpClient := &http.Client{
-		Transport: rt,
-	}
+func NewImageProcessor(storage StorageInterface, ds DatastoreInterface, spiritDataGenerator SpiritDataGenerator, imageGenerator ImageGenerator, transcoder *Transcoder) *ImageProcessor {
 	return &ImageProcessor{
-		StorageClient:   storage,
-		DatastoreClient: ds,
-		HttpClient:      httpClient,
+		StorageClient:       storage,
+		DatastoreClient:     ds,
+		SpiritDataGenerator: spiritDataGenerator,
+		ImageGenerator:      imageGenerator,
+		Transcoder:          transcoder,
+		RenditionSizes:      DefaultRenditionSizes,
+		MaxPhotoDimension:   DefaultMaxPhotoDimension,
+		DuplicateCheck:      DefaultDuplicateCheck(),
 	}
 }
 
@@ -71,41 +106,128 @@ func (ip *ImageProcessor) Close() {
 	ip.DatastoreClient.Close()
 }
 
+// Stage is a step of the spirit creation pipeline.
+type Stage string
+
+const (
+	// The photo is being analyzed to generate the spirit's data.
+	StageAnalyzing Stage = "analyzing"
+	// The spirit's image is being generated.
+	StageGeneratingImage Stage = "generating_image"
+	// The images and spirit document are being stored.
+	StageUploading Stage = "uploading"
+)
+
+// EventType identifies what a ProgressEvent reports.
+type EventType string
+
+const (
+	// A new stage has started. Only ProgressEvent.Stage is set.
+	EventStage EventType = "stage"
+	// The spirit's name, description, types and stats are known.
+	EventSpiritData EventType = "spirit_data"
+	// The spirit's move set has been chosen.
+	EventMoves EventType = "moves"
+	// The generated image has been stored and its URL is known.
+	EventGeneratedImage EventType = "generated_image"
+	// The spirit has been saved. The event holds the complete spirit.
+	EventSpirit EventType = "spirit"
+)
+
+// ProgressEvent is reported by ProcessWithProgress as the pipeline advances.
+type ProgressEvent struct {
+	Type  EventType
+	Stage Stage
+	// Everything known about the spirit so far. Each event adds to the spirit
+	// of the previous one. Nil for stage events.
+	Spirit *models.Spirit
+}
+
+// ProgressFunc receives the events of ProcessWithProgress. It is called on the
+// goroutine running the pipeline. Returning an error stops the pipeline, which
+// returns the error. The spirit is saved just before EventSpirit, so an error
+// returned for that event is ignored.
+type ProgressFunc func(event ProgressEvent) error
+
 // This is the implementation for the processImage endpoint. It will be called
 // at high QPS.
-func (ip *ImageProcessor) Process(base64Image *string, userId *string) (models.Spirit, error) {
+//
+// photo holds a JPEG, PNG, GIF, WebP or HEIC image and contentType is the type
+// the client sent it as. The format is detected from the data, so contentType
+// only serves to explain rejected photos. Photos that cannot be read return an
+// error wrapping ErrInvalidImage, and photos rejected by the DuplicateCheck
+// return an error wrapping ErrDuplicatePhoto.
+func (ip *ImageProcessor) Process(photo []byte, contentType string, userId *string) (models.Spirit, error) {
+	return ip.ProcessWithProgress(photo, contentType, userId, nil)
+}
+
+// ProcessWithProgress is Process with a callback that reports each stage as it
+// starts and each part of the spirit as soon as it is available. onProgress
+// may be nil.
+func (ip *ImageProcessor) ProcessWithProgress(photoData []byte, contentType string, userId *string, onProgress ProgressFunc) (models.Spirit, error) {
 	ctx := context.Background()
-	doc := make(map[string]interface{})
+	report := func(event ProgressEvent) error {
+		if onProgress == nil {
+			return nil
+		}
+		return onProgress(event)
+	}
+	reportStage := func(stage Stage) error {
+		return report(ProgressEvent{Type: EventStage, Stage: stage})
+	}
+	// Copies the partial spirit so that later changes do not affect events
+	// that have already been reported.
+	reportSpirit := func(eventType EventType, spirit models.Spirit) error {
+		return report(ProgressEvent{Type: eventType, Spirit: &spirit})
+	}
+	photo, err := DetectImage(photoData)
+	if err != nil {
+		return models.Spirit{}, fmt.Errorf("photo sent as %q: %w", contentType, err)
+	}
+	// Only the sanitized photo is used from here on, so the original's
+	// metadata never leaves this function.
+	photo, err = SanitizePhoto(photo, ip.MaxPhotoDimension)
+	if err != nil {
+		return models.Spirit{}, err
+	}
+	// Checked before anything is generated so that rejected duplicates cost
+	// nothing.
+	hash, err := hashPhoto(photo)
+	if err != nil {
+		return models.Spirit{}, err
+	}
+	duplicate, err := ip.findDuplicate(ctx, *userId, hash)
+	if err != nil {
+		return models.Spirit{}, err
+	}
+	if duplicate != nil && ip.DuplicateCheck.Action == DuplicateActionReject {
+		return models.Spirit{}, duplicate
+	}
 	// ISO 8601 Timestamp (human-readable UTC date and time)
 	timestamp := time.Now().UTC().Format(time.RFC3339)
-	doc["imageTimestamp"] = timestamp
 
-	originalFilename := fmt.Sprintf("%s-original.jpeg", timestamp)
-	generatedFilename := fmt.Sprintf("%s-generated.webp", timestamp)
-
-	// Step 1: Get the image caption from OpenAI
-	spiritData, err := ip.generateSpiritData(base64Image)
+	// Step 1: Generate the spirit's data from the photo. The model is sent a
+	// data URL with the photo's real media type.
+	if err := reportStage(StageAnalyzing); err != nil {
+		return models.Spirit{}, err
+	}
+	visionInput := photo.DataURL()
+	spiritData, err := ip.generateSpiritData(&visionInput)
 	if err != nil || spiritData == nil {
 		return models.Spirit{}, err
 	}
-	doc["name"] = spiritData.Name
-	doc["description"] = spiritData.Description
-	doc["imageGenerationPrompt"] = spiritData.ImageGenerationPrompt
-	doc["photoObject"] = spiritData.PhotoObject
-	doc["primaryType"] = spiritData.PrimaryType
-	doc["secondaryType"] = spiritData.SecondaryType
-	doc["height"] = spiritData.Height
-	doc["weight"] = spiritData.Weight
-	doc["strength"] = spiritData.Strength
-	doc["toughness"] = spiritData.Toughness
-	doc["agility"] = spiritData.Agility
-	doc["arcana"] = spiritData.Arcana
-	doc["aura"] = spiritData.Aura
-	doc["charisma"] = spiritData.Charisma
-	doc["intimidation"] = spiritData.Intimidation
-	doc["endurance"] = spiritData.Endurance
-	doc["luck"] = spiritData.Luck
-	doc["hitPoints"] = spiritData.HitPoints
+	doc := newSpiritDocument(spiritData)
+	doc.ImageTimestamp = timestamp
+	doc.PhotoHash = hash.String()
+	if duplicate != nil {
+		doc.DuplicateOfSpiritID = duplicate.SpiritID
+	}
+	// The document has no move or image fields yet, so this does not make
+	// any requests.
+	partial := models.BuildSpirit(ctx, ip.StorageClient, doc, ip.DatastoreClient)
+	if err := reportSpirit(EventSpiritData, partial); err != nil {
+		return models.Spirit{}, err
+	}
 
 	// Determine the Spirit's Move set.
 	primaryTypePossibleMoves, err := ip.DatastoreClient.GetDocumentsFilteredByValue(ctx, "moves", "type", spiritData.PrimaryType)
@@ -113,13 +235,22 @@ func (ip *ImageProcessor) Process(base64Image *string, userId *string) (models.S
 		return models.Spirit{}, err
 	}
 	var secondaryTypePossibleMoves []map[string]interface{}
-	if spiritData.SecondaryType != "None" {
+	if spiritData.SecondaryType != string(spirit_types.None) {
 		secondaryTypePossibleMoves, err = ip.DatastoreClient.GetDocumentsFilteredByValue(ctx, "moves", "type", spiritData.SecondaryType)
 		if err != nil {
 			return models.Spirit{}, err
 		}
 	}
 
+	// Index the candidates before selection since selectRandomMoves reorders
+	// the slices it is given.
+	candidateMoves := make(map[string]map[string]interface{})
+	for _, move := range append(append([]map[string]interface{}{}, primaryTypePossibleMoves...), secondaryTypePossibleMoves...) {
+		if moveID, ok := move["id"].(string); ok {
+			candidateMoves[moveID] = move
+		}
+	}
+
 	// Randomly select moves from primary and secondary types
 	var selectedMoves []string
 
@@ -133,58 +264,150 @@ func (ip *ImageProcessor) Process(base64Image *string, userId *string) (models.S
 	} else if len(primaryTypePossibleMoves) > 0 {
 		selectedMoves = append(selectedMoves, selectRandomMoves(primaryTypePossibleMoves, 2)...)
 	}
-	doc["moveIds"] = selectedMoves
+	doc.MoveIDs = selectedMoves
+	partial.Moves = nil
+	for _, moveID := range selectedMoves {
+		partial.Moves = append(partial.Moves, models.BuildMovefromDocData(candidateMoves[moveID]))
+	}
+	if err := reportSpirit(EventMoves, partial); err != nil {
+		return models.Spirit{}, err
+	}
 
-	// Step 2: Generate cartoon monster image using Replicate
-	generatedImage, err := ip.createSpiritImage(&spiritData.ImageGenerationPrompt)
+	// Step 2: Generate cartoon monster image
+	if err := reportStage(StageGeneratingImage); err != nil {
+		return models.Spirit{}, err
+	}
+	generatedImage, err := ip.ImageGenerator.GenerateImage(&spiritData.ImageGenerationPrompt)
 	if err != nil {
 		return models.Spirit{}, err
 	}
+	doc.ImageProvider = generatedImage.Provider
+	// Generators do not always return the format they were asked for, so the
+	// format is detected from the data rather than taken from MimeType.
+	generated, err := DetectImage(generatedImage.Data)
+	if err != nil {
+		return models.Spirit{}, fmt.Errorf("%s returned an unreadable image: %v", generatedImage.Provider, err)
+	}
+	generated, err = ip.Transcoder.Transcode(generated)
+	if err != nil {
+		return models.Spirit{}, err
+	}
+	generatedFilename := fmt.Sprintf("%s-generated.%s", timestamp, generated.Extension())
 
-	const origPrefix = "data:image/jpg;base64,"
-	trimmedBase64Image := strings.TrimPrefix(*base64Image, origPrefix)
-
-	// Decode the base64-encoded image data
-	decodedOrigImageData, err := base64.StdEncoding.DecodeString(trimmedBase64Image)
+	original, err := ip.Transcoder.Transcode(photo)
 	if err != nil {
-		return models.Spirit{}, fmt.Errorf("failed to decode original base64 image data: %v", err)
+		return models.Spirit{}, err
 	}
+	originalFilename := fmt.Sprintf("%s-original.%s", timestamp, original.Extension())
 
 	// Step 3: Upload results to Firebase Storage and Firestore
-	origFilePath := "photos/" + *userId + "/" + originalFilename
-	if err := ip.StorageClient.Write(ctx, "spirit-snap.appspot.com", origFilePath, []byte(decodedOrigImageData), "image/jpeg"); err != nil {
+	if err := reportStage(StageUploading); err != nil {
 		return models.Spirit{}, err
 	}
-
+	// The generated image is uploaded first so that it can be shown as soon
+	// as possible.
 	genFilePath := "generatedImages/" + *userId + "/" + generatedFilename
-	if err := ip.StorageClient.Write(ctx, "spirit-snap.appspot.com", genFilePath, generatedImage, "image/webp"); err != nil {
+	if err := ip.StorageClient.Write(ctx, "spirit-snap.appspot.com", genFilePath, generated.Data, generated.MimeType); err != nil {
 		return models.Spirit{}, err
 	}
-	doc["originalImageFilePath"] = origFilePath
-	doc["generatedImageFilePath"] = genFilePath
+	if onProgress != nil {
+		generatedUrl, err := ip.StorageClient.GetDownloadURL(ctx, "spirit-snap.appspot.com", genFilePath)
+		if err != nil {
+			return models.Spirit{}, err
+		}
+		partial.GeneratedImageURL = &generatedUrl
+		if err := reportSpirit(EventGeneratedImage, partial); err != nil {
+			return models.Spirit{}, err
+		}
+	}
+
+	renditions, err := CreateRenditions(generated, ip.RenditionSizes)
+	if err != nil {
+		return models.Spirit{}, err
+	}
+	renditionFilePaths := make(map[string]string, len(renditions.Images))
+	for size, rendition := range renditions.Images {
+		renditionFilePath := fmt.Sprintf("generatedImages/%s/%s-generated-%d.%s", *userId, timestamp, size, rendition.Extension())
+		if err := ip.StorageClient.Write(ctx, "spirit-snap.appspot.com", renditionFilePath, rendition.Data, rendition.MimeType); err != nil {
+			return models.Spirit{}, err
+		}
+		renditionFilePaths[RenditionKey(size)] = renditionFilePath
+	}
+	doc.GeneratedImageRenditionFilePaths = renditionFilePaths
+	// The placeholder is a few hundred bytes, so it is stored inline where
+	// the client gets it without another request.
+	doc.GeneratedImagePlaceholder = renditions.Placeholder.DataURL()
+
+	origFilePath := "photos/" + *userId + "/" + originalFilename
+	if err := ip.StorageClient.Write(ctx, "spirit-snap.appspot.com", origFilePath, original.Data, original.MimeType); err != nil {
+		return models.Spirit{}, err
+	}
+	doc.OriginalImageFilePath = origFilePath
+	doc.GeneratedImageFilePath = genFilePath
 
 	docId, err := ip.DatastoreClient.AddDocument(ctx, "users/"+*userId+"/spirits", doc)
 	if err != nil {
 		return models.Spirit{}, err
 	}
 	// log.Printf("Generated image data: %+v", doc)
-	doc["id"] = docId
-	spirit := models.BuildSpiritfromDocData(ctx, ip.StorageClient, doc, ip.DatastoreClient)
+	doc.ID = docId
+	spirit := models.BuildSpirit(ctx, ip.StorageClient, doc, ip.DatastoreClient)
 	fmt.Printf("Move Names:\n")
 	for _, move := range spirit.Moves {
 		fmt.Printf("- %s\n", *move.Name)
 	}
+	reportSpirit(EventSpirit, spirit)
 	return spirit, nil
 }
 
+// Returns the document of a new spirit with the given data. The caller adds
+// the moves, images and photo details as they become known.
+func newSpiritDocument(data *SpiritData) models.SpiritDocument {
+	return models.SpiritDocument{
+		SchemaVersion:         models.CurrentSpiritSchemaVersion,
+		Name:                  data.Name,
+		Description:           data.Description,
+		ImageGenerationPrompt: data.ImageGenerationPrompt,
+		PhotoObject:           data.PhotoObject,
+		PrimaryType:           data.PrimaryType,
+		SecondaryType:         data.SecondaryType,
+		Height:                &data.Height,
+		Weight:                &data.Weight,
+		Strength:              &data.Strength,
+		Toughness:             &data.Toughness,
+		Agility:               &data.Agility,
+		Arcana:                &data.Arcana,
+		Aura:                  &data.Aura,
+		Charisma:              &data.Charisma,
+		Intimidation:          &data.Intimidation,
+		Endurance:             &data.Endurance,
+		Luck:                  &data.Luck,
+		HitPoints:             &data.HitPoints,
+	}
+}
+
 func (ip *ImageProcessor) generateSpiritData(base64Image *string) (*SpiritData, error) {
-	// "model": "gpt-4o-2024-08-06",
-	// "model": "gpt-4o-2024-11-20",
-	model := "gpt-4o-2024-11-20"
-	spiritData, err := openAiGenerateSpirit(&model, base64Image, ip.HttpClient)
+	spiritData, err := ip.SpiritDataGenerator.GenerateSpiritData(base64Image)
 	if err != nil {
 		return nil, err
 	}
+	// Servers that do not enforce the response schema can return types in
+	// another case or types that do not exist. Moves are chosen by type, so
+	// the types must be canonical before they are stored.
+	primaryType, ok := spirit_types.Parse(spiritData.PrimaryType)
+	if !ok || !primaryType.Valid() {
+		return nil, fmt.Errorf("spirit data has unknown primary type %q", spiritData.PrimaryType)
+	}
+	secondaryType, ok := spirit_types.Parse(spiritData.SecondaryType)
+	if !ok {
+		log.Printf("Spirit data has unknown secondary type %q, using None", spiritData.SecondaryType)
+		secondaryType = spirit_types.None
+	}
+	if secondaryType == primaryType {
+		secondaryType = spirit_types.None
+	}
+	spiritData.PrimaryType = string(primaryType)
+	spiritData.SecondaryType = string(secondaryType)
 	fmt.Printf("Spirit Data:\n"+
 		"Name: %s\n"+
 		"Description: %s\n"+
@@ -226,11 +449,6 @@ func (ip *ImageProcessor) generateSpiritData(base64Image *string) (*SpiritData,
 	return spiritData, nil
 }
 
-func (ip *ImageProcessor) createSpiritImage(prompt *string) ([]byte, error) {
-	// return replicatePro1_1GenerateImage(prompt, ip.HttpClient)
-	return googleImagenGenerateImage(prompt, ip.HttpClient)
-}
-
 func selectRandomMoves(possibleMoves []map[string]interface{}, count int) []string {
 	var selectedMoves []string
 	rand.Seed(time.Now().UnixNano())
@@ -5,9 +5,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"image"
 	"io"
 	"net/http"
-	"os"
+	"spirit-snap/server/models"
+	"spirit-snap/server/wrappers/datastore"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -23,9 +26,11 @@ func (m *MockRoundTripper) RoundTrip(req *http.Request) (*http.Response, error)
 }
 
 type MockDatastoreClient struct {
-	AddDocumentFunc       func(ctx context.Context, collectionName string, data interface{}) (string, error)
-	GetDocumentsByIdsFunc func(ctx context.Context, collectionName string, ids []string) ([]map[string]interface{}, error)
-	CloseFunc             func() error
+	AddDocumentFunc                 func(ctx context.Context, collectionName string, data interface{}) (string, error)
+	GetDocumentsByIdsFunc           func(ctx context.Context, collectionName string, ids []string) ([]map[string]interface{}, error)
+	GetDocumentsFilteredByValueFunc func(ctx context.Context, collectionName string, fieldName string, value any) ([]map[string]interface{}, error)
+	RunQueryFunc                    func(ctx context.Context, query datastore.Query) (*datastore.PageResult, error)
+	CloseFunc                       func() error
 }
 
 func (m *MockDatastoreClient) AddDocument(ctx context.Context, collectionName string, data interface{}) (string, error) {
@@ -42,6 +47,20 @@ func (m *MockDatastoreClient) GetDocumentsByIds(ctx context.Context, collectionN
 	return m.GetDocumentsByIdsFunc(ctx, collectionName, ids)
 }
 
+func (m *MockDatastoreClient) GetDocumentsFilteredByValue(ctx context.Context, collectionName string, fieldName string, value any) ([]map[string]interface{}, error) {
+	if m.GetDocumentsFilteredByValueFunc == nil {
+		return nil, nil
+	}
+	return m.GetDocumentsFilteredByValueFunc(ctx, collectionName, fieldName, value)
+}
+
+func (m *MockDatastoreClient) RunQuery(ctx context.Context, query datastore.Query) (*datastore.PageResult, error) {
+	if m.RunQueryFunc == nil {
+		return &datastore.PageResult{}, nil
+	}
+	return m.RunQueryFunc(ctx, query)
+}
+
 func (m *MockDatastoreClient) Close() error {
 	if m.CloseFunc != nil {
 		return m.CloseFunc()
@@ -49,6 +68,30 @@ func (m *MockDatastoreClient) Close() error {
 	return nil
 }
 
+func newTestSpiritDataGenerator(rt http.RoundTripper) SpiritDataGenerator {
+	return NewOpenAISpiritDataGenerator("your_value", "test_model", &http.Client{Transport: rt})
+}
+
+func newTestImageGenerator(rt http.RoundTripper) ImageGenerator {
+	return NewFluxSchnellGenerator("your_value", &http.Client{Transport: rt})
+}
+
+type MockSpiritDataGenerator struct {
+	GenerateSpiritDataFunc func(base64Image *string) (*SpiritData, error)
+}
+
+func (m *MockSpiritDataGenerator) GenerateSpiritData(base64Image *string) (*SpiritData, error) {
+	return m.GenerateSpiritDataFunc(base64Image)
+}
+
+type MockImageGenerator struct {
+	GenerateImageFunc func(prompt *string) (*GeneratedImage, error)
+}
+
+func (m *MockImageGenerator) GenerateImage(prompt *string) (*GeneratedImage, error) {
+	return m.GenerateImageFunc(prompt)
+}
+
 type MockStorageClient struct {
 	GetDownloadURLFunc func(ctx context.Context, bucketName string, objectName string) (string, error)
 	WriteFunc          func(ctx context.Context, bucketName, objectName string, data []byte, contentType string) error
@@ -79,14 +122,8 @@ func (m *MockStorageClient) Close() error {
 
 func TestProcess_Success(t *testing.T) {
 	// Setup
-	// Base64encoded string: "test_base64_image_data"
-	base64Image := "dGVzdF9iYXNlNjRfaW1hZ2VfZGF0YQ=="
+	photo := encodeTestImage(MimeTypeJPEG)
 	userId := "test_user_id"
-	// Set the environment variables
-	os.Setenv("OPENAI_API_KEY", "your_value")
-	defer os.Unsetenv("OPENAI_API_KEY")
-	os.Setenv("REPLICATE_API_TOKEN", "your_value")
-	defer os.Unsetenv("REPLICATE_API_TOKEN")
 
 	// Mock StorageClient
 	mockStorage := &MockStorageClient{
@@ -110,7 +147,7 @@ func TestProcess_Success(t *testing.T) {
                     "choices": [
                         {
                             "message": {
-                                "content": "{\"name\": \"Glimmering Griffon\", \"description\": \"A majestic griffon with shimmering golden feathers and a piercing gaze.\", \"image_generation_prompt\": \"A griffon with golden feathers soaring through a cloudy sky, sunlight reflecting off its wings.\"}"
+                                "content": "{\"name\": \"Glimmering Griffon\", \"primary_type\": \"Sky\", \"secondary_type\": \"None\", \"description\": \"A majestic griffon with shimmering golden feathers and a piercing gaze.\", \"image_generation_prompt\": \"A griffon with golden feathers soaring through a cloudy sky, sunlight reflecting off its wings.\"}"
                             }
                         }
                     ]
@@ -132,7 +169,7 @@ func TestProcess_Success(t *testing.T) {
 					Header:     make(http.Header),
 				}, nil
 			} else if req.URL.String() == "https://mockdownloadurl.com" {
-				responseBody := `{ content: "mockgeneratedimage.webp"}`
+				responseBody := string(encodeTestImage(MimeTypePNG))
 				return &http.Response{
 					StatusCode: http.StatusOK,
 					Body:       io.NopCloser(bytes.NewBufferString(responseBody)),
@@ -144,10 +181,10 @@ func TestProcess_Success(t *testing.T) {
 	}
 
 	// Create ImageProcessor instance
-	ip := NewImageProcessor(mockStorage, mockDatastore, mockRoundTripper)
+	ip := NewImageProcessor(mockStorage, mockDatastore, newTestSpiritDataGenerator(mockRoundTripper), newTestImageGenerator(mockRoundTripper), nil)
 
 	// Execute
-	spirit, err := ip.Process(&base64Image, &userId)
+	spirit, err := ip.Process(photo, MimeTypeJPEG, &userId)
 
 	// Assert
 	assert.NoError(t, err)
@@ -159,14 +196,8 @@ func TestProcess_Success(t *testing.T) {
 
 func TestProcess_FailOnCaptionGeneration(t *testing.T) {
 	// Setup
-	// Base64encoded string: "test_base64_image_data"
-	base64Image := "dGVzdF9iYXNlNjRfaW1hZ2VfZGF0YQ=="
+	photo := encodeTestImage(MimeTypeJPEG)
 	userId := "test_user_id"
-	// Set the environment variables
-	os.Setenv("OPENAI_API_KEY", "your_value")
-	defer os.Unsetenv("OPENAI_API_KEY")
-	os.Setenv("REPLICATE_API_TOKEN", "your_value")
-	defer os.Unsetenv("REPLICATE_API_TOKEN")
 
 	// Mock HTTP Client to simulate failure in getImageCaption
 	mockRoundTripper := &MockRoundTripper{
@@ -187,10 +218,10 @@ func TestProcess_FailOnCaptionGeneration(t *testing.T) {
 	mockDatastore := &MockDatastoreClient{}
 
 	// Create ImageProcessor instance
-	ip := NewImageProcessor(mockStorage, mockDatastore, mockRoundTripper)
+	ip := NewImageProcessor(mockStorage, mockDatastore, newTestSpiritDataGenerator(mockRoundTripper), newTestImageGenerator(mockRoundTripper), nil)
 
 	// Execute
-	_, err := ip.Process(&base64Image, &userId)
+	_, err := ip.Process(photo, MimeTypeJPEG, &userId)
 
 	// Assert
 	assert.Error(t, err)
@@ -199,14 +230,8 @@ func TestProcess_FailOnCaptionGeneration(t *testing.T) {
 
 func TestProcess_FailOnMisunderstoodImageCaptionResponse(t *testing.T) {
 	// Setup
-	// Base64encoded string: "test_base64_image_data"
-	base64Image := "dGVzdF9iYXNlNjRfaW1hZ2VfZGF0YQ=="
+	photo := encodeTestImage(MimeTypeJPEG)
 	userId := "test_user_id"
-	// Set the environment variables
-	os.Setenv("OPENAI_API_KEY", "your_value")
-	defer os.Unsetenv("OPENAI_API_KEY")
-	os.Setenv("REPLICATE_API_TOKEN", "your_value")
-	defer os.Unsetenv("REPLICATE_API_TOKEN")
 
 	// Mock HTTP Client to simulate failure in getImageCaption
 	mockRoundTripper := &MockRoundTripper{
@@ -214,7 +239,7 @@ func TestProcess_FailOnMisunderstoodImageCaptionResponse(t *testing.T) {
 			if req.URL.String() == "https://api.openai.com/v1/chat/completions" {
 				return &http.Response{
 					StatusCode: http.StatusOK,
-					Body:       io.NopCloser(bytes.NewBufferString(`{"content": "{\"name\": \"Glimmering Griffon\", \"description\": \"A majestic griffon with shimmering golden feathers and a piercing gaze.\", \"image_generation_prompt\": \"A griffon with golden feathers soaring through a cloudy sky, sunlight reflecting off its wings.\"}" }`)),
+					Body:       io.NopCloser(bytes.NewBufferString(`{"content": "{\"name\": \"Glimmering Griffon\", \"primary_type\": \"Sky\", \"secondary_type\": \"None\", \"description\": \"A majestic griffon with shimmering golden feathers and a piercing gaze.\", \"image_generation_prompt\": \"A griffon with golden feathers soaring through a cloudy sky, sunlight reflecting off its wings.\"}" }`)),
 					Header:     make(http.Header),
 				}, nil
 			}
@@ -227,10 +252,10 @@ func TestProcess_FailOnMisunderstoodImageCaptionResponse(t *testing.T) {
 	mockDatastore := &MockDatastoreClient{}
 
 	// Create ImageProcessor instance
-	ip := NewImageProcessor(mockStorage, mockDatastore, mockRoundTripper)
+	ip := NewImageProcessor(mockStorage, mockDatastore, newTestSpiritDataGenerator(mockRoundTripper), newTestImageGenerator(mockRoundTripper), nil)
 
 	// Execute
-	_, err := ip.Process(&base64Image, &userId)
+	_, err := ip.Process(photo, MimeTypeJPEG, &userId)
 
 	// Assert
 	assert.Error(t, err)
@@ -239,14 +264,8 @@ func TestProcess_FailOnMisunderstoodImageCaptionResponse(t *testing.T) {
 
 func TestProcess_FailOnImageGeneration(t *testing.T) {
 	// Setup
-	// Base64encoded string: "test_base64_image_data"
-	base64Image := "dGVzdF9iYXNlNjRfaW1hZ2VfZGF0YQ=="
+	photo := encodeTestImage(MimeTypeJPEG)
 	userId := "test_user_id"
-	// Set the environment variables
-	os.Setenv("OPENAI_API_KEY", "your_value")
-	defer os.Unsetenv("OPENAI_API_KEY")
-	os.Setenv("REPLICATE_API_TOKEN", "your_value")
-	defer os.Unsetenv("REPLICATE_API_TOKEN")
 
 	// Mock HTTP Client to simulate failure in generateCartoonMonster
 	mockRoundTripper := &MockRoundTripper{
@@ -256,7 +275,7 @@ func TestProcess_FailOnImageGeneration(t *testing.T) {
                     "choices": [
                         {
                             "message": {
-                                "content": "{\"name\": \"Glimmering Griffon\", \"description\": \"A majestic griffon with shimmering golden feathers and a piercing gaze.\", \"image_generation_prompt\": \"A griffon with golden feathers soaring through a cloudy sky, sunlight reflecting off its wings.\"}"
+                                "content": "{\"name\": \"Glimmering Griffon\", \"primary_type\": \"Sky\", \"secondary_type\": \"None\", \"description\": \"A majestic griffon with shimmering golden feathers and a piercing gaze.\", \"image_generation_prompt\": \"A griffon with golden feathers soaring through a cloudy sky, sunlight reflecting off its wings.\"}"
                             }
                         }
                     ]
@@ -282,10 +301,10 @@ func TestProcess_FailOnImageGeneration(t *testing.T) {
 	mockDatastore := &MockDatastoreClient{}
 
 	// Create ImageProcessor instance
-	ip := NewImageProcessor(mockStorage, mockDatastore, mockRoundTripper)
+	ip := NewImageProcessor(mockStorage, mockDatastore, newTestSpiritDataGenerator(mockRoundTripper), newTestImageGenerator(mockRoundTripper), nil)
 
 	// Execute
-	_, err := ip.Process(&base64Image, &userId)
+	_, err := ip.Process(photo, MimeTypeJPEG, &userId)
 
 	// Assert
 	assert.Error(t, err)
@@ -294,14 +313,8 @@ func TestProcess_FailOnImageGeneration(t *testing.T) {
 
 func TestProcess_FailOnMisunderstoodImageGenerationResponse(t *testing.T) {
 	// Setup
-	// Base64encoded string: "test_base64_image_data"
-	base64Image := "dGVzdF9iYXNlNjRfaW1hZ2VfZGF0YQ=="
+	photo := encodeTestImage(MimeTypeJPEG)
 	userId := "test_user_id"
-	// Set the environment variables
-	os.Setenv("OPENAI_API_KEY", "your_value")
-	defer os.Unsetenv("OPENAI_API_KEY")
-	os.Setenv("REPLICATE_API_TOKEN", "your_value")
-	defer os.Unsetenv("REPLICATE_API_TOKEN")
 
 	// Mock HTTP Client to simulate failure in generateCartoonMonster
 	mockRoundTripper := &MockRoundTripper{
@@ -311,7 +324,7 @@ func TestProcess_FailOnMisunderstoodImageGenerationResponse(t *testing.T) {
                     "choices": [
                         {
                             "message": {
-                                "content": "{\"name\": \"Glimmering Griffon\", \"description\": \"A majestic griffon with shimmering golden feathers and a piercing gaze.\", \"image_generation_prompt\": \"A griffon with golden feathers soaring through a cloudy sky, sunlight reflecting off its wings.\"}"
+                                "content": "{\"name\": \"Glimmering Griffon\", \"primary_type\": \"Sky\", \"secondary_type\": \"None\", \"description\": \"A majestic griffon with shimmering golden feathers and a piercing gaze.\", \"image_generation_prompt\": \"A griffon with golden feathers soaring through a cloudy sky, sunlight reflecting off its wings.\"}"
                             }
                         }
                     ]
@@ -337,10 +350,10 @@ func TestProcess_FailOnMisunderstoodImageGenerationResponse(t *testing.T) {
 	mockDatastore := &MockDatastoreClient{}
 
 	// Create ImageProcessor instance
-	ip := NewImageProcessor(mockStorage, mockDatastore, mockRoundTripper)
+	ip := NewImageProcessor(mockStorage, mockDatastore, newTestSpiritDataGenerator(mockRoundTripper), newTestImageGenerator(mockRoundTripper), nil)
 
 	// Execute
-	_, err := ip.Process(&base64Image, &userId)
+	_, err := ip.Process(photo, MimeTypeJPEG, &userId)
 
 	// Assert
 	assert.Error(t, err)
@@ -349,14 +362,8 @@ func TestProcess_FailOnMisunderstoodImageGenerationResponse(t *testing.T) {
 
 func TestProcess_FailOnStorageWrite(t *testing.T) {
 	// Setup
-	// Base64encoded string: "test_base64_image_data"
-	base64Image := "dGVzdF9iYXNlNjRfaW1hZ2VfZGF0YQ=="
+	photo := encodeTestImage(MimeTypeJPEG)
 	userId := "test_user_id"
-	// Set the environment variables
-	os.Setenv("OPENAI_API_KEY", "your_value")
-	defer os.Unsetenv("OPENAI_API_KEY")
-	os.Setenv("REPLICATE_API_TOKEN", "your_value")
-	defer os.Unsetenv("REPLICATE_API_TOKEN")
 
 	// Mock StorageClient to fail on Write
 	mockStorage := &MockStorageClient{
@@ -376,7 +383,7 @@ func TestProcess_FailOnStorageWrite(t *testing.T) {
                     "choices": [
                         {
                             "message": {
-                                "content": "{\"name\": \"Glimmering Griffon\", \"description\": \"A majestic griffon with shimmering golden feathers and a piercing gaze.\", \"image_generation_prompt\": \"A griffon with golden feathers soaring through a cloudy sky, sunlight reflecting off its wings.\"}"
+                                "content": "{\"name\": \"Glimmering Griffon\", \"primary_type\": \"Sky\", \"secondary_type\": \"None\", \"description\": \"A majestic griffon with shimmering golden feathers and a piercing gaze.\", \"image_generation_prompt\": \"A griffon with golden feathers soaring through a cloudy sky, sunlight reflecting off its wings.\"}"
                             }
                         }
                     ]
@@ -399,7 +406,7 @@ func TestProcess_FailOnStorageWrite(t *testing.T) {
 					Header:     make(http.Header),
 				}, nil
 			} else if req.URL.String() == "https://mockdownloadurl.com" {
-				responseBody := `{ content: "mockgeneratedimage.webp"}`
+				responseBody := string(encodeTestImage(MimeTypePNG))
 				return &http.Response{
 					StatusCode: http.StatusOK,
 					Body:       io.NopCloser(bytes.NewBufferString(responseBody)),
@@ -411,10 +418,10 @@ func TestProcess_FailOnStorageWrite(t *testing.T) {
 	}
 
 	// Create ImageProcessor instance
-	ip := NewImageProcessor(mockStorage, mockDatastore, mockRoundTripper)
+	ip := NewImageProcessor(mockStorage, mockDatastore, newTestSpiritDataGenerator(mockRoundTripper), newTestImageGenerator(mockRoundTripper), nil)
 
 	// Execute
-	_, err := ip.Process(&base64Image, &userId)
+	_, err := ip.Process(photo, MimeTypeJPEG, &userId)
 
 	// Assert
 	assert.Error(t, err)
@@ -423,14 +430,8 @@ func TestProcess_FailOnStorageWrite(t *testing.T) {
 
 func TestProcess_FailOnFirestoreWrite(t *testing.T) {
 	// Setup
-	// Base64encoded string: "test_base64_image_data"
-	base64Image := "dGVzdF9iYXNlNjRfaW1hZ2VfZGF0YQ=="
+	photo := encodeTestImage(MimeTypeJPEG)
 	userId := "test_user_id"
-	// Set the environment variables
-	os.Setenv("OPENAI_API_KEY", "your_value")
-	defer os.Unsetenv("OPENAI_API_KEY")
-	os.Setenv("REPLICATE_API_TOKEN", "your_value")
-	defer os.Unsetenv("REPLICATE_API_TOKEN")
 
 	// Mock StorageClient with successful writes
 	mockStorage := &MockStorageClient{
@@ -454,7 +455,7 @@ func TestProcess_FailOnFirestoreWrite(t *testing.T) {
                     "choices": [
                         {
                             "message": {
-                                "content": "{\"name\": \"Glimmering Griffon\", \"description\": \"A majestic griffon with shimmering golden feathers and a piercing gaze.\", \"image_generation_prompt\": \"A griffon with golden feathers soaring through a cloudy sky, sunlight reflecting off its wings.\"}"
+                                "content": "{\"name\": \"Glimmering Griffon\", \"primary_type\": \"Sky\", \"secondary_type\": \"None\", \"description\": \"A majestic griffon with shimmering golden feathers and a piercing gaze.\", \"image_generation_prompt\": \"A griffon with golden feathers soaring through a cloudy sky, sunlight reflecting off its wings.\"}"
                             }
                         }
                     ]
@@ -477,7 +478,7 @@ func TestProcess_FailOnFirestoreWrite(t *testing.T) {
 					Header:     make(http.Header),
 				}, nil
 			} else if req.URL.String() == "https://mockdownloadurl.com" {
-				responseBody := `{ content: "mockgeneratedimage.webp"}`
+				responseBody := string(encodeTestImage(MimeTypePNG))
 				return &http.Response{
 					StatusCode: http.StatusOK,
 					Body:       io.NopCloser(bytes.NewBufferString(responseBody)),
@@ -489,12 +490,380 @@ func TestProcess_FailOnFirestoreWrite(t *testing.T) {
 	}
 
 	// Create ImageProcessor instance
-	ip := NewImageProcessor(mockStorage, mockDatastore, mockRoundTripper)
+	ip := NewImageProcessor(mockStorage, mockDatastore, newTestSpiritDataGenerator(mockRoundTripper), newTestImageGenerator(mockRoundTripper), nil)
 
 	// Execute
-	_, err := ip.Process(&base64Image, &userId)
+	_, err := ip.Process(photo, MimeTypeJPEG, &userId)
 
 	// Assert
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "firestore write failed")
 }
+
+func TestProcessWithProgress_ReportsEvents(t *testing.T) {
+	// Setup
+	photo := encodeTestImage(MimeTypeJPEG)
+	userId := "test_user_id"
+
+	writes := map[string]string{}
+	mockStorage := &MockStorageClient{
+		WriteFunc: func(ctx context.Context, bucketName, objectName string, data []byte, contentType string) error {
+			writes[objectName] = contentType
+			return nil
+		},
+		GetDownloadURLFunc: func(ctx context.Context, bucketName string, objectName string) (string, error) {
+			return "https://storage/" + objectName, nil
+		},
+	}
+	var savedDoc models.SpiritDocument
+	mockDatastore := &MockDatastoreClient{
+		AddDocumentFunc: func(ctx context.Context, collectionName string, data interface{}) (string, error) {
+			savedDoc = data.(models.SpiritDocument)
+			return "test_id", nil
+		},
+		GetDocumentsFilteredByValueFunc: func(ctx context.Context, collectionName string, fieldName string, value any) ([]map[string]interface{}, error) {
+			return []map[string]interface{}{{"id": "move1", "name": "Gust", "type": value}}, nil
+		},
+		GetDocumentsByIdsFunc: func(ctx context.Context, collectionName string, ids []string) ([]map[string]interface{}, error) {
+			return []map[string]interface{}{{"id": "move1", "name": "Gust", "type": "Sky"}}, nil
+		},
+	}
+	spiritDataGenerator := &MockSpiritDataGenerator{
+		GenerateSpiritDataFunc: func(base64Image *string) (*SpiritData, error) {
+			return &SpiritData{Name: "Glimmering Griffon", PrimaryType: "Sky", SecondaryType: "None", Strength: 42}, nil
+		},
+	}
+	imageGenerator := &MockImageGenerator{
+		GenerateImageFunc: func(prompt *string) (*GeneratedImage, error) {
+			return &GeneratedImage{Data: encodeTestImage(MimeTypePNG), MimeType: "image/webp", Provider: "imagen"}, nil
+		},
+	}
+	ip := NewImageProcessor(mockStorage, mockDatastore, spiritDataGenerator, imageGenerator, nil)
+
+	// Execute
+	var events []ProgressEvent
+	spirit, err := ip.ProcessWithProgress(photo, MimeTypeJPEG, &userId, func(event ProgressEvent) error {
+		events = append(events, event)
+		return nil
+	})
+
+	// Assert
+	assert.NoError(t, err)
+	var types []EventType
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []EventType{EventStage, EventSpiritData, EventMoves, EventStage, EventStage, EventGeneratedImage, EventSpirit}, types)
+
+	spiritDataEvent := events[1].Spirit
+	assert.Equal(t, "Glimmering Griffon", *spiritDataEvent.Name)
+	assert.Equal(t, 42, *spiritDataEvent.Strength)
+	assert.Nil(t, spiritDataEvent.Moves)
+
+	movesEvent := events[2].Spirit
+	assert.Equal(t, "Gust", *movesEvent.Moves[0].Name)
+	assert.Nil(t, movesEvent.GeneratedImageURL)
+
+	generatedImageEvent := events[5].Spirit
+	assert.Contains(t, *generatedImageEvent.GeneratedImageURL, "-generated.png")
+
+	assert.Equal(t, spirit, *events[6].Spirit)
+	assert.Equal(t, "test_id", *spirit.ID)
+
+	assert.Equal(t, "imagen", savedDoc.ImageProvider)
+
+	// Images are stored with the type detected from their data, not the
+	// type reported by the generator. Renditions are always JPEG.
+	timestamp := savedDoc.ImageTimestamp
+	assert.Equal(t, map[string]string{
+		"photos/test_user_id/" + timestamp + "-original.jpeg":               "image/jpeg",
+		"generatedImages/test_user_id/" + timestamp + "-generated.png":      "image/png",
+		"generatedImages/test_user_id/" + timestamp + "-generated-128.jpeg": "image/jpeg",
+		"generatedImages/test_user_id/" + timestamp + "-generated-256.jpeg": "image/jpeg",
+		"generatedImages/test_user_id/" + timestamp + "-generated-512.jpeg": "image/jpeg",
+	}, writes)
+	assert.Equal(t, map[string]string{
+		"128": "https://storage/generatedImages/test_user_id/" + timestamp + "-generated-128.jpeg",
+		"256": "https://storage/generatedImages/test_user_id/" + timestamp + "-generated-256.jpeg",
+		"512": "https://storage/generatedImages/test_user_id/" + timestamp + "-generated-512.jpeg",
+	}, spirit.GeneratedImageRenditionURLs)
+	assert.True(t, strings.HasPrefix(*spirit.GeneratedImagePlaceholder, "data:image/jpeg;base64,"))
+}
+
+func TestProcessWithProgress_StopsWhenProgressFails(t *testing.T) {
+	// Setup
+	photo := encodeTestImage(MimeTypeJPEG)
+	userId := "test_user_id"
+	mockStorage := &MockStorageClient{
+		WriteFunc: func(ctx context.Context, bucketName, objectName string, data []byte, contentType string) error {
+			return nil
+		},
+		GetDownloadURLFunc: func(ctx context.Context, bucketName string, objectName string) (string, error) {
+			return "https://storage/" + objectName, nil
+		},
+	}
+	mockDatastore := &MockDatastoreClient{
+		AddDocumentFunc: func(ctx context.Context, collectionName string, data interface{}) (string, error) {
+			t.Fatal("the spirit should not be saved after progress fails")
+			return "", nil
+		},
+		GetDocumentsFilteredByValueFunc: func(ctx context.Context, collectionName string, fieldName string, value any) ([]map[string]interface{}, error) {
+			return nil, nil
+		},
+	}
+	spiritDataGenerator := &MockSpiritDataGenerator{
+		GenerateSpiritDataFunc: func(base64Image *string) (*SpiritData, error) {
+			return &SpiritData{Name: "Glimmering Griffon", PrimaryType: "Sky", SecondaryType: "None"}, nil
+		},
+	}
+	imageGenerator := &MockImageGenerator{
+		GenerateImageFunc: func(prompt *string) (*GeneratedImage, error) {
+			return &GeneratedImage{Data: encodeTestImage(MimeTypePNG), MimeType: MimeTypePNG, Provider: "imagen"}, nil
+		},
+	}
+	ip := NewImageProcessor(mockStorage, mockDatastore, spiritDataGenerator, imageGenerator, nil)
+	claimLost := errors.New("claim lost")
+
+	// Execute
+	_, err := ip.ProcessWithProgress(photo, MimeTypeJPEG, &userId, func(event ProgressEvent) error {
+		if event.Type == EventStage && event.Stage == StageUploading {
+			return claimLost
+		}
+		return nil
+	})
+
+	// Assert
+	assert.ErrorIs(t, err, claimLost)
+}
+
+func TestProcess_InvalidImage(t *testing.T) {
+	// Setup
+	photo := []byte("test_base64_image_data")
+	userId := "test_user_id"
+	spiritDataGenerator := &MockSpiritDataGenerator{
+		GenerateSpiritDataFunc: func(base64Image *string) (*SpiritData, error) {
+			t.Fatal("an invalid image should not be analyzed")
+			return nil, nil
+		},
+	}
+	ip := NewImageProcessor(&MockStorageClient{}, &MockDatastoreClient{}, spiritDataGenerator, &MockImageGenerator{}, nil)
+
+	// Execute
+	_, err := ip.Process(photo, MimeTypeJPEG, &userId)
+
+	// Assert
+	assert.ErrorIs(t, err, ErrInvalidImage)
+}
+
+func TestProcess_TranscodesImages(t *testing.T) {
+	// Setup
+	// Sent with the wrong content type.
+	photo := encodeTestImage(MimeTypePNG)
+	userId := "test_user_id"
+
+	writes := map[string]string{}
+	mockStorage := &MockStorageClient{
+		WriteFunc: func(ctx context.Context, bucketName, objectName string, data []byte, contentType string) error {
+			writes[objectName] = contentType
+			return nil
+		},
+		GetDownloadURLFunc: func(ctx context.Context, bucketName string, objectName string) (string, error) {
+			return "https://storage/" + objectName, nil
+		},
+	}
+	mockDatastore := &MockDatastoreClient{
+		AddDocumentFunc: func(ctx context.Context, collectionName string, data interface{}) (string, error) {
+			return "test_id", nil
+		},
+	}
+	var visionInput string
+	spiritDataGenerator := &MockSpiritDataGenerator{
+		GenerateSpiritDataFunc: func(base64Image *string) (*SpiritData, error) {
+			visionInput = *base64Image
+			return &SpiritData{Name: "Glimmering Griffon", PrimaryType: "Sky", SecondaryType: "None"}, nil
+		},
+	}
+	imageGenerator := &MockImageGenerator{
+		GenerateImageFunc: func(prompt *string) (*GeneratedImage, error) {
+			return &GeneratedImage{Data: encodeTestImage(MimeTypeWebP), MimeType: MimeTypeWebP, Provider: "flux-pro"}, nil
+		},
+	}
+	transcoder, _ := NewTranscoder(MimeTypeJPEG)
+	ip := NewImageProcessor(mockStorage, mockDatastore, spiritDataGenerator, imageGenerator, transcoder)
+
+	// Execute
+	spirit, err := ip.Process(photo, MimeTypeJPEG, &userId)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Contains(t, *spirit.OriginalImageURL, "-original.jpeg")
+	// The model is sent the photo as it was uploaded, with its real type.
+	assert.True(t, strings.HasPrefix(visionInput, "data:image/png;base64,"))
+	assert.Len(t, writes, 5)
+	for objectName, contentType := range writes {
+		assert.True(t, strings.HasSuffix(objectName, ".jpeg"), objectName)
+		assert.Equal(t, "image/jpeg", contentType)
+	}
+}
+
+func TestProcess_NormalizesTypes(t *testing.T) {
+	tests := []struct {
+		name              string
+		primaryType       string
+		secondaryType     string
+		expectedPrimary   string
+		expectedSecondary string
+		expectedFilters   []any
+		expectedError     string
+	}{
+		{name: "Canonical", primaryType: "Sky", secondaryType: "Thread", expectedPrimary: "Sky", expectedSecondary: "Thread", expectedFilters: []any{"Sky", "Thread"}},
+		{name: "Other case", primaryType: "sky", secondaryType: " NONE", expectedPrimary: "Sky", expectedSecondary: "None", expectedFilters: []any{"Sky"}},
+		{name: "Unknown secondary type", primaryType: "Wave", secondaryType: "Weave", expectedPrimary: "Wave", expectedSecondary: "None", expectedFilters: []any{"Wave"}},
+		{name: "Repeated type", primaryType: "Wave", secondaryType: "Wave", expectedPrimary: "Wave", expectedSecondary: "None", expectedFilters: []any{"Wave"}},
+		{name: "Unknown primary type", primaryType: "Stream", secondaryType: "None", expectedError: `spirit data has unknown primary type "Stream"`},
+		{name: "None as primary type", primaryType: "None", secondaryType: "None", expectedError: `spirit data has unknown primary type "None"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Setup
+			userId := "test_user_id"
+			var savedDoc models.SpiritDocument
+			var filters []any
+			mockStorage := &MockStorageClient{
+				WriteFunc: func(ctx context.Context, bucketName, objectName string, data []byte, contentType string) error {
+					return nil
+				},
+			}
+			mockDatastore := &MockDatastoreClient{
+				AddDocumentFunc: func(ctx context.Context, collectionName string, data interface{}) (string, error) {
+					savedDoc = data.(models.SpiritDocument)
+					return "test_id", nil
+				},
+				GetDocumentsFilteredByValueFunc: func(ctx context.Context, collectionName string, fieldName string, value any) ([]map[string]interface{}, error) {
+					filters = append(filters, value)
+					return nil, nil
+				},
+			}
+			spiritDataGenerator := &MockSpiritDataGenerator{
+				GenerateSpiritDataFunc: func(base64Image *string) (*SpiritData, error) {
+					return &SpiritData{Name: "Glimmering Griffon", PrimaryType: tt.primaryType, SecondaryType: tt.secondaryType}, nil
+				},
+			}
+			imageGenerator := &MockImageGenerator{
+				GenerateImageFunc: func(prompt *string) (*GeneratedImage, error) {
+					return &GeneratedImage{Data: encodeTestImage(MimeTypePNG), MimeType: MimeTypePNG, Provider: "imagen"}, nil
+				},
+			}
+			ip := NewImageProcessor(mockStorage, mockDatastore, spiritDataGenerator, imageGenerator, nil)
+
+			// Execute
+			_, err := ip.Process(encodeTestImage(MimeTypeJPEG), MimeTypeJPEG, &userId)
+
+			// Assert
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedPrimary, savedDoc.PrimaryType)
+			assert.Equal(t, tt.expectedSecondary, savedDoc.SecondaryType)
+			assert.Equal(t, tt.expectedFilters, filters)
+		})
+	}
+}
+
+func TestProcess_SanitizesPhoto(t *testing.T) {
+	// Setup
+	photo := encodeOrientedJPEG(32, 16, orientationRotate90)
+	userId := "test_user_id"
+
+	var storedPhoto []byte
+	mockStorage := &MockStorageClient{
+		WriteFunc: func(ctx context.Context, bucketName, objectName string, data []byte, contentType string) error {
+			if strings.HasPrefix(objectName, "photos/") {
+				storedPhoto = data
+			}
+			return nil
+		},
+	}
+	mockDatastore := &MockDatastoreClient{
+		AddDocumentFunc: func(ctx context.Context, collectionName string, data interface{}) (string, error) {
+			return "test_id", nil
+		},
+	}
+	var visionInput string
+	spiritDataGenerator := &MockSpiritDataGenerator{
+		GenerateSpiritDataFunc: func(base64Image *string) (*SpiritData, error) {
+			visionInput = *base64Image
+			return &SpiritData{Name: "Glimmering Griffon", PrimaryType: "Sky", SecondaryType: "None"}, nil
+		},
+	}
+	imageGenerator := &MockImageGenerator{
+		GenerateImageFunc: func(prompt *string) (*GeneratedImage, error) {
+			return &GeneratedImage{Data: encodeTestImage(MimeTypePNG), MimeType: MimeTypePNG, Provider: "imagen"}, nil
+		},
+	}
+	ip := NewImageProcessor(mockStorage, mockDatastore, spiritDataGenerator, imageGenerator, nil)
+
+	// Execute
+	_, err := ip.Process(photo, MimeTypeJPEG, &userId)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NotContains(t, string(storedPhoto), "GPS")
+	assert.Equal(t, image.Pt(16, 32), decodeSize(t, &Image{Data: storedPhoto}))
+	// The model sees exactly the photo that is stored.
+	assert.Equal(t, (&Image{Data: storedPhoto, MimeType: MimeTypeJPEG}).DataURL(), visionInput)
+}
+
+func TestNewSpiritDocument(t *testing.T) {
+	data := &SpiritData{
+		Name:                  "Glimmering Griffon",
+		Description:           "A majestic griffon.",
+		ImageGenerationPrompt: "A griffon with golden feathers.",
+		PhotoObject:           "Bird",
+		PrimaryType:           "Sky",
+		SecondaryType:         "None",
+		Height:                120,
+		Weight:                80,
+		Strength:              70,
+		Toughness:             60,
+		Agility:               90,
+		Arcana:                50,
+		Aura:                  40,
+		Charisma:              30,
+		Intimidation:          20,
+		Endurance:             10,
+		Luck:                  5,
+		HitPoints:             100,
+	}
+
+	doc := newSpiritDocument(data)
+
+	encoded, err := datastore.EncodeDocument(doc)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"schemaVersion":         int64(models.CurrentSpiritSchemaVersion),
+		"name":                  "Glimmering Griffon",
+		"description":           "A majestic griffon.",
+		"imageGenerationPrompt": "A griffon with golden feathers.",
+		"photoObject":           "Bird",
+		"primaryType":           "Sky",
+		"secondaryType":         "None",
+		"height":                int64(120),
+		"weight":                int64(80),
+		"strength":              int64(70),
+		"toughness":             int64(60),
+		"agility":               int64(90),
+		"arcana":                int64(50),
+		"aura":                  int64(40),
+		"charisma":              int64(30),
+		"intimidation":          int64(20),
+		"endurance":             int64(10),
+		"luck":                  int64(5),
+		"hitPoints":             int64(100),
+		"moveIds":               nil,
+		"imageTimestamp":        "",
+	}, encoded)
+}
@@ -6,17 +6,65 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"spirit-snap/server/logic/spirit_types"
+	"strings"
 )
 
-func openAiGenerateSpirit(model_name *string, base64Image *string, httpClient *http.Client) (*SpiritData, error) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
+const openAiBaseURL = "https://api.openai.com/v1"
+
+// OpenAISpiritDataGenerator generates spirit data with the OpenAI Chat
+// Completions API.
+type OpenAISpiritDataGenerator struct {
+	APIKey     string
+	Model      string
+	HttpClient *http.Client
+}
+
+func NewOpenAISpiritDataGenerator(apiKey string, model string, httpClient *http.Client) *OpenAISpiritDataGenerator {
+	return &OpenAISpiritDataGenerator{
+		APIKey:     apiKey,
+		Model:      model,
+		HttpClient: httpClient,
+	}
+}
+
+func (g *OpenAISpiritDataGenerator) GenerateSpiritData(base64Image *string) (*SpiritData, error) {
+	if g.APIKey == "" {
 		return nil, fmt.Errorf("OpenAI API key not set")
 	}
+	return chatCompletionsGenerateSpirit(openAiBaseURL, g.APIKey, g.Model, base64Image, g.HttpClient, "OpenAI API")
+}
 
+// ChatCompletionsSpiritDataGenerator generates spirit data with any server that
+// implements the OpenAI Chat Completions API, such as a local model server.
+// The server and model must support images and JSON schema structured outputs.
+type ChatCompletionsSpiritDataGenerator struct {
+	// URL that the API paths are relative to, e.g. "http://localhost:11434/v1".
+	BaseURL string
+	// Sent as a bearer token. Servers that do not need one can leave it empty.
+	APIKey     string
+	Model      string
+	HttpClient *http.Client
+}
+
+func NewChatCompletionsSpiritDataGenerator(baseURL string, apiKey string, model string, httpClient *http.Client) *ChatCompletionsSpiritDataGenerator {
+	return &ChatCompletionsSpiritDataGenerator{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		APIKey:     apiKey,
+		Model:      model,
+		HttpClient: httpClient,
+	}
+}
+
+func (g *ChatCompletionsSpiritDataGenerator) GenerateSpiritData(base64Image *string) (*SpiritData, error) {
+	return chatCompletionsGenerateSpirit(g.BaseURL, g.APIKey, g.Model, base64Image, g.HttpClient, "Chat completions API at "+g.BaseURL)
+}
+
+// Sends the spirit creation prompt to a Chat Completions API. serviceName is
+// used to describe the API in errors.
+func chatCompletionsGenerateSpirit(baseURL string, apiKey string, model string, base64Image *string, httpClient *http.Client, serviceName string) (*SpiritData, error) {
 	requestBody := map[string]interface{}{
-		"model": model_name,
+		"model": model,
 		"messages": []map[string]interface{}{
 			{
 				"role":    "system",
@@ -65,51 +113,12 @@ func openAiGenerateSpirit(model_name *string, base64Image *string, httpClient *h
 						"primary_type": map[string]interface{}{
 							"type":        "string",
 							"description": primaryTypePrompt,
-							"enum": []string{
-								"Sky",     // wind/freedom/height
-								"Wave",    // water/fluidity/change
-								"Flame",   // fire/passion/warmth
-								"Stone",   // earth/endurance/stability
-								"Frost",   // ice/preservation/cold
-								"Growth",  // plant/nurturing/flourishing
-								"Dream",   // mystery/psychic/illusion
-								"Shadow",  // darkness/stealth/hidden
-								"Light",   // illumination/truth/radiance
-								"Spirit",  // essence/commonality/soul
-								"Harmony", // peace/balance/order
-								"Chaos",   // disorder/war/spontaneity
-								"Steel",   // technology/craft/construction
-								"Art",     // creativity/expression/beauty
-								"Song",    // music/sound/rhythm
-								"Spark",   // electricity/energy/power
-								"Thread",  // patterns/connections/textiles
-								"Rune",    // knowledge/symbols/writing
-							},
+							"enum":        spirit_types.Names(spirit_types.All),
 						},
 						"secondary_type": map[string]interface{}{
 							"type":        "string",
 							"description": secondaryTypePrompt,
-							"enum": []string{
-								"None",    // mono-type
-								"Sky",     // wind/freedom/height
-								"Wave",    // water/fluidity/change
-								"Flame",   // fire/passion/warmth
-								"Stone",   // earth/endurance/stability
-								"Frost",   // ice/preservation/cold
-								"Growth",  // plant/nurturing/flourishing
-								"Dream",   // mystery/psychic/illusion
-								"Shadow",  // darkness/stealth/hidden
-								"Light",   // illumination/truth/radiance
-								"Spirit",  // essence/commonality/soul
-								"Harmony", // peace/balance/order
-								"Chaos",   // disorder/war/spontaneity
-								"Steel",   // technology/craft/construction
-								"Art",     // creativity/expression/beauty
-								"Song",    // music/sound/rhythm
-								"Spark",   // electricity/energy/power
-								"Thread",  // patterns/connections/textiles
-								"Rune",    // knowledge/symbols/writing
-							},
+							"enum":        spirit_types.Names(append([]spirit_types.Type{spirit_types.None}, spirit_types.All...)),
 						},
 						"height": map[string]interface{}{
 							"type":        "integer",
@@ -176,11 +185,13 @@ func openAiGenerateSpirit(model_name *string, base64Image *string, httpClient *h
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest("POST", baseURL+"/chat/completions", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := httpClient.Do(req)
@@ -192,7 +203,7 @@ func openAiGenerateSpirit(model_name *string, base64Image *string, httpClient *h
 	// Check if the status code indicates success
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		bodyBytes, _ := io.ReadAll(resp.Body) // Read the body in case of error for debugging
-		return nil, fmt.Errorf("OpenAI API request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		return nil, fmt.Errorf("%s request failed with status %d: %s", serviceName, resp.StatusCode, string(bodyBytes))
 	}
 
 	bodyBytes, err := io.ReadAll(resp.Body)
@@ -207,7 +218,7 @@ func openAiGenerateSpirit(model_name *string, base64Image *string, httpClient *h
 
 	spiritData, err := getContentFromOpenAiResponse(result)
 	if err != nil {
-		return nil, fmt.Errorf("unexpected OpenAI API response: %s", err)
+		return nil, fmt.Errorf("unexpected %s response: %s", serviceName, err)
 	}
 	return spiritData, nil
 }
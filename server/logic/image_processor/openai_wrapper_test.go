@@ -0,0 +1,108 @@
+package image_processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const chatCompletionsResponse = `{
+	"choices": [
+		{
+			"message": {
+				"content": "{\"name\": \"Glimmering Griffon\", \"primary_type\": \"Sky\", \"secondary_type\": \"None\", \"strength\": 42}"
+			}
+		}
+	]
+}`
+
+// Returns a RoundTripper that records the request and responds with a
+// successful chat completion.
+func recordingRoundTripper(captured **http.Request, capturedBody *map[string]interface{}) *MockRoundTripper {
+	return &MockRoundTripper{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			*captured = req
+			body, _ := io.ReadAll(req.Body)
+			json.Unmarshal(body, capturedBody)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(chatCompletionsResponse)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+}
+
+func TestOpenAISpiritDataGenerator(t *testing.T) {
+	// Setup
+	var req *http.Request
+	var body map[string]interface{}
+	httpClient := &http.Client{Transport: recordingRoundTripper(&req, &body)}
+	generator := NewOpenAISpiritDataGenerator("test_key", "gpt-test", httpClient)
+	image := "data:image/jpeg;base64,abc"
+
+	// Execute
+	spiritData, err := generator.GenerateSpiritData(&image)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "Glimmering Griffon", spiritData.Name)
+	assert.Equal(t, 42, spiritData.Strength)
+	assert.Equal(t, "https://api.openai.com/v1/chat/completions", req.URL.String())
+	assert.Equal(t, "Bearer test_key", req.Header.Get("Authorization"))
+	assert.Equal(t, "gpt-test", body["model"])
+}
+
+func TestOpenAISpiritDataGenerator_MissingAPIKey(t *testing.T) {
+	generator := NewOpenAISpiritDataGenerator("", "gpt-test", &http.Client{})
+	image := "data:image/jpeg;base64,abc"
+
+	_, err := generator.GenerateSpiritData(&image)
+
+	assert.EqualError(t, err, "OpenAI API key not set")
+}
+
+func TestChatCompletionsSpiritDataGenerator(t *testing.T) {
+	// Setup
+	var req *http.Request
+	var body map[string]interface{}
+	httpClient := &http.Client{Transport: recordingRoundTripper(&req, &body)}
+	generator := NewChatCompletionsSpiritDataGenerator("http://localhost:11434/v1/", "", "llava", httpClient)
+	image := "data:image/jpeg;base64,abc"
+
+	// Execute
+	spiritData, err := generator.GenerateSpiritData(&image)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "Glimmering Griffon", spiritData.Name)
+	assert.Equal(t, "http://localhost:11434/v1/chat/completions", req.URL.String())
+	// Local servers usually do not need a key, so none is sent.
+	assert.Empty(t, req.Header.Get("Authorization"))
+	assert.Equal(t, "llava", body["model"])
+}
+
+func TestChatCompletionsSpiritDataGenerator_Error(t *testing.T) {
+	// Setup
+	httpClient := &http.Client{Transport: &MockRoundTripper{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusBadGateway,
+				Body:       io.NopCloser(bytes.NewBufferString("model not loaded")),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}}
+	generator := NewChatCompletionsSpiritDataGenerator("http://localhost:8000/v1", "key", "llava", httpClient)
+	image := "data:image/jpeg;base64,abc"
+
+	// Execute
+	_, err := generator.GenerateSpiritData(&image)
+
+	// Assert
+	assert.EqualError(t, err, "Chat completions API at http://localhost:8000/v1 request failed with status 502: model not loaded")
+}
@@ -0,0 +1,246 @@
+package image_processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/png"
+
+	"golang.org/x/image/draw"
+)
+
+// DefaultMaxPhotoDimension is the longest side, in pixels, that uploaded
+// photos are scaled down to. It is well above what vision models look at.
+const DefaultMaxPhotoDimension = 2048
+
+// SanitizePhoto prepares an uploaded photo for storage and analysis. It scales
+// the photo down so that its longest side is at most maxDimension, turns it
+// upright according to its EXIF orientation and re-encodes it. Re-encoding
+// drops all metadata, including the location the photo was taken at.
+//
+// PNG photos, and photos with transparent pixels, become PNG so that
+// transparency is kept. Everything else, HEIC included, becomes JPEG. Photos that cannot be decoded are rejected with an
+// error wrapping ErrInvalidImage since their metadata cannot be removed.
+func SanitizePhoto(photo *Image, maxDimension int) (*Image, error) {
+	decoded, err := photo.Decode()
+	if err != nil {
+		return nil, newUploadError(UploadErrorUndecodable, "failed to decode %s photo: %v", photo.MimeType, err)
+	}
+	// Scaling first leaves fewer pixels to move. Both sides are bounded by
+	// maxDimension, so the result is the same once rotated.
+	decoded = resize(decoded, maxDimension)
+	decoded = applyOrientation(decoded, photoOrientation(photo))
+
+	if photo.MimeType == MimeTypePNG || !isOpaque(decoded) {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, decoded); err != nil {
+			return nil, fmt.Errorf("failed to encode photo: %v", err)
+		}
+		return &Image{Data: buf.Bytes(), MimeType: MimeTypePNG}, nil
+	}
+	return encodeJPEG(decoded, jpegQuality)
+}
+
+// EXIF orientations, named after the transform that makes the image upright.
+const (
+	orientationNormal         = 1
+	orientationFlipHorizontal = 2
+	orientationRotate180      = 3
+	orientationFlipVertical   = 4
+	orientationTranspose      = 5
+	orientationRotate90       = 6
+	orientationTransverse     = 7
+	orientationRotate270      = 8
+)
+
+const exifOrientationTag = 0x0112
+
+// Returns the EXIF orientation of a photo, or orientationNormal if it has
+// none. HEIC photos are turned upright by their decoder, which applies the
+// rotation and mirroring of the HEIF container, and the HEIF specification
+// has their EXIF orientation ignored. GIFs carry no EXIF data.
+func photoOrientation(photo *Image) int {
+	switch photo.MimeType {
+	case MimeTypeJPEG:
+		return jpegOrientation(photo.Data)
+	case MimeTypePNG:
+		return pngOrientation(photo.Data)
+	case MimeTypeWebP:
+		return webpOrientation(photo.Data)
+	}
+	return orientationNormal
+}
+
+// Returns the EXIF orientation of a JPEG, or orientationNormal if it has none
+// or its EXIF data is malformed.
+func jpegOrientation(data []byte) int {
+	// JPEG segments start with 0xFF, a marker byte and a big endian length
+	// that includes the length bytes themselves.
+	for offset := 2; offset+4 <= len(data); {
+		if data[offset] != 0xFF {
+			return orientationNormal
+		}
+		marker := data[offset+1]
+		// Start of scan. Metadata segments all come before the image data.
+		if marker == 0xDA {
+			return orientationNormal
+		}
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		if length < 2 || offset+2+length > len(data) {
+			return orientationNormal
+		}
+		segment := data[offset+4 : offset+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		offset += 2 + length
+	}
+	return orientationNormal
+}
+
+// Returns the EXIF orientation of a PNG, or orientationNormal if it has none
+// or its EXIF data is malformed.
+func pngOrientation(data []byte) int {
+	// After the 8 byte signature, PNG chunks have a big endian length, a type,
+	// the data and a CRC.
+	for offset := 8; offset+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		chunkType := string(data[offset+4 : offset+8])
+		if length < 0 || offset+12+length > len(data) || chunkType == "IEND" {
+			return orientationNormal
+		}
+		if chunkType == "eXIf" {
+			return exifOrientation(data[offset+8 : offset+8+length])
+		}
+		offset += 12 + length
+	}
+	return orientationNormal
+}
+
+// Returns the EXIF orientation of a WebP, or orientationNormal if it has none
+// or its EXIF data is malformed.
+func webpOrientation(data []byte) int {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return orientationNormal
+	}
+	// RIFF chunks have a type, a little endian length and the data, padded
+	// to an even length.
+	for offset := 12; offset+8 <= len(data); {
+		length := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		if length < 0 || offset+8+length > len(data) {
+			return orientationNormal
+		}
+		if string(data[offset:offset+4]) == "EXIF" {
+			// Some encoders keep the JPEG header in front of the TIFF data.
+			return exifOrientation(bytes.TrimPrefix(data[offset+8:offset+8+length], []byte("Exif\x00\x00")))
+		}
+		offset += 8 + length + length%2
+	}
+	return orientationNormal
+}
+
+// Reads the orientation tag from the first IFD of a TIFF structure.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return orientationNormal
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return orientationNormal
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return orientationNormal
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == exifOrientationTag {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < orientationNormal || orientation > orientationRotate270 {
+				return orientationNormal
+			}
+			return orientation
+		}
+	}
+	return orientationNormal
+}
+
+// Returns img transformed so that it displays upright. Pixels are copied
+// between *image.RGBA buffers, so img is converted to one first unless it
+// already is one.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation == orientationNormal {
+		return img
+	}
+	src := toRGBA(img)
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	// Orientations 5 to 8 swap the width and height.
+	swap := orientation >= orientationTranspose
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	if swap {
+		dst = image.NewRGBA(image.Rect(0, 0, height, width))
+	}
+	for y := 0; y < height; y++ {
+		row := src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y+y)
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case orientationFlipHorizontal:
+				dx, dy = width-1-x, y
+			case orientationRotate180:
+				dx, dy = width-1-x, height-1-y
+			case orientationFlipVertical:
+				dx, dy = x, height-1-y
+			case orientationTranspose:
+				dx, dy = y, x
+			case orientationRotate90:
+				dx, dy = height-1-y, x
+			case orientationTransverse:
+				dx, dy = height-1-y, width-1-x
+			case orientationRotate270:
+				dx, dy = y, width-1-x
+			}
+			s := row + x*4
+			d := dst.PixOffset(dx, dy)
+			copy(dst.Pix[d:d+4], src.Pix[s:s+4])
+		}
+	}
+	return dst
+}
+
+// Reports whether every pixel of img is fully opaque.
+func isOpaque(img image.Image) bool {
+	// Every image type of the standard library reports it itself.
+	if opaque, ok := img.(interface{ Opaque() bool }); ok {
+		return opaque.Opaque()
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, bounds.Min, draw.Src)
+	return rgba
+}
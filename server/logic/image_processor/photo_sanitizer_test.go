@@ -0,0 +1,260 @@
+package image_processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	red  = color.RGBA{R: 255, A: 255}
+	blue = color.RGBA{B: 255, A: 255}
+)
+
+// Returns a JPEG whose left half is red and right half is blue, with an EXIF
+// segment holding the given orientation and a GPS position.
+func encodeOrientedJPEG(width, height int, orientation uint16) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, image.Rect(0, 0, width/2, height), &image.Uniform{red}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(width/2, 0, width, height), &image.Uniform{blue}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100})
+	data := buf.Bytes()
+
+	segment := append([]byte("Exif\x00\x00"), exifWithOrientation(orientation)...)
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+	return append(append(append([]byte{}, data[:2]...), app1...), data[2:]...)
+}
+
+// Returns a little endian TIFF structure with an IFD holding the orientation
+// and a pointer to GPS data, as stored in EXIF metadata.
+func exifWithOrientation(orientation uint16) []byte {
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	tiff = binary.LittleEndian.AppendUint16(tiff, 2)
+	tiff = binary.LittleEndian.AppendUint16(tiff, exifOrientationTag)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 3)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x8825)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 4)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 0)
+	return append(tiff, "GPS 37.7749 N 122.4194 W"...)
+}
+
+// Returns an extended format WebP of the given size with an EXIF chunk
+// holding the orientation. testWebP is a lossless image of a single color,
+// whose pixels take no bits, so only the size in its header is changed.
+func encodeOrientedWebP(width, height int, orientation uint16) []byte {
+	vp8l := bytes.Clone(encodeTestImage(MimeTypeWebP)[20:])
+	size := binary.LittleEndian.Uint32(vp8l[1:]) &^ (1<<28 - 1)
+	binary.LittleEndian.PutUint32(vp8l[1:], size|uint32(width-1)|uint32(height-1)<<14)
+
+	chunk := func(fourCC string, data []byte) []byte {
+		chunk := binary.LittleEndian.AppendUint32([]byte(fourCC), uint32(len(data)))
+		chunk = append(chunk, data...)
+		if len(data)%2 == 1 {
+			chunk = append(chunk, 0)
+		}
+		return chunk
+	}
+	// The EXIF flag, then the canvas width and height less one as 24 bit
+	// little endian integers.
+	vp8x := []byte{0x08, 0, 0, 0, byte(width - 1), byte((width - 1) >> 8), 0, byte(height - 1), byte((height - 1) >> 8), 0}
+	webp := []byte("WEBP")
+	webp = append(webp, chunk("VP8X", vp8x)...)
+	webp = append(webp, chunk("VP8L", vp8l)...)
+	webp = append(webp, chunk("EXIF", exifWithOrientation(orientation))...)
+	return append(binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(webp))), webp...)
+}
+
+func isColor(c color.Color, want color.RGBA) bool {
+	r, g, b, _ := c.RGBA()
+	return int(r>>8)-int(want.R) < 40 && int(want.R)-int(r>>8) < 40 &&
+		int(g>>8)-int(want.G) < 40 && int(want.G)-int(g>>8) < 40 &&
+		int(b>>8)-int(want.B) < 40 && int(want.B)-int(b>>8) < 40
+}
+
+func TestJpegOrientation(t *testing.T) {
+	for orientation := uint16(1); orientation <= 8; orientation++ {
+		assert.Equal(t, int(orientation), jpegOrientation(encodeOrientedJPEG(4, 4, orientation)))
+	}
+	assert.Equal(t, orientationNormal, jpegOrientation(encodeTestImage(MimeTypeJPEG)))
+	assert.Equal(t, orientationNormal, jpegOrientation(encodeOrientedJPEG(4, 4, 42)))
+	assert.Equal(t, orientationNormal, jpegOrientation(encodeOrientedJPEG(4, 4, 6)[:30]))
+}
+
+func TestWebpOrientation(t *testing.T) {
+	for orientation := uint16(1); orientation <= 8; orientation++ {
+		assert.Equal(t, int(orientation), webpOrientation(encodeOrientedWebP(4, 4, orientation)))
+	}
+	assert.Equal(t, orientationNormal, webpOrientation(encodeTestImage(MimeTypeWebP)))
+	assert.Equal(t, orientationNormal, webpOrientation(encodeOrientedWebP(4, 4, 42)))
+	truncated := encodeOrientedWebP(4, 4, 6)
+	assert.Equal(t, orientationNormal, webpOrientation(truncated[:len(truncated)-30]))
+}
+
+func TestSanitizePhoto_StripsMetadata(t *testing.T) {
+	photo := &Image{Data: encodeOrientedJPEG(32, 16, orientationNormal), MimeType: MimeTypeJPEG}
+	assert.Contains(t, string(photo.Data), "GPS")
+
+	sanitized, err := SanitizePhoto(photo, DefaultMaxPhotoDimension)
+
+	assert.NoError(t, err)
+	assert.Equal(t, MimeTypeJPEG, sanitized.MimeType)
+	assert.NotContains(t, string(sanitized.Data), "Exif")
+	assert.NotContains(t, string(sanitized.Data), "GPS")
+}
+
+func TestSanitizePhoto_AppliesOrientation(t *testing.T) {
+	// Rotating 90 degrees clockwise moves the red left half to the top.
+	photo := &Image{Data: encodeOrientedJPEG(32, 16, orientationRotate90), MimeType: MimeTypeJPEG}
+
+	sanitized, err := SanitizePhoto(photo, DefaultMaxPhotoDimension)
+
+	assert.NoError(t, err)
+	decoded, err := jpeg.Decode(bytes.NewReader(sanitized.Data))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 16, 32), decoded.Bounds())
+	assert.True(t, isColor(decoded.At(8, 4), red))
+	assert.True(t, isColor(decoded.At(8, 28), blue))
+	assert.Equal(t, orientationNormal, jpegOrientation(sanitized.Data))
+}
+
+func TestSanitizePhoto_AppliesWebPOrientation(t *testing.T) {
+	photo := &Image{Data: encodeOrientedWebP(32, 16, orientationRotate90), MimeType: MimeTypeWebP}
+	decoded, err := photo.Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 32, 16), decoded.Bounds())
+
+	sanitized, err := SanitizePhoto(photo, DefaultMaxPhotoDimension)
+
+	assert.NoError(t, err)
+	// testWebP is transparent, so it stays transparent as a PNG.
+	assert.Equal(t, MimeTypePNG, sanitized.MimeType)
+	assert.Equal(t, image.Pt(16, 32), decodeSize(t, sanitized))
+	assert.NotContains(t, string(sanitized.Data), "GPS")
+}
+
+func TestSanitizePhoto_CapsResolution(t *testing.T) {
+	photo := &Image{Data: encodeOrientedJPEG(400, 100, orientationNormal), MimeType: MimeTypeJPEG}
+
+	sanitized, err := SanitizePhoto(photo, 200)
+
+	assert.NoError(t, err)
+	assert.Equal(t, image.Pt(200, 50), decodeSize(t, sanitized))
+
+	// Rotated photos are capped on their longest side as well.
+	photo = &Image{Data: encodeOrientedJPEG(400, 100, orientationRotate270), MimeType: MimeTypeJPEG}
+
+	sanitized, err = SanitizePhoto(photo, 200)
+
+	assert.NoError(t, err)
+	decoded, err := jpeg.Decode(bytes.NewReader(sanitized.Data))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 50, 200), decoded.Bounds())
+	// Rotating 90 degrees counterclockwise moves the red left half to the
+	// bottom.
+	assert.True(t, isColor(decoded.At(25, 180), red))
+	assert.True(t, isColor(decoded.At(25, 20), blue))
+}
+
+func TestSanitizePhoto_Formats(t *testing.T) {
+	// A GIF whose left half is transparent.
+	transparent := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Transparent, red})
+	draw.Draw(transparent, image.Rect(2, 0, 4, 4), &image.Uniform{red}, image.Point{}, draw.Src)
+	var transparentGIF bytes.Buffer
+	assert.NoError(t, gif.Encode(&transparentGIF, transparent, nil))
+
+	tests := []struct {
+		name     string
+		mimeType string
+		data     []byte
+		want     string
+	}{
+		{name: "PNG", mimeType: MimeTypePNG, data: encodeTestImage(MimeTypePNG), want: MimeTypePNG},
+		{name: "GIF", mimeType: MimeTypeGIF, data: encodeTestImage(MimeTypeGIF), want: MimeTypeJPEG},
+		{name: "Transparent GIF", mimeType: MimeTypeGIF, data: transparentGIF.Bytes(), want: MimeTypePNG},
+		// testWebP is a transparent pixel.
+		{name: "Transparent WebP", mimeType: MimeTypeWebP, data: encodeTestImage(MimeTypeWebP), want: MimeTypePNG},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sanitized, err := SanitizePhoto(&Image{Data: tt.data, MimeType: tt.mimeType}, DefaultMaxPhotoDimension)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, sanitized.MimeType)
+			assert.Equal(t, tt.want, sniffImageType(sanitized.Data))
+		})
+	}
+}
+
+func TestSanitizePhoto_HEIC(t *testing.T) {
+	heic, err := os.ReadFile("testdata/photo.heic")
+	assert.NoError(t, err)
+
+	sanitized, err := SanitizePhoto(&Image{Data: heic, MimeType: MimeTypeHEIC}, 256)
+
+	assert.NoError(t, err)
+	assert.Equal(t, MimeTypeJPEG, sanitized.MimeType)
+	assert.Equal(t, image.Pt(256, 256), decodeSize(t, sanitized))
+}
+
+func TestSanitizePhoto_RejectsUndecodablePhotos(t *testing.T) {
+	heic := append([]byte{0, 0, 0, 24}, []byte("ftypheic\x00\x00\x00\x00mif1heic")...)
+	photo := encodeTestImage(MimeTypePNG)
+
+	_, err := SanitizePhoto(&Image{Data: heic, MimeType: MimeTypeHEIC}, DefaultMaxPhotoDimension)
+
+	assert.ErrorIs(t, err, ErrInvalidImage)
+
+	// Photos that pass ValidateUpload with a valid header.
+	_, err = SanitizePhoto(&Image{Data: photo[:len(photo)-20], MimeType: MimeTypePNG}, DefaultMaxPhotoDimension)
+
+	assert.ErrorIs(t, err, ErrInvalidImage)
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// A 3x2 image where only the top left pixel is set.
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	src.Set(0, 0, red)
+
+	tests := []struct {
+		orientation int
+		size        image.Point
+		topLeft     image.Point
+	}{
+		{orientationNormal, image.Pt(3, 2), image.Pt(0, 0)},
+		{orientationFlipHorizontal, image.Pt(3, 2), image.Pt(2, 0)},
+		{orientationRotate180, image.Pt(3, 2), image.Pt(2, 1)},
+		{orientationFlipVertical, image.Pt(3, 2), image.Pt(0, 1)},
+		{orientationTranspose, image.Pt(2, 3), image.Pt(0, 0)},
+		{orientationRotate90, image.Pt(2, 3), image.Pt(1, 0)},
+		{orientationTransverse, image.Pt(2, 3), image.Pt(1, 2)},
+		{orientationRotate270, image.Pt(2, 3), image.Pt(0, 2)},
+	}
+	// Images in other types, with bounds that do not start at the origin,
+	// are converted first.
+	offset := image.NewNRGBA(image.Rect(5, 5, 8, 7))
+	offset.Set(5, 5, red)
+	for _, tt := range tests {
+		for _, img := range []image.Image{src, offset} {
+			dst := applyOrientation(img, tt.orientation)
+
+			assert.Equal(t, tt.size, dst.Bounds().Size(), "orientation %d", tt.orientation)
+			topLeft := dst.Bounds().Min.Add(tt.topLeft)
+			assert.Equal(t, color.RGBAModel.Convert(red), color.RGBAModel.Convert(dst.At(topLeft.X, topLeft.Y)), "orientation %d", tt.orientation)
+		}
+	}
+}
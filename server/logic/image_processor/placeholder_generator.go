@@ -0,0 +1,72 @@
+package image_processor
+
+import (
+	"bytes"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// Side length of the images PlaceholderImageGenerator draws.
+const placeholderImageSize = 1024
+
+// PlaceholderImageGenerator draws a simple image instead of calling an image
+// generation model, so the server runs without network access. The image is
+// a gradient with a disc in the middle whose colors are picked from the
+// prompt, so every spirit gets its own image and the same prompt always
+// gives the same image.
+type PlaceholderImageGenerator struct{}
+
+func NewPlaceholderImageGenerator() *PlaceholderImageGenerator {
+	return &PlaceholderImageGenerator{}
+}
+
+func (g *PlaceholderImageGenerator) GenerateImage(prompt *string) (*GeneratedImage, error) {
+	hash := fnv.New64a()
+	if prompt != nil {
+		hash.Write([]byte(*prompt))
+	}
+	sum := hash.Sum64()
+	top, bottom, disc := placeholderColor(sum), placeholderColor(sum>>20), placeholderColor(sum>>40)
+
+	img := image.NewRGBA(image.Rect(0, 0, placeholderImageSize, placeholderImageSize))
+	center := placeholderImageSize / 2
+	radius := placeholderImageSize / 3
+	for y := 0; y < placeholderImageSize; y++ {
+		background := mixColors(top, bottom, y, placeholderImageSize-1)
+		for x := 0; x < placeholderImageSize; x++ {
+			dx, dy := x-center, y-center
+			pixel := background
+			if dx*dx+dy*dy <= radius*radius {
+				pixel = disc
+			}
+			offset := img.PixOffset(x, y)
+			img.Pix[offset], img.Pix[offset+1], img.Pix[offset+2], img.Pix[offset+3] = pixel.R, pixel.G, pixel.B, 255
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return &GeneratedImage{Data: buf.Bytes(), MimeType: MimeTypePNG, Provider: "local"}, nil
+}
+
+// Returns an opaque color made from the low 21 bits of bits, 7 for each
+// channel. Channels are kept between 48 and 238 so that no color is close to
+// black or white.
+func placeholderColor(bits uint64) color.RGBA {
+	channel := func(shift uint) uint8 {
+		return uint8(48 + int((bits>>shift)&0x7f)*3/2)
+	}
+	return color.RGBA{R: channel(0), G: channel(7), B: channel(14), A: 255}
+}
+
+// Mixes a and b, weighting b by step/steps.
+func mixColors(a, b color.RGBA, step, steps int) color.RGBA {
+	mix := func(from, to uint8) uint8 {
+		return uint8((int(from)*(steps-step) + int(to)*step) / steps)
+	}
+	return color.RGBA{R: mix(a.R, b.R), G: mix(a.G, b.G), B: mix(a.B, b.B), A: 255}
+}
@@ -0,0 +1,31 @@
+package image_processor
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlaceholderImageGenerator_GenerateImage(t *testing.T) {
+	generator := NewPlaceholderImageGenerator()
+	griffon, golem := "A glimmering griffon", "A mossy golem"
+
+	first, err := generator.GenerateImage(&griffon)
+	assert.NoError(t, err)
+	again, err := generator.GenerateImage(&griffon)
+	assert.NoError(t, err)
+	other, err := generator.GenerateImage(&golem)
+	assert.NoError(t, err)
+
+	assert.Equal(t, MimeTypePNG, first.MimeType)
+	assert.Equal(t, "local", first.Provider)
+	img, err := png.Decode(bytes.NewReader(first.Data))
+	assert.NoError(t, err)
+	assert.Equal(t, placeholderImageSize, img.Bounds().Dx())
+	assert.Equal(t, placeholderImageSize, img.Bounds().Dy())
+	// The same prompt always gives the same image.
+	assert.Equal(t, first.Data, again.Data)
+	assert.NotEqual(t, first.Data, other.Data)
+}
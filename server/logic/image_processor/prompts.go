@@ -1,6 +1,8 @@
 package image_processor
 
 import (
+	"fmt"
+	"spirit-snap/server/logic/spirit_types"
 	"strings"
 )
 
@@ -148,24 +150,7 @@ TYPE SYSTEM RULES
  * Consider both literal and metaphorical connections to the type's domain
 
 Available Primary Types:
-Sky (wind/freedom/height)
-Stream (water/fluidity/change)
-Flame (fire/passion/warmth)
-Stone (earth/endurance/stability)
-Frost (ice/preservation/cold)
-Growth (plant/nurturing/flourishing)
-Dream (mystery/psychic/illusion)
-Shadow (darkness/stealth/hidden)
-Light (illumination/truth/radiance)
-Ritual (ancestral/tradition/sacred)
-Harmony (peace/balance/order)
-Chaos (disorder/war/spontaneity)
-Steel (technology/craft/construction)
-Art (creativity/expression/beauty)
-Song (music/sound/rhythm)
-Spark (electricity/energy/power)
-Weave (patterns/connections/textiles)
-Rune (knowledge/symbols/writing)
+%s
 
 OUTPUT INSTRUCTIONS
  * Analyze the subject using the aspects listed above
@@ -180,7 +165,9 @@ Remember:
  * Ensure the connection between subject and type feels natural and intuitive
  * Consider the frequency of the subject type in the provided frequency list to maintain game balance`
 
-var primaryTypePrompt = strings.ReplaceAll(humanReadablePrimaryTypePrompt, "\n", " ")
+// The available types are filled in from spirit_types, which the response
+// schema also allows, so the prompt cannot offer a type the schema rejects.
+var primaryTypePrompt = strings.ReplaceAll(fmt.Sprintf(humanReadablePrimaryTypePrompt, typeList(spirit_types.All)), "\n", " ")
 
 const humanReadableSecondaryTypePrompt = `
 Your task is to determine if a spirit should have a secondary type based on its photographic subject and primary type, and if so, select the most appropriate secondary type.
@@ -197,25 +184,7 @@ TYPE SYSTEM RULES
 * Consider both harmonious and contrasting type combinations
 
 Available Secondary Types:
-None (mono-type)
-Sky (wind/freedom/height)
-Stream (water/fluidity/change)
-Flame (fire/passion/warmth)
-Stone (earth/endurance/stability)
-Frost (ice/preservation/cold)
-Growth (plant/nurturing/flourishing)
-Dream (mystery/psychic/illusion)
-Shadow (darkness/stealth/hidden)
-Light (illumination/truth/radiance)
-Ritual (ancestral/tradition/sacred)
-Harmony (peace/balance/order)
-Chaos (disorder/war/spontaneity)
-Steel (technology/craft/construction)
-Art (creativity/expression/beauty)
-Song (music/sound/rhythm)
-Spark (electricity/energy/power)
-Weave (patterns/connections/textiles)
-Rune (knowledge/symbols/writing)
+%s
 
 MONO-TYPE CONSIDERATION CRITERIA
 Consider keeping the spirit mono-typed if:
@@ -248,7 +217,16 @@ Remember:
 * Account for cultural and symbolic meanings
 * Consider the frequency of the subject type for game balance`
 
-var secondaryTypePrompt = strings.ReplaceAll(humanReadableSecondaryTypePrompt, "\n", " ")
+var secondaryTypePrompt = strings.ReplaceAll(fmt.Sprintf(humanReadableSecondaryTypePrompt, typeList(append([]spirit_types.Type{spirit_types.None}, spirit_types.All...))), "\n", " ")
+
+// Lists types one per line with their themes, e.g. "Sky (wind/freedom/height)".
+func typeList(types []spirit_types.Type) string {
+	lines := make([]string, len(types))
+	for i, spiritType := range types {
+		lines[i] = fmt.Sprintf("%s (%s)", spiritType, spiritType.Description())
+	}
+	return strings.Join(lines, "\n")
+}
 
 const humanReadableHeightPrompt = `
 Guess the creature's height based on the image generation prompt. Height should be the number of centimeters.`
@@ -0,0 +1,255 @@
+package image_processor
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrNoRegionAvailable is returned by RegionRouter.Acquire when every region
+// is cooling down.
+var ErrNoRegionAvailable = errors.New("no region available, all regions are cooling down")
+
+// CircuitState is the state of a region's circuit breaker.
+type CircuitState string
+
+const (
+	// The region is healthy and receives traffic.
+	CircuitClosed CircuitState = "closed"
+	// The region recently failed and receives no traffic until its cooldown
+	// ends.
+	CircuitOpen CircuitState = "open"
+	// The region's cooldown has ended. A single trial request decides whether
+	// it closes again or goes back into a longer cooldown.
+	CircuitHalfOpen CircuitState = "half_open"
+)
+
+// Weight of the newest sample in the moving averages of latency and error
+// rate.
+const ewmaWeight = 0.3
+
+// How much a region's error rate adds to its latency when comparing regions.
+// A region failing half of its requests counts as 10 seconds slower.
+const errorRatePenalty = 20 * time.Second
+
+// RegionRouterConfig controls how a RegionRouter reacts to failures.
+type RegionRouterConfig struct {
+	Regions []string
+	// Consecutive network errors or 5xx responses that put a region into
+	// cooldown. A 429 puts it into cooldown right away since the region's
+	// quota is used up. Other 4xx responses are caused by the request, not
+	// the region, and do not count.
+	FailureThreshold int
+	// Length of a region's first cooldown. It doubles every time the region
+	// fails its trial request, up to MaxCooldown.
+	Cooldown    time.Duration
+	MaxCooldown time.Duration
+	// Latency assumed for regions without successful requests, so that they
+	// are compared to measured regions as a typical region rather than an
+	// instant one.
+	PriorLatency time.Duration
+}
+
+func DefaultRegionRouterConfig() RegionRouterConfig {
+	return RegionRouterConfig{
+		Regions:          DefaultImagenRegions,
+		FailureThreshold: 3,
+		Cooldown:         30 * time.Second,
+		MaxCooldown:      10 * time.Minute,
+		PriorLatency:     8 * time.Second,
+	}
+}
+
+// RegionState is a snapshot of a region's health, reported for debugging.
+type RegionState struct {
+	Region       string       `json:"region"`
+	State        CircuitState `json:"state"`
+	Requests     int          `json:"requests"`
+	Successes    int          `json:"successes"`
+	RateLimited  int          `json:"rateLimited"`
+	ServerErrors int          `json:"serverErrors"`
+	// Responses with a 4xx status other than 429. They are only counted and
+	// do not affect the region's health.
+	ClientErrors int `json:"clientErrors"`
+	// Moving average of the latency of successful requests.
+	AverageLatencyMs int64 `json:"averageLatencyMs"`
+	// Moving average of the fraction of requests that were rate limited or
+	// failed.
+	ErrorRate           float64    `json:"errorRate"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	CooldownUntil       *time.Time `json:"cooldownUntil,omitempty"`
+}
+
+type regionHealth struct {
+	RegionState
+	latency time.Duration
+	// Number of cooldowns since the region was last healthy.
+	trips         int
+	cooldownUntil time.Time
+	trialInFlight bool
+}
+
+// Lower is better. Regions without successful requests are scored with
+// priorLatency.
+func (h *regionHealth) score(priorLatency time.Duration) time.Duration {
+	latency := h.latency
+	if h.Successes == 0 {
+		latency = priorLatency
+	}
+	return latency + time.Duration(h.ErrorRate*float64(errorRatePenalty))
+}
+
+// RegionRouter chooses the region for each request from the health of recent
+// requests. It is safe for concurrent use.
+//
+// Load is spread across regions with the "power of two choices": two random
+// available regions are compared and the one with the lower latency and error
+// rate wins. Failing regions are taken out of rotation by a circuit breaker.
+type RegionRouter struct {
+	config RegionRouterConfig
+
+	mu      sync.Mutex
+	regions []*regionHealth
+	byName  map[string]*regionHealth
+
+	// Replaced in tests.
+	now    func() time.Time
+	random func(n int) int
+}
+
+func NewRegionRouter(config RegionRouterConfig) *RegionRouter {
+	router := &RegionRouter{
+		config: config,
+		byName: make(map[string]*regionHealth),
+		now:    time.Now,
+		random: rand.Intn,
+	}
+	for _, region := range config.Regions {
+		health := &regionHealth{RegionState: RegionState{Region: region, State: CircuitClosed}}
+		router.regions = append(router.regions, health)
+		router.byName[region] = health
+	}
+	return router
+}
+
+// Acquire returns the region to send the next request to. Every acquired
+// region must be followed by a call to Report.
+func (r *RegionRouter) Acquire() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	var candidates []*regionHealth
+	for _, health := range r.regions {
+		if health.State == CircuitOpen && !now.Before(health.cooldownUntil) {
+			health.State = CircuitHalfOpen
+		}
+		switch {
+		case health.State == CircuitOpen:
+			continue
+		case health.State == CircuitHalfOpen && !health.trialInFlight:
+			// Trial requests go first so that recovered regions rejoin quickly.
+			health.trialInFlight = true
+			return health.Region, nil
+		case health.State == CircuitClosed:
+			candidates = append(candidates, health)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", ErrNoRegionAvailable
+	}
+
+	best := candidates[r.random(len(candidates))]
+	if other := candidates[r.random(len(candidates))]; other.score(r.config.PriorLatency) < best.score(r.config.PriorLatency) {
+		best = other
+	}
+	return best.Region, nil
+}
+
+// Report records the outcome of a request to a region. statusCode is 0 if no
+// response was received. Only 429, 5xx and network errors count against the
+// region; other 4xx responses, such as a prompt rejected by the safety
+// filter, say nothing about its health.
+func (r *RegionRouter) Report(region string, statusCode int, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	health, ok := r.byName[region]
+	if !ok {
+		return
+	}
+	health.Requests++
+	wasTrial := health.State == CircuitHalfOpen
+	health.trialInFlight = false
+
+	switch {
+	case statusCode >= 200 && statusCode < 300:
+		health.Successes++
+		health.ConsecutiveFailures = 0
+		if health.latency == 0 {
+			health.latency = latency
+		} else {
+			health.latency = time.Duration(ewmaWeight*float64(latency) + (1-ewmaWeight)*float64(health.latency))
+		}
+		health.ErrorRate = (1 - ewmaWeight) * health.ErrorRate
+		r.close(health)
+	case statusCode == 429:
+		health.RateLimited++
+		health.ErrorRate = ewmaWeight + (1-ewmaWeight)*health.ErrorRate
+		r.open(health)
+	case statusCode >= 400 && statusCode < 500:
+		// A failed trial is retried by the next request.
+		health.ClientErrors++
+	case statusCode == 0 || statusCode >= 500:
+		health.ServerErrors++
+		health.ConsecutiveFailures++
+		health.ErrorRate = ewmaWeight + (1-ewmaWeight)*health.ErrorRate
+		if wasTrial || health.ConsecutiveFailures >= r.config.FailureThreshold {
+			r.open(health)
+		}
+	default:
+		// Informational and redirect responses say nothing about the
+		// region's health but do show that it is reachable.
+		if wasTrial {
+			r.close(health)
+		}
+	}
+}
+
+func (r *RegionRouter) open(health *regionHealth) {
+	health.trips++
+	cooldown := r.config.Cooldown
+	for i := 1; i < health.trips && cooldown < r.config.MaxCooldown; i++ {
+		cooldown *= 2
+	}
+	if cooldown > r.config.MaxCooldown {
+		cooldown = r.config.MaxCooldown
+	}
+	health.State = CircuitOpen
+	health.cooldownUntil = r.now().Add(cooldown)
+	health.ConsecutiveFailures = 0
+}
+
+func (r *RegionRouter) close(health *regionHealth) {
+	health.State = CircuitClosed
+	health.trips = 0
+}
+
+// Snapshot returns the current state of every region in configuration order.
+func (r *RegionRouter) Snapshot() []RegionState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	states := make([]RegionState, 0, len(r.regions))
+	for _, health := range r.regions {
+		state := health.RegionState
+		state.AverageLatencyMs = health.latency.Milliseconds()
+		if health.State != CircuitClosed {
+			cooldownUntil := health.cooldownUntil
+			state.CooldownUntil = &cooldownUntil
+		}
+		states = append(states, state)
+	}
+	return states
+}
@@ -0,0 +1,220 @@
+package image_processor
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var routerStart = time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+// Returns a router whose clock can be advanced and whose random choices are
+// taken from picks in turn.
+func newTestRouter(regions []string, picks ...int) (*RegionRouter, *time.Time) {
+	router := NewRegionRouter(RegionRouterConfig{
+		Regions:          regions,
+		FailureThreshold: 2,
+		Cooldown:         time.Minute,
+		MaxCooldown:      3 * time.Minute,
+		PriorLatency:     500 * time.Millisecond,
+	})
+	now := routerStart
+	router.now = func() time.Time { return now }
+	router.random = func(n int) int {
+		if len(picks) == 0 {
+			return 0
+		}
+		pick := picks[0] % n
+		picks = picks[1:]
+		return pick
+	}
+	return router, &now
+}
+
+func stateOf(router *RegionRouter, region string) RegionState {
+	for _, state := range router.Snapshot() {
+		if state.Region == region {
+			return state
+		}
+	}
+	return RegionState{}
+}
+
+func TestRegionRouter_PrefersLowLatency(t *testing.T) {
+	router, _ := newTestRouter([]string{"fast", "slow"}, 1, 0)
+	router.Report("fast", 200, 100*time.Millisecond)
+	router.Report("slow", 200, 900*time.Millisecond)
+
+	// "slow" is drawn first but "fast" wins the comparison.
+	region, err := router.Acquire()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "fast", region)
+}
+
+func TestRegionRouter_PenalizesErrors(t *testing.T) {
+	router, _ := newTestRouter([]string{"flaky", "steady"}, 0, 1)
+	router.Report("flaky", 200, 100*time.Millisecond)
+	router.Report("flaky", 503, 100*time.Millisecond)
+	router.Report("steady", 200, 200*time.Millisecond)
+
+	region, err := router.Acquire()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "steady", region)
+}
+
+func TestRegionRouter_RateLimitStartsCooldown(t *testing.T) {
+	router, now := newTestRouter([]string{"a", "b"})
+
+	router.Report("a", 429, 50*time.Millisecond)
+
+	state := stateOf(router, "a")
+	assert.Equal(t, CircuitOpen, state.State)
+	assert.Equal(t, 1, state.RateLimited)
+	assert.Equal(t, routerStart.Add(time.Minute), *state.CooldownUntil)
+	for i := 0; i < 5; i++ {
+		region, _ := router.Acquire()
+		assert.Equal(t, "b", region)
+	}
+
+	// Once the cooldown ends the region gets a single trial request.
+	*now = now.Add(time.Minute)
+	region, _ := router.Acquire()
+	assert.Equal(t, "a", region)
+	assert.Equal(t, CircuitHalfOpen, stateOf(router, "a").State)
+	region, _ = router.Acquire()
+	assert.Equal(t, "b", region)
+
+	router.Report("a", 200, 50*time.Millisecond)
+	assert.Equal(t, CircuitClosed, stateOf(router, "a").State)
+	assert.Nil(t, stateOf(router, "a").CooldownUntil)
+}
+
+func TestRegionRouter_ServerErrorsOpenAfterThreshold(t *testing.T) {
+	router, _ := newTestRouter([]string{"a", "b"})
+
+	router.Report("a", 500, time.Second)
+	assert.Equal(t, CircuitClosed, stateOf(router, "a").State)
+	assert.Equal(t, 1, stateOf(router, "a").ConsecutiveFailures)
+
+	// Network errors count as server errors.
+	router.Report("a", 0, time.Second)
+	assert.Equal(t, CircuitOpen, stateOf(router, "a").State)
+	assert.Equal(t, 2, stateOf(router, "a").ServerErrors)
+}
+
+func TestRegionRouter_FailedTrialDoublesCooldown(t *testing.T) {
+	router, now := newTestRouter([]string{"a"})
+
+	router.Report("a", 429, 0)
+	*now = now.Add(time.Minute)
+	region, _ := router.Acquire()
+	router.Report(region, 503, 0)
+	assert.Equal(t, now.Add(2*time.Minute), *stateOf(router, "a").CooldownUntil)
+
+	*now = now.Add(2 * time.Minute)
+	region, _ = router.Acquire()
+	router.Report(region, 429, 0)
+	// Capped at MaxCooldown.
+	assert.Equal(t, now.Add(3*time.Minute), *stateOf(router, "a").CooldownUntil)
+}
+
+// Rejected prompts do not trip healthy regions.
+func TestRegionRouter_ClientErrorsDoNotCountAsFailures(t *testing.T) {
+	router, now := newTestRouter([]string{"a"})
+
+	for i := 0; i < 5; i++ {
+		router.Report("a", 400, time.Second)
+	}
+	router.Report("a", 404, time.Second)
+
+	state := stateOf(router, "a")
+	assert.Equal(t, CircuitClosed, state.State)
+	assert.Zero(t, state.ErrorRate)
+	assert.Zero(t, state.ConsecutiveFailures)
+	assert.Equal(t, 6, state.ClientErrors)
+	assert.Equal(t, 6, state.Requests)
+
+	// Nor do they decide a trial.
+	router.Report("a", 429, 0)
+	*now = now.Add(time.Minute)
+	region, _ := router.Acquire()
+	router.Report(region, 400, time.Second)
+	assert.Equal(t, CircuitHalfOpen, stateOf(router, "a").State)
+	region, err := router.Acquire()
+	assert.NoError(t, err)
+	router.Report(region, 200, time.Second)
+	assert.Equal(t, CircuitClosed, stateOf(router, "a").State)
+}
+
+// Regions without successful requests are compared using the prior latency
+// rather than winning every comparison.
+func TestRegionRouter_UnmeasuredRegionsUsePriorLatency(t *testing.T) {
+	router, _ := newTestRouter([]string{"fast", "new", "slow"}, 1, 0, 1, 2)
+	router.Report("fast", 200, 100*time.Millisecond)
+	router.Report("slow", 200, 900*time.Millisecond)
+
+	region, err := router.Acquire()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "fast", region)
+
+	region, err = router.Acquire()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "new", region)
+}
+
+// A region that has only failed is not scored as instant.
+func TestRegionRouter_FailedRegionWithoutLatency(t *testing.T) {
+	router, _ := newTestRouter([]string{"failing", "steady"}, 0, 1)
+	router.Report("failing", 503, 0)
+	router.Report("steady", 200, 800*time.Millisecond)
+
+	region, err := router.Acquire()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "steady", region)
+}
+
+func TestRegionRouter_NoRegionAvailable(t *testing.T) {
+	router, _ := newTestRouter([]string{"a"})
+	router.Report("a", 429, 0)
+
+	_, err := router.Acquire()
+
+	assert.ErrorIs(t, err, ErrNoRegionAvailable)
+}
+
+func TestRegionRouter_ConcurrentUse(t *testing.T) {
+	router := NewRegionRouter(DefaultRegionRouterConfig())
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				region, err := router.Acquire()
+				if err != nil {
+					continue
+				}
+				status := 200
+				if (i+j)%7 == 0 {
+					status = 429
+				}
+				router.Report(region, status, time.Duration(j)*time.Millisecond)
+			}
+			router.Snapshot()
+		}(i)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, state := range router.Snapshot() {
+		total += state.Requests
+	}
+	assert.Positive(t, total)
+}
@@ -0,0 +1,98 @@
+package image_processor
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"strconv"
+
+	"golang.org/x/image/draw"
+)
+
+// DefaultRenditionSizes are the sizes, in pixels along the longest side, that
+// generated images are resized to. Bench cards use the smallest and full
+// cards the largest.
+var DefaultRenditionSizes = []int{128, 256, 512}
+
+// Longest side of the blur placeholder. The client stretches it, with a blur,
+// while the real image loads.
+const placeholderSize = 16
+
+// Renditions are always stored as JPEG since they are only shown as previews
+// and JPEG is by far the smallest at these sizes.
+const (
+	renditionQuality   = 85
+	placeholderQuality = 50
+)
+
+// Renditions holds resized copies of an image.
+type Renditions struct {
+	// Resized images keyed by their size.
+	Images map[int]*Image
+	// A tiny version of the image, small enough to store inline.
+	Placeholder *Image
+}
+
+// RenditionKey is the key a rendition of the given size is stored under in
+// spirit documents and returned under to clients.
+func RenditionKey(size int) string {
+	return strconv.Itoa(size)
+}
+
+// CreateRenditions resizes img so that its longest side is each of sizes, and
+// creates its blur placeholder. Images are never enlarged, so a rendition
+// larger than img has img's dimensions.
+func CreateRenditions(img *Image, sizes []int) (*Renditions, error) {
+	decoded, err := img.Decode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s for renditions: %v", img.MimeType, err)
+	}
+	renditions := &Renditions{Images: make(map[int]*Image, len(sizes))}
+	for _, size := range sizes {
+		renditions.Images[size], err = encodeJPEG(resize(decoded, size), renditionQuality)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Bilinear scaling averages over a wide area when shrinking this far,
+	// which blurs the placeholder more than the sharper CatmullRom kernel.
+	placeholder := image.NewRGBA(fitWithin(decoded.Bounds(), placeholderSize))
+	draw.BiLinear.Scale(placeholder, placeholder.Bounds(), decoded, decoded.Bounds(), draw.Src, nil)
+	renditions.Placeholder, err = encodeJPEG(placeholder, placeholderQuality)
+	if err != nil {
+		return nil, err
+	}
+	return renditions, nil
+}
+
+func resize(src image.Image, size int) image.Image {
+	bounds := fitWithin(src.Bounds(), size)
+	if bounds.Dx() == src.Bounds().Dx() && bounds.Dy() == src.Bounds().Dy() {
+		return src
+	}
+	dst := image.NewRGBA(bounds)
+	draw.CatmullRom.Scale(dst, bounds, src, src.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// Returns the bounds of src scaled down so that its longest side is at most
+// size, keeping its aspect ratio.
+func fitWithin(src image.Rectangle, size int) image.Rectangle {
+	width, height := src.Dx(), src.Dy()
+	if width <= size && height <= size {
+		return image.Rect(0, 0, width, height)
+	}
+	if width >= height {
+		return image.Rect(0, 0, size, max(1, height*size/width))
+	}
+	return image.Rect(0, 0, max(1, width*size/height), size)
+}
+
+func encodeJPEG(img image.Image, quality int) (*Image, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("failed to encode rendition: %v", err)
+	}
+	return &Image{Data: buf.Bytes(), MimeType: MimeTypeJPEG}, nil
+}
@@ -0,0 +1,71 @@
+package image_processor
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeSizedPNG(width, height int) *Image {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)))
+	return &Image{Data: buf.Bytes(), MimeType: MimeTypePNG}
+}
+
+func decodeSize(t *testing.T, img *Image) image.Point {
+	config, _, err := image.DecodeConfig(bytes.NewReader(img.Data))
+	assert.NoError(t, err)
+	return image.Pt(config.Width, config.Height)
+}
+
+func TestCreateRenditions(t *testing.T) {
+	renditions, err := CreateRenditions(encodeSizedPNG(1024, 768), DefaultRenditionSizes)
+
+	assert.NoError(t, err)
+	assert.Len(t, renditions.Images, 3)
+	assert.Equal(t, image.Pt(128, 96), decodeSize(t, renditions.Images[128]))
+	assert.Equal(t, image.Pt(256, 192), decodeSize(t, renditions.Images[256]))
+	assert.Equal(t, image.Pt(512, 384), decodeSize(t, renditions.Images[512]))
+	for _, rendition := range renditions.Images {
+		assert.Equal(t, MimeTypeJPEG, rendition.MimeType)
+	}
+	assert.Equal(t, MimeTypeJPEG, renditions.Placeholder.MimeType)
+	assert.Equal(t, image.Pt(16, 12), decodeSize(t, renditions.Placeholder))
+	assert.Less(t, len(renditions.Placeholder.DataURL()), 1000)
+}
+
+func TestCreateRenditions_Portrait(t *testing.T) {
+	renditions, err := CreateRenditions(encodeSizedPNG(300, 1200), []int{128})
+
+	assert.NoError(t, err)
+	assert.Equal(t, image.Pt(32, 128), decodeSize(t, renditions.Images[128]))
+	assert.Equal(t, image.Pt(4, 16), decodeSize(t, renditions.Placeholder))
+}
+
+func TestCreateRenditions_NeverEnlarges(t *testing.T) {
+	renditions, err := CreateRenditions(encodeSizedPNG(200, 100), []int{128, 512})
+
+	assert.NoError(t, err)
+	assert.Equal(t, image.Pt(128, 64), decodeSize(t, renditions.Images[128]))
+	assert.Equal(t, image.Pt(200, 100), decodeSize(t, renditions.Images[512]))
+}
+
+func TestCreateRenditions_HEIC(t *testing.T) {
+	heic, err := os.ReadFile("testdata/photo.heic")
+	assert.NoError(t, err)
+
+	renditions, err := CreateRenditions(&Image{Data: heic, MimeType: MimeTypeHEIC}, []int{128})
+
+	assert.NoError(t, err)
+	assert.Equal(t, image.Pt(128, 128), decodeSize(t, renditions.Images[128]))
+}
+
+func TestCreateRenditions_UndecodableImage(t *testing.T) {
+	_, err := CreateRenditions(&Image{Data: []byte("\x89PNG\r\n\x1a\n"), MimeType: MimeTypePNG}, DefaultRenditionSizes)
+
+	assert.Error(t, err)
+}
@@ -6,126 +6,108 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 )
 
-func replicateSchnellGenerateImation(prompt *string, httpClient *http.Client) ([]byte, error) {
-	apiKey := os.Getenv("REPLICATE_API_TOKEN")
-	if apiKey == "" {
-		return nil, fmt.Errorf("replicate API token not set")
-	}
+const replicateModelsURL = "https://api.replicate.com/v1/models"
 
-	requestBody := map[string]interface{}{
-		"input": map[string]interface{}{
-			// Prompt for generated image
-			"prompt": prompt,
-			// Format of the output images
-			"output_format": "webp",
-			// Random seed for reproducible generation
-			"seed": 42,
-			// Run faster predictions with model optimized for speed (currently fp8 quantized); disable to run in original bf16
-			"go_fast": true,
-			// Disable safety checker for generated images.
-			"disable_safety_checker": true,
-			// Approximate number of megapixels for generated image
-			"megapixels": "1",
-			// Number of outputs to generate
-			"num_outputs": 1,
-			// Aspect ratio for the generated image
-			"aspect_ratio": "1:1",
-			// Quality when saving the output images, from 0 to 100 (not relevant for .png outputs)
-			"output_quality": 90,
-			// // Number of denoising steps; lower steps produce faster but lower quality results
-			"num_inference_steps": 4,
-		},
-	}
-
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, err
-	}
+// FluxProGenerator generates images with Flux 1.1 Pro on Replicate.
+type FluxProGenerator struct {
+	APIToken   string
+	HttpClient *http.Client
+}
 
-	req, err := http.NewRequest("POST", "https://api.replicate.com/v1/models/black-forest-labs/flux-schnell/predictions", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, err
+func NewFluxProGenerator(apiToken string, httpClient *http.Client) *FluxProGenerator {
+	return &FluxProGenerator{
+		APIToken:   apiToken,
+		HttpClient: httpClient,
 	}
-	req.Header.Set("Authorization", "Token "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Prefer", "wait")
+}
 
-	resp, err := httpClient.Do(req)
+func (g *FluxProGenerator) GenerateImage(prompt *string) (*GeneratedImage, error) {
+	input := map[string]interface{}{
+		// Prompt for generated image
+		"prompt": prompt,
+		// Format of the output images
+		"output_format": "webp",
+		// Random seed for reproducible generation
+		"seed":         42,
+		"width":        1024,
+		"height":       1024,
+		"aspect_ratio": "1:1",
+		// Quality when saving the output images, from 0 to 100 (not relevant for .png outputs)
+		"output_quality": 90,
+		// Use an LLM to improve the prompt.
+		"prompt_upsampling": false,
+	}
+	data, err := replicateGenerateImage(g.APIToken, "black-forest-labs/flux-1.1-pro", input, getImageUriFromReplicateFluxPro1_1Response, g.HttpClient)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	// Check if the status code indicates success
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		bodyBytes, _ := io.ReadAll(resp.Body) // Read the body in case of error for debugging
-		//lint:ignore ST1005 Capitilization is intentional as it is the API provider's name.
-		return nil, fmt.Errorf("Replicate API request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
-	}
+	return &GeneratedImage{Data: data, MimeType: "image/webp", Provider: "flux-pro"}, nil
+}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+// FluxSchnellGenerator generates images with Flux Schnell on Replicate. It is
+// faster and cheaper than Flux 1.1 Pro at the cost of quality.
+type FluxSchnellGenerator struct {
+	APIToken   string
+	HttpClient *http.Client
+}
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(bodyBytes, &result); err != nil {
-		return nil, err
+func NewFluxSchnellGenerator(apiToken string, httpClient *http.Client) *FluxSchnellGenerator {
+	return &FluxSchnellGenerator{
+		APIToken:   apiToken,
+		HttpClient: httpClient,
 	}
+}
 
-	image_uri, err := getImageUriFromReplicateSchnellResponse(result)
-	if err != nil {
-		return nil, fmt.Errorf("unexpected replicate API response: %s", err)
-	}
-	// Download generated image
-	imageResp, err := httpClient.Get(image_uri)
-	if err != nil {
-		return nil, err
-	}
-	defer imageResp.Body.Close()
-	generatedImage, err := io.ReadAll(imageResp.Body)
+func (g *FluxSchnellGenerator) GenerateImage(prompt *string) (*GeneratedImage, error) {
+	input := map[string]interface{}{
+		// Prompt for generated image
+		"prompt": prompt,
+		// Format of the output images
+		"output_format": "webp",
+		// Random seed for reproducible generation
+		"seed": 42,
+		// Run faster predictions with model optimized for speed (currently fp8 quantized); disable to run in original bf16
+		"go_fast": true,
+		// Disable safety checker for generated images.
+		"disable_safety_checker": true,
+		// Approximate number of megapixels for generated image
+		"megapixels": "1",
+		// Number of outputs to generate
+		"num_outputs": 1,
+		// Aspect ratio for the generated image
+		"aspect_ratio": "1:1",
+		// Quality when saving the output images, from 0 to 100 (not relevant for .png outputs)
+		"output_quality": 90,
+		// // Number of denoising steps; lower steps produce faster but lower quality results
+		"num_inference_steps": 4,
+	}
+	data, err := replicateGenerateImage(g.APIToken, "black-forest-labs/flux-schnell", input, getImageUriFromReplicateSchnellResponse, g.HttpClient)
 	if err != nil {
 		return nil, err
 	}
-	return generatedImage, nil
+	return &GeneratedImage{Data: data, MimeType: "image/webp", Provider: "flux-schnell"}, nil
 }
-func replicatePro1_1GenerateImage(prompt *string, httpClient *http.Client) ([]byte, error) {
-	apiKey := os.Getenv("REPLICATE_API_TOKEN")
-	if apiKey == "" {
+
+// Runs a prediction on a Replicate model and downloads the resulting image.
+// parseOutput extracts the image URI from the prediction, whose shape differs
+// between models.
+func replicateGenerateImage(apiToken string, model string, input map[string]interface{}, parseOutput func(map[string]interface{}) (string, error), httpClient *http.Client) ([]byte, error) {
+	if apiToken == "" {
 		return nil, fmt.Errorf("replicate API token not set")
 	}
 
-	requestBody := map[string]interface{}{
-		"input": map[string]interface{}{
-			// Prompt for generated image
-			"prompt": prompt,
-			// Format of the output images
-			"output_format": "webp",
-			// Random seed for reproducible generation
-			"seed":         42,
-			"width":        1024,
-			"height":       1024,
-			"aspect_ratio": "1:1",
-			// Quality when saving the output images, from 0 to 100 (not relevant for .png outputs)
-			"output_quality": 90,
-			// Use an LLM to improve the prompt.
-			"prompt_upsampling": false,
-		},
-	}
-
-	jsonData, err := json.Marshal(requestBody)
+	jsonData, err := json.Marshal(map[string]interface{}{"input": input})
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", "https://api.replicate.com/v1/models/black-forest-labs/flux-1.1-pro/predictions", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest("POST", replicateModelsURL+"/"+model+"/predictions", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Token "+apiKey)
+	req.Header.Set("Authorization", "Token "+apiToken)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Prefer", "wait")
 
@@ -138,8 +120,7 @@ func replicatePro1_1GenerateImage(prompt *string, httpClient *http.Client) ([]by
 	// Check if the status code indicates success
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		bodyBytes, _ := io.ReadAll(resp.Body) // Read the body in case of error for debugging
-		//lint:ignore ST1005 Capitilization is intentional as it is the API provider's name.
-		return nil, fmt.Errorf("Replicate API request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		return nil, &APIError{Service: "Replicate API", StatusCode: resp.StatusCode, Body: string(bodyBytes)}
 	}
 
 	bodyBytes, err := io.ReadAll(resp.Body)
@@ -152,7 +133,7 @@ func replicatePro1_1GenerateImage(prompt *string, httpClient *http.Client) ([]by
 		return nil, err
 	}
 
-	image_uri, err := getImageUriFromReplicateFluxPro1_1Response(result)
+	image_uri, err := parseOutput(result)
 	if err != nil {
 		return nil, fmt.Errorf("unexpected replicate API response: %s", err)
 	}
@@ -162,6 +143,10 @@ func replicatePro1_1GenerateImage(prompt *string, httpClient *http.Client) ([]by
 		return nil, err
 	}
 	defer imageResp.Body.Close()
+	if imageResp.StatusCode < 200 || imageResp.StatusCode >= 300 {
+		bodyBytes, _ := io.ReadAll(imageResp.Body)
+		return nil, &APIError{Service: "Replicate image download", StatusCode: imageResp.StatusCode, Body: string(bodyBytes)}
+	}
 	generatedImage, err := io.ReadAll(imageResp.Body)
 	if err != nil {
 		return nil, err
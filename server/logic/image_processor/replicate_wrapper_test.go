@@ -0,0 +1,77 @@
+package image_processor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns a RoundTripper that serves a Replicate prediction for the given model
+// and the image it points to.
+func replicateRoundTripper(model string, predictionResponse string) *MockRoundTripper {
+	return &MockRoundTripper{
+		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+			switch req.URL.String() {
+			case "https://api.replicate.com/v1/models/" + model + "/predictions":
+				if req.Header.Get("Authorization") != "Token test-token" {
+					return nil, fmt.Errorf("unexpected Authorization header: %s", req.Header.Get("Authorization"))
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewBufferString(predictionResponse)),
+					Header:     make(http.Header),
+				}, nil
+			case "https://mockdownloadurl.com":
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewBufferString("webp bytes")),
+					Header:     make(http.Header),
+				}, nil
+			}
+			return nil, fmt.Errorf("unknown URL: %s", req.URL.String())
+		},
+	}
+}
+
+func TestFluxProGenerator_GenerateImage(t *testing.T) {
+	// Setup
+	rt := replicateRoundTripper("black-forest-labs/flux-1.1-pro", `{"output": "https://mockdownloadurl.com"}`)
+	generator := NewFluxProGenerator("test-token", &http.Client{Transport: rt})
+	prompt := "A griffon"
+
+	// Execute
+	image, err := generator.GenerateImage(&prompt)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("webp bytes"), image.Data)
+	assert.Equal(t, "image/webp", image.MimeType)
+}
+
+func TestFluxSchnellGenerator_GenerateImage(t *testing.T) {
+	// Setup
+	rt := replicateRoundTripper("black-forest-labs/flux-schnell", `{"output": ["https://mockdownloadurl.com"]}`)
+	generator := NewFluxSchnellGenerator("test-token", &http.Client{Transport: rt})
+	prompt := "A griffon"
+
+	// Execute
+	image, err := generator.GenerateImage(&prompt)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("webp bytes"), image.Data)
+	assert.Equal(t, "image/webp", image.MimeType)
+}
+
+func TestFluxSchnellGenerator_MissingToken(t *testing.T) {
+	generator := NewFluxSchnellGenerator("", &http.Client{})
+	prompt := "A griffon"
+
+	_, err := generator.GenerateImage(&prompt)
+
+	assert.EqualError(t, err, "replicate API token not set")
+}
@@ -0,0 +1,133 @@
+package image_processor
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Codes of the reasons an upload is rejected. Clients can rely on these not
+// changing.
+const (
+	// The request body is larger than UploadLimits.MaxBodyBytes.
+	UploadErrorBodyTooLarge = "body_too_large"
+	// The decoded photo is larger than UploadLimits.MaxImageBytes.
+	UploadErrorImageTooLarge = "image_too_large"
+	// The photo is not a data URL or its base64 is malformed.
+	UploadErrorInvalidDataURL = "invalid_data_url"
+	// The photo is not in a supported format.
+	UploadErrorUnsupportedFormat = "unsupported_image_format"
+	// The photo looks like a supported format but its header cannot be read.
+	UploadErrorUndecodable = "undecodable_image"
+	// The photo is narrower or shorter than UploadLimits.MinDimension.
+	UploadErrorDimensionsTooSmall = "dimensions_too_small"
+	// The photo is wider or taller than UploadLimits.MaxDimension.
+	UploadErrorDimensionsTooLarge = "dimensions_too_large"
+	// The request is not JSON, multipart/form-data or an image.
+	UploadErrorUnsupportedContentType = "unsupported_content_type"
+	// A multipart/form-data request has no "image" field.
+	UploadErrorMissingImage = "missing_image"
+)
+
+// UploadError explains why an uploaded photo was rejected. It wraps
+// ErrInvalidImage.
+type UploadError struct {
+	Code    string
+	Message string
+}
+
+func (e *UploadError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrInvalidImage, e.Message)
+}
+
+func (e *UploadError) Unwrap() error {
+	return ErrInvalidImage
+}
+
+// TooLarge reports whether the upload was rejected for its size in bytes
+// rather than for its content.
+func (e *UploadError) TooLarge() bool {
+	return e.Code == UploadErrorBodyTooLarge || e.Code == UploadErrorImageTooLarge
+}
+
+func newUploadError(code string, format string, args ...interface{}) *UploadError {
+	return &UploadError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// UploadLimits bounds the photos that are accepted for processing. A zero
+// field is not enforced.
+type UploadLimits struct {
+	// Size of the whole request body, which holds the base64 encoded photo.
+	MaxBodyBytes int64
+	// Size of the photo once it is decoded from base64.
+	MaxImageBytes int
+	// Bounds on both the width and the height of the photo in pixels.
+	MinDimension int
+	MaxDimension int
+}
+
+// DefaultUploadLimits accepts 12 megapixel photos, the default resolution of
+// most phone cameras, while rejecting images that are too small to tell what
+// they show. Photos are scaled down to DefaultMaxPhotoDimension anyway, so
+// larger ones would only cost memory to decode.
+func DefaultUploadLimits() UploadLimits {
+	const maxImageBytes = 15 << 20
+	return UploadLimits{
+		// Base64 grows data by a third. The rest is room for the JSON.
+		MaxBodyBytes:  maxImageBytes/3*4 + 64<<10,
+		MaxImageBytes: maxImageBytes,
+		MinDimension:  64,
+		MaxDimension:  4096,
+	}
+}
+
+// ValidateUpload checks an uploaded photo against the limits before any work
+// is done with it. The photo must be a data URL, or bare base64, of a
+// supported image whose header can be read. Only the header is decoded, so a
+// photo that is corrupt further in is rejected when SanitizePhoto decodes it.
+// The returned error is always an *UploadError.
+func ValidateUpload(dataURL string, limits UploadLimits) (*Image, error) {
+	// Checked before decoding so that oversized uploads cost nothing. Bare
+	// base64 is all payload.
+	payload := dataURL
+	if _, data, found := strings.Cut(dataURL, ","); found {
+		payload = data
+	}
+	if limits.MaxImageBytes > 0 && base64.StdEncoding.DecodedLen(len(payload)) > limits.MaxImageBytes+2 {
+		return nil, newUploadError(UploadErrorImageTooLarge, "photo is larger than %d bytes", limits.MaxImageBytes)
+	}
+	img, err := ParseDataURL(dataURL)
+	if err != nil {
+		return nil, err
+	}
+	return ValidateImage(img.Data, limits)
+}
+
+// ValidateImage is ValidateUpload for a photo that was uploaded as binary
+// data. The returned error is always an *UploadError.
+func ValidateImage(data []byte, limits UploadLimits) (*Image, error) {
+	img, err := DetectImage(data)
+	if err != nil {
+		return nil, err
+	}
+	if img.MimeType == MimeTypeAVIF {
+		return nil, newUploadError(UploadErrorUnsupportedFormat, "avif photos are not supported")
+	}
+	if limits.MaxImageBytes > 0 && len(img.Data) > limits.MaxImageBytes {
+		return nil, newUploadError(UploadErrorImageTooLarge, "photo is larger than %d bytes", limits.MaxImageBytes)
+	}
+
+	// Only the header is read so that validation never allocates the pixels
+	// of an image.
+	config, err := img.DecodeConfig()
+	if err != nil {
+		return nil, newUploadError(UploadErrorUndecodable, "failed to decode %s photo: %v", img.MimeType, err)
+	}
+	if limits.MinDimension > 0 && (config.Width < limits.MinDimension || config.Height < limits.MinDimension) {
+		return nil, newUploadError(UploadErrorDimensionsTooSmall, "photo is %dx%d pixels, the minimum is %dx%d", config.Width, config.Height, limits.MinDimension, limits.MinDimension)
+	}
+	if limits.MaxDimension > 0 && (config.Width > limits.MaxDimension || config.Height > limits.MaxDimension) {
+		return nil, newUploadError(UploadErrorDimensionsTooLarge, "photo is %dx%d pixels, the maximum is %dx%d", config.Width, config.Height, limits.MaxDimension, limits.MaxDimension)
+	}
+	return img, nil
+}
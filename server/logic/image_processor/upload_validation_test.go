@@ -0,0 +1,88 @@
+package image_processor
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateUpload(t *testing.T) {
+	photo := encodeSizedPNG(100, 80)
+	photoURL := photo.DataURL()
+	heic := append([]byte{0, 0, 0, 24}, []byte("ftypheic\x00\x00\x00\x00mif1heic")...)
+	avif := append([]byte{0, 0, 0, 24}, []byte("ftypavif\x00\x00\x00\x00mif1avif")...)
+	limits := UploadLimits{MaxImageBytes: 10000, MinDimension: 64, MaxDimension: 128}
+
+	tests := []struct {
+		name    string
+		dataURL string
+		limits  UploadLimits
+		code    string
+	}{
+		{"valid", photoURL, limits, ""},
+		{"no limits", encodeSizedPNG(1, 1).DataURL(), UploadLimits{}, ""},
+		{"too many base64 bytes", "data:image/png;base64," + strings.Repeat("A", 20000), limits, UploadErrorImageTooLarge},
+		{"too many bare base64 bytes", strings.Repeat("A", 20000), limits, UploadErrorImageTooLarge},
+		{"too many decoded bytes", photoURL, UploadLimits{MaxImageBytes: len(photo.Data) - 1}, UploadErrorImageTooLarge},
+		{"malformed base64", "data:image/png;base64,***", limits, UploadErrorInvalidDataURL},
+		{"unknown format", "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte("<svg></svg>")), limits, UploadErrorUnsupportedFormat},
+		{"avif", "data:image/avif;base64," + base64.StdEncoding.EncodeToString(avif), limits, UploadErrorUnsupportedFormat},
+		{"corrupt heic", "data:image/heic;base64," + base64.StdEncoding.EncodeToString(heic), limits, UploadErrorUndecodable},
+		// Only the header is read, so SanitizePhoto rejects the rest.
+		{"truncated", (&Image{Data: photo.Data[:len(photo.Data)-20], MimeType: MimeTypePNG}).DataURL(), limits, ""},
+		{"corrupt header", (&Image{Data: photo.Data[:20], MimeType: MimeTypePNG}).DataURL(), limits, UploadErrorUndecodable},
+		{"too narrow", encodeSizedPNG(63, 100).DataURL(), limits, UploadErrorDimensionsTooSmall},
+		{"too tall", encodeSizedPNG(100, 129).DataURL(), limits, UploadErrorDimensionsTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := ValidateUpload(tt.dataURL, tt.limits)
+
+			if tt.code == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, MimeTypePNG, img.MimeType)
+				return
+			}
+			var uploadErr *UploadError
+			assert.True(t, errors.As(err, &uploadErr))
+			assert.Equal(t, tt.code, uploadErr.Code)
+			assert.ErrorIs(t, err, ErrInvalidImage)
+		})
+	}
+}
+
+func TestUploadError_TooLarge(t *testing.T) {
+	assert.True(t, (&UploadError{Code: UploadErrorBodyTooLarge}).TooLarge())
+	assert.True(t, (&UploadError{Code: UploadErrorImageTooLarge}).TooLarge())
+	assert.False(t, (&UploadError{Code: UploadErrorDimensionsTooLarge}).TooLarge())
+}
+
+func TestValidateImage(t *testing.T) {
+	photo := encodeSizedPNG(100, 80)
+	limits := UploadLimits{MaxImageBytes: 10000, MinDimension: 64, MaxDimension: 128}
+
+	img, err := ValidateImage(photo.Data, limits)
+	assert.NoError(t, err)
+	assert.Equal(t, MimeTypePNG, img.MimeType)
+
+	_, err = ValidateImage(photo.Data, UploadLimits{MaxImageBytes: len(photo.Data) - 1})
+	var uploadErr *UploadError
+	assert.True(t, errors.As(err, &uploadErr))
+	assert.Equal(t, UploadErrorImageTooLarge, uploadErr.Code)
+
+	// A 512x512 photo.
+	heic, err := os.ReadFile("testdata/photo.heic")
+	assert.NoError(t, err)
+
+	img, err = ValidateImage(heic, UploadLimits{MinDimension: 64, MaxDimension: 1024})
+	assert.NoError(t, err)
+	assert.Equal(t, MimeTypeHEIC, img.MimeType)
+
+	_, err = ValidateImage(heic, UploadLimits{MaxDimension: 256})
+	assert.True(t, errors.As(err, &uploadErr))
+	assert.Equal(t, UploadErrorDimensionsTooLarge, uploadErr.Code)
+}
@@ -0,0 +1,241 @@
+// Package move_seeder loads the moves defined in the move data file,
+// data/moves.json, into the moves collection.
+package move_seeder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"strings"
+
+	"spirit-snap/server/models"
+	"spirit-snap/server/wrappers/datastore"
+)
+
+type DatastoreInterface interface {
+	RunQuery(ctx context.Context, query datastore.Query) (*datastore.PageResult, error)
+	AddDocument(ctx context.Context, collectionName string, data interface{}) (string, error)
+	UpdateDocument(ctx context.Context, collectionName string, id string, updates map[string]interface{}) error
+}
+
+// Number of stored moves read per query.
+const DefaultBatchSize = 100
+
+// ErrOutdatedData is returned when the moves collection was seeded from a
+// newer version of the move data file than the one being loaded.
+var ErrOutdatedData = errors.New("move data is older than the stored moves")
+
+// MoveData is the contents of a move data file.
+type MoveData struct {
+	// Version of the file. It goes up by one whenever the moves change, and
+	// is stored with every move seeded from the file.
+	Version int              `json:"version"`
+	Moves   []MoveDefinition `json:"moves"`
+}
+
+// MoveDefinition is a move as it is written in the move data file. The
+// fields are those of models.MoveDocument.
+type MoveDefinition struct {
+	Name     string              `json:"name"`
+	Type     string              `json:"type"`
+	Category string              `json:"category"`
+	Power    int                 `json:"power"`
+	Accuracy int                 `json:"accuracy"`
+	Priority int                 `json:"priority"`
+	Target   string              `json:"target"`
+	Cooldown int                 `json:"cooldown"`
+	Uses     int                 `json:"uses"`
+	Effects  []models.MoveEffect `json:"effects"`
+}
+
+// Document returns the move document seeded from a definition in the given
+// version of the move data file.
+func (d MoveDefinition) Document(version int) models.MoveDocument {
+	return models.MoveDocument{
+		Name:        d.Name,
+		Type:        d.Type,
+		DataVersion: version,
+		Category:    d.Category,
+		Power:       d.Power,
+		Accuracy:    d.Accuracy,
+		Priority:    d.Priority,
+		Target:      d.Target,
+		Cooldown:    d.Cooldown,
+		Uses:        d.Uses,
+		Effects:     d.Effects,
+	}
+}
+
+// ParseMoveData reads a move data file and validates every move in it. Fields
+// the file format does not have are rejected, so typos are not silently
+// dropped. The returned error lists every problem.
+func ParseMoveData(r io.Reader) (MoveData, error) {
+	var data MoveData
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&data); err != nil {
+		return MoveData{}, fmt.Errorf("failed to parse move data: %w", err)
+	}
+
+	var errs []error
+	if data.Version < 1 {
+		errs = append(errs, fmt.Errorf("version must be at least 1, got %d", data.Version))
+	}
+	if len(data.Moves) == 0 {
+		errs = append(errs, errors.New("move data has no moves"))
+	}
+	names := make(map[string]bool, len(data.Moves))
+	for _, definition := range data.Moves {
+		if err := definition.Document(data.Version).Validate(); err != nil {
+			errs = append(errs, err)
+		}
+		// Moves are matched to stored documents by name.
+		key := nameKey(definition.Name)
+		if names[key] {
+			errs = append(errs, fmt.Errorf("move %q is defined more than once", definition.Name))
+		}
+		names[key] = true
+	}
+	if err := errors.Join(errs...); err != nil {
+		return MoveData{}, fmt.Errorf("invalid move data: %w", err)
+	}
+	return data, nil
+}
+
+func nameKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// MoveSeeder writes move definitions to the moves collection.
+type MoveSeeder struct {
+	DatastoreClient DatastoreInterface
+	BatchSize       int
+}
+
+func NewMoveSeeder(ds DatastoreInterface) *MoveSeeder {
+	return &MoveSeeder{
+		DatastoreClient: ds,
+		BatchSize:       DefaultBatchSize,
+	}
+}
+
+// Options select what a seeding run does.
+type Options struct {
+	// Counts the moves that would be written without writing them.
+	DryRun bool
+}
+
+// Report counts the moves a seeding run has handled.
+type Report struct {
+	// Moves added to the collection, or that would have been on a dry run.
+	Created int
+	// Stored moves overwritten with their definition, or that would have
+	// been on a dry run.
+	Updated int
+	// Stored moves that already matched their definition.
+	Unchanged int
+	// Stored moves the data file does not define. They are left as they are.
+	Unlisted int
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("%d moves created, %d updated, %d unchanged, %d not in the data file",
+		r.Created, r.Updated, r.Unchanged, r.Unlisted)
+}
+
+// Seed writes the moves in data to the moves collection.
+//
+// Definitions are matched to stored moves by name, ignoring case, and the
+// matching documents are overwritten in place. Spirits refer to their moves
+// by document ID, so they keep their moves and pick up the new definitions.
+// Definitions without a stored move are added with a new ID. Stored moves
+// the file does not define are counted in the report and never deleted,
+// since spirits may still refer to them.
+//
+// Seed returns ErrOutdatedData without writing anything if any stored move
+// was seeded from a newer version of the file. Otherwise it stops at the
+// first failed write and returns the report of the moves handled before it.
+func (ms *MoveSeeder) Seed(ctx context.Context, data MoveData, options Options) (Report, error) {
+	var report Report
+	stored, err := ms.storedMoves(ctx)
+	if err != nil {
+		return report, fmt.Errorf("failed to read stored moves: %w", err)
+	}
+
+	byName := make(map[string][]models.MoveDocument)
+	for _, move := range stored {
+		if move.DataVersion > data.Version {
+			return report, fmt.Errorf("%w: move %s was seeded from version %d, the data is version %d", ErrOutdatedData, move.ID, move.DataVersion, data.Version)
+		}
+		key := nameKey(move.Name)
+		byName[key] = append(byName[key], move)
+	}
+
+	for _, definition := range data.Moves {
+		doc := definition.Document(data.Version)
+		key := nameKey(definition.Name)
+		matches := byName[key]
+		delete(byName, key)
+
+		if len(matches) == 0 {
+			if !options.DryRun {
+				if _, err := ms.DatastoreClient.AddDocument(ctx, "moves", doc); err != nil {
+					return report, fmt.Errorf("failed to add move %q: %w", definition.Name, err)
+				}
+			}
+			report.Created++
+			continue
+		}
+		// Moves from before the data file may have been added more than
+		// once. Every copy is updated, since each may be in use.
+		for _, match := range matches {
+			id := match.ID
+			match.ID = ""
+			if reflect.DeepEqual(match, doc) {
+				report.Unchanged++
+				continue
+			}
+			if !options.DryRun {
+				updates, err := datastore.EncodeDocument(doc)
+				if err != nil {
+					return report, err
+				}
+				if err := ms.DatastoreClient.UpdateDocument(ctx, "moves", id, updates); err != nil {
+					return report, fmt.Errorf("failed to update move %q: %w", definition.Name, err)
+				}
+			}
+			report.Updated++
+		}
+	}
+
+	for _, unlisted := range byName {
+		for _, move := range unlisted {
+			log.Printf("Move %s (%q) is not in the data file", move.ID, move.Name)
+			report.Unlisted++
+		}
+	}
+	return report, nil
+}
+
+// Reads every document in the moves collection.
+func (ms *MoveSeeder) storedMoves(ctx context.Context) ([]models.MoveDocument, error) {
+	var moves []models.MoveDocument
+	query := datastore.NewQuery("moves").OrderBy(datastore.DocumentID, datastore.Asc).Limit(ms.BatchSize)
+	for {
+		result, err := ms.DatastoreClient.RunQuery(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range result.Documents {
+			moves = append(moves, models.DecodeMoveDocument(doc))
+		}
+		if !result.HasMore {
+			return moves, nil
+		}
+		query = query.StartAfter(result.LastCursor...)
+	}
+}
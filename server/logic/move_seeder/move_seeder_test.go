@@ -0,0 +1,151 @@
+package move_seeder
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"spirit-snap/server/models"
+	"spirit-snap/server/wrappers/datastore"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testData = `{
+  "version": 2,
+  "moves": [
+    {"name": "Tailwind", "type": "Sky", "category": "status", "power": 0, "accuracy": 100, "target": "self", "effects": [{"kind": "statStage", "target": "user", "stat": "agility", "stages": 1}]},
+    {"name": "Tempest Wing", "type": "Sky", "category": "physical", "power": 90, "accuracy": 85, "target": "single", "cooldown": 1}
+  ]
+}`
+
+func parseTestData(t *testing.T) MoveData {
+	data, err := ParseMoveData(strings.NewReader(testData))
+	assert.NoError(t, err)
+	return data
+}
+
+func storedMoves(t *testing.T, ds *datastore.MemoryClient) map[string]models.MoveDocument {
+	result, err := ds.RunQuery(context.Background(), datastore.NewQuery("moves").Limit(100))
+	assert.NoError(t, err)
+	moves := make(map[string]models.MoveDocument)
+	for _, doc := range result.Documents {
+		move := models.DecodeMoveDocument(doc)
+		moves[move.ID] = move
+	}
+	return moves
+}
+
+func TestParseMoveData(t *testing.T) {
+	data := parseTestData(t)
+
+	assert.Equal(t, 2, data.Version)
+	assert.Len(t, data.Moves, 2)
+	assert.Equal(t, models.MoveDocument{
+		Name: "Tempest Wing", Type: "Sky", DataVersion: 2, Category: "physical",
+		Power: 90, Accuracy: 85, Target: "single", Cooldown: 1,
+	}, data.Moves[1].Document(data.Version))
+}
+
+func TestParseMoveData_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{name: "Not JSON", data: `moves`, expected: "failed to parse move data"},
+		{name: "Unknown field", data: `{"version": 1, "moves": [{"name": "Tailwind", "speed": 1}]}`, expected: `unknown field "speed"`},
+		{name: "No version", data: `{"moves": [{"name": "Gust Strike", "type": "Sky", "category": "physical", "power": 40, "accuracy": 100, "target": "single"}]}`, expected: "version must be at least 1"},
+		{name: "No moves", data: `{"version": 1, "moves": []}`, expected: "move data has no moves"},
+		{name: "Invalid move", data: `{"version": 1, "moves": [{"name": "Gust Strike", "type": "Air", "category": "physical", "power": 40, "accuracy": 100, "target": "single"}]}`, expected: `move "Gust Strike": unknown type "Air"`},
+		{name: "Duplicate name", data: `{"version": 1, "moves": [
+			{"name": "Gust Strike", "type": "Sky", "category": "physical", "power": 40, "accuracy": 100, "target": "single"},
+			{"name": "gust strike", "type": "Sky", "category": "physical", "power": 50, "accuracy": 100, "target": "single"}
+		]}`, expected: `move "gust strike" is defined more than once`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseMoveData(strings.NewReader(tt.data))
+
+			assert.ErrorContains(t, err, tt.expected)
+		})
+	}
+}
+
+// The move data file the server is seeded from must always load.
+func TestParseMoveData_DataFile(t *testing.T) {
+	file, err := os.Open("../../data/moves.json")
+	assert.NoError(t, err)
+	defer file.Close()
+
+	data, err := ParseMoveData(file)
+
+	assert.NoError(t, err)
+	// Spirits are given moves of their primary type, so every type needs
+	// moves that deal damage.
+	damaging := make(map[string]int)
+	for _, move := range data.Moves {
+		if move.Category != models.MoveCategoryStatus {
+			damaging[move.Type]++
+		}
+	}
+	assert.Len(t, damaging, 18)
+	for moveType, count := range damaging {
+		assert.GreaterOrEqual(t, count, 4, moveType)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	ctx := context.Background()
+	ds := datastore.NewMemoryClient()
+	// Moves stored before the data file, one of them under an old type name.
+	// Spirits refer to them by ID.
+	assert.NoError(t, ds.CreateDocument(ctx, "moves", "old_tailwind", map[string]interface{}{"name": "tailwind", "type": "Sky"}))
+	assert.NoError(t, ds.CreateDocument(ctx, "moves", "old_gust", map[string]interface{}{"name": "Gust", "type": "Weave"}))
+	seeder := NewMoveSeeder(ds)
+	seeder.BatchSize = 1
+	data := parseTestData(t)
+
+	report, err := seeder.Seed(ctx, data, Options{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, Report{Created: 1, Updated: 1, Unlisted: 1}, report)
+	moves := storedMoves(t, ds)
+	assert.Len(t, moves, 3)
+	tailwind := data.Moves[0].Document(2)
+	tailwind.ID = "old_tailwind"
+	assert.Equal(t, tailwind, moves["old_tailwind"])
+	assert.Equal(t, models.MoveDocument{ID: "old_gust", Name: "Gust", Type: "Weave"}, moves["old_gust"])
+
+	// Seeding the same data again changes nothing.
+	report, err = seeder.Seed(ctx, data, Options{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, Report{Unchanged: 2, Unlisted: 1}, report)
+	assert.Len(t, storedMoves(t, ds), 3)
+}
+
+func TestSeed_DryRun(t *testing.T) {
+	ctx := context.Background()
+	ds := datastore.NewMemoryClient()
+	assert.NoError(t, ds.CreateDocument(ctx, "moves", "old_tailwind", map[string]interface{}{"name": "Tailwind", "type": "Sky"}))
+
+	report, err := NewMoveSeeder(ds).Seed(ctx, parseTestData(t), Options{DryRun: true})
+
+	assert.NoError(t, err)
+	assert.Equal(t, Report{Created: 1, Updated: 1}, report)
+	assert.Equal(t, map[string]models.MoveDocument{"old_tailwind": {ID: "old_tailwind", Name: "Tailwind", Type: "Sky"}}, storedMoves(t, ds))
+}
+
+func TestSeed_OutdatedData(t *testing.T) {
+	ctx := context.Background()
+	ds := datastore.NewMemoryClient()
+	assert.NoError(t, ds.CreateDocument(ctx, "moves", "newer", map[string]interface{}{"name": "Tailwind", "type": "Sky", "dataVersion": 3}))
+
+	report, err := NewMoveSeeder(ds).Seed(ctx, parseTestData(t), Options{})
+
+	assert.ErrorIs(t, err, ErrOutdatedData)
+	assert.Equal(t, Report{}, report)
+	assert.Len(t, storedMoves(t, ds), 1)
+}
@@ -0,0 +1,61 @@
+package page_token
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodec_RoundTrip(t *testing.T) {
+	codec := NewCodec([]byte("test-secret"))
+
+	token, err := codec.Encode("user1", []interface{}{"2024-01-15T10:30:00Z", 70, 1.5})
+	assert.NoError(t, err)
+
+	cursor, err := codec.Decode("user1", token)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"2024-01-15T10:30:00Z", int64(70), 1.5}, cursor)
+}
+
+func TestCodec_RejectsOtherScope(t *testing.T) {
+	codec := NewCodec([]byte("test-secret"))
+
+	token, err := codec.Encode("user1", []interface{}{"2024-01-15T10:30:00Z"})
+	assert.NoError(t, err)
+
+	_, err = codec.Decode("user2", token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestCodec_RejectsOtherSecret(t *testing.T) {
+	token, err := NewCodec([]byte("test-secret")).Encode("user1", []interface{}{"cursor"})
+	assert.NoError(t, err)
+
+	_, err = NewCodec([]byte("other-secret")).Decode("user1", token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestCodec_RejectsTamperedPayload(t *testing.T) {
+	codec := NewCodec([]byte("test-secret"))
+
+	token, err := codec.Encode("user1", []interface{}{"2024-01-15T10:30:00Z"})
+	assert.NoError(t, err)
+	forged, err := codec.Encode("user1", []interface{}{"2099-01-01T00:00:00Z"})
+	assert.NoError(t, err)
+
+	// Swap in the payload of another token while keeping the original signature.
+	payload, _, _ := strings.Cut(forged, ".")
+	_, signature, _ := strings.Cut(token, ".")
+	_, err = codec.Decode("user1", payload+"."+signature)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestCodec_RejectsMalformedToken(t *testing.T) {
+	codec := NewCodec([]byte("test-secret"))
+
+	for _, token := range []string{"", "no-separator", "!!!.!!!", "e30.e30"} {
+		_, err := codec.Decode("user1", token)
+		assert.ErrorIs(t, err, ErrInvalidToken, token)
+	}
+}
@@ -0,0 +1,472 @@
+// Runs spirit creation in the background so that clients do not have to hold
+// a request open for the whole ImageProcessor pipeline.
+//
+// Each job is stored as a document in the top level "jobs" collection and the
+// submitted photo is kept in storage until the job finishes. Jobs that are
+// left unfinished, for example because the server restarted, are picked up
+// again once their document has not been updated for StaleAfter.
+//
+// Every server recovers stale jobs, so a server claims a job before queueing
+// it by counting an attempt with a conditional update on the job's attempts.
+// Only one of several servers that read the same job can claim it. Every
+// later update is made on the condition that the claim still holds, and a
+// server stops running a job, before its spirit is added, as soon as another
+// server has claimed it.
+package spirit_jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"spirit-snap/server/logic/image_processor"
+	"spirit-snap/server/models"
+	"spirit-snap/server/wrappers/datastore"
+	"sync"
+	"time"
+)
+
+const (
+	bucketName     = "spirit-snap.appspot.com"
+	jobsCollection = "jobs"
+)
+
+// Stage is the state of a job.
+type Stage string
+
+const (
+	StageQueued          Stage = "queued"
+	StageAnalyzing       Stage = Stage(image_processor.StageAnalyzing)
+	StageGeneratingImage Stage = Stage(image_processor.StageGeneratingImage)
+	StageUploading       Stage = Stage(image_processor.StageUploading)
+	StageDone            Stage = "done"
+	StageFailed          Stage = "failed"
+)
+
+var unfinishedStages = []interface{}{StageQueued, StageAnalyzing, StageGeneratingImage, StageUploading}
+
+var (
+	// ErrNotFound is returned when a job does not exist or belongs to another
+	// user.
+	ErrNotFound = errors.New("job not found")
+	// ErrQueueFull is returned by Submit when too many jobs are waiting.
+	ErrQueueFull = errors.New("job queue is full")
+)
+
+// Job is the state of a spirit creation job as reported to clients.
+type Job struct {
+	ID    string `json:"id"`
+	Stage Stage  `json:"stage"`
+	// ID of the created spirit, set once the job is done.
+	SpiritID *string `json:"spiritId"`
+	// Reason the job failed, set once the job has failed.
+	Error     *string   `json:"error"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type ProcessorInterface interface {
+	ProcessWithProgress(photo []byte, contentType string, userId *string, onProgress image_processor.ProgressFunc) (models.Spirit, error)
+}
+
+type StorageInterface interface {
+	Write(ctx context.Context, bucketName, objectName string, data []byte, contentType string) error
+	Read(ctx context.Context, bucketName, objectName string) ([]byte, error)
+	Delete(ctx context.Context, bucketName, objectName string) error
+}
+
+type DatastoreInterface interface {
+	AddDocument(ctx context.Context, collectionName string, data interface{}) (string, error)
+	GetDocument(ctx context.Context, collectionName string, id string) (map[string]interface{}, error)
+	UpdateDocumentIf(ctx context.Context, collectionName string, id string, field string, expected interface{}, updates map[string]interface{}) error
+	RunQuery(ctx context.Context, query datastore.Query) (*datastore.PageResult, error)
+}
+
+// Config controls the size of the worker pool and how unfinished jobs are
+// recovered.
+type Config struct {
+	// Number of jobs processed concurrently.
+	Workers int
+	// Number of submitted jobs that may wait for a worker.
+	QueueSize int
+	// An unfinished job whose document has not been updated for this long is
+	// assumed to have been abandoned and is run again. It must be longer than
+	// any stage of the ImageProcessor pipeline takes.
+	StaleAfter time.Duration
+	// Number of times a job is started before it is given up on.
+	MaxAttempts int
+}
+
+// DefaultConfig returns the settings used in production.
+func DefaultConfig() Config {
+	return Config{
+		Workers:     4,
+		QueueSize:   100,
+		StaleAfter:  5 * time.Minute,
+		MaxAttempts: 3,
+	}
+}
+
+type JobManager struct {
+	Processor       ProcessorInterface
+	StorageClient   StorageInterface
+	DatastoreClient DatastoreInterface
+	Config          Config
+
+	// Returns the current time. Replaced in tests.
+	now func() time.Time
+
+	queue chan claim
+	stop  chan struct{}
+	wg    sync.WaitGroup
+
+	mu sync.Mutex
+	// IDs of jobs that are queued or running on this server.
+	active map[string]bool
+}
+
+func NewJobManager(processor ProcessorInterface, storage StorageInterface, ds DatastoreInterface, config Config) *JobManager {
+	return &JobManager{
+		Processor:       processor,
+		StorageClient:   storage,
+		DatastoreClient: ds,
+		Config:          config,
+		now:             func() time.Time { return time.Now().UTC() },
+		queue:           make(chan claim, config.QueueSize),
+		stop:            make(chan struct{}),
+		active:          make(map[string]bool),
+	}
+}
+
+// Start launches the worker pool and a loop that periodically re-queues
+// abandoned jobs, including those left over from before a restart.
+func (jm *JobManager) Start() {
+	for i := 0; i < jm.Config.Workers; i++ {
+		jm.wg.Add(1)
+		go jm.worker()
+	}
+
+	jm.wg.Add(1)
+	go func() {
+		defer jm.wg.Done()
+		jm.RecoverStaleJobs()
+		ticker := time.NewTicker(jm.Config.StaleAfter)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-jm.stop:
+				return
+			case <-ticker.C:
+				jm.RecoverStaleJobs()
+			}
+		}
+	}()
+}
+
+// Close stops the workers after their current jobs finish. Jobs still waiting
+// in the queue stay in the datastore and are recovered after a restart.
+func (jm *JobManager) Close() {
+	close(jm.stop)
+	jm.wg.Wait()
+}
+
+// Submit stores the photo and a new job document, then queues the job.
+//
+// Returns:
+//   - The ID of the new job.
+//   - ErrQueueFull if no more jobs can be queued, or another error if the job
+//     could not be stored.
+func (jm *JobManager) Submit(userId *string, photo []byte, contentType string) (string, error) {
+	ctx := context.Background()
+	if jm.queueFull() {
+		return "", ErrQueueFull
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	now := jm.now()
+	inputFilePath := fmt.Sprintf("jobInputs/%s/%s-%s", *userId, now.Format(time.RFC3339), hex.EncodeToString(suffix))
+	if err := jm.StorageClient.Write(ctx, bucketName, inputFilePath, photo, contentType); err != nil {
+		return "", err
+	}
+
+	doc := map[string]interface{}{
+		"userId":           *userId,
+		"stage":            string(StageQueued),
+		"inputFilePath":    inputFilePath,
+		"inputContentType": contentType,
+		"attempts":         0,
+		"createdAt":        now,
+		"updatedAt":        now,
+	}
+	jobId, err := jm.DatastoreClient.AddDocument(ctx, jobsCollection, doc)
+	if err != nil {
+		jm.StorageClient.Delete(ctx, bucketName, inputFilePath)
+		return "", err
+	}
+
+	// A job that is not claimed here is stored, so it is picked up by
+	// RecoverStaleJobs later.
+	if !jm.claim(ctx, jobId, doc) {
+		log.Printf("Job %s was not queued, it will be run once it is stale.", jobId)
+	}
+	return jobId, nil
+}
+
+// Get returns one of the user's jobs.
+func (jm *JobManager) Get(userId *string, jobId string) (Job, error) {
+	if jobId == "" {
+		return Job{}, ErrNotFound
+	}
+	doc, err := jm.DatastoreClient.GetDocument(context.Background(), jobsCollection, jobId)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return Job{}, ErrNotFound
+	}
+	if err != nil {
+		return Job{}, err
+	}
+	// Reporting other users' jobs as missing avoids revealing that they exist.
+	if owner := models.GetOptionalStringField(doc, "userId"); owner == nil || *owner != *userId {
+		return Job{}, ErrNotFound
+	}
+	return buildJobFromDocData(doc), nil
+}
+
+// RecoverStaleJobs claims and queues unfinished jobs that no worker has
+// updated for StaleAfter. Jobs already queued or running on this server, and
+// jobs another server claims first, are skipped.
+func (jm *JobManager) RecoverStaleJobs() {
+	ctx := context.Background()
+	cutoff := jm.now().Add(-jm.Config.StaleAfter)
+	query := datastore.NewQuery(jobsCollection).
+		Where("stage", datastore.In, unfinishedStages).
+		OrderBy(datastore.DocumentID, datastore.Asc).
+		Limit(100)
+
+	for {
+		result, err := jm.DatastoreClient.RunQuery(ctx, query)
+		if err != nil {
+			log.Printf("Error querying unfinished jobs: %s", err)
+			return
+		}
+		for _, doc := range result.Documents {
+			updatedAt, _ := doc["updatedAt"].(time.Time)
+			jobId, _ := doc["id"].(string)
+			if updatedAt.After(cutoff) || jm.isActive(jobId) {
+				continue
+			}
+			if jm.queueFull() {
+				return
+			}
+			if jm.claim(ctx, jobId, doc) {
+				log.Printf("Recovered stale job %s.", jobId)
+			}
+		}
+		if !result.HasMore {
+			return
+		}
+		query = query.StartAfter(result.LastCursor...)
+	}
+}
+
+// A job claimed by this server.
+type claim struct {
+	jobId string
+	// The job's attempts after it was claimed. The claim holds as long as
+	// the job document still has them.
+	attempts int
+}
+
+// Claims a job read as doc for this server and queues it. Claiming counts an
+// attempt and refreshes updatedAt, on the condition that the job's attempts
+// have not changed since doc was read. Jobs that have used up MaxAttempts are
+// failed instead.
+//
+// Returns true if the job was claimed and queued. Jobs that are already
+// active, that another server claimed first, or that do not fit in the queue
+// are left alone.
+func (jm *JobManager) claim(ctx context.Context, jobId string, doc map[string]interface{}) bool {
+	jm.mu.Lock()
+	if jm.active[jobId] || jm.queueFull() {
+		jm.mu.Unlock()
+		return false
+	}
+	// Marking the job active keeps this server from claiming it twice.
+	jm.active[jobId] = true
+	jm.mu.Unlock()
+	claimed := false
+	defer func() {
+		if !claimed {
+			jm.deactivate(jobId)
+		}
+	}()
+
+	attempts := 0
+	if value := models.GetOptionalIntField(doc, "attempts"); value != nil {
+		attempts = *value
+	}
+	updates := map[string]interface{}{"stage": string(StageQueued), "attempts": attempts + 1}
+	if attempts >= jm.Config.MaxAttempts {
+		updates = map[string]interface{}{"stage": string(StageFailed), "error": "job was interrupted too many times"}
+	}
+	updates["updatedAt"] = jm.now()
+	err := jm.DatastoreClient.UpdateDocumentIf(ctx, jobsCollection, jobId, "attempts", doc["attempts"], updates)
+	if errors.Is(err, datastore.ErrConditionFailed) {
+		log.Printf("Job %s was claimed by another server.", jobId)
+		return false
+	}
+	if err != nil {
+		log.Printf("Error claiming job %s: %s", jobId, err)
+		return false
+	}
+	if attempts >= jm.Config.MaxAttempts {
+		jm.deleteInput(ctx, jobId, models.GetOptionalStringField(doc, "inputFilePath"))
+		return false
+	}
+
+	select {
+	case jm.queue <- claim{jobId: jobId, attempts: attempts + 1}:
+		claimed = true
+		return true
+	default:
+		// The job stays claimed until it goes stale and is recovered.
+		log.Printf("Job queue filled up before job %s could be queued.", jobId)
+		return false
+	}
+}
+
+func (jm *JobManager) queueFull() bool {
+	return len(jm.queue) >= cap(jm.queue)
+}
+
+func (jm *JobManager) deactivate(jobId string) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	delete(jm.active, jobId)
+}
+
+func (jm *JobManager) isActive(jobId string) bool {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+	return jm.active[jobId]
+}
+
+func (jm *JobManager) worker() {
+	defer jm.wg.Done()
+	for {
+		select {
+		case <-jm.stop:
+			return
+		case job := <-jm.queue:
+			jm.run(job)
+			jm.deactivate(job.jobId)
+		}
+	}
+}
+
+// Runs a claimed job and records its outcome.
+func (jm *JobManager) run(job claim) {
+	ctx := context.Background()
+	jobId := job.jobId
+	doc, err := jm.DatastoreClient.GetDocument(ctx, jobsCollection, jobId)
+	if err != nil {
+		log.Printf("Error loading job %s: %s", jobId, err)
+		return
+	}
+	if stage := models.GetOptionalStringField(doc, "stage"); stage != nil && (Stage(*stage) == StageDone || Stage(*stage) == StageFailed) {
+		return
+	}
+	userId := models.GetOptionalStringField(doc, "userId")
+	inputFilePath := models.GetOptionalStringField(doc, "inputFilePath")
+	contentType := models.GetOptionalStringField(doc, "inputContentType")
+	if userId == nil || inputFilePath == nil || contentType == nil {
+		jm.finish(ctx, job, inputFilePath, map[string]interface{}{"stage": string(StageFailed), "error": "job document is incomplete"})
+		return
+	}
+
+	// The job may have gone stale while it waited in the queue and been
+	// claimed by another server.
+	if err := jm.update(ctx, job, map[string]interface{}{"stage": string(StageAnalyzing)}); err != nil {
+		return
+	}
+
+	input, err := jm.StorageClient.Read(ctx, bucketName, *inputFilePath)
+	if err != nil {
+		// The input may be temporarily unavailable, so leave the job to be
+		// recovered rather than failing it.
+		log.Printf("Error reading input of job %s: %s", jobId, err)
+		return
+	}
+	// A stage that takes longer than StaleAfter lets another server claim
+	// the job. The pipeline is then stopped at the next stage, before it
+	// saves a spirit, and the job is left to the other server.
+	spirit, err := jm.Processor.ProcessWithProgress(input, *contentType, userId, func(event image_processor.ProgressEvent) error {
+		if event.Type != image_processor.EventStage {
+			return nil
+		}
+		if err := jm.update(ctx, job, map[string]interface{}{"stage": string(event.Stage)}); errors.Is(err, datastore.ErrConditionFailed) {
+			return err
+		}
+		return nil
+	})
+	if errors.Is(err, datastore.ErrConditionFailed) {
+		return
+	}
+	if err != nil {
+		log.Printf("Job %s failed: %s", jobId, err)
+		jm.finish(ctx, job, inputFilePath, map[string]interface{}{"stage": string(StageFailed), "error": err.Error()})
+		return
+	}
+	jm.finish(ctx, job, inputFilePath, map[string]interface{}{"stage": string(StageDone), "spiritId": *spirit.ID})
+}
+
+// Records the final state of a job and removes its input photo.
+func (jm *JobManager) finish(ctx context.Context, job claim, inputFilePath *string, updates map[string]interface{}) {
+	if err := jm.update(ctx, job, updates); err != nil {
+		return
+	}
+	jm.deleteInput(ctx, job.jobId, inputFilePath)
+}
+
+func (jm *JobManager) deleteInput(ctx context.Context, jobId string, inputFilePath *string) {
+	if inputFilePath == nil {
+		return
+	}
+	if err := jm.StorageClient.Delete(ctx, bucketName, *inputFilePath); err != nil {
+		log.Printf("Error deleting input of job %s: %s", jobId, err)
+	}
+}
+
+// Updates a job on the condition that this server's claim still holds.
+// Returns an error wrapping datastore.ErrConditionFailed if another server has
+// claimed the job since.
+func (jm *JobManager) update(ctx context.Context, job claim, updates map[string]interface{}) error {
+	updates["updatedAt"] = jm.now()
+	err := jm.DatastoreClient.UpdateDocumentIf(ctx, jobsCollection, job.jobId, "attempts", job.attempts, updates)
+	if errors.Is(err, datastore.ErrConditionFailed) {
+		log.Printf("Job %s was claimed by another server.", job.jobId)
+	} else if err != nil {
+		log.Printf("Error updating job %s: %s", job.jobId, err)
+	}
+	return err
+}
+
+func buildJobFromDocData(doc map[string]interface{}) Job {
+	job := Job{
+		SpiritID: models.GetOptionalStringField(doc, "spiritId"),
+		Error:    models.GetOptionalStringField(doc, "error"),
+	}
+	if id := models.GetOptionalStringField(doc, "id"); id != nil {
+		job.ID = *id
+	}
+	if stage := models.GetOptionalStringField(doc, "stage"); stage != nil {
+		job.Stage = Stage(*stage)
+	}
+	job.CreatedAt, _ = doc["createdAt"].(time.Time)
+	job.UpdatedAt, _ = doc["updatedAt"].(time.Time)
+	return job
+}
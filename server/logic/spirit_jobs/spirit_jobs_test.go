@@ -0,0 +1,352 @@
+package spirit_jobs
+
+import (
+	"context"
+	"errors"
+	"spirit-snap/server/logic/image_processor"
+	"spirit-snap/server/models"
+	"spirit-snap/server/wrappers/datastore"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockProcessor struct {
+	mock.Mock
+}
+
+func (m *MockProcessor) ProcessWithProgress(photo []byte, contentType string, userId *string, onProgress image_processor.ProgressFunc) (models.Spirit, error) {
+	args := m.Called(photo, contentType, *userId)
+	if err := onProgress(image_processor.ProgressEvent{Type: image_processor.EventStage, Stage: image_processor.StageGeneratingImage}); err != nil {
+		return models.Spirit{}, err
+	}
+	if err := onProgress(image_processor.ProgressEvent{Type: image_processor.EventMoves, Spirit: &models.Spirit{}}); err != nil {
+		return models.Spirit{}, err
+	}
+	return args.Get(0).(models.Spirit), args.Error(1)
+}
+
+type MockStorageClient struct {
+	mock.Mock
+}
+
+func (m *MockStorageClient) Write(ctx context.Context, bucketName, objectName string, data []byte, contentType string) error {
+	args := m.Called(ctx, bucketName, objectName, data, contentType)
+	return args.Error(0)
+}
+
+func (m *MockStorageClient) Read(ctx context.Context, bucketName, objectName string) ([]byte, error) {
+	args := m.Called(ctx, bucketName, objectName)
+	data, _ := args.Get(0).([]byte)
+	return data, args.Error(1)
+}
+
+func (m *MockStorageClient) Delete(ctx context.Context, bucketName, objectName string) error {
+	args := m.Called(ctx, bucketName, objectName)
+	return args.Error(0)
+}
+
+type MockDatastoreClient struct {
+	mock.Mock
+}
+
+func (m *MockDatastoreClient) AddDocument(ctx context.Context, collectionName string, data interface{}) (string, error) {
+	args := m.Called(ctx, collectionName, data)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockDatastoreClient) GetDocument(ctx context.Context, collectionName string, id string) (map[string]interface{}, error) {
+	args := m.Called(ctx, collectionName, id)
+	doc, _ := args.Get(0).(map[string]interface{})
+	return doc, args.Error(1)
+}
+
+func (m *MockDatastoreClient) UpdateDocumentIf(ctx context.Context, collectionName string, id string, field string, expected interface{}, updates map[string]interface{}) error {
+	args := m.Called(ctx, collectionName, id, field, expected, updates)
+	return args.Error(0)
+}
+
+func (m *MockDatastoreClient) RunQuery(ctx context.Context, query datastore.Query) (*datastore.PageResult, error) {
+	args := m.Called(ctx, query)
+	result, _ := args.Get(0).(*datastore.PageResult)
+	return result, args.Error(1)
+}
+
+var testNow = time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+func newTestJobManager(config Config) (*JobManager, *MockProcessor, *MockStorageClient, *MockDatastoreClient) {
+	processor := &MockProcessor{}
+	storage := &MockStorageClient{}
+	ds := &MockDatastoreClient{}
+	jm := NewJobManager(processor, storage, ds, config)
+	jm.now = func() time.Time { return testNow }
+	return jm, processor, storage, ds
+}
+
+func hasStage(stage Stage) interface{} {
+	return mock.MatchedBy(func(updates map[string]interface{}) bool { return updates["stage"] == string(stage) })
+}
+
+func TestJobManager_Submit(t *testing.T) {
+	jm, _, storage, ds := newTestJobManager(DefaultConfig())
+	userId := "user1"
+	photo := []byte("jpeg bytes")
+
+	storage.On("Write", mock.Anything, bucketName, mock.Anything, photo, "image/jpeg").Return(nil)
+	ds.On("AddDocument", mock.Anything, jobsCollection, mock.MatchedBy(func(data map[string]interface{}) bool {
+		return data["userId"] == "user1" && data["stage"] == string(StageQueued) && data["attempts"] == 0 && data["inputContentType"] == "image/jpeg"
+	})).Return("job1", nil)
+	ds.On("UpdateDocumentIf", mock.Anything, jobsCollection, "job1", "attempts", 0, mock.MatchedBy(func(updates map[string]interface{}) bool {
+		return updates["stage"] == string(StageQueued) && updates["attempts"] == 1
+	})).Return(nil)
+
+	jobId, err := jm.Submit(&userId, photo, "image/jpeg")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "job1", jobId)
+	assert.True(t, jm.isActive("job1"))
+	assert.Len(t, jm.queue, 1)
+	assert.Equal(t, claim{jobId: "job1", attempts: 1}, <-jm.queue)
+	storage.AssertExpectations(t)
+	ds.AssertExpectations(t)
+}
+
+func TestJobManager_SubmitQueueFull(t *testing.T) {
+	config := DefaultConfig()
+	config.QueueSize = 1
+	jm, _, _, _ := newTestJobManager(config)
+	jm.queue <- claim{jobId: "other"}
+	userId := "user1"
+
+	_, err := jm.Submit(&userId, []byte("jpeg bytes"), "image/jpeg")
+
+	assert.ErrorIs(t, err, ErrQueueFull)
+}
+
+func TestJobManager_SubmitDatastoreError(t *testing.T) {
+	jm, _, storage, ds := newTestJobManager(DefaultConfig())
+	userId := "user1"
+
+	storage.On("Write", mock.Anything, bucketName, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	storage.On("Delete", mock.Anything, bucketName, mock.Anything).Return(nil)
+	ds.On("AddDocument", mock.Anything, jobsCollection, mock.Anything).Return("", errors.New("datastore error"))
+
+	_, err := jm.Submit(&userId, []byte("jpeg bytes"), "image/jpeg")
+
+	assert.Error(t, err)
+	// The stored input is cleaned up when the job cannot be created.
+	storage.AssertCalled(t, "Delete", mock.Anything, bucketName, mock.Anything)
+	assert.Empty(t, jm.queue)
+}
+
+func TestJobManager_Get(t *testing.T) {
+	jm, _, _, ds := newTestJobManager(DefaultConfig())
+	userId := "user1"
+	ds.On("GetDocument", mock.Anything, jobsCollection, "job1").Return(map[string]interface{}{
+		"id":        "job1",
+		"userId":    "user1",
+		"stage":     "done",
+		"spiritId":  "spirit1",
+		"createdAt": testNow,
+		"updatedAt": testNow,
+	}, nil)
+
+	job, err := jm.Get(&userId, "job1")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "job1", job.ID)
+	assert.Equal(t, StageDone, job.Stage)
+	assert.Equal(t, "spirit1", *job.SpiritID)
+	assert.Nil(t, job.Error)
+	assert.Equal(t, testNow, job.CreatedAt)
+}
+
+func TestJobManager_GetOtherUsersJob(t *testing.T) {
+	jm, _, _, ds := newTestJobManager(DefaultConfig())
+	userId := "user2"
+	ds.On("GetDocument", mock.Anything, jobsCollection, "job1").Return(map[string]interface{}{"id": "job1", "userId": "user1"}, nil)
+
+	_, err := jm.Get(&userId, "job1")
+
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestJobManager_GetMissingJob(t *testing.T) {
+	jm, _, _, ds := newTestJobManager(DefaultConfig())
+	userId := "user1"
+	ds.On("GetDocument", mock.Anything, jobsCollection, "job1").Return(nil, datastore.ErrNotFound)
+
+	_, err := jm.Get(&userId, "job1")
+
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestJobManager_RunSuccess(t *testing.T) {
+	jm, processor, storage, ds := newTestJobManager(DefaultConfig())
+	spiritId := "spirit1"
+
+	ds.On("GetDocument", mock.Anything, jobsCollection, "job1").Return(map[string]interface{}{
+		"id":               "job1",
+		"userId":           "user1",
+		"stage":            "queued",
+		"inputFilePath":    "jobInputs/user1/input",
+		"inputContentType": "image/jpeg",
+		"attempts":         int64(1),
+	}, nil)
+	ds.On("UpdateDocumentIf", mock.Anything, jobsCollection, "job1", "attempts", 1, mock.Anything).Return(nil)
+	storage.On("Read", mock.Anything, bucketName, "jobInputs/user1/input").Return([]byte("jpeg bytes"), nil)
+	storage.On("Delete", mock.Anything, bucketName, "jobInputs/user1/input").Return(nil)
+	processor.On("ProcessWithProgress", []byte("jpeg bytes"), "image/jpeg", "user1").Return(models.Spirit{ID: &spiritId}, nil)
+
+	jm.run(claim{jobId: "job1", attempts: 1})
+
+	ds.AssertExpectations(t)
+	ds.AssertCalled(t, "UpdateDocumentIf", mock.Anything, jobsCollection, "job1", "attempts", 1, hasStage(StageAnalyzing))
+	ds.AssertCalled(t, "UpdateDocumentIf", mock.Anything, jobsCollection, "job1", "attempts", 1, hasStage(StageGeneratingImage))
+	// Only stage events change the job.
+	ds.AssertNotCalled(t, "UpdateDocumentIf", mock.Anything, jobsCollection, "job1", "attempts", 1, hasStage(""))
+	ds.AssertCalled(t, "UpdateDocumentIf", mock.Anything, jobsCollection, "job1", "attempts", 1, mock.MatchedBy(func(updates map[string]interface{}) bool {
+		return updates["stage"] == string(StageDone) && updates["spiritId"] == "spirit1" && updates["updatedAt"] == testNow
+	}))
+	storage.AssertExpectations(t)
+}
+
+func TestJobManager_RunProcessorError(t *testing.T) {
+	jm, processor, storage, ds := newTestJobManager(DefaultConfig())
+
+	ds.On("GetDocument", mock.Anything, jobsCollection, "job1").Return(map[string]interface{}{
+		"userId":           "user1",
+		"stage":            "queued",
+		"inputFilePath":    "jobInputs/user1/input.txt",
+		"inputContentType": "image/png",
+		"attempts":         int64(1),
+	}, nil)
+	ds.On("UpdateDocumentIf", mock.Anything, jobsCollection, "job1", "attempts", 1, mock.Anything).Return(nil)
+	storage.On("Read", mock.Anything, bucketName, mock.Anything).Return([]byte("image"), nil)
+	storage.On("Delete", mock.Anything, bucketName, mock.Anything).Return(nil)
+	processor.On("ProcessWithProgress", []byte("image"), "image/png", "user1").Return(models.Spirit{}, errors.New("generation failed"))
+
+	jm.run(claim{jobId: "job1", attempts: 1})
+
+	ds.AssertCalled(t, "UpdateDocumentIf", mock.Anything, jobsCollection, "job1", "attempts", 1, mock.MatchedBy(func(updates map[string]interface{}) bool {
+		return updates["stage"] == string(StageFailed) && updates["error"] == "generation failed"
+	}))
+	storage.AssertCalled(t, "Delete", mock.Anything, bucketName, "jobInputs/user1/input.txt")
+}
+
+// Jobs from before the input content type was stored cannot be run.
+func TestJobManager_RunMissingContentType(t *testing.T) {
+	jm, processor, storage, ds := newTestJobManager(DefaultConfig())
+
+	ds.On("GetDocument", mock.Anything, jobsCollection, "job1").Return(map[string]interface{}{
+		"userId":        "user1",
+		"stage":         "queued",
+		"inputFilePath": "jobInputs/user1/input.txt",
+		"attempts":      int64(1),
+	}, nil)
+	ds.On("UpdateDocumentIf", mock.Anything, jobsCollection, "job1", "attempts", 1, mock.Anything).Return(nil)
+	storage.On("Delete", mock.Anything, bucketName, mock.Anything).Return(nil)
+
+	jm.run(claim{jobId: "job1", attempts: 1})
+
+	ds.AssertCalled(t, "UpdateDocumentIf", mock.Anything, jobsCollection, "job1", "attempts", 1, mock.MatchedBy(func(updates map[string]interface{}) bool {
+		return updates["stage"] == string(StageFailed) && updates["error"] == "job document is incomplete"
+	}))
+	storage.AssertCalled(t, "Delete", mock.Anything, bucketName, "jobInputs/user1/input.txt")
+	processor.AssertNotCalled(t, "ProcessWithProgress", mock.Anything, mock.Anything, mock.Anything)
+}
+
+// A job that went stale while it was queued may have been claimed by another
+// server, which then runs it.
+func TestJobManager_RunClaimLost(t *testing.T) {
+	jm, processor, storage, ds := newTestJobManager(DefaultConfig())
+
+	ds.On("GetDocument", mock.Anything, jobsCollection, "job1").Return(map[string]interface{}{
+		"userId":           "user1",
+		"stage":            "queued",
+		"inputFilePath":    "jobInputs/user1/input.txt",
+		"inputContentType": "image/jpeg",
+		"attempts":         int64(2),
+	}, nil)
+	ds.On("UpdateDocumentIf", mock.Anything, jobsCollection, "job1", "attempts", 1, mock.Anything).Return(datastore.ErrConditionFailed)
+
+	jm.run(claim{jobId: "job1", attempts: 1})
+
+	ds.AssertNumberOfCalls(t, "UpdateDocumentIf", 1)
+	storage.AssertNotCalled(t, "Read", mock.Anything, mock.Anything, mock.Anything)
+	storage.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything, mock.Anything)
+	processor.AssertNotCalled(t, "ProcessWithProgress", mock.Anything, mock.Anything, mock.Anything)
+}
+
+// A stage that outlasts StaleAfter lets another server claim the job while it
+// runs. The pipeline stops at the next stage, so no spirit is added, and the
+// job is left to the other server.
+func TestJobManager_RunClaimLostWhileRunning(t *testing.T) {
+	jm, processor, storage, ds := newTestJobManager(DefaultConfig())
+	spiritId := "spirit1"
+
+	ds.On("GetDocument", mock.Anything, jobsCollection, "job1").Return(map[string]interface{}{
+		"userId":           "user1",
+		"stage":            "queued",
+		"inputFilePath":    "jobInputs/user1/input.txt",
+		"inputContentType": "image/jpeg",
+		"attempts":         int64(1),
+	}, nil)
+	ds.On("UpdateDocumentIf", mock.Anything, jobsCollection, "job1", "attempts", 1, hasStage(StageAnalyzing)).Return(nil)
+	ds.On("UpdateDocumentIf", mock.Anything, jobsCollection, "job1", "attempts", 1, hasStage(StageGeneratingImage)).Return(datastore.ErrConditionFailed)
+	storage.On("Read", mock.Anything, bucketName, "jobInputs/user1/input.txt").Return([]byte("jpeg bytes"), nil)
+	processor.On("ProcessWithProgress", []byte("jpeg bytes"), "image/jpeg", "user1").Return(models.Spirit{ID: &spiritId}, nil)
+
+	jm.run(claim{jobId: "job1", attempts: 1})
+
+	ds.AssertNumberOfCalls(t, "UpdateDocumentIf", 2)
+	ds.AssertNotCalled(t, "UpdateDocumentIf", mock.Anything, jobsCollection, "job1", "attempts", 1, hasStage(StageDone))
+	ds.AssertNotCalled(t, "UpdateDocumentIf", mock.Anything, jobsCollection, "job1", "attempts", 1, hasStage(StageFailed))
+	storage.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestJobManager_RunSkipsFinishedJob(t *testing.T) {
+	jm, processor, _, ds := newTestJobManager(DefaultConfig())
+	ds.On("GetDocument", mock.Anything, jobsCollection, "job1").Return(map[string]interface{}{"userId": "user1", "stage": "done"}, nil)
+
+	jm.run(claim{jobId: "job1", attempts: 1})
+
+	ds.AssertNotCalled(t, "UpdateDocumentIf", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	processor.AssertNotCalled(t, "ProcessWithProgress", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestJobManager_RecoverStaleJobs(t *testing.T) {
+	jm, _, storage, ds := newTestJobManager(DefaultConfig())
+	jm.active["running"] = true
+
+	ds.On("RunQuery", mock.Anything, mock.MatchedBy(func(query datastore.Query) bool {
+		return query.Collection == jobsCollection && query.Filters[0].Operator == datastore.In
+	})).Return(&datastore.PageResult{
+		Documents: []map[string]interface{}{
+			{"id": "stale", "updatedAt": testNow.Add(-time.Hour), "attempts": int64(1)},
+			{"id": "fresh", "updatedAt": testNow.Add(-time.Second), "attempts": int64(1)},
+			{"id": "running", "updatedAt": testNow.Add(-time.Hour), "attempts": int64(1)},
+			{"id": "claimed", "updatedAt": testNow.Add(-time.Hour), "attempts": int64(1)},
+			{"id": "exhausted", "updatedAt": testNow.Add(-time.Hour), "attempts": int64(3), "inputFilePath": "jobInputs/user1/input.txt"},
+		},
+	}, nil)
+	ds.On("UpdateDocumentIf", mock.Anything, jobsCollection, "stale", "attempts", int64(1), mock.MatchedBy(func(updates map[string]interface{}) bool {
+		return updates["stage"] == string(StageQueued) && updates["attempts"] == 2 && updates["updatedAt"] == testNow
+	})).Return(nil)
+	// Another server claimed the job after it was read.
+	ds.On("UpdateDocumentIf", mock.Anything, jobsCollection, "claimed", "attempts", int64(1), mock.Anything).Return(datastore.ErrConditionFailed)
+	ds.On("UpdateDocumentIf", mock.Anything, jobsCollection, "exhausted", "attempts", int64(3), hasStage(StageFailed)).Return(nil)
+	storage.On("Delete", mock.Anything, bucketName, "jobInputs/user1/input.txt").Return(nil)
+
+	jm.RecoverStaleJobs()
+
+	ds.AssertExpectations(t)
+	storage.AssertExpectations(t)
+	assert.Len(t, jm.queue, 1)
+	assert.Equal(t, claim{jobId: "stale", attempts: 2}, <-jm.queue)
+	assert.True(t, jm.isActive("stale"))
+	assert.False(t, jm.isActive("claimed"))
+	assert.False(t, jm.isActive("exhausted"))
+}
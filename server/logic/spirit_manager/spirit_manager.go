@@ -0,0 +1,203 @@
+// The logic for reading, editing and deleting a single spirit.
+package spirit_manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"spirit-snap/server/models"
+	"spirit-snap/server/wrappers/datastore"
+	"strings"
+	"unicode/utf8"
+)
+
+const bucketName = "spirit-snap.appspot.com"
+
+const (
+	maxNameLength        = 50
+	maxDescriptionLength = 500
+)
+
+var (
+	// ErrNotFound is returned when the spirit does not exist in the caller's
+	// collection.
+	ErrNotFound = errors.New("spirit not found")
+	// ErrForbidden is returned when a spirit document references files that do
+	// not belong to the caller.
+	ErrForbidden = errors.New("spirit does not belong to user")
+	// ErrInvalidUpdate is returned when an update has no fields or a field is
+	// not acceptable.
+	ErrInvalidUpdate = errors.New("invalid spirit update")
+)
+
+type StorageInterface interface {
+	GetDownloadURL(ctx context.Context, bucketName, objectName string) (string, error)
+	Delete(ctx context.Context, bucketName, objectName string) error
+}
+
+type DatastoreInterface interface {
+	GetDocument(ctx context.Context, collectionName string, id string) (map[string]interface{}, error)
+	UpdateDocument(ctx context.Context, collectionName string, id string, updates map[string]interface{}) error
+	DeleteDocument(ctx context.Context, collectionName string, id string) error
+	GetDocumentsByIds(ctx context.Context, collectionName string, ids []string) ([]map[string]interface{}, error)
+}
+
+type SpiritManager struct {
+	StorageClient   StorageInterface
+	DatastoreClient DatastoreInterface
+}
+
+func NewSpiritManager(storage StorageInterface, ds DatastoreInterface) *SpiritManager {
+	return &SpiritManager{
+		StorageClient:   storage,
+		DatastoreClient: ds,
+	}
+}
+
+// SpiritUpdate holds the user editable fields of a spirit. Nil fields are left
+// unchanged.
+type SpiritUpdate struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+// Get returns one of the user's spirits.
+func (sm *SpiritManager) Get(userId *string, spiritId string) (models.Spirit, error) {
+	ctx := context.Background()
+	doc, err := sm.getOwnedDocument(ctx, *userId, spiritId)
+	if err != nil {
+		return models.Spirit{}, err
+	}
+	return models.BuildSpirit(ctx, sm.StorageClient, doc, sm.DatastoreClient), nil
+}
+
+// Update renames a spirit or edits its description and returns the updated
+// spirit.
+func (sm *SpiritManager) Update(userId *string, spiritId string, update SpiritUpdate) (models.Spirit, error) {
+	ctx := context.Background()
+	updates, err := update.toDocumentUpdates()
+	if err != nil {
+		return models.Spirit{}, err
+	}
+	if _, err := sm.getOwnedDocument(ctx, *userId, spiritId); err != nil {
+		return models.Spirit{}, err
+	}
+
+	err = sm.DatastoreClient.UpdateDocument(ctx, spiritsCollection(*userId), spiritId, updates)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return models.Spirit{}, ErrNotFound
+	}
+	if err != nil {
+		return models.Spirit{}, err
+	}
+	return sm.Get(userId, spiritId)
+}
+
+// Delete removes a spirit along with the original photo, generated image and
+// generated image renditions that were stored for it.
+//
+// The images are deleted before the document. Storage deletes are idempotent,
+// so if any step fails the whole delete can be retried.
+func (sm *SpiritManager) Delete(userId *string, spiritId string) error {
+	ctx := context.Background()
+	doc, err := sm.getOwnedDocument(ctx, *userId, spiritId)
+	if err != nil {
+		return err
+	}
+
+	filePaths := []string{}
+	addFilePath := func(field string, path string) error {
+		if path == "" {
+			return nil
+		}
+		if !ownsFile(*userId, path) {
+			log.Printf("Refusing to delete spirit %s: %s %q is outside of user %s's folders", spiritId, field, path, *userId)
+			return ErrForbidden
+		}
+		filePaths = append(filePaths, path)
+		return nil
+	}
+	if err := addFilePath("originalImageFilePath", doc.OriginalImageFilePath); err != nil {
+		return err
+	}
+	if err := addFilePath("generatedImageFilePath", doc.GeneratedImageFilePath); err != nil {
+		return err
+	}
+	renditions := doc.GeneratedImageRenditionFilePaths
+	for _, size := range slices.Sorted(maps.Keys(renditions)) {
+		if err := addFilePath("generatedImageRenditionFilePaths."+size, renditions[size]); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range filePaths {
+		if err := sm.StorageClient.Delete(ctx, bucketName, path); err != nil {
+			return fmt.Errorf("failed to delete spirit image %s: %w", path, err)
+		}
+	}
+	return sm.DatastoreClient.DeleteDocument(ctx, spiritsCollection(*userId), spiritId)
+}
+
+// Loads a spirit document from the user's own collection. Because the
+// collection path is derived from the user ID, a spirit owned by someone else
+// is reported as not found rather than revealing that it exists.
+func (sm *SpiritManager) getOwnedDocument(ctx context.Context, userId string, spiritId string) (models.SpiritDocument, error) {
+	if !isValidDocumentId(spiritId) {
+		return models.SpiritDocument{}, ErrNotFound
+	}
+	doc, err := sm.DatastoreClient.GetDocument(ctx, spiritsCollection(userId), spiritId)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return models.SpiritDocument{}, ErrNotFound
+	}
+	if err != nil {
+		return models.SpiritDocument{}, err
+	}
+	return models.DecodeSpiritDocument(doc), nil
+}
+
+func (update SpiritUpdate) toDocumentUpdates() (map[string]interface{}, error) {
+	updates := make(map[string]interface{})
+	if update.Name != nil {
+		name := strings.TrimSpace(*update.Name)
+		if name == "" || utf8.RuneCountInString(name) > maxNameLength {
+			return nil, fmt.Errorf("%w: name must be between 1 and %d characters", ErrInvalidUpdate, maxNameLength)
+		}
+		updates["name"] = name
+	}
+	if update.Description != nil {
+		description := strings.TrimSpace(*update.Description)
+		if utf8.RuneCountInString(description) > maxDescriptionLength {
+			return nil, fmt.Errorf("%w: description must be at most %d characters", ErrInvalidUpdate, maxDescriptionLength)
+		}
+		updates["description"] = description
+	}
+	if len(updates) == 0 {
+		return nil, fmt.Errorf("%w: nothing to update", ErrInvalidUpdate)
+	}
+	return updates, nil
+}
+
+func spiritsCollection(userId string) string {
+	return "users/" + userId + "/spirits"
+}
+
+// Document IDs must be a single path segment, otherwise they could address a
+// document outside of the user's spirits collection.
+func isValidDocumentId(id string) bool {
+	return id != "" && id != "." && id != ".." && !strings.Contains(id, "/")
+}
+
+// Reports whether a storage path is inside one of the folders that
+// ImageProcessor writes the user's images to.
+func ownsFile(userId string, path string) bool {
+	for _, folder := range []string{"photos/", "generatedImages/"} {
+		prefix := folder + userId + "/"
+		if strings.HasPrefix(path, prefix) && !strings.Contains(path[len(prefix):], "..") {
+			return true
+		}
+	}
+	return false
+}
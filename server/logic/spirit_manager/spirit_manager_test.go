@@ -0,0 +1,263 @@
+package spirit_manager
+
+import (
+	"context"
+	"errors"
+	"spirit-snap/server/models"
+	"spirit-snap/server/wrappers/datastore"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockStorageClient struct {
+	mock.Mock
+}
+
+func (m *MockStorageClient) GetDownloadURL(ctx context.Context, bucketName, objectName string) (string, error) {
+	args := m.Called(ctx, bucketName, objectName)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockStorageClient) Delete(ctx context.Context, bucketName, objectName string) error {
+	args := m.Called(ctx, bucketName, objectName)
+	return args.Error(0)
+}
+
+type MockDatastoreClient struct {
+	mock.Mock
+}
+
+func (m *MockDatastoreClient) GetDocument(ctx context.Context, collectionName string, id string) (map[string]interface{}, error) {
+	args := m.Called(ctx, collectionName, id)
+	doc, _ := args.Get(0).(map[string]interface{})
+	return doc, args.Error(1)
+}
+
+func (m *MockDatastoreClient) UpdateDocument(ctx context.Context, collectionName string, id string, updates map[string]interface{}) error {
+	args := m.Called(ctx, collectionName, id, updates)
+	return args.Error(0)
+}
+
+func (m *MockDatastoreClient) DeleteDocument(ctx context.Context, collectionName string, id string) error {
+	args := m.Called(ctx, collectionName, id)
+	return args.Error(0)
+}
+
+func (m *MockDatastoreClient) GetDocumentsByIds(ctx context.Context, collectionName string, ids []string) ([]map[string]interface{}, error) {
+	args := m.Called(ctx, collectionName, ids)
+	return args.Get(0).([]map[string]interface{}), args.Error(1)
+}
+
+func testSpiritDoc() map[string]interface{} {
+	return map[string]interface{}{
+		"id":                     "spirit1",
+		"schemaVersion":          int64(models.CurrentSpiritSchemaVersion),
+		"name":                   "Test Spirit",
+		"description":            "Test Description",
+		"originalImageFilePath":  "photos/user1/2024-01-15T10:30:00Z-original.jpeg",
+		"generatedImageFilePath": "generatedImages/user1/2024-01-15T10:30:00Z-generated.webp",
+	}
+}
+
+func TestSpiritManager_Get(t *testing.T) {
+	mockStorage := &MockStorageClient{}
+	mockDatastore := &MockDatastoreClient{}
+	manager := NewSpiritManager(mockStorage, mockDatastore)
+	userId := "user1"
+
+	mockDatastore.On("GetDocument", mock.Anything, "users/user1/spirits", "spirit1").Return(testSpiritDoc(), nil)
+	mockStorage.On("GetDownloadURL", mock.Anything, bucketName, mock.Anything).Return("http://url", nil)
+
+	spirit, err := manager.Get(&userId, "spirit1")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "spirit1", *spirit.ID)
+	assert.Equal(t, "Test Spirit", *spirit.Name)
+	assert.Equal(t, "http://url", *spirit.GeneratedImageURL)
+	mockDatastore.AssertExpectations(t)
+}
+
+func TestSpiritManager_GetUpgradesOldDocument(t *testing.T) {
+	mockStorage := &MockStorageClient{}
+	mockDatastore := &MockDatastoreClient{}
+	manager := NewSpiritManager(mockStorage, mockDatastore)
+	userId := "user1"
+	doc := testSpiritDoc()
+	delete(doc, "schemaVersion")
+	delete(doc, "secondaryType")
+
+	mockDatastore.On("GetDocument", mock.Anything, "users/user1/spirits", "spirit1").Return(doc, nil)
+	mockStorage.On("GetDownloadURL", mock.Anything, bucketName, mock.Anything).Return("http://url", nil)
+
+	spirit, err := manager.Get(&userId, "spirit1")
+
+	// The spirit is served upgraded, but only the spirit migrator writes
+	// upgrades back.
+	assert.NoError(t, err)
+	assert.Equal(t, "None", *spirit.SecondaryType)
+	mockDatastore.AssertNotCalled(t, "UpdateDocument", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSpiritManager_GetNotFound(t *testing.T) {
+	mockDatastore := &MockDatastoreClient{}
+	manager := NewSpiritManager(&MockStorageClient{}, mockDatastore)
+	userId := "user1"
+
+	mockDatastore.On("GetDocument", mock.Anything, "users/user1/spirits", "missing").Return(nil, datastore.ErrNotFound)
+
+	_, err := manager.Get(&userId, "missing")
+
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestSpiritManager_RejectsIdsOutsideCollection(t *testing.T) {
+	mockDatastore := &MockDatastoreClient{}
+	manager := NewSpiritManager(&MockStorageClient{}, mockDatastore)
+	userId := "user1"
+
+	for _, spiritId := range []string{"", ".", "..", "../../user2/spirits/spirit1", "spirit1/moves/x"} {
+		_, err := manager.Get(&userId, spiritId)
+		assert.ErrorIs(t, err, ErrNotFound, spiritId)
+		assert.ErrorIs(t, manager.Delete(&userId, spiritId), ErrNotFound, spiritId)
+	}
+	mockDatastore.AssertNotCalled(t, "GetDocument", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSpiritManager_Update(t *testing.T) {
+	mockStorage := &MockStorageClient{}
+	mockDatastore := &MockDatastoreClient{}
+	manager := NewSpiritManager(mockStorage, mockDatastore)
+	userId := "user1"
+
+	updated := testSpiritDoc()
+	updated["name"] = "Renamed"
+	mockDatastore.On("GetDocument", mock.Anything, "users/user1/spirits", "spirit1").Return(testSpiritDoc(), nil).Once()
+	mockDatastore.On("UpdateDocument", mock.Anything, "users/user1/spirits", "spirit1", map[string]interface{}{"name": "Renamed"}).Return(nil)
+	mockDatastore.On("GetDocument", mock.Anything, "users/user1/spirits", "spirit1").Return(updated, nil).Once()
+	mockStorage.On("GetDownloadURL", mock.Anything, bucketName, mock.Anything).Return("http://url", nil)
+
+	name := "  Renamed "
+	spirit, err := manager.Update(&userId, "spirit1", SpiritUpdate{Name: &name})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Renamed", *spirit.Name)
+	mockDatastore.AssertExpectations(t)
+}
+
+func TestSpiritManager_UpdateInvalid(t *testing.T) {
+	mockDatastore := &MockDatastoreClient{}
+	manager := NewSpiritManager(&MockStorageClient{}, mockDatastore)
+	userId := "user1"
+
+	empty := " "
+	long := string(make([]rune, maxDescriptionLength+1))
+	tests := []struct {
+		name   string
+		update SpiritUpdate
+	}{
+		{name: "No fields", update: SpiritUpdate{}},
+		{name: "Blank name", update: SpiritUpdate{Name: &empty}},
+		{name: "Description too long", update: SpiritUpdate{Description: &long}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := manager.Update(&userId, "spirit1", tt.update)
+			assert.ErrorIs(t, err, ErrInvalidUpdate)
+		})
+	}
+	mockDatastore.AssertNotCalled(t, "UpdateDocument", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSpiritManager_Delete(t *testing.T) {
+	mockStorage := &MockStorageClient{}
+	mockDatastore := &MockDatastoreClient{}
+	manager := NewSpiritManager(mockStorage, mockDatastore)
+	userId := "user1"
+
+	mockDatastore.On("GetDocument", mock.Anything, "users/user1/spirits", "spirit1").Return(testSpiritDoc(), nil)
+	mockStorage.On("Delete", mock.Anything, bucketName, "photos/user1/2024-01-15T10:30:00Z-original.jpeg").Return(nil)
+	mockStorage.On("Delete", mock.Anything, bucketName, "generatedImages/user1/2024-01-15T10:30:00Z-generated.webp").Return(nil)
+	mockDatastore.On("DeleteDocument", mock.Anything, "users/user1/spirits", "spirit1").Return(nil)
+
+	err := manager.Delete(&userId, "spirit1")
+
+	assert.NoError(t, err)
+	mockStorage.AssertExpectations(t)
+	mockDatastore.AssertExpectations(t)
+}
+
+func TestSpiritManager_DeleteRenditions(t *testing.T) {
+	mockStorage := &MockStorageClient{}
+	mockDatastore := &MockDatastoreClient{}
+	manager := NewSpiritManager(mockStorage, mockDatastore)
+	userId := "user1"
+
+	doc := testSpiritDoc()
+	doc["generatedImageRenditionFilePaths"] = map[string]interface{}{
+		"128": "generatedImages/user1/2024-01-15T10:30:00Z-generated-128.jpeg",
+		"512": "generatedImages/user1/2024-01-15T10:30:00Z-generated-512.jpeg",
+	}
+	mockDatastore.On("GetDocument", mock.Anything, "users/user1/spirits", "spirit1").Return(doc, nil)
+	mockStorage.On("Delete", mock.Anything, bucketName, mock.Anything).Return(nil)
+	mockDatastore.On("DeleteDocument", mock.Anything, "users/user1/spirits", "spirit1").Return(nil)
+
+	err := manager.Delete(&userId, "spirit1")
+
+	assert.NoError(t, err)
+	mockStorage.AssertNumberOfCalls(t, "Delete", 4)
+	mockStorage.AssertCalled(t, "Delete", mock.Anything, bucketName, "generatedImages/user1/2024-01-15T10:30:00Z-generated-128.jpeg")
+	mockStorage.AssertCalled(t, "Delete", mock.Anything, bucketName, "generatedImages/user1/2024-01-15T10:30:00Z-generated-512.jpeg")
+}
+
+func TestSpiritManager_DeleteKeepsDocumentWhenStorageFails(t *testing.T) {
+	mockStorage := &MockStorageClient{}
+	mockDatastore := &MockDatastoreClient{}
+	manager := NewSpiritManager(mockStorage, mockDatastore)
+	userId := "user1"
+
+	mockDatastore.On("GetDocument", mock.Anything, "users/user1/spirits", "spirit1").Return(testSpiritDoc(), nil)
+	mockStorage.On("Delete", mock.Anything, bucketName, mock.Anything).Return(errors.New("storage unavailable"))
+
+	err := manager.Delete(&userId, "spirit1")
+
+	assert.ErrorContains(t, err, "storage unavailable")
+	mockDatastore.AssertNotCalled(t, "DeleteDocument", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSpiritManager_DeleteRefusesForeignRenditions(t *testing.T) {
+	mockStorage := &MockStorageClient{}
+	mockDatastore := &MockDatastoreClient{}
+	manager := NewSpiritManager(mockStorage, mockDatastore)
+	userId := "user1"
+
+	doc := testSpiritDoc()
+	doc["generatedImageRenditionFilePaths"] = map[string]interface{}{
+		"128": "generatedImages/user2/2024-01-15T10:30:00Z-generated-128.jpeg",
+	}
+	mockDatastore.On("GetDocument", mock.Anything, "users/user1/spirits", "spirit1").Return(doc, nil)
+
+	err := manager.Delete(&userId, "spirit1")
+
+	assert.ErrorIs(t, err, ErrForbidden)
+	mockStorage.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSpiritManager_DeleteRefusesForeignFiles(t *testing.T) {
+	mockStorage := &MockStorageClient{}
+	mockDatastore := &MockDatastoreClient{}
+	manager := NewSpiritManager(mockStorage, mockDatastore)
+	userId := "user1"
+
+	doc := testSpiritDoc()
+	doc["generatedImageFilePath"] = "generatedImages/user2/2024-01-15T10:30:00Z-generated.webp"
+	mockDatastore.On("GetDocument", mock.Anything, "users/user1/spirits", "spirit1").Return(doc, nil)
+
+	err := manager.Delete(&userId, "spirit1")
+
+	assert.ErrorIs(t, err, ErrForbidden)
+	mockStorage.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything, mock.Anything)
+	mockDatastore.AssertNotCalled(t, "DeleteDocument", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,131 @@
+package spirit_migrator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"spirit-snap/server/models"
+	"spirit-snap/server/wrappers/datastore"
+)
+
+type DatastoreInterface interface {
+	ListDocumentIDs(ctx context.Context, collectionName string) ([]string, error)
+	RunQuery(ctx context.Context, query datastore.Query) (*datastore.PageResult, error)
+	UpdateDocument(ctx context.Context, collectionName string, id string, updates map[string]interface{}) error
+}
+
+// Number of spirits read per query.
+const DefaultBatchSize = 100
+
+// SpiritMigrator upgrades every stored spirit to the current schema version.
+// The server upgrades spirits in memory when it reads them but never writes
+// the upgrade back; the migrator is the only thing that does.
+type SpiritMigrator struct {
+	DatastoreClient DatastoreInterface
+	BatchSize       int
+	// Reports the migration's progress after each batch of spirits.
+	OnProgress func(report Report)
+}
+
+func NewSpiritMigrator(ds DatastoreInterface) *SpiritMigrator {
+	return &SpiritMigrator{
+		DatastoreClient: ds,
+		BatchSize:       DefaultBatchSize,
+	}
+}
+
+// Options select what a migration run does.
+type Options struct {
+	// Counts the spirits that would be upgraded without writing them.
+	DryRun bool
+	// Only migrates these users. Every user is migrated when empty.
+	UserIDs []string
+}
+
+// Report counts the spirits a migration run has handled so far.
+type Report struct {
+	Users int
+	// Spirits read.
+	Scanned int
+	// Spirits written with the current schema version, or that would have
+	// been on a dry run.
+	Upgraded int
+	// Spirits that were already current.
+	Current int
+	// Spirits whose migration or write failed. They are left as they were.
+	Failed int
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("%d users, %d spirits scanned, %d upgraded, %d already current, %d failed",
+		r.Users, r.Scanned, r.Upgraded, r.Current, r.Failed)
+}
+
+// Run upgrades the spirits of every user, or of the users in options. A
+// spirit that cannot be upgraded is logged and counted as failed, and the run
+// moves on. Run only returns an error when a collection cannot be read, along
+// with the report of the spirits handled before it.
+func (sm *SpiritMigrator) Run(ctx context.Context, options Options) (Report, error) {
+	var report Report
+	userIds := options.UserIDs
+	if len(userIds) == 0 {
+		var err error
+		userIds, err = sm.DatastoreClient.ListDocumentIDs(ctx, "users")
+		if err != nil {
+			return report, err
+		}
+	}
+
+	for _, userId := range userIds {
+		if err := sm.migrateUser(ctx, userId, options.DryRun, &report); err != nil {
+			return report, fmt.Errorf("failed to migrate spirits of user %s: %w", userId, err)
+		}
+		report.Users++
+	}
+	return report, nil
+}
+
+func (sm *SpiritMigrator) migrateUser(ctx context.Context, userId string, dryRun bool, report *Report) error {
+	collection := fmt.Sprintf("users/%s/spirits", userId)
+	// Ordering by ID keeps the pages stable while documents are updated.
+	query := datastore.NewQuery(collection).OrderBy(datastore.DocumentID, datastore.Asc).Limit(sm.BatchSize)
+	for {
+		result, err := sm.DatastoreClient.RunQuery(ctx, query)
+		if err != nil {
+			return err
+		}
+		for _, doc := range result.Documents {
+			sm.migrateSpirit(ctx, collection, doc, dryRun, report)
+		}
+		if sm.OnProgress != nil {
+			sm.OnProgress(*report)
+		}
+		if !result.HasMore {
+			return nil
+		}
+		query = query.StartAfter(result.LastCursor...)
+	}
+}
+
+func (sm *SpiritMigrator) migrateSpirit(ctx context.Context, collection string, doc map[string]interface{}, dryRun bool, report *Report) {
+	report.Scanned++
+	id, _ := doc["id"].(string)
+	changes, err := models.UpgradeSpiritDocument(doc)
+	if err != nil {
+		log.Printf("Failed to upgrade spirit %s/%s: %s", collection, id, err)
+		report.Failed++
+		return
+	}
+	if len(changes) == 0 {
+		report.Current++
+		return
+	}
+	if !dryRun {
+		if err := sm.DatastoreClient.UpdateDocument(ctx, collection, id, changes); err != nil {
+			log.Printf("Failed to store upgraded spirit %s/%s: %s", collection, id, err)
+			report.Failed++
+			return
+		}
+	}
+	report.Upgraded++
+}
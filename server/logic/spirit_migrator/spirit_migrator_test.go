@@ -0,0 +1,155 @@
+package spirit_migrator
+
+import (
+	"context"
+	"errors"
+	"spirit-snap/server/models"
+	"spirit-snap/server/wrappers/datastore"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockDatastoreClient struct {
+	mock.Mock
+}
+
+func (m *MockDatastoreClient) ListDocumentIDs(ctx context.Context, collectionName string) ([]string, error) {
+	args := m.Called(ctx, collectionName)
+	ids, _ := args.Get(0).([]string)
+	return ids, args.Error(1)
+}
+
+func (m *MockDatastoreClient) RunQuery(ctx context.Context, query datastore.Query) (*datastore.PageResult, error) {
+	args := m.Called(ctx, query)
+	result, _ := args.Get(0).(*datastore.PageResult)
+	return result, args.Error(1)
+}
+
+func (m *MockDatastoreClient) UpdateDocument(ctx context.Context, collectionName string, id string, updates map[string]interface{}) error {
+	args := m.Called(ctx, collectionName, id, updates)
+	return args.Error(0)
+}
+
+func currentSpirit(id string) map[string]interface{} {
+	return map[string]interface{}{"id": id, "schemaVersion": int64(models.CurrentSpiritSchemaVersion), "name": "Current"}
+}
+
+func oldSpirit(id string) map[string]interface{} {
+	return map[string]interface{}{"id": id, "name": "Old"}
+}
+
+func spiritsQuery(userId string, cursor ...interface{}) datastore.Query {
+	query := datastore.NewQuery("users/"+userId+"/spirits").OrderBy(datastore.DocumentID, datastore.Asc).Limit(2)
+	if len(cursor) > 0 {
+		query = query.StartAfter(cursor...)
+	}
+	return query
+}
+
+// Returns a migrator over two users. user1 has three spirits spread over two
+// pages and user2 has one.
+func newTestMigrator() (*SpiritMigrator, *MockDatastoreClient) {
+	mockDatastore := &MockDatastoreClient{}
+	mockDatastore.On("ListDocumentIDs", mock.Anything, "users").Return([]string{"user1", "user2"}, nil)
+	mockDatastore.On("RunQuery", mock.Anything, spiritsQuery("user1")).Return(&datastore.PageResult{
+		Documents:  []map[string]interface{}{oldSpirit("a"), currentSpirit("b")},
+		LastCursor: []interface{}{"b"},
+		HasMore:    true,
+	}, nil)
+	mockDatastore.On("RunQuery", mock.Anything, spiritsQuery("user1", "b")).Return(&datastore.PageResult{
+		Documents: []map[string]interface{}{oldSpirit("c")},
+	}, nil)
+	mockDatastore.On("RunQuery", mock.Anything, spiritsQuery("user2")).Return(&datastore.PageResult{
+		Documents: []map[string]interface{}{oldSpirit("d")},
+	}, nil)
+
+	migrator := NewSpiritMigrator(mockDatastore)
+	migrator.BatchSize = 2
+	return migrator, mockDatastore
+}
+
+func isUpgrade(updates map[string]interface{}) bool {
+	return updates["schemaVersion"] == int64(models.CurrentSpiritSchemaVersion) && updates["secondaryType"] == "None"
+}
+
+func TestSpiritMigrator_Run(t *testing.T) {
+	migrator, mockDatastore := newTestMigrator()
+	mockDatastore.On("UpdateDocument", mock.Anything, "users/user1/spirits", "a", mock.MatchedBy(isUpgrade)).Return(nil)
+	mockDatastore.On("UpdateDocument", mock.Anything, "users/user1/spirits", "c", mock.MatchedBy(isUpgrade)).Return(nil)
+	mockDatastore.On("UpdateDocument", mock.Anything, "users/user2/spirits", "d", mock.MatchedBy(isUpgrade)).Return(nil)
+	var progress []Report
+	migrator.OnProgress = func(report Report) {
+		progress = append(progress, report)
+	}
+
+	report, err := migrator.Run(context.Background(), Options{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, Report{Users: 2, Scanned: 4, Upgraded: 3, Current: 1}, report)
+	assert.Equal(t, []Report{
+		{Users: 0, Scanned: 2, Upgraded: 1, Current: 1},
+		{Users: 0, Scanned: 3, Upgraded: 2, Current: 1},
+		{Users: 1, Scanned: 4, Upgraded: 3, Current: 1},
+	}, progress)
+	mockDatastore.AssertExpectations(t)
+}
+
+func TestSpiritMigrator_DryRun(t *testing.T) {
+	migrator, mockDatastore := newTestMigrator()
+
+	report, err := migrator.Run(context.Background(), Options{DryRun: true})
+
+	assert.NoError(t, err)
+	assert.Equal(t, Report{Users: 2, Scanned: 4, Upgraded: 3, Current: 1}, report)
+	mockDatastore.AssertNotCalled(t, "UpdateDocument", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSpiritMigrator_SelectedUsers(t *testing.T) {
+	migrator, mockDatastore := newTestMigrator()
+	mockDatastore.On("UpdateDocument", mock.Anything, "users/user2/spirits", "d", mock.Anything).Return(nil)
+
+	report, err := migrator.Run(context.Background(), Options{UserIDs: []string{"user2"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, Report{Users: 1, Scanned: 1, Upgraded: 1}, report)
+	mockDatastore.AssertNotCalled(t, "ListDocumentIDs", mock.Anything, mock.Anything)
+}
+
+func TestSpiritMigrator_ContinuesPastFailedSpirits(t *testing.T) {
+	migrator, mockDatastore := newTestMigrator()
+	mockDatastore.On("UpdateDocument", mock.Anything, "users/user1/spirits", "a", mock.Anything).Return(errors.New("unavailable"))
+	mockDatastore.On("UpdateDocument", mock.Anything, "users/user1/spirits", "c", mock.Anything).Return(nil)
+	mockDatastore.On("UpdateDocument", mock.Anything, "users/user2/spirits", "d", mock.Anything).Return(nil)
+
+	report, err := migrator.Run(context.Background(), Options{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, Report{Users: 2, Scanned: 4, Upgraded: 2, Current: 1, Failed: 1}, report)
+}
+
+func TestSpiritMigrator_UnknownSchemaVersion(t *testing.T) {
+	mockDatastore := &MockDatastoreClient{}
+	mockDatastore.On("RunQuery", mock.Anything, mock.Anything).Return(&datastore.PageResult{
+		Documents: []map[string]interface{}{{"id": "a", "schemaVersion": int64(models.CurrentSpiritSchemaVersion + 1)}},
+	}, nil)
+	migrator := NewSpiritMigrator(mockDatastore)
+
+	report, err := migrator.Run(context.Background(), Options{UserIDs: []string{"user1"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, Report{Users: 1, Scanned: 1, Failed: 1}, report)
+	mockDatastore.AssertNotCalled(t, "UpdateDocument", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSpiritMigrator_QueryFails(t *testing.T) {
+	migrator, mockDatastore := newTestMigrator()
+	mockDatastore.ExpectedCalls = nil
+	mockDatastore.On("ListDocumentIDs", mock.Anything, "users").Return([]string{"user1"}, nil)
+	mockDatastore.On("RunQuery", mock.Anything, mock.Anything).Return(nil, errors.New("unavailable"))
+
+	_, err := migrator.Run(context.Background(), Options{})
+
+	assert.ErrorContains(t, err, "user user1")
+}
@@ -0,0 +1,120 @@
+package spirit_types
+
+// Multipliers of the damage an attack deals to a single type.
+const (
+	SuperEffective = 2.0
+	Neutral        = 1.0
+	Resisted       = 0.5
+	Immune         = 0.0
+)
+
+// The types an attacking type is not neutral against.
+type matchup struct {
+	superEffective []Type
+	resisted       []Type
+	immune         []Type
+}
+
+// Attacks are neutral against every type not listed for them.
+var matchups = map[Type]matchup{
+	Sky:     {superEffective: []Type{Growth, Thread}, resisted: []Type{Stone, Steel, Spark}},
+	Wave:    {superEffective: []Type{Flame, Stone}, resisted: []Type{Wave, Growth, Frost}},
+	Flame:   {superEffective: []Type{Growth, Frost, Steel, Thread}, resisted: []Type{Wave, Stone, Flame}},
+	Stone:   {superEffective: []Type{Sky, Flame, Spark}, resisted: []Type{Steel, Growth}},
+	Frost:   {superEffective: []Type{Sky, Growth}, resisted: []Type{Flame, Steel, Frost}},
+	Growth:  {superEffective: []Type{Wave, Stone}, resisted: []Type{Flame, Sky, Steel, Growth}},
+	Dream:   {superEffective: []Type{Chaos, Song}, resisted: []Type{Steel, Dream}, immune: []Type{Shadow}},
+	Shadow:  {superEffective: []Type{Dream, Spirit, Light}, resisted: []Type{Shadow, Harmony}},
+	Light:   {superEffective: []Type{Shadow, Dream}, resisted: []Type{Light, Flame, Steel}},
+	Spirit:  {superEffective: []Type{Spirit, Shadow}, resisted: []Type{Light}, immune: []Type{Steel}},
+	Harmony: {superEffective: []Type{Chaos, Shadow}, resisted: []Type{Harmony, Steel}},
+	Chaos:   {superEffective: []Type{Harmony, Steel, Rune}, resisted: []Type{Spirit, Dream, Chaos}},
+	Steel:   {superEffective: []Type{Frost, Stone, Art}, resisted: []Type{Flame, Wave, Steel, Spark}},
+	Art:     {superEffective: []Type{Spirit, Harmony}, resisted: []Type{Chaos, Steel, Art}},
+	Song:    {superEffective: []Type{Dream, Art}, resisted: []Type{Thread, Steel}},
+	Spark:   {superEffective: []Type{Wave, Sky, Steel}, resisted: []Type{Growth, Spark}, immune: []Type{Stone}},
+	Thread:  {superEffective: []Type{Sky, Song}, resisted: []Type{Flame, Steel, Thread}},
+	Rune:    {superEffective: []Type{Spirit, Chaos}, resisted: []Type{Wave, Shadow, Rune}},
+}
+
+// The multiplier of every attacking type against every defending type.
+var chart = buildChart()
+
+func buildChart() map[Type]map[Type]float64 {
+	chart := make(map[Type]map[Type]float64, len(All))
+	for _, attacker := range All {
+		row := make(map[Type]float64, len(All))
+		for _, defender := range All {
+			row[defender] = Neutral
+		}
+		for _, defender := range matchups[attacker].superEffective {
+			row[defender] = SuperEffective
+		}
+		for _, defender := range matchups[attacker].resisted {
+			row[defender] = Resisted
+		}
+		for _, defender := range matchups[attacker].immune {
+			row[defender] = Immune
+		}
+		chart[attacker] = row
+	}
+	return chart
+}
+
+// Effectiveness returns the multiplier of the damage an attack of one type
+// deals to a spirit of another. Attacks without a valid type, and attacks on
+// None, are neutral.
+func Effectiveness(attack Type, defender Type) float64 {
+	row, ok := chart[attack]
+	if !ok {
+		return Neutral
+	}
+	multiplier, ok := row[defender]
+	if !ok {
+		return Neutral
+	}
+	return multiplier
+}
+
+// Multiplier returns the multiplier of the damage an attack of one type
+// deals to a spirit with the given primary and secondary types: the product
+// of its effectiveness against each. It ranges from 0 to 4.
+func Multiplier(attack Type, primary Type, secondary Type) float64 {
+	multiplier := Effectiveness(attack, primary)
+	if secondary != primary {
+		multiplier *= Effectiveness(attack, secondary)
+	}
+	return multiplier
+}
+
+// TypeInfo describes a type to clients.
+type TypeInfo struct {
+	Name        Type   `json:"name"`
+	Description string `json:"description"`
+}
+
+// Chart is the effectiveness of every type against every other, as
+// published to clients.
+type Chart struct {
+	// Every type, in the order of All.
+	Types []TypeInfo `json:"types"`
+	// Effectiveness[i][j] is the multiplier of attacks of Types[i] against
+	// Types[j]. Against two different types, multiply the two multipliers.
+	// A spirit whose secondary type is the same as its primary type counts
+	// it once, as Multiplier does.
+	Effectiveness [][]float64 `json:"effectiveness"`
+}
+
+// NewChart returns the full chart.
+func NewChart() Chart {
+	result := Chart{}
+	for _, attacker := range All {
+		result.Types = append(result.Types, TypeInfo{Name: attacker, Description: attacker.Description()})
+		row := make([]float64, len(All))
+		for j, defender := range All {
+			row[j] = Effectiveness(attacker, defender)
+		}
+		result.Effectiveness = append(result.Effectiveness, row)
+	}
+	return result
+}
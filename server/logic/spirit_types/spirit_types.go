@@ -0,0 +1,93 @@
+// Package spirit_types defines the elemental types of spirits and moves, and
+// how effective each type's attacks are against the others.
+//
+// It is the one list of types on the server: the spirit data prompt offers
+// these types, moves are chosen by them and battles apply their chart.
+package spirit_types
+
+import "strings"
+
+// Type is an elemental type.
+type Type string
+
+const (
+	Sky     Type = "Sky"
+	Wave    Type = "Wave"
+	Flame   Type = "Flame"
+	Stone   Type = "Stone"
+	Frost   Type = "Frost"
+	Growth  Type = "Growth"
+	Dream   Type = "Dream"
+	Shadow  Type = "Shadow"
+	Light   Type = "Light"
+	Spirit  Type = "Spirit"
+	Harmony Type = "Harmony"
+	Chaos   Type = "Chaos"
+	Steel   Type = "Steel"
+	Art     Type = "Art"
+	Song    Type = "Song"
+	Spark   Type = "Spark"
+	Thread  Type = "Thread"
+	Rune    Type = "Rune"
+
+	// None is the secondary type of spirits that only have a primary type.
+	// It is not one of All.
+	None Type = "None"
+)
+
+// All is every type, in the order they are presented to players.
+var All = []Type{Sky, Wave, Flame, Stone, Frost, Growth, Dream, Shadow, Light, Spirit, Harmony, Chaos, Steel, Art, Song, Spark, Thread, Rune}
+
+var descriptions = map[Type]string{
+	Sky:     "wind/freedom/height",
+	Wave:    "water/fluidity/change",
+	Flame:   "fire/passion/warmth",
+	Stone:   "earth/endurance/stability",
+	Frost:   "ice/preservation/cold",
+	Growth:  "plant/nurturing/flourishing",
+	Dream:   "mystery/psychic/illusion",
+	Shadow:  "darkness/stealth/hidden",
+	Light:   "illumination/truth/radiance",
+	Spirit:  "essence/commonality/soul",
+	Harmony: "peace/balance/order",
+	Chaos:   "disorder/war/spontaneity",
+	Steel:   "technology/craft/construction",
+	Art:     "creativity/expression/beauty",
+	Song:    "music/sound/rhythm",
+	Spark:   "electricity/energy/power",
+	Thread:  "patterns/connections/textiles",
+	Rune:    "knowledge/symbols/writing",
+	None:    "mono-type",
+}
+
+// Description returns the themes of a type, e.g. "wind/freedom/height" for
+// Sky, as the spirit data prompt describes them.
+func (t Type) Description() string {
+	return descriptions[t]
+}
+
+// Valid reports whether t is one of All.
+func (t Type) Valid() bool {
+	return t != None && descriptions[t] != ""
+}
+
+// Parse returns the type with the given name, ignoring case and surrounding
+// spaces, or false if there is none. "None" parses as None.
+func Parse(name string) (Type, bool) {
+	name = strings.TrimSpace(name)
+	for t := range descriptions {
+		if strings.EqualFold(string(t), name) {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+// Names returns the names of types, e.g. for a JSON schema enum.
+func Names(types []Type) []string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = string(t)
+	}
+	return names
+}
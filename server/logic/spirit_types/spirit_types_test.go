@@ -0,0 +1,102 @@
+package spirit_types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAll(t *testing.T) {
+	assert.Len(t, All, 18)
+	seen := map[Type]bool{}
+	for _, spiritType := range All {
+		assert.True(t, spiritType.Valid(), spiritType)
+		assert.NotEmpty(t, spiritType.Description(), spiritType)
+		assert.False(t, seen[spiritType], "%s is listed twice", spiritType)
+		seen[spiritType] = true
+	}
+	assert.False(t, None.Valid())
+	assert.False(t, Type("Air").Valid())
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected Type
+		ok       bool
+	}{
+		{name: "Sky", expected: Sky, ok: true},
+		{name: " thread ", expected: Thread, ok: true},
+		{name: "NONE", expected: None, ok: true},
+		{name: "Stream"},
+		{name: ""},
+	}
+	for _, tt := range tests {
+		parsed, ok := Parse(tt.name)
+		assert.Equal(t, tt.ok, ok, tt.name)
+		assert.Equal(t, tt.expected, parsed, tt.name)
+	}
+}
+
+func TestMatchups(t *testing.T) {
+	for attacker, matchup := range matchups {
+		assert.True(t, attacker.Valid(), attacker)
+		// Listing a type twice would make the chart depend on which list is
+		// applied last.
+		listed := map[Type]bool{}
+		for _, defenders := range [][]Type{matchup.superEffective, matchup.resisted, matchup.immune} {
+			for _, defender := range defenders {
+				assert.True(t, defender.Valid(), "%s lists %s", attacker, defender)
+				assert.False(t, listed[defender], "%s lists %s twice", attacker, defender)
+				listed[defender] = true
+			}
+		}
+	}
+	assert.Len(t, matchups, len(All))
+}
+
+func TestEffectiveness(t *testing.T) {
+	assert.Equal(t, SuperEffective, Effectiveness(Wave, Flame))
+	assert.Equal(t, Resisted, Effectiveness(Flame, Wave))
+	assert.Equal(t, Immune, Effectiveness(Spark, Stone))
+	assert.Equal(t, Neutral, Effectiveness(Sky, Wave))
+	assert.Equal(t, Neutral, Effectiveness(Sky, None))
+	assert.Equal(t, Neutral, Effectiveness("", Flame))
+	assert.Equal(t, Neutral, Effectiveness("Air", Flame))
+}
+
+func TestMultiplier(t *testing.T) {
+	tests := []struct {
+		name      string
+		attack    Type
+		primary   Type
+		secondary Type
+		expected  float64
+	}{
+		{name: "Mono-type", attack: Wave, primary: Flame, secondary: None, expected: 2},
+		{name: "Both super effective", attack: Flame, primary: Growth, secondary: Frost, expected: 4},
+		{name: "Both resisted", attack: Flame, primary: Wave, secondary: Stone, expected: 0.25},
+		{name: "Super effective and resisted", attack: Wave, primary: Flame, secondary: Growth, expected: 1},
+		{name: "Immune", attack: Spark, primary: Wave, secondary: Stone, expected: 0},
+		{name: "Same type twice", attack: Wave, primary: Flame, secondary: Flame, expected: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Multiplier(tt.attack, tt.primary, tt.secondary))
+		})
+	}
+}
+
+func TestNewChart(t *testing.T) {
+	chart := NewChart()
+
+	assert.Len(t, chart.Types, 18)
+	assert.Equal(t, TypeInfo{Name: Sky, Description: "wind/freedom/height"}, chart.Types[0])
+	assert.Len(t, chart.Effectiveness, 18)
+	for i, row := range chart.Effectiveness {
+		assert.Len(t, row, 18)
+		for j, multiplier := range row {
+			assert.Equal(t, Effectiveness(chart.Types[i].Name, chart.Types[j].Name), multiplier)
+		}
+	}
+}
@@ -8,77 +8,480 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"spirit-snap/server/logic/battle_engine"
+	"spirit-snap/server/logic/battle_manager"
 	"spirit-snap/server/logic/collection_fetcher"
 	"spirit-snap/server/logic/image_processor"
+	"spirit-snap/server/logic/page_token"
+	"spirit-snap/server/logic/spirit_jobs"
+	"spirit-snap/server/logic/spirit_manager"
+	"spirit-snap/server/logic/spirit_types"
 	"spirit-snap/server/middleware"
 	"spirit-snap/server/models"
 	"spirit-snap/server/wrappers/datastore"
 	"spirit-snap/server/wrappers/file_storage"
+	"strconv"
+	"strings"
+	"time"
 
 	firebase "firebase.google.com/go"
 	"firebase.google.com/go/auth"
+	"golang.org/x/net/websocket"
 	"google.golang.org/api/option"
 )
 
 type ImageProcessorInterface interface {
-	Process(image *string, userId *string) (models.Spirit, error)
+	Process(photo []byte, contentType string, userId *string) (models.Spirit, error)
+	ProcessWithProgress(photo []byte, contentType string, userId *string, onProgress image_processor.ProgressFunc) (models.Spirit, error)
 	Close()
 }
 
 type ColectionFetcherInterface interface {
-	Fetch(*string, int, []interface{}) ([]models.Spirit, error)
+	Fetch(*string, int, []interface{}) (*collection_fetcher.SpiritPage, error)
+	Search(*string, collection_fetcher.SpiritQuery) (*collection_fetcher.SpiritPage, error)
+}
+
+type SpiritManagerInterface interface {
+	Get(userId *string, spiritId string) (models.Spirit, error)
+	Update(userId *string, spiritId string, update spirit_manager.SpiritUpdate) (models.Spirit, error)
+	Delete(userId *string, spiritId string) error
+}
+
+type JobManagerInterface interface {
+	Submit(userId *string, photo []byte, contentType string) (string, error)
+	Get(userId *string, jobId string) (spirit_jobs.Job, error)
+	Close()
+}
+
+type BattleManagerInterface interface {
+	Create(userId *string, request battle_manager.CreateRequest) (battle_manager.Battle, error)
+	Accept(userId *string, battleId string, team battle_manager.TeamRequest) (battle_manager.Battle, error)
+	Decline(userId *string, battleId string) (battle_manager.Battle, error)
+	Get(userId *string, battleId string) (battle_manager.Battle, error)
+	Submit(userId *string, battleId string, action battle_engine.Action) (battle_manager.Battle, []battle_engine.Event, error)
+	List(userId *string, status battle_manager.Status, limit int, startAfter []interface{}) (*battle_manager.BattlePage, error)
+	Actions(userId *string, battleId string, after int) ([]battle_manager.LoggedAction, error)
+	Updates(userId *string, battleId string, after int) (battle_manager.Battle, []battle_manager.Update, error)
+	Subscribe(battleId string) (<-chan struct{}, func())
+}
+
+type RegionRouterInterface interface {
+	Snapshot() []image_processor.RegionState
 }
 
 type AuthInterface interface {
 	VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error)
 }
 
+// StorageInterface covers every file storage method used by the logic
+// packages.
+type StorageInterface interface {
+	Write(ctx context.Context, bucketName, objectName string, data []byte, contentType string) error
+	Read(ctx context.Context, bucketName, objectName string) ([]byte, error)
+	GetDownloadURL(ctx context.Context, bucketName, objectName string) (string, error)
+	Delete(ctx context.Context, bucketName, objectName string) error
+}
+
+// DatastoreInterface covers every datastore method used by the logic
+// packages.
+type DatastoreInterface interface {
+	AddDocument(ctx context.Context, collectionName string, data interface{}) (string, error)
+	CreateDocument(ctx context.Context, collectionName string, id string, data interface{}) error
+	GetCollection(ctx context.Context, collectionName string, limit int, sortField string, sortDirection datastore.Direction, startAfter []interface{}) (*datastore.PageResult, error)
+	RunQuery(ctx context.Context, query datastore.Query) (*datastore.PageResult, error)
+	GetDocument(ctx context.Context, collectionName string, id string) (map[string]interface{}, error)
+	GetDocumentsByIds(ctx context.Context, collectionName string, ids []string) ([]map[string]interface{}, error)
+	GetDocumentsFilteredByValue(ctx context.Context, collectionName string, fieldName string, value any) ([]map[string]interface{}, error)
+	UpdateDocument(ctx context.Context, collectionName string, id string, updates map[string]interface{}) error
+	UpdateDocumentIf(ctx context.Context, collectionName string, id string, field string, expected interface{}, updates map[string]interface{}) error
+	DeleteDocument(ctx context.Context, collectionName string, id string) error
+	Close() error
+}
+
+// Backend holds the services the server stores spirits and files in and
+// authenticates users with.
+type Backend struct {
+	Storage   StorageInterface
+	Datastore DatastoreInterface
+	Auth      AuthInterface
+	// Serves stored files under /files/, for storage whose download URLs
+	// point at the server itself. Nil when storage hands out its own URLs.
+	Files http.Handler
+}
+
+const (
+	// Stores everything in Firebase and authenticates with Firebase Auth.
+	BackendFirebase = "firebase"
+	// Keeps everything in memory and trusts bearer tokens as user IDs, for
+	// running the server without any Firebase project.
+	BackendLocal = "local"
+)
+
 type Server struct {
-	FirebaseApp       *firebase.App
 	ImageProcessor    ImageProcessorInterface
 	CollectionFetcher ColectionFetcherInterface
+	SpiritManager     SpiritManagerInterface
+	JobManager        JobManagerInterface
+	BattleManager     BattleManagerInterface
+	ImagenRegions     RegionRouterInterface
 	AuthClient        AuthInterface
+	PageTokens        *page_token.Codec
+	// Limits on the photos sent to the image processing endpoints.
+	UploadLimits image_processor.UploadLimits
+	// Serves stored files, if the backend needs the server to. See Backend.
+	Files http.Handler
 }
 
-func NewServer(ctx context.Context, firebaseApp *firebase.App, rt http.RoundTripper) (*Server, error) {
+// Config holds the server settings that are read from the environment at startup.
+type Config struct {
+	// Where data is stored, BackendFirebase or BackendLocal. Set by the
+	// -backend flag.
+	Backend string
+	// Base URL that clients reach the server at. The local backend builds
+	// file download URLs from it. Defaults to http://localhost:{port}.
+	PublicURL string
+	// SQLite database the local backend stores documents in. Documents are
+	// kept in memory when empty.
+	DatabasePath string
+	// Directory the local backend stores files in. Files are kept in memory
+	// when empty.
+	FilesDir string
+	// Secret used to sign the download URLs of files stored by the local
+	// backend.
+	FileURLSecret []byte
+
+	// Secret used to sign the cursors handed out by paginated endpoints.
+	PageTokenSecret []byte
+
+	// Service that generates spirit data from photos, either "openai" or
+	// "openai-compatible" for any other server implementing the OpenAI Chat
+	// Completions API.
+	SpiritDataProvider string
+	// Base URL of the Chat Completions API. Only used by "openai-compatible".
+	SpiritDataBaseURL string
+	SpiritDataAPIKey  string
+	SpiritDataModel   string
+
+	// Services that generate spirit images, in the order they are tried:
+	// "imagen", "flux-pro" or "flux-schnell".
+	ImageGenerators []string
+	// Service account credentials, used by Firebase and by Imagen.
+	GoogleCredentialsJSON []byte
+	GoogleCloudProjectID  string
+	ImagenModel           string
+	ImagenRegions         []string
+	ReplicateAPIToken     string
+
+	// MIME type that photos and generated images are transcoded to before
+	// they are stored. Empty keeps their original format.
+	ImageStorageFormat string
+
+	// How photos that duplicate one of the user's earlier photos are handled.
+	DuplicateCheck image_processor.DuplicateCheck
+
+	// Serves the /Debug endpoints, which report the server's internal state
+	// to any signed in user.
+	DebugEndpoints bool
+}
+
+const defaultOpenAIModel = "gpt-4o-2024-11-20"
+
+func loadConfig(backend string) (Config, error) {
+	config := Config{
+		Backend:               backend,
+		PublicURL:             strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/"),
+		DatabasePath:          os.Getenv("DATABASE_PATH"),
+		FilesDir:              os.Getenv("FILES_DIR"),
+		FileURLSecret:         []byte(os.Getenv("FILE_URL_SECRET")),
+		PageTokenSecret:       []byte(os.Getenv("PAGE_TOKEN_SECRET")),
+		SpiritDataProvider:    os.Getenv("SPIRIT_DATA_PROVIDER"),
+		SpiritDataModel:       os.Getenv("SPIRIT_DATA_MODEL"),
+		GoogleCredentialsJSON: []byte(os.Getenv("FIREBASE_CREDENTIALS_JSON")),
+		GoogleCloudProjectID:  os.Getenv("GOOGLE_CLOUD_PROJECT_ID"),
+		ImagenModel:           os.Getenv("IMAGEN_MODEL"),
+		ReplicateAPIToken:     os.Getenv("REPLICATE_API_TOKEN"),
+	}
+	switch config.Backend {
+	case BackendFirebase:
+		if len(config.GoogleCredentialsJSON) == 0 {
+			return Config{}, fmt.Errorf("FIREBASE_CREDENTIALS_JSON environment variable is not set")
+		}
+	case BackendLocal:
+	default:
+		return Config{}, fmt.Errorf("unknown backend %q", config.Backend)
+	}
+	if len(config.PageTokenSecret) == 0 {
+		// Tokens signed with a random secret stop verifying after a restart, which
+		// only forces clients back to the first page.
+		log.Print("PAGE_TOKEN_SECRET environment variable is not set, generating a random secret.")
+		config.PageTokenSecret = make([]byte, 32)
+		if _, err := rand.Read(config.PageTokenSecret); err != nil {
+			return Config{}, fmt.Errorf("error generating page token secret: %v", err)
+		}
+	}
+
+	switch config.SpiritDataProvider {
+	case "", "openai":
+		config.SpiritDataProvider = "openai"
+		config.SpiritDataAPIKey = os.Getenv("OPENAI_API_KEY")
+		if config.SpiritDataAPIKey == "" {
+			return Config{}, fmt.Errorf("OPENAI_API_KEY environment variable is not set")
+		}
+		if config.SpiritDataModel == "" {
+			config.SpiritDataModel = defaultOpenAIModel
+		}
+	case "openai-compatible":
+		config.SpiritDataBaseURL = os.Getenv("SPIRIT_DATA_BASE_URL")
+		config.SpiritDataAPIKey = os.Getenv("SPIRIT_DATA_API_KEY")
+		if config.SpiritDataBaseURL == "" || config.SpiritDataModel == "" {
+			return Config{}, fmt.Errorf("SPIRIT_DATA_BASE_URL and SPIRIT_DATA_MODEL must be set for the openai-compatible provider")
+		}
+	default:
+		return Config{}, fmt.Errorf("unknown SPIRIT_DATA_PROVIDER %q", config.SpiritDataProvider)
+	}
+
+	// IMAGE_GENERATOR is a comma separated list such as "imagen,flux-pro".
+	// The local backend draws placeholder images by default so it runs
+	// without a Google Cloud project.
+	config.ImageGenerators = []string{"imagen"}
+	if config.Backend == BackendLocal {
+		config.ImageGenerators = []string{"local"}
+	}
+	if value := os.Getenv("IMAGE_GENERATOR"); value != "" {
+		config.ImageGenerators = strings.Split(value, ",")
+	}
+	for i, name := range config.ImageGenerators {
+		name = strings.TrimSpace(name)
+		config.ImageGenerators[i] = name
+		switch name {
+		case "imagen":
+			if config.GoogleCloudProjectID == "" {
+				return Config{}, fmt.Errorf("GOOGLE_CLOUD_PROJECT_ID environment variable is not set")
+			}
+			if config.ImagenModel == "" {
+				config.ImagenModel = image_processor.DefaultImagenModel
+			}
+			config.ImagenRegions = image_processor.DefaultImagenRegions
+			if value := os.Getenv("IMAGEN_REGIONS"); value != "" {
+				config.ImagenRegions = nil
+				for _, region := range strings.Split(value, ",") {
+					config.ImagenRegions = append(config.ImagenRegions, strings.TrimSpace(region))
+				}
+			}
+		case "flux-pro", "flux-schnell":
+			if config.ReplicateAPIToken == "" {
+				return Config{}, fmt.Errorf("REPLICATE_API_TOKEN environment variable is not set")
+			}
+		case "local":
+		default:
+			return Config{}, fmt.Errorf("unknown IMAGE_GENERATOR %q", name)
+		}
+	}
+
+	switch value := os.Getenv("IMAGE_STORAGE_FORMAT"); value {
+	case "":
+	case "jpeg", "png":
+		config.ImageStorageFormat = "image/" + value
+	default:
+		return Config{}, fmt.Errorf("unknown IMAGE_STORAGE_FORMAT %q", value)
+	}
+
+	config.DuplicateCheck = image_processor.DefaultDuplicateCheck()
+	switch value := image_processor.DuplicateAction(os.Getenv("DUPLICATE_PHOTO_ACTION")); value {
+	case "":
+	case image_processor.DuplicateActionOff, image_processor.DuplicateActionFlag, image_processor.DuplicateActionReject:
+		config.DuplicateCheck.Action = value
+	default:
+		return Config{}, fmt.Errorf("unknown DUPLICATE_PHOTO_ACTION %q", value)
+	}
+	if value := os.Getenv("DUPLICATE_PHOTO_MAX_DISTANCE"); value != "" {
+		distance, err := strconv.Atoi(value)
+		if err != nil || distance < 0 || distance > 64 {
+			return Config{}, fmt.Errorf("DUPLICATE_PHOTO_MAX_DISTANCE must be a number of bits from 0 to 64, got %q", value)
+		}
+		config.DuplicateCheck.MaxDistance = distance
+	}
+
+	if value := os.Getenv("DEBUG_ENDPOINTS"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return Config{}, fmt.Errorf("DEBUG_ENDPOINTS must be true or false, got %q", value)
+		}
+		config.DebugEndpoints = enabled
+	}
+	return config, nil
+}
+
+func newSpiritDataGenerator(config Config, httpClient *http.Client) image_processor.SpiritDataGenerator {
+	if config.SpiritDataProvider == "openai-compatible" {
+		return image_processor.NewChatCompletionsSpiritDataGenerator(config.SpiritDataBaseURL, config.SpiritDataAPIKey, config.SpiritDataModel, httpClient)
+	}
+	return image_processor.NewOpenAISpiritDataGenerator(config.SpiritDataAPIKey, config.SpiritDataModel, httpClient)
+}
+
+// Creates the configured image generators. They are wrapped in a
+// FallbackImageGenerator, even if there is only one, so that failed requests
+// are retried.
+func newImageGenerator(ctx context.Context, config Config, imagenRegions *image_processor.RegionRouter, httpClient *http.Client) (image_processor.ImageGenerator, error) {
+	var generators []image_processor.ImageGenerator
+	for _, name := range config.ImageGenerators {
+		switch name {
+		case "flux-pro":
+			generators = append(generators, image_processor.NewFluxProGenerator(config.ReplicateAPIToken, httpClient))
+		case "flux-schnell":
+			generators = append(generators, image_processor.NewFluxSchnellGenerator(config.ReplicateAPIToken, httpClient))
+		case "local":
+			generators = append(generators, image_processor.NewPlaceholderImageGenerator())
+		default:
+			imagen, err := image_processor.NewImagenGenerator(ctx, config.GoogleCloudProjectID, config.ImagenModel, config.GoogleCredentialsJSON, imagenRegions, httpClient)
+			if err != nil {
+				return nil, err
+			}
+			generators = append(generators, imagen)
+		}
+	}
+	return image_processor.NewFallbackImageGenerator(image_processor.DefaultRetryPolicy(), generators...), nil
+}
+
+// Creates the clients of the Firebase project the app belongs to.
+func newFirebaseBackend(ctx context.Context, firebaseApp *firebase.App) (Backend, error) {
 	storageClient, err := file_storage.NewClient(ctx, firebaseApp)
 	if err != nil {
-		return nil, fmt.Errorf("error initializing Firebase Storage client: %v", err)
+		return Backend{}, fmt.Errorf("error initializing Firebase Storage client: %v", err)
 	}
 
 	datastoreClient, err := datastore.NewClient(ctx, firebaseApp)
 	if err != nil {
-		return nil, fmt.Errorf("error initializing Firestore client: %v", err)
+		return Backend{}, fmt.Errorf("error initializing Firestore client: %v", err)
 	}
 
 	authClient, err := firebaseApp.Auth(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("error initializing Firebase Auth client: %v", err)
+		return Backend{}, fmt.Errorf("error initializing Firebase Auth client: %v", err)
+	}
+	return Backend{Storage: storageClient, Datastore: datastoreClient, Auth: authClient}, nil
+}
+
+// Creates storage that needs no Google Cloud project. Files are stored in
+// config.FilesDir, or in memory, and served by the server at
+// config.PublicURL/files/. Documents are stored in the SQLite database at
+// config.DatabasePath, or in memory.
+func newLocalBackend(config Config) (Backend, error) {
+	var datastoreClient DatastoreInterface = datastore.NewMemoryClient()
+	if config.DatabasePath != "" {
+		sqliteClient, err := datastore.NewSQLiteClient(config.DatabasePath)
+		if err != nil {
+			return Backend{}, err
+		}
+		datastoreClient = sqliteClient
 	}
 
+	secret := config.FileURLSecret
+	if len(secret) == 0 {
+		// URLs signed with a random secret stop working after a restart.
+		// Clients fetch spirits again for new URLs, as they do when signed
+		// URLs of Firebase Storage expire.
+		log.Print("FILE_URL_SECRET environment variable is not set, generating a random secret.")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return Backend{}, fmt.Errorf("error generating file URL secret: %v", err)
+		}
+	}
+	var storageClient interface {
+		StorageInterface
+		http.Handler
+	}
+	if config.FilesDir != "" {
+		localClient, err := file_storage.NewLocalClient(config.FilesDir, config.PublicURL+"/files", secret)
+		if err != nil {
+			return Backend{}, err
+		}
+		storageClient = localClient
+	} else {
+		memoryClient, err := file_storage.NewMemoryClient(config.PublicURL+"/files", secret)
+		if err != nil {
+			return Backend{}, err
+		}
+		storageClient = memoryClient
+	}
+	return Backend{
+		Storage:   storageClient,
+		Datastore: datastoreClient,
+		Auth:      middleware.LocalAuth{},
+		Files:     storageClient,
+	}, nil
+}
+
+func NewServer(ctx context.Context, backend Backend, rt http.RoundTripper, config Config) (*Server, error) {
+	storageClient, datastoreClient := backend.Storage, backend.Datastore
+
+	// To idiomatically mock HTTP clients, you mock the connectivity component i.e. the RoundTripper which makes the network calls.
+	httpClient := &http.Client{Transport: rt}
+	routerConfig := image_processor.DefaultRegionRouterConfig()
+	routerConfig.Regions = config.ImagenRegions
+	imagenRegions := image_processor.NewRegionRouter(routerConfig)
+	imageGenerator, err := newImageGenerator(ctx, config, imagenRegions, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing image generator: %v", err)
+	}
+	spiritDataGenerator := newSpiritDataGenerator(config, httpClient)
+	transcoder, err := image_processor.NewTranscoder(config.ImageStorageFormat)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing transcoder: %v", err)
+	}
+	imageProcessor := image_processor.NewImageProcessor(storageClient, datastoreClient, spiritDataGenerator, imageGenerator, transcoder)
+	imageProcessor.DuplicateCheck = config.DuplicateCheck
+	jobManager := spirit_jobs.NewJobManager(imageProcessor, storageClient, datastoreClient, spirit_jobs.DefaultConfig())
+	jobManager.Start()
+
 	return &Server{
-		FirebaseApp:       firebaseApp,
-		ImageProcessor:    image_processor.NewImageProcessor(storageClient, datastoreClient, rt),
+		ImageProcessor:    imageProcessor,
 		CollectionFetcher: collection_fetcher.NewCollectionFetcher(storageClient, datastoreClient),
-		AuthClient:        authClient,
+		SpiritManager:     spirit_manager.NewSpiritManager(storageClient, datastoreClient),
+		JobManager:        jobManager,
+		BattleManager:     battle_manager.NewBattleManager(datastoreClient),
+		ImagenRegions:     imagenRegions,
+		UploadLimits:      image_processor.DefaultUploadLimits(),
+		AuthClient:        backend.Auth,
+		PageTokens:        page_token.NewCodec(config.PageTokenSecret),
+		Files:             backend.Files,
 	}, nil
 }
 
 func (s *Server) Close() {
+	// Running jobs use the image processor, so they are stopped first.
+	s.JobManager.Close()
 	s.ImageProcessor.Close()
 }
 
+// ImageData is the JSON body of the image processing endpoints. Photos can
+// also be sent as binary, see readImageUpload.
 type ImageData struct {
 	Base64Image string
 }
 
+// JobResponse is returned by the Jobs endpoint.
+type JobResponse struct {
+	spirit_jobs.Job
+	// The created spirit, set once the job is done.
+	Spirit *models.Spirit `json:"spirit,omitempty"`
+}
+
 // Hanldes the HTTP details for the processImage endpoint.
+//
+// With ?async=true the image is processed in the background and the response
+// is 202 Accepted with the ID of a job that can be polled at /Jobs/{id}.
 func (s *Server) processImageHandler(w http.ResponseWriter, r *http.Request) {
 	log.Print("Received request to process image.")
 	if r.Method != http.MethodPost {
@@ -92,15 +495,29 @@ func (s *Server) processImageHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	var image ImageData
-	err := json.NewDecoder(r.Body).Decode(&image)
-	if err != nil {
-		log.Printf("Error during JSON decoding: %s", err)
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	photo, ok := s.readImageUpload(w, r)
+	if !ok {
 		return
 	}
 
-	spirit, err := s.ImageProcessor.Process(&image.Base64Image, &token.UID)
+	if r.URL.Query().Get("async") == "true" {
+		s.submitJob(w, photo, &token.UID)
+		return
+	}
+
+	spirit, err := s.ImageProcessor.Process(photo.Data, photo.MimeType, &token.UID)
+	var uploadErr *image_processor.UploadError
+	if errors.As(err, &uploadErr) {
+		writeUploadError(w, uploadErr)
+		return
+	}
+	var duplicateErr *image_processor.DuplicatePhotoError
+	if errors.As(err, &duplicateErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(duplicatePhotoResponse(duplicateErr))
+		return
+	}
 	if err != nil {
 		log.Printf("Error during image processing: %s", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -111,6 +528,373 @@ func (s *Server) processImageHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(spirit)
 }
 
+// Handles the processImageStream endpoint. It takes the same request as
+// processImage but responds with a stream of Server-Sent Events that reveal
+// the spirit as it is created. Errors after the stream has started are sent
+// as an "error" event since the status code has already been written.
+func (s *Server) processImageStreamHandler(w http.ResponseWriter, r *http.Request) {
+	log.Print("Received request to process image with progress stream.")
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, ok := middleware.GetAuthenticatedUser(r.Context())
+	if !ok {
+		log.Printf("Error getting authenticated user.")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	photo, ok := s.readImageUpload(w, r)
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// If the client disconnects, writes fail silently and the spirit is still
+	// created, so it shows up in the client's collection on the next fetch.
+	_, err := s.ImageProcessor.ProcessWithProgress(photo.Data, photo.MimeType, &token.UID, func(event image_processor.ProgressEvent) error {
+		if event.Type == image_processor.EventStage {
+			writeServerSentEvent(w, string(event.Type), map[string]string{"stage": string(event.Stage)})
+		} else {
+			writeServerSentEvent(w, string(event.Type), event.Spirit)
+		}
+		flusher.Flush()
+		return nil
+	})
+	var duplicateErr *image_processor.DuplicatePhotoError
+	if errors.As(err, &duplicateErr) {
+		writeServerSentEvent(w, "error", duplicatePhotoResponse(duplicateErr))
+		flusher.Flush()
+	} else if err != nil {
+		log.Printf("Error during image processing: %s", err)
+		writeServerSentEvent(w, "error", map[string]string{"error": err.Error()})
+		flusher.Flush()
+	}
+}
+
+// UploadErrorResponse is returned when a photo is rejected. Code is one of
+// the image_processor.UploadError* codes.
+type UploadErrorResponse struct {
+	Code  string `json:"code"`
+	Error string `json:"error"`
+	// The spirit whose photo was uploaded again. Only set for duplicate
+	// photos.
+	SpiritID string `json:"spiritId,omitempty"`
+}
+
+// Returned with 409 Conflict when the user has already turned the photo into
+// a spirit.
+const duplicatePhotoCode = "duplicate_photo"
+
+func duplicatePhotoResponse(err *image_processor.DuplicatePhotoError) UploadErrorResponse {
+	return UploadErrorResponse{
+		Code:     duplicatePhotoCode,
+		Error:    "You already caught a spirit with this photo. Try snapping something new!",
+		SpiritID: err.SpiritID,
+	}
+}
+
+// Reads the photo sent to the image processing endpoints and checks it
+// against the upload limits, so that rejected photos are never sent to the
+// spirit data or image generators. The photo can be sent as:
+//   - JSON holding ImageData, the original format.
+//   - multipart/form-data with the photo in a file field named "image".
+//   - A raw body with an image/* content type.
+//
+// The binary formats are read straight from the request without the base64
+// overhead. Writes the error response and returns false if the request is
+// rejected.
+func (s *Server) readImageUpload(w http.ResponseWriter, r *http.Request) (*image_processor.Image, bool) {
+	if s.UploadLimits.MaxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.UploadLimits.MaxBodyBytes)
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var photo *image_processor.Image
+	var err error
+	switch {
+	case mediaType == "" || mediaType == "application/json":
+		var image ImageData
+		if err := json.NewDecoder(r.Body).Decode(&image); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeUploadError(w, bodyTooLargeError(maxBytesErr))
+				return nil, false
+			}
+			log.Printf("Error during JSON decoding: %s", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return nil, false
+		}
+		photo, err = image_processor.ValidateUpload(image.Base64Image, s.UploadLimits)
+	case mediaType == "multipart/form-data":
+		var data []byte
+		data, err = s.readMultipartImage(r)
+		if err == nil {
+			photo, err = image_processor.ValidateImage(data, s.UploadLimits)
+		}
+	case strings.HasPrefix(mediaType, "image/"):
+		var data []byte
+		data, err = s.readImageBytes(r.Body)
+		if err == nil {
+			photo, err = image_processor.ValidateImage(data, s.UploadLimits)
+		}
+	default:
+		err = &image_processor.UploadError{
+			Code:    image_processor.UploadErrorUnsupportedContentType,
+			Message: fmt.Sprintf("content type %q is not supported, send JSON, multipart/form-data or an image", mediaType),
+		}
+	}
+	if err != nil {
+		log.Printf("Rejected upload: %s", err)
+		var uploadErr *image_processor.UploadError
+		if !errors.As(err, &uploadErr) {
+			// Reading the request failed, e.g. because the client went away.
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return nil, false
+		}
+		writeUploadError(w, uploadErr)
+		return nil, false
+	}
+	return photo, true
+}
+
+// Reads the "image" field of a multipart/form-data request. Parts are read
+// one at a time as they arrive, so other fields are never held in memory.
+func (s *Server) readMultipartImage(r *http.Request) ([]byte, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, &image_processor.UploadError{
+				Code:    image_processor.UploadErrorMissingImage,
+				Message: `multipart request has no "image" field`,
+			}
+		}
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil, bodyTooLargeError(maxBytesErr)
+			}
+			return nil, err
+		}
+		if part.FormName() == "image" {
+			return s.readImageBytes(part)
+		}
+	}
+}
+
+// Reads a binary photo, stopping as soon as it is larger than allowed.
+func (s *Server) readImageBytes(body io.Reader) ([]byte, error) {
+	if s.UploadLimits.MaxImageBytes > 0 {
+		body = io.LimitReader(body, int64(s.UploadLimits.MaxImageBytes)+1)
+	}
+	data, err := io.ReadAll(body)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return nil, bodyTooLargeError(maxBytesErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.UploadLimits.MaxImageBytes > 0 && len(data) > s.UploadLimits.MaxImageBytes {
+		return nil, &image_processor.UploadError{
+			Code:    image_processor.UploadErrorImageTooLarge,
+			Message: fmt.Sprintf("photo is larger than %d bytes", s.UploadLimits.MaxImageBytes),
+		}
+	}
+	return data, nil
+}
+
+func bodyTooLargeError(err *http.MaxBytesError) *image_processor.UploadError {
+	return &image_processor.UploadError{
+		Code:    image_processor.UploadErrorBodyTooLarge,
+		Message: fmt.Sprintf("request body is larger than %d bytes", err.Limit),
+	}
+}
+
+// Writes a rejected upload as JSON. Uploads that are too large get 413,
+// unknown content types get 415 and every other rejection gets 400.
+func writeUploadError(w http.ResponseWriter, err *image_processor.UploadError) {
+	status := http.StatusBadRequest
+	if err.TooLarge() {
+		status = http.StatusRequestEntityTooLarge
+	} else if err.Code == image_processor.UploadErrorUnsupportedContentType {
+		status = http.StatusUnsupportedMediaType
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(UploadErrorResponse{Code: err.Code, Error: err.Message})
+}
+
+// Writes a single Server-Sent Event with a JSON payload.
+func writeServerSentEvent(w io.Writer, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
+
+func (s *Server) submitJob(w http.ResponseWriter, photo *image_processor.Image, userId *string) {
+	jobId, err := s.JobManager.Submit(userId, photo.Data, photo.MimeType)
+	if errors.Is(err, spirit_jobs.ErrQueueFull) {
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Printf("Error submitting job: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/Jobs/"+jobId)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"jobId": jobId})
+}
+
+// Reports the progress of a job started with /ProcessImage?async=true.
+func (s *Server) jobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, ok := middleware.GetAuthenticatedUser(r.Context())
+	if !ok {
+		log.Printf("Error getting authenticated user.")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	jobId := r.PathValue("id")
+
+	job, err := s.JobManager.Get(&token.UID, jobId)
+	if errors.Is(err, spirit_jobs.ErrNotFound) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting job %s: %s", jobId, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := JobResponse{Job: job}
+	if job.Stage == spirit_jobs.StageDone && job.SpiritID != nil {
+		spirit, err := s.SpiritManager.Get(&token.UID, *job.SpiritID)
+		if err != nil && !errors.Is(err, spirit_manager.ErrNotFound) {
+			log.Printf("Error getting spirit of job %s: %s", jobId, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// The spirit may have been deleted since the job finished.
+		if err == nil {
+			response.Spirit = &spirit
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Returns every spirit type and how effective each type's attacks are against
+// the others, so clients can show the same multipliers battles apply.
+func (s *Server) typesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// The chart only changes when the server is deployed.
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	json.NewEncoder(w).Encode(spirit_types.NewChart())
+}
+
+// Reports the health of each Imagen region for debugging.
+func (s *Server) imagenRegionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"regions": s.ImagenRegions.Snapshot()})
+}
+
+const (
+	defaultPageSize = 10
+	maxPageSize     = 50
+)
+
+// SpiritPageResponse is the envelope returned by the paginated spirit endpoints.
+type SpiritPageResponse struct {
+	Spirits []models.Spirit `json:"spirits"`
+	// Opaque token to pass back as pageToken to fetch the next page. Only set
+	// when HasMore is true.
+	NextPageToken *string `json:"nextPageToken"`
+	HasMore       bool    `json:"hasMore"`
+}
+
+// Parses the pageSize and pageToken query parameters. Page tokens are only
+// accepted for the scope they were issued for.
+func (s *Server) parsePageParams(r *http.Request, scope string) (int, []interface{}, error) {
+	pageSize := defaultPageSize
+	if value := r.URL.Query().Get("pageSize"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxPageSize {
+			return 0, nil, fmt.Errorf("pageSize must be an integer between 1 and %d", maxPageSize)
+		}
+		pageSize = parsed
+	}
+
+	var startAfter []interface{}
+	if value := r.URL.Query().Get("pageToken"); value != "" {
+		cursor, err := s.PageTokens.Decode(scope, value)
+		if err != nil {
+			log.Printf("Error decoding page token: %s", err)
+			//lint:ignore ST1005 The message is returned to the client as is.
+			return 0, nil, fmt.Errorf("Invalid pageToken")
+		}
+		startAfter = cursor
+	}
+	return pageSize, startAfter, nil
+}
+
+func (s *Server) writeSpiritPage(w http.ResponseWriter, page *collection_fetcher.SpiritPage, scope string) {
+	response := SpiritPageResponse{
+		Spirits: page.Spirits,
+		HasMore: page.HasMore,
+	}
+	if page.HasMore {
+		nextPageToken, err := s.PageTokens.Encode(scope, page.LastCursor)
+		if err != nil {
+			log.Printf("Error encoding page token: %s", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response.NextPageToken = &nextPageToken
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
 func (s *Server) fetchSpiritsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
@@ -124,34 +908,661 @@ func (s *Server) fetchSpiritsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	spirits, err := s.CollectionFetcher.Fetch(&token.UID, 10, nil)
+	pageSize, startAfter, err := s.parsePageParams(r, token.UID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := s.CollectionFetcher.Fetch(&token.UID, pageSize, startAfter)
+	if errors.Is(err, collection_fetcher.ErrInvalidQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		log.Printf("Error fetching spirits: %s", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	s.writeSpiritPage(w, page, token.UID)
+}
+
+// Parses the filter and sort query parameters of the SearchSpirits endpoint.
+func parseSpiritQuery(params url.Values) (collection_fetcher.SpiritQuery, error) {
+	query := collection_fetcher.SpiritQuery{
+		PrimaryType:   params.Get("primaryType"),
+		SecondaryType: params.Get("secondaryType"),
+		NameContains:  params.Get("name"),
+		MoveType:      params.Get("moveType"),
+		SortField:     params.Get("sort"),
+	}
+
+	switch params.Get("order") {
+	case "":
+	case "asc":
+		query.SortDirection = datastore.Asc
+	case "desc":
+		query.SortDirection = datastore.Desc
+	default:
+		return query, fmt.Errorf("order must be asc or desc")
+	}
+
+	// Stat ranges are passed as e.g. minStrength=70&maxStrength=90.
+	for _, stat := range collection_fetcher.StatFields {
+		statRange := collection_fetcher.StatRange{Stat: stat}
+		suffix := strings.ToUpper(stat[:1]) + stat[1:]
+		for _, bound := range []struct {
+			param string
+			value **int
+		}{{"min" + suffix, &statRange.Min}, {"max" + suffix, &statRange.Max}} {
+			raw := params.Get(bound.param)
+			if raw == "" {
+				continue
+			}
+			parsed, err := strconv.Atoi(raw)
+			if err != nil {
+				return query, fmt.Errorf("%s must be an integer", bound.param)
+			}
+			*bound.value = &parsed
+		}
+		if statRange.Min != nil || statRange.Max != nil {
+			query.StatRanges = append(query.StatRanges, statRange)
+		}
+	}
+	return query, nil
+}
+
+func (s *Server) searchSpiritsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, ok := middleware.GetAuthenticatedUser(r.Context())
+	if !ok {
+		log.Printf("Error getting authenticated user.")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query, err := parseSpiritQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Cursors depend on the filters and sort, so page tokens are bound to them
+	// as well as to the user.
+	filters := r.URL.Query()
+	filters.Del("pageSize")
+	filters.Del("pageToken")
+	scope := token.UID + "?" + filters.Encode()
+
+	query.Limit, query.StartAfter, err = s.parsePageParams(r, scope)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := s.CollectionFetcher.Search(&token.UID, query)
+	if errors.Is(err, collection_fetcher.ErrInvalidQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error searching spirits: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.writeSpiritPage(w, page, scope)
+}
+
+// Handles reading (GET), editing (PATCH) and deleting (DELETE) a single spirit
+// in the authenticated user's collection.
+func (s *Server) spiritHandler(w http.ResponseWriter, r *http.Request) {
+	token, ok := middleware.GetAuthenticatedUser(r.Context())
+	if !ok {
+		log.Printf("Error getting authenticated user.")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	spiritId := r.PathValue("id")
+
+	var spirit models.Spirit
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		spirit, err = s.SpiritManager.Get(&token.UID, spiritId)
+	case http.MethodPatch:
+		var update spirit_manager.SpiritUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			log.Printf("Error during JSON decoding: %s", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		spirit, err = s.SpiritManager.Update(&token.UID, spiritId, update)
+	case http.MethodDelete:
+		err = s.SpiritManager.Delete(&token.UID, spiritId)
+	default:
+		http.Error(w, "Only GET, PATCH and DELETE methods are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case errors.Is(err, spirit_manager.ErrNotFound):
+		http.Error(w, "Spirit not found", http.StatusNotFound)
+		return
+	case errors.Is(err, spirit_manager.ErrForbidden):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	case errors.Is(err, spirit_manager.ErrInvalidUpdate):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("Error handling %s for spirit %s: %s", r.Method, spiritId, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(spirit)
+}
+
+// BattlePageResponse is returned when listing battles.
+type BattlePageResponse struct {
+	Battles []battle_manager.Battle `json:"battles"`
+	// Opaque token to pass back as pageToken to fetch the next page. Only set
+	// when HasMore is true.
+	NextPageToken *string `json:"nextPageToken"`
+	HasMore       bool    `json:"hasMore"`
+}
+
+// ActionResponse is returned when an action is submitted.
+type ActionResponse struct {
+	Battle battle_manager.Battle `json:"battle"`
+	// What the action caused, in order, for the client to animate.
+	Events []battle_engine.Event `json:"events"`
+}
+
+// Handles listing the authenticated user's battles (GET), optionally
+// filtered by ?status, and starting or inviting to a battle (POST).
+func (s *Server) battlesHandler(w http.ResponseWriter, r *http.Request) {
+	token, ok := middleware.GetAuthenticatedUser(r.Context())
+	if !ok {
+		log.Printf("Error getting authenticated user.")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		status := battle_manager.Status(r.URL.Query().Get("status"))
+		switch status {
+		case "", battle_manager.StatusInvited, battle_manager.StatusActive, battle_manager.StatusFinished, battle_manager.StatusDeclined:
+		default:
+			http.Error(w, "status must be invited, active, finished or declined", http.StatusBadRequest)
+			return
+		}
+		scope := token.UID + "?battles&status=" + string(status)
+		limit, startAfter, err := s.parsePageParams(r, scope)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		page, err := s.BattleManager.List(&token.UID, status, limit, startAfter)
+		if err != nil {
+			log.Printf("Error listing battles: %s", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		response := BattlePageResponse{Battles: page.Battles, HasMore: page.HasMore}
+		if page.HasMore {
+			nextPageToken, err := s.PageTokens.Encode(scope, page.LastCursor)
+			if err != nil {
+				log.Printf("Error encoding page token: %s", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			response.NextPageToken = &nextPageToken
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	case http.MethodPost:
+		var request battle_manager.CreateRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			log.Printf("Error during JSON decoding: %s", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		battle, err := s.BattleManager.Create(&token.UID, request)
+		if errors.Is(err, battle_manager.ErrInvalidBattle) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Error creating battle: %s", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/Battles/"+battle.ID)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(battle)
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Returns one of the authenticated user's battles with its current state.
+func (s *Server) battleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, ok := middleware.GetAuthenticatedUser(r.Context())
+	if !ok {
+		log.Printf("Error getting authenticated user.")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	battleId := r.PathValue("id")
+
+	battle, err := s.BattleManager.Get(&token.UID, battleId)
+	if err != nil {
+		writeBattleError(w, r, battleId, err)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(spirits)
+	json.NewEncoder(w).Encode(battle)
+}
+
+// Accepts a battle the authenticated user was invited to, with a team from
+// their collection.
+func (s *Server) battleAcceptHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, ok := middleware.GetAuthenticatedUser(r.Context())
+	if !ok {
+		log.Printf("Error getting authenticated user.")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	battleId := r.PathValue("id")
+
+	var request struct {
+		Team battle_manager.TeamRequest `json:"team"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("Error during JSON decoding: %s", err)
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	battle, err := s.BattleManager.Accept(&token.UID, battleId, request.Team)
+	if err != nil {
+		writeBattleError(w, r, battleId, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(battle)
+}
+
+// Declines a battle the authenticated user was invited to, or withdraws an
+// invitation they sent.
+func (s *Server) battleDeclineHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, ok := middleware.GetAuthenticatedUser(r.Context())
+	if !ok {
+		log.Printf("Error getting authenticated user.")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	battleId := r.PathValue("id")
+
+	battle, err := s.BattleManager.Decline(&token.UID, battleId)
+	if err != nil {
+		writeBattleError(w, r, battleId, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(battle)
+}
+
+// Handles reading a battle's action log (GET), optionally only the actions
+// after ?after={sequence}, and submitting an action for the current turn
+// (POST).
+func (s *Server) battleActionsHandler(w http.ResponseWriter, r *http.Request) {
+	token, ok := middleware.GetAuthenticatedUser(r.Context())
+	if !ok {
+		log.Printf("Error getting authenticated user.")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	battleId := r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodGet:
+		after, err := parseAfter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		actions, err := s.BattleManager.Actions(&token.UID, battleId, after)
+		if err != nil {
+			writeBattleError(w, r, battleId, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"actions": actions})
+	case http.MethodPost:
+		var action battle_engine.Action
+		if err := json.NewDecoder(r.Body).Decode(&action); err != nil {
+			log.Printf("Error during JSON decoding: %s", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		battle, events, err := s.BattleManager.Submit(&token.UID, battleId, action)
+		if err != nil {
+			writeBattleError(w, r, battleId, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ActionResponse{Battle: battle, Events: events})
+	default:
+		http.Error(w, "Only GET and POST methods are allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Reads the ?after={sequence} parameter of the battle endpoints. It defaults
+// to 0, the start of the log.
+func parseAfter(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("after")
+	if value == "" {
+		return 0, nil
+	}
+	after, err := strconv.Atoi(value)
+	if err != nil || after < 0 {
+		return 0, errors.New("after must be a non-negative integer")
+	}
+	return after, nil
+}
+
+// Maps the errors of the battle endpoints to responses.
+func writeBattleError(w http.ResponseWriter, r *http.Request, battleId string, err error) {
+	status, message := battleErrorStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("Error handling %s for battle %s: %s", r.Method, battleId, err)
+	}
+	http.Error(w, message, status)
+}
+
+// Returns the status code and message that report an error of the battle
+// manager.
+func battleErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, battle_manager.ErrNotFound):
+		return http.StatusNotFound, "Battle not found"
+	case errors.Is(err, battle_engine.ErrNotYourTurn):
+		return http.StatusForbidden, err.Error()
+	case errors.Is(err, battle_engine.ErrInvalidAction), errors.Is(err, battle_manager.ErrInvalidBattle):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, battle_engine.ErrBattleOver), errors.Is(err, battle_manager.ErrConflict),
+		errors.Is(err, battle_manager.ErrNotInvited), errors.Is(err, battle_manager.ErrNotStarted):
+		return http.StatusConflict, err.Error()
+	default:
+		return http.StatusInternalServerError, err.Error()
+	}
+}
+
+// How often battle sockets check the log for actions taken through other
+// server instances, which are not notified.
+const battleSocketPollInterval = 3 * time.Second
+
+// Largest message a client may send on a battle socket.
+const maxBattleSocketMessageBytes = 64 << 10
+
+// Types of BattleSocketMessage.
+const (
+	// An action was logged. Sent for the user's own actions too.
+	battleSocketUpdate = "update"
+	// The client has received every update up to the current state of the
+	// battle. Sent once after connecting.
+	battleSocketSynced = "synced"
+	// A request from the client was rejected.
+	battleSocketError = "error"
+)
+
+// BattleSocketRequest is a message from the client on a battle socket.
+type BattleSocketRequest struct {
+	// "action", the only kind of request.
+	Type string `json:"type"`
+	// Chosen by the client and sent back if the request is rejected.
+	RequestID string               `json:"requestId,omitempty"`
+	Action    battle_engine.Action `json:"action"`
+}
+
+// BattleSocketMessage is a message from the server on a battle socket.
+type BattleSocketMessage struct {
+	Type string `json:"type"`
+	// Set for updates.
+	Update *battle_manager.Update `json:"update,omitempty"`
+	// The current battle, set when synced.
+	Battle *battle_manager.Battle `json:"battle,omitempty"`
+	// Set for errors. Status is the HTTP status code the same error has on
+	// POST /Battles/{id}/Actions.
+	RequestID string `json:"requestId,omitempty"`
+	Status    int    `json:"status,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Upgrades to a WebSocket that pushes a battle's actions to a player as they
+// are logged, with the events and state after each one, and accepts actions
+// from them. Clients that reconnect pass the last sequence number they saw
+// as ?after={sequence} to receive the updates they missed. The server closes
+// the socket once the battle is over.
+func (s *Server) battleSocketHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, ok := middleware.GetAuthenticatedUser(r.Context())
+	if !ok {
+		log.Printf("Error getting authenticated user.")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	battleId := r.PathValue("id")
+	after, err := parseAfter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Subscribing before reading the log ensures no action is missed in
+	// between. Errors are reported before upgrading, as plain responses.
+	changed, unsubscribe := s.BattleManager.Subscribe(battleId)
+	defer unsubscribe()
+	battle, updates, err := s.BattleManager.Updates(&token.UID, battleId, after)
+	if err != nil {
+		writeBattleError(w, r, battleId, err)
+		return
+	}
+
+	// There is no Origin check: browsers do not attach the Authorization
+	// header on their own, so other sites cannot open sockets as the user.
+	websocket.Server{Handler: func(ws *websocket.Conn) {
+		ws.MaxPayloadBytes = maxBattleSocketMessageBytes
+		session := battleSession{server: s, ws: ws, userId: token.UID, battleId: battleId, lastSequence: after}
+		if !session.push(updates) || websocket.JSON.Send(ws, BattleSocketMessage{Type: battleSocketSynced, Battle: &battle}) != nil {
+			return
+		}
+		if battle.Status != battle_manager.StatusFinished {
+			session.serve(changed)
+		}
+	}}.ServeHTTP(w, r)
+}
+
+// A player's connection to a battle socket. Messages are only sent from the
+// goroutine running serve.
+type battleSession struct {
+	server   *Server
+	ws       *websocket.Conn
+	userId   string
+	battleId string
+	// Sequence number of the last update sent.
+	lastSequence int
+}
+
+// Pushes new updates and submits the client's actions until the client
+// disconnects or the battle is over.
+func (session *battleSession) serve(changed <-chan struct{}) {
+	requests := make(chan []byte)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(requests)
+		for {
+			var payload []byte
+			if err := websocket.Message.Receive(session.ws, &payload); err != nil {
+				return
+			}
+			select {
+			case requests <- payload:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(battleSocketPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case payload, ok := <-requests:
+			if !ok {
+				return
+			}
+			// The update for an accepted action arrives through changed.
+			if !session.submit(payload) {
+				return
+			}
+		case <-changed:
+			if !session.catchUp() {
+				return
+			}
+		case <-ticker.C:
+			// Checking the log for new actions is cheaper than replaying it.
+			actions, err := session.server.BattleManager.Actions(&session.userId, session.battleId, session.lastSequence)
+			if err != nil {
+				log.Printf("Error polling battle %s: %s", session.battleId, err)
+				continue
+			}
+			if len(actions) > 0 && !session.catchUp() {
+				return
+			}
+		}
+	}
+}
+
+// Submits an action sent by the client and reports a rejection back to it.
+// Returns false if the socket failed.
+func (session *battleSession) submit(payload []byte) bool {
+	var request BattleSocketRequest
+	if err := json.Unmarshal(payload, &request); err != nil {
+		log.Printf("Error during JSON decoding: %s", err)
+		return session.sendError(request.RequestID, http.StatusBadRequest, "Invalid request payload")
+	}
+	if request.Type != "action" {
+		return session.sendError(request.RequestID, http.StatusBadRequest, fmt.Sprintf("Unknown request type %q", request.Type))
+	}
+	if _, _, err := session.server.BattleManager.Submit(&session.userId, session.battleId, request.Action); err != nil {
+		status, message := battleErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			log.Printf("Error submitting action to battle %s: %s", session.battleId, err)
+		}
+		return session.sendError(request.RequestID, status, message)
+	}
+	return true
+}
+
+// Sends the updates logged since the last one sent. Returns false if the
+// socket failed or the battle is over.
+func (session *battleSession) catchUp() bool {
+	battle, updates, err := session.server.BattleManager.Updates(&session.userId, session.battleId, session.lastSequence)
+	if err != nil {
+		// The next notification or poll tries again.
+		log.Printf("Error reading updates of battle %s: %s", session.battleId, err)
+		return true
+	}
+	return session.push(updates) && battle.Status != battle_manager.StatusFinished
+}
+
+// Sends updates in order. Returns false if the socket failed.
+func (session *battleSession) push(updates []battle_manager.Update) bool {
+	for i := range updates {
+		if err := websocket.JSON.Send(session.ws, BattleSocketMessage{Type: battleSocketUpdate, Update: &updates[i]}); err != nil {
+			return false
+		}
+		session.lastSequence = updates[i].Sequence
+	}
+	return true
+}
+
+func (session *battleSession) sendError(requestId string, status int, message string) bool {
+	err := websocket.JSON.Send(session.ws, BattleSocketMessage{Type: battleSocketError, RequestID: requestId, Status: status, Error: message})
+	return err == nil
 }
 
 func main() {
-	port := *flag.Int("port", 8080, "Port for the HTTP server")
+	port := flag.Int("port", 8080, "Port for the HTTP server")
+	backendName := flag.String("backend", BackendFirebase, `Where data is stored: "firebase", or "local" to run without Firebase`)
 	flag.Parse()
 
-	jsonCredentials := os.Getenv("FIREBASE_CREDENTIALS_JSON")
-	if jsonCredentials == "" {
-		log.Fatal("FIREBASE_CREDENTIALS_JSON environment variable is not set")
+	config, err := loadConfig(*backendName)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if config.PublicURL == "" {
+		config.PublicURL = fmt.Sprintf("http://localhost:%d", *port)
 	}
 
 	ctx := context.Background()
-	opts := option.WithCredentialsJSON([]byte(jsonCredentials))
-	firebaseApp, err := firebase.NewApp(ctx, nil, opts)
-	if err != nil {
-		log.Fatalf("Failed to initialize Firebase App: %v", err)
+	var backend Backend
+	if config.Backend == BackendLocal {
+		log.Print("Using the local backend. Bearer tokens are trusted as user IDs.")
+		backend, err = newLocalBackend(config)
+		if err != nil {
+			log.Fatalf("Failed to create backend: %v", err)
+		}
+	} else {
+		opts := option.WithCredentialsJSON(config.GoogleCredentialsJSON)
+		firebaseApp, err := firebase.NewApp(ctx, nil, opts)
+		if err != nil {
+			log.Fatalf("Failed to initialize Firebase App: %v", err)
+		}
+		backend, err = newFirebaseBackend(ctx, firebaseApp)
+		if err != nil {
+			log.Fatalf("Failed to create backend: %v", err)
+		}
 	}
 
-	s, err := NewServer(ctx, firebaseApp, http.DefaultTransport)
+	s, err := NewServer(ctx, backend, http.DefaultTransport, config)
 	if err != nil {
 		log.Fatalf("Failed to create server: %v", err)
 	}
@@ -160,10 +1571,30 @@ func main() {
 	mux := http.NewServeMux()
 
 	mux.Handle("/ProcessImage", middleware.AuthMiddleware(s.AuthClient)(http.HandlerFunc(s.processImageHandler)))
+	mux.Handle("/ProcessImageStream", middleware.AuthMiddleware(s.AuthClient)(http.HandlerFunc(s.processImageStreamHandler)))
 	mux.Handle("/FetchSpirits", middleware.AuthMiddleware(s.AuthClient)(http.HandlerFunc(s.fetchSpiritsHandler)))
+	mux.Handle("/SearchSpirits", middleware.AuthMiddleware(s.AuthClient)(http.HandlerFunc(s.searchSpiritsHandler)))
+	mux.Handle("/Spirits/{id}", middleware.AuthMiddleware(s.AuthClient)(http.HandlerFunc(s.spiritHandler)))
+	mux.Handle("/Jobs/{id}", middleware.AuthMiddleware(s.AuthClient)(http.HandlerFunc(s.jobHandler)))
+	mux.Handle("/Battles", middleware.AuthMiddleware(s.AuthClient)(http.HandlerFunc(s.battlesHandler)))
+	mux.Handle("/Battles/{id}", middleware.AuthMiddleware(s.AuthClient)(http.HandlerFunc(s.battleHandler)))
+	mux.Handle("/Battles/{id}/Accept", middleware.AuthMiddleware(s.AuthClient)(http.HandlerFunc(s.battleAcceptHandler)))
+	mux.Handle("/Battles/{id}/Decline", middleware.AuthMiddleware(s.AuthClient)(http.HandlerFunc(s.battleDeclineHandler)))
+	mux.Handle("/Battles/{id}/Actions", middleware.AuthMiddleware(s.AuthClient)(http.HandlerFunc(s.battleActionsHandler)))
+	mux.Handle("/Battles/{id}/Socket", middleware.AuthMiddleware(s.AuthClient)(http.HandlerFunc(s.battleSocketHandler)))
+	mux.Handle("/Types", middleware.AuthMiddleware(s.AuthClient)(http.HandlerFunc(s.typesHandler)))
+	if config.DebugEndpoints {
+		mux.Handle("/Debug/ImagenRegions", middleware.AuthMiddleware(s.AuthClient)(http.HandlerFunc(s.imagenRegionsHandler)))
+	}
+	if s.Files != nil {
+		// Download URLs are links to images, like the signed URLs of Firebase
+		// Storage, so they are not authenticated. Files in FILES_DIR are only
+		// served for URLs with a valid signature.
+		mux.Handle("/files/", http.StripPrefix("/files", s.Files))
+	}
 
-	portMessage := fmt.Sprintf("Server is running on port %d.", port)
+	portMessage := fmt.Sprintf("Server is running on port %d.", *port)
 	fmt.Println(portMessage)
 	log.Print(portMessage)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), mux))
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *port), mux))
 }
@@ -3,39 +3,181 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/png"
+	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"spirit-snap/server/logic/battle_engine"
+	"spirit-snap/server/logic/battle_manager"
+	"spirit-snap/server/logic/collection_fetcher"
+	"spirit-snap/server/logic/image_processor"
+	"spirit-snap/server/logic/page_token"
+	"spirit-snap/server/logic/spirit_jobs"
+	"spirit-snap/server/logic/spirit_manager"
+	"spirit-snap/server/logic/spirit_types"
 	"spirit-snap/server/middleware"
 	"spirit-snap/server/models"
+	"spirit-snap/server/wrappers/datastore"
+	"spirit-snap/server/wrappers/file_storage"
+	"strings"
 	"testing"
+	"time"
 
 	"firebase.google.com/go/auth"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
 )
 
+// A valid photo to upload, as PNG bytes and as a data URL.
+var testPhoto = encodeTestPNG(128, 128)
+var testImage = encodeTestImage(128, 128)
+
+// Returns a PNG of the given size.
+func encodeTestPNG(width, height int) []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)))
+	return buf.Bytes()
+}
+
+// Returns a PNG data URL of the given size.
+func encodeTestImage(width, height int) string {
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(encodeTestPNG(width, height))
+}
+
 // MockImageProcessor implements the Processor interface for testing
 type MockImageProcessor struct {
-	ProcessFunc func(image *string, userId *string) (models.Spirit, error)
+	ProcessFunc             func(photo []byte, contentType string, userId *string) (models.Spirit, error)
+	ProcessWithProgressFunc func(photo []byte, contentType string, userId *string, onProgress image_processor.ProgressFunc) (models.Spirit, error)
+}
+
+func (m *MockImageProcessor) Process(photo []byte, contentType string, userId *string) (models.Spirit, error) {
+	return m.ProcessFunc(photo, contentType, userId)
 }
 
-func (m *MockImageProcessor) Process(image *string, userId *string) (models.Spirit, error) {
-	return m.ProcessFunc(image, userId)
+func (m *MockImageProcessor) ProcessWithProgress(photo []byte, contentType string, userId *string, onProgress image_processor.ProgressFunc) (models.Spirit, error) {
+	return m.ProcessWithProgressFunc(photo, contentType, userId, onProgress)
 }
 
 func (m *MockImageProcessor) Close() {}
 
 // MockCollectionFetcher implements the CollectionFetcher interface for testing
 type MockCollectionFetcher struct {
-	FetchFunc func(*string, int, []interface{}) ([]models.Spirit, error)
+	FetchFunc  func(*string, int, []interface{}) (*collection_fetcher.SpiritPage, error)
+	SearchFunc func(*string, collection_fetcher.SpiritQuery) (*collection_fetcher.SpiritPage, error)
 }
 
-func (m *MockCollectionFetcher) Fetch(userId *string, limit int, cursor []interface{}) ([]models.Spirit, error) {
+func (m *MockCollectionFetcher) Fetch(userId *string, limit int, cursor []interface{}) (*collection_fetcher.SpiritPage, error) {
 	return m.FetchFunc(userId, limit, cursor)
 }
 
+func (m *MockCollectionFetcher) Search(userId *string, query collection_fetcher.SpiritQuery) (*collection_fetcher.SpiritPage, error) {
+	return m.SearchFunc(userId, query)
+}
+
+// MockSpiritManager implements the SpiritManager interface for testing
+type MockSpiritManager struct {
+	GetFunc    func(userId *string, spiritId string) (models.Spirit, error)
+	UpdateFunc func(userId *string, spiritId string, update spirit_manager.SpiritUpdate) (models.Spirit, error)
+	DeleteFunc func(userId *string, spiritId string) error
+}
+
+func (m *MockSpiritManager) Get(userId *string, spiritId string) (models.Spirit, error) {
+	return m.GetFunc(userId, spiritId)
+}
+
+func (m *MockSpiritManager) Update(userId *string, spiritId string, update spirit_manager.SpiritUpdate) (models.Spirit, error) {
+	return m.UpdateFunc(userId, spiritId, update)
+}
+
+func (m *MockSpiritManager) Delete(userId *string, spiritId string) error {
+	return m.DeleteFunc(userId, spiritId)
+}
+
+// MockJobManager implements the JobManager interface for testing
+type MockJobManager struct {
+	SubmitFunc func(userId *string, photo []byte, contentType string) (string, error)
+	GetFunc    func(userId *string, jobId string) (spirit_jobs.Job, error)
+}
+
+func (m *MockJobManager) Submit(userId *string, photo []byte, contentType string) (string, error) {
+	return m.SubmitFunc(userId, photo, contentType)
+}
+
+func (m *MockJobManager) Get(userId *string, jobId string) (spirit_jobs.Job, error) {
+	return m.GetFunc(userId, jobId)
+}
+
+func (m *MockJobManager) Close() {}
+
+// MockBattleManager implements the BattleManager interface for testing
+type MockBattleManager struct {
+	CreateFunc    func(userId *string, request battle_manager.CreateRequest) (battle_manager.Battle, error)
+	AcceptFunc    func(userId *string, battleId string, team battle_manager.TeamRequest) (battle_manager.Battle, error)
+	DeclineFunc   func(userId *string, battleId string) (battle_manager.Battle, error)
+	GetFunc       func(userId *string, battleId string) (battle_manager.Battle, error)
+	SubmitFunc    func(userId *string, battleId string, action battle_engine.Action) (battle_manager.Battle, []battle_engine.Event, error)
+	ListFunc      func(userId *string, status battle_manager.Status, limit int, startAfter []interface{}) (*battle_manager.BattlePage, error)
+	ActionsFunc   func(userId *string, battleId string, after int) ([]battle_manager.LoggedAction, error)
+	UpdatesFunc   func(userId *string, battleId string, after int) (battle_manager.Battle, []battle_manager.Update, error)
+	SubscribeFunc func(battleId string) (<-chan struct{}, func())
+}
+
+func (m *MockBattleManager) Create(userId *string, request battle_manager.CreateRequest) (battle_manager.Battle, error) {
+	return m.CreateFunc(userId, request)
+}
+
+func (m *MockBattleManager) Accept(userId *string, battleId string, team battle_manager.TeamRequest) (battle_manager.Battle, error) {
+	return m.AcceptFunc(userId, battleId, team)
+}
+
+func (m *MockBattleManager) Decline(userId *string, battleId string) (battle_manager.Battle, error) {
+	return m.DeclineFunc(userId, battleId)
+}
+
+func (m *MockBattleManager) Get(userId *string, battleId string) (battle_manager.Battle, error) {
+	return m.GetFunc(userId, battleId)
+}
+
+func (m *MockBattleManager) Submit(userId *string, battleId string, action battle_engine.Action) (battle_manager.Battle, []battle_engine.Event, error) {
+	return m.SubmitFunc(userId, battleId, action)
+}
+
+func (m *MockBattleManager) List(userId *string, status battle_manager.Status, limit int, startAfter []interface{}) (*battle_manager.BattlePage, error) {
+	return m.ListFunc(userId, status, limit, startAfter)
+}
+
+func (m *MockBattleManager) Actions(userId *string, battleId string, after int) ([]battle_manager.LoggedAction, error) {
+	return m.ActionsFunc(userId, battleId, after)
+}
+
+func (m *MockBattleManager) Updates(userId *string, battleId string, after int) (battle_manager.Battle, []battle_manager.Update, error) {
+	return m.UpdatesFunc(userId, battleId, after)
+}
+
+func (m *MockBattleManager) Subscribe(battleId string) (<-chan struct{}, func()) {
+	if m.SubscribeFunc != nil {
+		return m.SubscribeFunc(battleId)
+	}
+	return make(chan struct{}), func() {}
+}
+
+// MockRegionRouter implements the RegionRouter interface for testing
+type MockRegionRouter struct {
+	SnapshotFunc func() []image_processor.RegionState
+}
+
+func (m *MockRegionRouter) Snapshot() []image_processor.RegionState {
+	return m.SnapshotFunc()
+}
+
 // MockAuthClient implements a mock Firebase auth client
 type MockAuthClient struct {
 	VerifyIDTokenFunc func(context.Context, string) (*auth.Token, error)
@@ -93,7 +235,7 @@ func TestProcessImageHandler_InternalServerError(t *testing.T) {
 	// Setup
 	server := &Server{
 		ImageProcessor: &MockImageProcessor{
-			ProcessFunc: func(image *string, userId *string) (models.Spirit, error) {
+			ProcessFunc: func(photo []byte, contentType string, userId *string) (models.Spirit, error) {
 				return models.Spirit{}, fmt.Errorf("mock error")
 			},
 		},
@@ -101,7 +243,7 @@ func TestProcessImageHandler_InternalServerError(t *testing.T) {
 	}
 
 	// Send a valid JSON body
-	imageData := ImageData{Base64Image: "test_base64_image"}
+	imageData := ImageData{Base64Image: testImage}
 	body, _ := json.Marshal(imageData)
 	req := httptest.NewRequest(http.MethodPost, "/ProcessImage", bytes.NewBuffer(body))
 	req.Header.Set("Authorization", "Bearer test-token")
@@ -116,11 +258,130 @@ func TestProcessImageHandler_InternalServerError(t *testing.T) {
 	assert.Equal(t, "mock error\n", rr.Body.String())
 }
 
+func TestProcessImageHandler_InvalidImage(t *testing.T) {
+	// Setup
+	server := &Server{
+		ImageProcessor: &MockImageProcessor{
+			ProcessFunc: func(photo []byte, contentType string, userId *string) (models.Spirit, error) {
+				return models.Spirit{}, &image_processor.UploadError{Code: image_processor.UploadErrorUndecodable, Message: "failed to decode image/png photo"}
+			},
+		},
+		AuthClient: &MockAuthClient{},
+	}
+
+	imageData := ImageData{Base64Image: testImage}
+	body, _ := json.Marshal(imageData)
+	req := httptest.NewRequest(http.MethodPost, "/ProcessImage", bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.processImageHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"code": "undecodable_image", "error": "failed to decode image/png photo"}`, rr.Body.String())
+}
+
+func TestImageHandlers_RejectUploads(t *testing.T) {
+	limits := image_processor.UploadLimits{
+		MaxBodyBytes:  4096,
+		MaxImageBytes: 2048,
+		MinDimension:  64,
+		MaxDimension:  256,
+	}
+	// Cut off in the middle of the header, which is all that is read before
+	// the photo is processed.
+	corruptHeader := "data:image/png;base64," + base64.StdEncoding.EncodeToString(encodeTestPNG(128, 128)[:20])
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{"body too large", `{"Base64Image": "` + strings.Repeat("A", 5000) + `"}`, http.StatusRequestEntityTooLarge, image_processor.UploadErrorBodyTooLarge},
+		{"image too large", `{"Base64Image": "data:image/png;base64,` + strings.Repeat("A", 3000) + `"}`, http.StatusRequestEntityTooLarge, image_processor.UploadErrorImageTooLarge},
+		{"invalid data URL", `{"Base64Image": "data:image/png;base64,!!!"}`, http.StatusBadRequest, image_processor.UploadErrorInvalidDataURL},
+		{"not an image", `{"Base64Image": "data:image/png;base64,aGVsbG8gd29ybGQ="}`, http.StatusBadRequest, image_processor.UploadErrorUnsupportedFormat},
+		{"undecodable", `{"Base64Image": "` + corruptHeader + `"}`, http.StatusBadRequest, image_processor.UploadErrorUndecodable},
+		{"too small", `{"Base64Image": "` + encodeTestImage(32, 128) + `"}`, http.StatusBadRequest, image_processor.UploadErrorDimensionsTooSmall},
+		{"too large", `{"Base64Image": "` + encodeTestImage(512, 128) + `"}`, http.StatusBadRequest, image_processor.UploadErrorDimensionsTooLarge},
+	}
+	endpoints := []struct {
+		path    string
+		handler func(s *Server) http.HandlerFunc
+	}{
+		{"/ProcessImage", func(s *Server) http.HandlerFunc { return s.processImageHandler }},
+		{"/ProcessImage?async=true", func(s *Server) http.HandlerFunc { return s.processImageHandler }},
+		{"/ProcessImageStream", func(s *Server) http.HandlerFunc { return s.processImageStreamHandler }},
+	}
+	for _, endpoint := range endpoints {
+		for _, tt := range tests {
+			t.Run(endpoint.path+" "+tt.name, func(t *testing.T) {
+				// Setup
+				// The mocks have no funcs, so any call to them panics.
+				server := &Server{
+					ImageProcessor: &MockImageProcessor{},
+					JobManager:     &MockJobManager{},
+					AuthClient:     &MockAuthClient{},
+					UploadLimits:   limits,
+				}
+				req := httptest.NewRequest(http.MethodPost, endpoint.path, strings.NewReader(tt.body))
+				req.Header.Set("Authorization", "Bearer test-token")
+				rr := httptest.NewRecorder()
+
+				// Execute
+				handler := middleware.AuthMiddleware(server.AuthClient)(endpoint.handler(server))
+				handler.ServeHTTP(rr, req)
+
+				// Assert
+				assert.Equal(t, tt.status, rr.Code)
+				assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+				var response UploadErrorResponse
+				assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+				assert.Equal(t, tt.code, response.Code)
+				assert.NotEmpty(t, response.Error)
+			})
+		}
+	}
+}
+
+func TestProcessImageHandler_DuplicatePhoto(t *testing.T) {
+	// Setup
+	server := &Server{
+		ImageProcessor: &MockImageProcessor{
+			ProcessFunc: func(photo []byte, contentType string, userId *string) (models.Spirit, error) {
+				return models.Spirit{}, fmt.Errorf("processing: %w", &image_processor.DuplicatePhotoError{SpiritID: "spirit_123", Distance: 2})
+			},
+		},
+		AuthClient: &MockAuthClient{},
+	}
+	body, _ := json.Marshal(ImageData{Base64Image: testImage})
+	req := httptest.NewRequest(http.MethodPost, "/ProcessImage", bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.processImageHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	var response UploadErrorResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "duplicate_photo", response.Code)
+	assert.Equal(t, "spirit_123", response.SpiritID)
+	assert.NotEmpty(t, response.Error)
+}
+
 func TestProcessImageHandler_Success(t *testing.T) {
 	// Setup
 	server := &Server{
 		ImageProcessor: &MockImageProcessor{
-			ProcessFunc: func(image *string, userId *string) (models.Spirit, error) {
+			ProcessFunc: func(photo []byte, contentType string, userId *string) (models.Spirit, error) {
 				return models.Spirit{ID: ptr("test_id")}, nil
 			},
 		},
@@ -128,7 +389,7 @@ func TestProcessImageHandler_Success(t *testing.T) {
 	}
 
 	// Send a valid JSON body
-	imageData := ImageData{Base64Image: "test_base64_image"}
+	imageData := ImageData{Base64Image: testImage}
 	body, _ := json.Marshal(imageData)
 	req := httptest.NewRequest(http.MethodPost, "/ProcessImage", bytes.NewBuffer(body))
 	req.Header.Set("Authorization", "Bearer test-token")
@@ -144,6 +405,112 @@ func TestProcessImageHandler_Success(t *testing.T) {
 	expectedJSON, _ := json.Marshal(expectedResponse)
 	assert.Equal(t, string(expectedJSON)+"\n", rr.Body.String())
 }
+
+// Returns a multipart/form-data body with data in the given field.
+func multipartBody(field string, data []byte) (*bytes.Buffer, string) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("note", "sent before the photo")
+	part, _ := writer.CreateFormFile(field, "photo.png")
+	part.Write(data)
+	writer.Close()
+	return &body, writer.FormDataContentType()
+}
+
+func TestProcessImageHandler_BinaryUploads(t *testing.T) {
+	multipartPhoto, multipartType := multipartBody("image", testPhoto)
+	tests := []struct {
+		name        string
+		body        io.Reader
+		contentType string
+	}{
+		{"multipart", multipartPhoto, multipartType},
+		{"raw", bytes.NewReader(testPhoto), "image/png"},
+		// The format is detected from the content, not the header.
+		{"raw with wrong image type", bytes.NewReader(testPhoto), "image/jpeg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Setup
+			server := &Server{
+				ImageProcessor: &MockImageProcessor{
+					ProcessFunc: func(photo []byte, contentType string, userId *string) (models.Spirit, error) {
+						assert.Equal(t, testPhoto, photo)
+						assert.Equal(t, image_processor.MimeTypePNG, contentType)
+						return models.Spirit{ID: ptr("test_id")}, nil
+					},
+				},
+				AuthClient:   &MockAuthClient{},
+				UploadLimits: image_processor.DefaultUploadLimits(),
+			}
+			req := httptest.NewRequest(http.MethodPost, "/ProcessImage", tt.body)
+			req.Header.Set("Authorization", "Bearer test-token")
+			req.Header.Set("Content-Type", tt.contentType)
+			rr := httptest.NewRecorder()
+
+			// Execute
+			handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.processImageHandler))
+			handler.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, http.StatusOK, rr.Code)
+		})
+	}
+}
+
+func TestProcessImageHandler_RejectBinaryUploads(t *testing.T) {
+	limits := image_processor.UploadLimits{
+		MaxBodyBytes:  4096,
+		MaxImageBytes: 2048,
+		MinDimension:  64,
+		MaxDimension:  256,
+	}
+	largePhoto := bytes.Repeat([]byte{0}, 3000)
+	multipartLarge, largeType := multipartBody("image", largePhoto)
+	multipartMissing, missingType := multipartBody("photo", testPhoto)
+	multipartSmall, smallType := multipartBody("image", encodeTestPNG(32, 128))
+
+	tests := []struct {
+		name        string
+		body        io.Reader
+		contentType string
+		status      int
+		code        string
+	}{
+		{"raw image too large", bytes.NewReader(largePhoto), "image/png", http.StatusRequestEntityTooLarge, image_processor.UploadErrorImageTooLarge},
+		{"raw not an image", strings.NewReader("hello world"), "image/png", http.StatusBadRequest, image_processor.UploadErrorUnsupportedFormat},
+		{"multipart image too large", multipartLarge, largeType, http.StatusRequestEntityTooLarge, image_processor.UploadErrorImageTooLarge},
+		{"multipart missing image", multipartMissing, missingType, http.StatusBadRequest, image_processor.UploadErrorMissingImage},
+		{"multipart too small", multipartSmall, smallType, http.StatusBadRequest, image_processor.UploadErrorDimensionsTooSmall},
+		{"unsupported content type", strings.NewReader("photo"), "text/plain", http.StatusUnsupportedMediaType, image_processor.UploadErrorUnsupportedContentType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Setup
+			// The mock has no funcs, so any call to it panics.
+			server := &Server{
+				ImageProcessor: &MockImageProcessor{},
+				AuthClient:     &MockAuthClient{},
+				UploadLimits:   limits,
+			}
+			req := httptest.NewRequest(http.MethodPost, "/ProcessImage", tt.body)
+			req.Header.Set("Authorization", "Bearer test-token")
+			req.Header.Set("Content-Type", tt.contentType)
+			rr := httptest.NewRecorder()
+
+			// Execute
+			handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.processImageHandler))
+			handler.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, tt.status, rr.Code)
+			var response UploadErrorResponse
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			assert.Equal(t, tt.code, response.Code)
+		})
+	}
+}
+
 func TestProcessImageHandler_Unauthorized(t *testing.T) {
 	// Setup
 	server := &Server{
@@ -233,7 +600,7 @@ func TestFetchSpiritsHandler_InternalServerError(t *testing.T) {
 	// Setup
 	server := &Server{
 		CollectionFetcher: &MockCollectionFetcher{
-			FetchFunc: func(userId *string, limit int, cursor []interface{}) ([]models.Spirit, error) {
+			FetchFunc: func(userId *string, limit int, cursor []interface{}) (*collection_fetcher.SpiritPage, error) {
 				return nil, fmt.Errorf("mock error")
 			},
 		},
@@ -263,11 +630,14 @@ func TestFetchSpiritsHandler_Success(t *testing.T) {
 
 	server := &Server{
 		CollectionFetcher: &MockCollectionFetcher{
-			FetchFunc: func(userId *string, limit int, cursor []interface{}) ([]models.Spirit, error) {
-				return mockSpirits, nil
+			FetchFunc: func(userId *string, limit int, cursor []interface{}) (*collection_fetcher.SpiritPage, error) {
+				assert.Equal(t, defaultPageSize, limit)
+				assert.Nil(t, cursor)
+				return &collection_fetcher.SpiritPage{Spirits: mockSpirits}, nil
 			},
 		},
 		AuthClient: &MockAuthClient{},
+		PageTokens: page_token.NewCodec([]byte("test-secret")),
 	}
 
 	req := httptest.NewRequest(http.MethodGet, "/FetchSpirits", nil)
@@ -280,12 +650,1404 @@ func TestFetchSpiritsHandler_Success(t *testing.T) {
 
 	// Assert
 	assert.Equal(t, http.StatusOK, rr.Code)
-	var response []models.Spirit
+	var response SpiritPageResponse
 	err := json.NewDecoder(rr.Body).Decode(&response)
 	assert.NoError(t, err)
-	assert.Equal(t, mockSpirits, response)
+	assert.Equal(t, mockSpirits, response.Spirits)
+	assert.False(t, response.HasMore)
+	assert.Nil(t, response.NextPageToken)
+}
+
+func TestFetchSpiritsHandler_Pagination(t *testing.T) {
+	// Setup
+	lastCursor := []interface{}{"2024-01-15T10:30:00Z"}
+	server := &Server{
+		CollectionFetcher: &MockCollectionFetcher{
+			FetchFunc: func(userId *string, limit int, cursor []interface{}) (*collection_fetcher.SpiritPage, error) {
+				if cursor == nil {
+					return &collection_fetcher.SpiritPage{
+						Spirits:    []models.Spirit{{ID: ptr("1")}, {ID: ptr("2")}},
+						LastCursor: lastCursor,
+						HasMore:    true,
+					}, nil
+				}
+				assert.Equal(t, lastCursor, cursor)
+				return &collection_fetcher.SpiritPage{
+					Spirits: []models.Spirit{{ID: ptr("3")}},
+				}, nil
+			},
+		},
+		AuthClient: &MockAuthClient{},
+		PageTokens: page_token.NewCodec([]byte("test-secret")),
+	}
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.fetchSpiritsHandler))
+
+	// Execute first page
+	req := httptest.NewRequest(http.MethodGet, "/FetchSpirits?pageSize=2", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
 
+	// Assert first page
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var firstPage SpiritPageResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&firstPage))
+	assert.Len(t, firstPage.Spirits, 2)
+	assert.True(t, firstPage.HasMore)
+	assert.NotNil(t, firstPage.NextPageToken)
+
+	// Execute second page
+	req = httptest.NewRequest(http.MethodGet, "/FetchSpirits?pageSize=2&pageToken="+*firstPage.NextPageToken, nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	// Assert second page
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var secondPage SpiritPageResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&secondPage))
+	assert.Equal(t, []models.Spirit{{ID: ptr("3")}}, secondPage.Spirits)
+	assert.False(t, secondPage.HasMore)
+	assert.Nil(t, secondPage.NextPageToken)
+}
+
+func TestFetchSpiritsHandler_InvalidPageSize(t *testing.T) {
+	// Setup
+	server := &Server{
+		CollectionFetcher: &MockCollectionFetcher{},
+		AuthClient:        &MockAuthClient{},
+		PageTokens:        page_token.NewCodec([]byte("test-secret")),
+	}
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.fetchSpiritsHandler))
+
+	for _, pageSize := range []string{"0", "51", "ten"} {
+		req := httptest.NewRequest(http.MethodGet, "/FetchSpirits?pageSize="+pageSize, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+
+		// Execute
+		handler.ServeHTTP(rr, req)
+
+		// Assert
+		assert.Equal(t, http.StatusBadRequest, rr.Code, pageSize)
+		assert.Equal(t, "pageSize must be an integer between 1 and 50\n", rr.Body.String())
+	}
+}
+
+func TestFetchSpiritsHandler_InvalidPageToken(t *testing.T) {
+	// Setup
+	codec := page_token.NewCodec([]byte("test-secret"))
+	server := &Server{
+		CollectionFetcher: &MockCollectionFetcher{},
+		AuthClient:        &MockAuthClient{},
+		PageTokens:        codec,
+	}
+	// A token minted for a different user must not be accepted.
+	otherUsersToken, _ := codec.Encode("other-user-id", []interface{}{"2024-01-15T10:30:00Z"})
+
+	req := httptest.NewRequest(http.MethodGet, "/FetchSpirits?pageToken="+otherUsersToken, nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.fetchSpiritsHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Invalid pageToken\n", rr.Body.String())
+}
+
+func TestSearchSpiritsHandler_ParsesQuery(t *testing.T) {
+	// Setup
+	var received collection_fetcher.SpiritQuery
+	server := &Server{
+		CollectionFetcher: &MockCollectionFetcher{
+			SearchFunc: func(userId *string, query collection_fetcher.SpiritQuery) (*collection_fetcher.SpiritPage, error) {
+				received = query
+				return &collection_fetcher.SpiritPage{
+					Spirits:    []models.Spirit{{ID: ptr("1")}},
+					LastCursor: []interface{}{int64(80), "1"},
+					HasMore:    true,
+				}, nil
+			},
+		},
+		AuthClient: &MockAuthClient{},
+		PageTokens: page_token.NewCodec([]byte("test-secret")),
+	}
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.searchSpiritsHandler))
+
+	req := httptest.NewRequest(http.MethodGet, "/SearchSpirits?primaryType=Flame&name=ember&moveType=Stone&minStrength=70&maxHitPoints=120&sort=strength&order=asc&pageSize=5", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	seventy, oneTwenty := 70, 120
+	assert.Equal(t, collection_fetcher.SpiritQuery{
+		PrimaryType:  "Flame",
+		NameContains: "ember",
+		MoveType:     "Stone",
+		StatRanges: []collection_fetcher.StatRange{
+			{Stat: "strength", Min: &seventy},
+			{Stat: "hitPoints", Max: &oneTwenty},
+		},
+		SortField:     "strength",
+		SortDirection: datastore.Asc,
+		Limit:         5,
+	}, received)
+	var response SpiritPageResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.True(t, response.HasMore)
+	assert.NotNil(t, response.NextPageToken)
+
+	// The token is only valid with the same filters.
+	req = httptest.NewRequest(http.MethodGet, "/SearchSpirits?primaryType=Wave&pageToken="+*response.NextPageToken, nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Invalid pageToken\n", rr.Body.String())
+}
+
+func TestSearchSpiritsHandler_BadRequest(t *testing.T) {
+	// Setup
+	server := &Server{
+		CollectionFetcher: &MockCollectionFetcher{
+			SearchFunc: func(userId *string, query collection_fetcher.SpiritQuery) (*collection_fetcher.SpiritPage, error) {
+				return nil, fmt.Errorf("%w: cannot sort by %q", collection_fetcher.ErrInvalidQuery, query.SortField)
+			},
+		},
+		AuthClient: &MockAuthClient{},
+		PageTokens: page_token.NewCodec([]byte("test-secret")),
+	}
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.searchSpiritsHandler))
+
+	tests := []struct {
+		query        string
+		expectedBody string
+	}{
+		{query: "order=sideways", expectedBody: "order must be asc or desc\n"},
+		{query: "minLuck=lots", expectedBody: "minLuck must be an integer\n"},
+		{query: "sort=name", expectedBody: "invalid spirit query: cannot sort by \"name\"\n"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/SearchSpirits?"+tt.query, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		rr := httptest.NewRecorder()
+
+		// Execute
+		handler.ServeHTTP(rr, req)
+
+		// Assert
+		assert.Equal(t, http.StatusBadRequest, rr.Code, tt.query)
+		assert.Equal(t, tt.expectedBody, rr.Body.String())
+	}
+}
+
+func TestSpiritHandler_Get(t *testing.T) {
+	// Setup
+	server := &Server{
+		SpiritManager: &MockSpiritManager{
+			GetFunc: func(userId *string, spiritId string) (models.Spirit, error) {
+				assert.Equal(t, "test-user-id", *userId)
+				assert.Equal(t, "spirit1", spiritId)
+				return models.Spirit{ID: ptr("spirit1"), Name: ptr("Spirit 1")}, nil
+			},
+		},
+		AuthClient: &MockAuthClient{},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/Spirits/spirit1", nil)
+	req.SetPathValue("id", "spirit1")
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.spiritHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response models.Spirit
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.Equal(t, "Spirit 1", *response.Name)
+}
+
+func TestSpiritHandler_Update(t *testing.T) {
+	// Setup
+	server := &Server{
+		SpiritManager: &MockSpiritManager{
+			UpdateFunc: func(userId *string, spiritId string, update spirit_manager.SpiritUpdate) (models.Spirit, error) {
+				assert.Equal(t, "Renamed", *update.Name)
+				assert.Nil(t, update.Description)
+				return models.Spirit{ID: ptr("spirit1"), Name: update.Name}, nil
+			},
+		},
+		AuthClient: &MockAuthClient{},
+	}
+
+	req := httptest.NewRequest(http.MethodPatch, "/Spirits/spirit1", bytes.NewBufferString(`{"name": "Renamed"}`))
+	req.SetPathValue("id", "spirit1")
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.spiritHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response models.Spirit
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.Equal(t, "Renamed", *response.Name)
+}
+
+func TestSpiritHandler_Delete(t *testing.T) {
+	// Setup
+	deleted := false
+	server := &Server{
+		SpiritManager: &MockSpiritManager{
+			DeleteFunc: func(userId *string, spiritId string) error {
+				deleted = true
+				return nil
+			},
+		},
+		AuthClient: &MockAuthClient{},
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/Spirits/spirit1", nil)
+	req.SetPathValue("id", "spirit1")
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.spiritHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.True(t, deleted)
 }
+
+func TestSpiritHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		body           string
+		err            error
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "Not found", method: http.MethodGet, err: spirit_manager.ErrNotFound, expectedStatus: http.StatusNotFound, expectedBody: "Spirit not found\n"},
+		{name: "Forbidden", method: http.MethodDelete, err: spirit_manager.ErrForbidden, expectedStatus: http.StatusForbidden, expectedBody: "Forbidden\n"},
+		{name: "Invalid update", method: http.MethodPatch, body: `{}`, err: fmt.Errorf("%w: nothing to update", spirit_manager.ErrInvalidUpdate), expectedStatus: http.StatusBadRequest, expectedBody: "invalid spirit update: nothing to update\n"},
+		{name: "Invalid JSON", method: http.MethodPatch, body: `invalid-json`, expectedStatus: http.StatusBadRequest, expectedBody: "Invalid request payload\n"},
+		{name: "Internal error", method: http.MethodGet, err: fmt.Errorf("mock error"), expectedStatus: http.StatusInternalServerError, expectedBody: "mock error\n"},
+		{name: "Method not allowed", method: http.MethodPost, expectedStatus: http.StatusMethodNotAllowed, expectedBody: "Only GET, PATCH and DELETE methods are allowed\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Setup
+			server := &Server{
+				SpiritManager: &MockSpiritManager{
+					GetFunc: func(userId *string, spiritId string) (models.Spirit, error) {
+						return models.Spirit{}, tt.err
+					},
+					UpdateFunc: func(userId *string, spiritId string, update spirit_manager.SpiritUpdate) (models.Spirit, error) {
+						return models.Spirit{}, tt.err
+					},
+					DeleteFunc: func(userId *string, spiritId string) error {
+						return tt.err
+					},
+				},
+				AuthClient: &MockAuthClient{},
+			}
+
+			req := httptest.NewRequest(tt.method, "/Spirits/spirit1", bytes.NewBufferString(tt.body))
+			req.SetPathValue("id", "spirit1")
+			req.Header.Set("Authorization", "Bearer test-token")
+			rr := httptest.NewRecorder()
+
+			// Execute
+			handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.spiritHandler))
+			handler.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedBody, rr.Body.String())
+		})
+	}
+}
+
+func TestProcessImageStreamHandler_Success(t *testing.T) {
+	// Setup
+	server := &Server{
+		ImageProcessor: &MockImageProcessor{
+			ProcessWithProgressFunc: func(photo []byte, contentType string, userId *string, onProgress image_processor.ProgressFunc) (models.Spirit, error) {
+				onProgress(image_processor.ProgressEvent{Type: image_processor.EventStage, Stage: image_processor.StageAnalyzing})
+				onProgress(image_processor.ProgressEvent{Type: image_processor.EventSpiritData, Spirit: &models.Spirit{Name: ptr("Spirit 1")}})
+				spirit := models.Spirit{ID: ptr("test_id"), Name: ptr("Spirit 1")}
+				onProgress(image_processor.ProgressEvent{Type: image_processor.EventSpirit, Spirit: &spirit})
+				return spirit, nil
+			},
+		},
+		AuthClient: &MockAuthClient{},
+	}
+
+	body, _ := json.Marshal(ImageData{Base64Image: testImage})
+	req := httptest.NewRequest(http.MethodPost, "/ProcessImageStream", bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.processImageStreamHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/event-stream", rr.Header().Get("Content-Type"))
+	spiritData, _ := json.Marshal(models.Spirit{Name: ptr("Spirit 1")})
+	spirit, _ := json.Marshal(models.Spirit{ID: ptr("test_id"), Name: ptr("Spirit 1")})
+	expected := "event: stage\ndata: {\"stage\":\"analyzing\"}\n\n" +
+		"event: spirit_data\ndata: " + string(spiritData) + "\n\n" +
+		"event: spirit\ndata: " + string(spirit) + "\n\n"
+	assert.Equal(t, expected, rr.Body.String())
+}
+
+func TestProcessImageStreamHandler_Error(t *testing.T) {
+	// Setup
+	server := &Server{
+		ImageProcessor: &MockImageProcessor{
+			ProcessWithProgressFunc: func(photo []byte, contentType string, userId *string, onProgress image_processor.ProgressFunc) (models.Spirit, error) {
+				onProgress(image_processor.ProgressEvent{Type: image_processor.EventStage, Stage: image_processor.StageAnalyzing})
+				return models.Spirit{}, fmt.Errorf("mock error")
+			},
+		},
+		AuthClient: &MockAuthClient{},
+	}
+
+	body, _ := json.Marshal(ImageData{Base64Image: testImage})
+	req := httptest.NewRequest(http.MethodPost, "/ProcessImageStream", bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.processImageStreamHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	// The status is sent before processing starts, so errors arrive as events.
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "event: error\ndata: {\"error\":\"mock error\"}\n\n")
+}
+
+func TestProcessImageStreamHandler_BadRequest(t *testing.T) {
+	// Setup
+	server := &Server{
+		ImageProcessor: &MockImageProcessor{},
+		AuthClient:     &MockAuthClient{},
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/ProcessImageStream", bytes.NewBuffer([]byte(`invalid-json`)))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.processImageStreamHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "Invalid request payload\n", rr.Body.String())
+}
+
+func TestProcessImageHandler_Async(t *testing.T) {
+	// Setup
+	server := &Server{
+		ImageProcessor: &MockImageProcessor{},
+		JobManager: &MockJobManager{
+			SubmitFunc: func(userId *string, photo []byte, contentType string) (string, error) {
+				assert.Equal(t, "test-user-id", *userId)
+				assert.Equal(t, testPhoto, photo)
+				assert.Equal(t, image_processor.MimeTypePNG, contentType)
+				return "job1", nil
+			},
+		},
+		AuthClient: &MockAuthClient{},
+	}
+
+	body, _ := json.Marshal(ImageData{Base64Image: testImage})
+	req := httptest.NewRequest(http.MethodPost, "/ProcessImage?async=true", bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.processImageHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	assert.Equal(t, "/Jobs/job1", rr.Header().Get("Location"))
+	assert.JSONEq(t, `{"jobId":"job1"}`, rr.Body.String())
+}
+
+func TestProcessImageHandler_AsyncQueueFull(t *testing.T) {
+	// Setup
+	server := &Server{
+		JobManager: &MockJobManager{
+			SubmitFunc: func(userId *string, photo []byte, contentType string) (string, error) {
+				return "", spirit_jobs.ErrQueueFull
+			},
+		},
+		AuthClient: &MockAuthClient{},
+	}
+
+	body, _ := json.Marshal(ImageData{Base64Image: testImage})
+	req := httptest.NewRequest(http.MethodPost, "/ProcessImage?async=true", bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.processImageHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+}
+
+func TestJobHandler_Running(t *testing.T) {
+	// Setup
+	server := &Server{
+		JobManager: &MockJobManager{
+			GetFunc: func(userId *string, jobId string) (spirit_jobs.Job, error) {
+				assert.Equal(t, "job1", jobId)
+				return spirit_jobs.Job{ID: "job1", Stage: spirit_jobs.StageGeneratingImage}, nil
+			},
+		},
+		AuthClient: &MockAuthClient{},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/Jobs/job1", nil)
+	req.SetPathValue("id", "job1")
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.jobHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response JobResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.Equal(t, spirit_jobs.StageGeneratingImage, response.Stage)
+	assert.Nil(t, response.Spirit)
+}
+
+func TestJobHandler_DoneIncludesSpirit(t *testing.T) {
+	// Setup
+	server := &Server{
+		JobManager: &MockJobManager{
+			GetFunc: func(userId *string, jobId string) (spirit_jobs.Job, error) {
+				return spirit_jobs.Job{ID: "job1", Stage: spirit_jobs.StageDone, SpiritID: ptr("spirit1")}, nil
+			},
+		},
+		SpiritManager: &MockSpiritManager{
+			GetFunc: func(userId *string, spiritId string) (models.Spirit, error) {
+				assert.Equal(t, "spirit1", spiritId)
+				return models.Spirit{ID: ptr("spirit1"), Name: ptr("Spirit 1")}, nil
+			},
+		},
+		AuthClient: &MockAuthClient{},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/Jobs/job1", nil)
+	req.SetPathValue("id", "job1")
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.jobHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response JobResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.Equal(t, "Spirit 1", *response.Spirit.Name)
+}
+
+func TestJobHandler_NotFound(t *testing.T) {
+	// Setup
+	server := &Server{
+		JobManager: &MockJobManager{
+			GetFunc: func(userId *string, jobId string) (spirit_jobs.Job, error) {
+				return spirit_jobs.Job{}, spirit_jobs.ErrNotFound
+			},
+		},
+		AuthClient: &MockAuthClient{},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/Jobs/job1", nil)
+	req.SetPathValue("id", "job1")
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.jobHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "Job not found\n", rr.Body.String())
+}
+
+func TestBattlesHandler_Create(t *testing.T) {
+	// Setup
+	server := &Server{
+		BattleManager: &MockBattleManager{
+			CreateFunc: func(userId *string, request battle_manager.CreateRequest) (battle_manager.Battle, error) {
+				assert.Equal(t, "test-user-id", *userId)
+				assert.Equal(t, "opponent", request.PlayerTwoUserID)
+				assert.Equal(t, []string{"spirit1"}, request.PlayerOneTeam.SpiritIDs)
+				return battle_manager.Battle{ID: "battle1", Status: battle_manager.StatusInvited}, nil
+			},
+		},
+		AuthClient: &MockAuthClient{},
+	}
+
+	body := `{"playerOneTeam": {"id": "team1", "spiritIds": ["spirit1"]}, "playerTwoUserId": "opponent"}`
+	req := httptest.NewRequest(http.MethodPost, "/Battles", bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.battlesHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, "/Battles/battle1", rr.Header().Get("Location"))
+	var response battle_manager.Battle
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.Equal(t, "battle1", response.ID)
+}
+
+func TestBattleAcceptHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptErr      error
+		expectedStatus int
+	}{
+		{name: "Accepted", expectedStatus: http.StatusOK},
+		{name: "Invalid team", acceptErr: fmt.Errorf("%w: spirit b3 is not in your collection", battle_manager.ErrInvalidBattle), expectedStatus: http.StatusBadRequest},
+		{name: "Not invited", acceptErr: battle_manager.ErrNotInvited, expectedStatus: http.StatusConflict},
+		{name: "Another user's battle", acceptErr: battle_manager.ErrNotFound, expectedStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Setup
+			server := &Server{
+				BattleManager: &MockBattleManager{
+					AcceptFunc: func(userId *string, battleId string, team battle_manager.TeamRequest) (battle_manager.Battle, error) {
+						assert.Equal(t, "test-user-id", *userId)
+						assert.Equal(t, "battle1", battleId)
+						assert.Equal(t, battle_manager.TeamRequest{ID: "team2", SpiritIDs: []string{"spirit2"}}, team)
+						return battle_manager.Battle{ID: battleId, Status: battle_manager.StatusActive}, tt.acceptErr
+					},
+				},
+				AuthClient: &MockAuthClient{},
+			}
+			req := httptest.NewRequest(http.MethodPost, "/Battles/battle1/Accept", bytes.NewBufferString(`{"team": {"id": "team2", "spiritIds": ["spirit2"]}}`))
+			req.SetPathValue("id", "battle1")
+			req.Header.Set("Authorization", "Bearer test-token")
+			rr := httptest.NewRecorder()
+
+			// Execute
+			handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.battleAcceptHandler))
+			handler.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+		})
+	}
+}
+
+func TestBattleDeclineHandler(t *testing.T) {
+	// Setup
+	server := &Server{
+		BattleManager: &MockBattleManager{
+			DeclineFunc: func(userId *string, battleId string) (battle_manager.Battle, error) {
+				assert.Equal(t, "test-user-id", *userId)
+				return battle_manager.Battle{ID: battleId, Status: battle_manager.StatusDeclined}, nil
+			},
+		},
+		AuthClient: &MockAuthClient{},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/Battles/battle1/Decline", nil)
+	req.SetPathValue("id", "battle1")
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.battleDeclineHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response battle_manager.Battle
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.Equal(t, battle_manager.StatusDeclined, response.Status)
+}
+
+func TestBattlesHandler_List(t *testing.T) {
+	// Setup
+	lastCursor := []interface{}{"2024-01-15T10:30:00.000000Z", "battle2"}
+	server := &Server{
+		BattleManager: &MockBattleManager{
+			ListFunc: func(userId *string, status battle_manager.Status, limit int, startAfter []interface{}) (*battle_manager.BattlePage, error) {
+				assert.Equal(t, battle_manager.StatusActive, status)
+				if startAfter == nil {
+					return &battle_manager.BattlePage{
+						Battles:    []battle_manager.Battle{{ID: "battle1"}, {ID: "battle2"}},
+						LastCursor: lastCursor,
+						HasMore:    true,
+					}, nil
+				}
+				assert.Equal(t, lastCursor, startAfter)
+				return &battle_manager.BattlePage{Battles: []battle_manager.Battle{{ID: "battle3"}}}, nil
+			},
+		},
+		AuthClient: &MockAuthClient{},
+		PageTokens: page_token.NewCodec([]byte("test-secret")),
+	}
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.battlesHandler))
+
+	// Execute first page
+	req := httptest.NewRequest(http.MethodGet, "/Battles?status=active&pageSize=2", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	// Assert first page
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var firstPage BattlePageResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&firstPage))
+	assert.Len(t, firstPage.Battles, 2)
+	assert.True(t, firstPage.HasMore)
+	assert.NotNil(t, firstPage.NextPageToken)
+
+	// Execute second page
+	req = httptest.NewRequest(http.MethodGet, "/Battles?status=active&pageSize=2&pageToken="+*firstPage.NextPageToken, nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	// Assert second page
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var secondPage BattlePageResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&secondPage))
+	assert.Equal(t, "battle3", secondPage.Battles[0].ID)
+	assert.False(t, secondPage.HasMore)
+
+	// A token minted for one status filter must not be accepted for another.
+	req = httptest.NewRequest(http.MethodGet, "/Battles?status=finished&pageToken="+*firstPage.NextPageToken, nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestBattlesHandler_InvalidStatus(t *testing.T) {
+	// Setup
+	server := &Server{
+		BattleManager: &MockBattleManager{},
+		AuthClient:    &MockAuthClient{},
+		PageTokens:    page_token.NewCodec([]byte("test-secret")),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/Battles?status=paused", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.battlesHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "status must be invited, active, finished or declined\n", rr.Body.String())
+}
+
+func TestBattleHandler_Get(t *testing.T) {
+	// Setup
+	server := &Server{
+		BattleManager: &MockBattleManager{
+			GetFunc: func(userId *string, battleId string) (battle_manager.Battle, error) {
+				assert.Equal(t, "test-user-id", *userId)
+				assert.Equal(t, "battle1", battleId)
+				return battle_manager.Battle{ID: "battle1", State: &battle_engine.State{TurnNumber: 3}}, nil
+			},
+		},
+		AuthClient: &MockAuthClient{},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/Battles/battle1", nil)
+	req.SetPathValue("id", "battle1")
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.battleHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response battle_manager.Battle
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.Equal(t, 3, response.State.TurnNumber)
+}
+
+func TestBattleActionsHandler_Submit(t *testing.T) {
+	// Setup
+	server := &Server{
+		BattleManager: &MockBattleManager{
+			SubmitFunc: func(userId *string, battleId string, action battle_engine.Action) (battle_manager.Battle, []battle_engine.Event, error) {
+				assert.Equal(t, "battle1", battleId)
+				assert.Equal(t, battle_engine.ActionMove, action.Type)
+				assert.Equal(t, battle_engine.PlayerOne, action.Player)
+				assert.Equal(t, battle_engine.MiddleLeft, action.Move.TargetSlot)
+				return battle_manager.Battle{ID: "battle1", ActionCount: 1}, []battle_engine.Event{{Type: battle_engine.EventDamage, Damage: 12}}, nil
+			},
+		},
+		AuthClient: &MockAuthClient{},
+	}
+
+	body := `{"type": "Move", "player": "PlayerOne", "move": {"attackerSlot": "Frontline-Center", "targetSlot": "Middle-Left"}}`
+	req := httptest.NewRequest(http.MethodPost, "/Battles/battle1/Actions", bytes.NewBufferString(body))
+	req.SetPathValue("id", "battle1")
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.battleActionsHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response ActionResponse
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.Equal(t, 1, response.Battle.ActionCount)
+	assert.Equal(t, 12, response.Events[0].Damage)
+}
+
+func TestBattleActionsHandler_List(t *testing.T) {
+	// Setup
+	server := &Server{
+		BattleManager: &MockBattleManager{
+			ActionsFunc: func(userId *string, battleId string, after int) ([]battle_manager.LoggedAction, error) {
+				assert.Equal(t, 2, after)
+				return []battle_manager.LoggedAction{{Sequence: 3, UserID: "test-user-id"}}, nil
+			},
+		},
+		AuthClient: &MockAuthClient{},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/Battles/battle1/Actions?after=2", nil)
+	req.SetPathValue("id", "battle1")
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.battleActionsHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response struct {
+		Actions []battle_manager.LoggedAction `json:"actions"`
+	}
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.Equal(t, 3, response.Actions[0].Sequence)
+}
+
+func TestBattleActionsHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		body           string
+		err            error
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "Not found", method: http.MethodGet, err: battle_manager.ErrNotFound, expectedStatus: http.StatusNotFound, expectedBody: "Battle not found\n"},
+		{name: "Not your turn", method: http.MethodPost, body: `{"type": "Rotate", "player": "PlayerTwo"}`, err: battle_engine.ErrNotYourTurn, expectedStatus: http.StatusForbidden, expectedBody: "not your turn\n"},
+		{name: "Invalid action", method: http.MethodPost, body: `{"type": "Move", "player": "PlayerOne"}`, err: fmt.Errorf("%w: move fields are missing", battle_engine.ErrInvalidAction), expectedStatus: http.StatusBadRequest, expectedBody: "invalid action: move fields are missing\n"},
+		{name: "Battle over", method: http.MethodPost, body: `{"type": "Surrender", "player": "PlayerOne"}`, err: battle_engine.ErrBattleOver, expectedStatus: http.StatusConflict, expectedBody: "battle is over\n"},
+		{name: "Conflict", method: http.MethodPost, body: `{"type": "Rotate", "player": "PlayerOne"}`, err: battle_manager.ErrConflict, expectedStatus: http.StatusConflict, expectedBody: battle_manager.ErrConflict.Error() + "\n"},
+		{name: "Unknown slot", method: http.MethodPost, body: `{"type": "Swap", "player": "PlayerOne", "swap": {"swapInSlot": "Sideline"}}`, expectedStatus: http.StatusBadRequest, expectedBody: "Invalid request payload\n"},
+		{name: "Invalid after", method: http.MethodGet, target: "?after=-1", expectedStatus: http.StatusBadRequest, expectedBody: "after must be a non-negative integer\n"},
+		{name: "Internal error", method: http.MethodGet, err: fmt.Errorf("mock error"), expectedStatus: http.StatusInternalServerError, expectedBody: "mock error\n"},
+		{name: "Method not allowed", method: http.MethodDelete, expectedStatus: http.StatusMethodNotAllowed, expectedBody: "Only GET and POST methods are allowed\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Setup
+			server := &Server{
+				BattleManager: &MockBattleManager{
+					SubmitFunc: func(userId *string, battleId string, action battle_engine.Action) (battle_manager.Battle, []battle_engine.Event, error) {
+						return battle_manager.Battle{}, nil, tt.err
+					},
+					ActionsFunc: func(userId *string, battleId string, after int) ([]battle_manager.LoggedAction, error) {
+						return nil, tt.err
+					},
+				},
+				AuthClient: &MockAuthClient{},
+			}
+
+			req := httptest.NewRequest(tt.method, "/Battles/battle1/Actions"+tt.target, bytes.NewBufferString(tt.body))
+			req.SetPathValue("id", "battle1")
+			req.Header.Set("Authorization", "Bearer test-token")
+			rr := httptest.NewRecorder()
+
+			// Execute
+			handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.battleActionsHandler))
+			handler.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedBody, rr.Body.String())
+		})
+	}
+}
+
+// Opens a battle socket on a test server running the handler.
+func dialBattleSocket(t *testing.T, server *Server, query string) (*websocket.Conn, error) {
+	mux := http.NewServeMux()
+	mux.Handle("/Battles/{id}/Socket", middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.battleSocketHandler)))
+	httpServer := httptest.NewServer(mux)
+	t.Cleanup(httpServer.Close)
+
+	config, err := websocket.NewConfig(strings.Replace(httpServer.URL, "http", "ws", 1)+"/Battles/battle1/Socket"+query, httpServer.URL)
+	assert.NoError(t, err)
+	config.Header.Set("Authorization", "Bearer test-token")
+	ws, err := websocket.DialConfig(config)
+	if err == nil {
+		t.Cleanup(func() { ws.Close() })
+		ws.SetDeadline(time.Now().Add(5 * time.Second))
+	}
+	return ws, err
+}
+
+func TestBattleSocketHandler_Resumes(t *testing.T) {
+	// Setup
+	server := &Server{
+		BattleManager: &MockBattleManager{
+			UpdatesFunc: func(userId *string, battleId string, after int) (battle_manager.Battle, []battle_manager.Update, error) {
+				assert.Equal(t, "test-user-id", *userId)
+				assert.Equal(t, "battle1", battleId)
+				assert.Equal(t, 1, after)
+				return battle_manager.Battle{ID: "battle1", ActionCount: 3}, []battle_manager.Update{
+					{LoggedAction: battle_manager.LoggedAction{Sequence: 2}},
+					{LoggedAction: battle_manager.LoggedAction{Sequence: 3}, Events: []battle_engine.Event{{Type: battle_engine.EventDamage, Damage: 7}}},
+				}, nil
+			},
+		},
+		AuthClient: &MockAuthClient{},
+	}
+
+	// Execute
+	ws, err := dialBattleSocket(t, server, "?after=1")
+	assert.NoError(t, err)
+
+	// Assert
+	var messages [3]BattleSocketMessage
+	for i := range messages {
+		assert.NoError(t, websocket.JSON.Receive(ws, &messages[i]))
+	}
+	assert.Equal(t, battleSocketUpdate, messages[0].Type)
+	assert.Equal(t, 2, messages[0].Update.Sequence)
+	assert.Equal(t, 7, messages[1].Update.Events[0].Damage)
+	assert.Equal(t, battleSocketSynced, messages[2].Type)
+	assert.Equal(t, 3, messages[2].Battle.ActionCount)
+}
+
+func TestBattleSocketHandler_PushesActions(t *testing.T) {
+	// Setup
+	changed := make(chan struct{}, 1)
+	unsubscribed := make(chan struct{})
+	var logged []battle_manager.Update
+	server := &Server{
+		BattleManager: &MockBattleManager{
+			SubscribeFunc: func(battleId string) (<-chan struct{}, func()) {
+				return changed, func() { close(unsubscribed) }
+			},
+			UpdatesFunc: func(userId *string, battleId string, after int) (battle_manager.Battle, []battle_manager.Update, error) {
+				battle := battle_manager.Battle{ID: "battle1", Status: battle_manager.StatusActive, ActionCount: len(logged)}
+				if len(logged) > 0 && logged[len(logged)-1].Action.Type == battle_engine.ActionSurrender {
+					battle.Status = battle_manager.StatusFinished
+				}
+				return battle, logged[after:], nil
+			},
+			SubmitFunc: func(userId *string, battleId string, action battle_engine.Action) (battle_manager.Battle, []battle_engine.Event, error) {
+				if action.Type == battle_engine.ActionRotate {
+					return battle_manager.Battle{}, nil, battle_engine.ErrNotYourTurn
+				}
+				logged = append(logged, battle_manager.Update{
+					LoggedAction: battle_manager.LoggedAction{Sequence: len(logged) + 1, UserID: *userId, Action: action},
+					Events:       []battle_engine.Event{{Type: battle_engine.EventSurrender}, {Type: battle_engine.EventWin}},
+				})
+				changed <- struct{}{}
+				return battle_manager.Battle{}, nil, nil
+			},
+		},
+		AuthClient: &MockAuthClient{},
+	}
+	ws, err := dialBattleSocket(t, server, "")
+	assert.NoError(t, err)
+	var synced BattleSocketMessage
+	assert.NoError(t, websocket.JSON.Receive(ws, &synced))
+	assert.Equal(t, battleSocketSynced, synced.Type)
+
+	// Execute out of turn
+	assert.NoError(t, websocket.JSON.Send(ws, BattleSocketRequest{Type: "action", RequestID: "r1", Action: battle_engine.Action{Type: battle_engine.ActionRotate}}))
+
+	// Assert out of turn
+	var rejected BattleSocketMessage
+	assert.NoError(t, websocket.JSON.Receive(ws, &rejected))
+	assert.Equal(t, BattleSocketMessage{Type: battleSocketError, RequestID: "r1", Status: http.StatusForbidden, Error: "not your turn"}, rejected)
+
+	// Execute invalid request
+	assert.NoError(t, websocket.Message.Send(ws, "invalid-json"))
+
+	// Assert invalid request
+	assert.NoError(t, websocket.JSON.Receive(ws, &rejected))
+	assert.Equal(t, http.StatusBadRequest, rejected.Status)
+	assert.Equal(t, "Invalid request payload", rejected.Error)
+
+	// Execute surrender
+	assert.NoError(t, websocket.JSON.Send(ws, BattleSocketRequest{Type: "action", Action: battle_engine.Action{Type: battle_engine.ActionSurrender}}))
+
+	// Assert surrender
+	var update BattleSocketMessage
+	assert.NoError(t, websocket.JSON.Receive(ws, &update))
+	assert.Equal(t, battleSocketUpdate, update.Type)
+	assert.Equal(t, 1, update.Update.Sequence)
+	assert.Equal(t, "test-user-id", update.Update.UserID)
+	assert.Equal(t, battle_engine.EventWin, update.Update.Events[1].Type)
+	// The socket is closed once the battle is over.
+	assert.Error(t, websocket.JSON.Receive(ws, &update))
+	<-unsubscribed
+}
+
+func TestBattleSocketHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name           string
+		target         string
+		err            error
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "Not found", err: battle_manager.ErrNotFound, expectedStatus: http.StatusNotFound, expectedBody: "Battle not found\n"},
+		{name: "Invalid after", target: "?after=x", expectedStatus: http.StatusBadRequest, expectedBody: "after must be a non-negative integer\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Setup
+			server := &Server{
+				BattleManager: &MockBattleManager{
+					UpdatesFunc: func(userId *string, battleId string, after int) (battle_manager.Battle, []battle_manager.Update, error) {
+						return battle_manager.Battle{}, nil, tt.err
+					},
+				},
+				AuthClient: &MockAuthClient{},
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/Battles/battle1/Socket"+tt.target, nil)
+			req.SetPathValue("id", "battle1")
+			req.Header.Set("Authorization", "Bearer test-token")
+			rr := httptest.NewRecorder()
+
+			// Execute
+			handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.battleSocketHandler))
+			handler.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedBody, rr.Body.String())
+		})
+	}
+}
+
+func TestTypesHandler(t *testing.T) {
+	// Setup
+	server := &Server{
+		AuthClient: &MockAuthClient{},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/Types", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.typesHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response spirit_types.Chart
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.Equal(t, spirit_types.NewChart(), response)
+	assert.Equal(t, spirit_types.Sky, response.Types[0].Name)
+	assert.Equal(t, spirit_types.SuperEffective, response.Effectiveness[1][2], "Wave against Flame")
+}
+
+func TestImagenRegionsHandler(t *testing.T) {
+	// Setup
+	server := &Server{
+		ImagenRegions: &MockRegionRouter{
+			SnapshotFunc: func() []image_processor.RegionState {
+				return []image_processor.RegionState{{Region: "us-central1", State: image_processor.CircuitOpen, RateLimited: 1}}
+			},
+		},
+		AuthClient: &MockAuthClient{},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/Debug/ImagenRegions", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+
+	// Execute
+	handler := middleware.AuthMiddleware(server.AuthClient)(http.HandlerFunc(server.imagenRegionsHandler))
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response struct {
+		Regions []image_processor.RegionState `json:"regions"`
+	}
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+	assert.Equal(t, "us-central1", response.Regions[0].Region)
+	assert.Equal(t, image_processor.CircuitOpen, response.Regions[0].State)
+}
+
+func TestNewServer_LocalBackend(t *testing.T) {
+	// Setup
+	ctx := context.Background()
+	config := Config{
+		Backend:            BackendLocal,
+		PublicURL:          "http://localhost:8080",
+		DatabasePath:       filepath.Join(t.TempDir(), "spirit-snap.db"),
+		FilesDir:           t.TempDir(),
+		FileURLSecret:      []byte("file secret"),
+		PageTokenSecret:    []byte("secret"),
+		SpiritDataProvider: "openai",
+		SpiritDataAPIKey:   "key",
+		ImageGenerators:    []string{"flux-schnell"},
+		ReplicateAPIToken:  "token",
+		DuplicateCheck:     image_processor.DefaultDuplicateCheck(),
+	}
+	backend, err := newLocalBackend(config)
+	assert.NoError(t, err)
+	assert.IsType(t, &datastore.SQLiteClient{}, backend.Datastore)
+	assert.IsType(t, &file_storage.LocalClient{}, backend.Storage)
+	s, err := NewServer(ctx, backend, http.DefaultTransport, config)
+	assert.NoError(t, err)
+	defer s.Close()
+	imagePath := "generatedImages/alice/generated.png"
+	assert.NoError(t, backend.Storage.Write(ctx, "spirit-snap.appspot.com", imagePath, testPhoto, "image/png"))
+	spiritId, err := backend.Datastore.AddDocument(ctx, "users/alice/spirits", models.SpiritDocument{
+		SchemaVersion:          models.CurrentSpiritSchemaVersion,
+		Name:                   "Glimmering Griffon",
+		GeneratedImageFilePath: imagePath,
+	})
+	assert.NoError(t, err)
+
+	// Execute
+	token, err := s.AuthClient.VerifyIDToken(ctx, "alice")
+	assert.NoError(t, err)
+	spirit, err := s.SpiritManager.Get(&token.UID, spiritId)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	fileURL, _ := url.Parse(*spirit.GeneratedImageURL)
+	http.StripPrefix("/files", s.Files).ServeHTTP(w, httptest.NewRequest(http.MethodGet, fileURL.RequestURI(), nil))
+
+	// Assert
+	assert.Equal(t, "Glimmering Griffon", *spirit.Name)
+	assert.Equal(t, "http://localhost:8080/files/spirit-snap.appspot.com/"+imagePath, fileURL.Scheme+"://"+fileURL.Host+fileURL.Path)
+	assert.NotEmpty(t, fileURL.Query().Get("signature"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, testPhoto, w.Body.Bytes())
+}
+
+// Changes a configuration to the placeholder images the local backend draws
+// by default.
+func useLocalImageGenerator(config *Config) {
+	config.ImageGenerators = []string{"local"}
+	config.ImagenModel = ""
+	config.ImagenRegions = nil
+}
+
+func TestLoadConfig(t *testing.T) {
+	// Settings that every valid configuration needs.
+	baseEnv := map[string]string{
+		"PAGE_TOKEN_SECRET":         "secret",
+		"FIREBASE_CREDENTIALS_JSON": "{}",
+		"OPENAI_API_KEY":            "key",
+		"GOOGLE_CLOUD_PROJECT_ID":   "project",
+	}
+	baseConfig := Config{
+		Backend:               BackendFirebase,
+		FileURLSecret:         []byte{},
+		PageTokenSecret:       []byte("secret"),
+		SpiritDataProvider:    "openai",
+		SpiritDataAPIKey:      "key",
+		SpiritDataModel:       defaultOpenAIModel,
+		ImageGenerators:       []string{"imagen"},
+		GoogleCredentialsJSON: []byte("{}"),
+		GoogleCloudProjectID:  "project",
+		ImagenModel:           image_processor.DefaultImagenModel,
+		ImagenRegions:         image_processor.DefaultImagenRegions,
+		DuplicateCheck:        image_processor.DefaultDuplicateCheck(),
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		// Defaults to BackendFirebase.
+		backend string
+		modify  func(config *Config)
+		wantErr bool
+	}{
+		{
+			name:   "Defaults to OpenAI and Imagen",
+			modify: func(config *Config) {},
+		},
+		{
+			name:    "Missing credentials",
+			env:     map[string]string{"FIREBASE_CREDENTIALS_JSON": ""},
+			wantErr: true,
+		},
+		{
+			name:    "Local backend does not need credentials",
+			env:     map[string]string{"FIREBASE_CREDENTIALS_JSON": "", "PUBLIC_URL": "http://192.168.1.20:8080/"},
+			backend: BackendLocal,
+			modify: func(config *Config) {
+				config.Backend = BackendLocal
+				config.PublicURL = "http://192.168.1.20:8080"
+				config.GoogleCredentialsJSON = []byte{}
+				useLocalImageGenerator(config)
+			},
+		},
+		{
+			name:    "Local backend does not need a Google Cloud project",
+			env:     map[string]string{"FIREBASE_CREDENTIALS_JSON": "", "GOOGLE_CLOUD_PROJECT_ID": ""},
+			backend: BackendLocal,
+			modify: func(config *Config) {
+				config.Backend = BackendLocal
+				config.GoogleCredentialsJSON = []byte{}
+				config.GoogleCloudProjectID = ""
+				useLocalImageGenerator(config)
+			},
+		},
+		{
+			name:    "Local backend with Imagen",
+			env:     map[string]string{"IMAGE_GENERATOR": "imagen"},
+			backend: BackendLocal,
+			modify: func(config *Config) {
+				config.Backend = BackendLocal
+			},
+		},
+		{
+			name:    "Local backend with a database",
+			env:     map[string]string{"DATABASE_PATH": "/var/lib/spirit-snap/spirit-snap.db"},
+			backend: BackendLocal,
+			modify: func(config *Config) {
+				config.Backend = BackendLocal
+				config.DatabasePath = "/var/lib/spirit-snap/spirit-snap.db"
+				useLocalImageGenerator(config)
+			},
+		},
+		{
+			name:    "Local backend with a files directory",
+			env:     map[string]string{"FILES_DIR": "/var/lib/spirit-snap/files", "FILE_URL_SECRET": "file secret"},
+			backend: BackendLocal,
+			modify: func(config *Config) {
+				config.Backend = BackendLocal
+				config.FilesDir = "/var/lib/spirit-snap/files"
+				config.FileURLSecret = []byte("file secret")
+				useLocalImageGenerator(config)
+			},
+		},
+		{
+			name:    "Unknown backend",
+			backend: "floppy-disk",
+			wantErr: true,
+		},
+		{
+			name:    "OpenAI requires a key",
+			env:     map[string]string{"SPIRIT_DATA_PROVIDER": "openai", "OPENAI_API_KEY": ""},
+			wantErr: true,
+		},
+		{
+			name: "OpenAI compatible server",
+			env: map[string]string{
+				"SPIRIT_DATA_PROVIDER": "openai-compatible",
+				"SPIRIT_DATA_BASE_URL": "http://localhost:11434/v1",
+				"SPIRIT_DATA_MODEL":    "llava",
+			},
+			modify: func(config *Config) {
+				config.SpiritDataProvider = "openai-compatible"
+				config.SpiritDataBaseURL = "http://localhost:11434/v1"
+				config.SpiritDataAPIKey = ""
+				config.SpiritDataModel = "llava"
+			},
+		},
+		{
+			name:    "OpenAI compatible server requires a base URL",
+			env:     map[string]string{"SPIRIT_DATA_PROVIDER": "openai-compatible", "SPIRIT_DATA_MODEL": "llava"},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown spirit data provider",
+			env:     map[string]string{"SPIRIT_DATA_PROVIDER": "carrier-pigeon"},
+			wantErr: true,
+		},
+		{
+			name:    "Imagen requires a project",
+			env:     map[string]string{"GOOGLE_CLOUD_PROJECT_ID": ""},
+			wantErr: true,
+		},
+		{
+			name: "Flux Schnell",
+			env:  map[string]string{"IMAGE_GENERATOR": "flux-schnell", "REPLICATE_API_TOKEN": "token", "GOOGLE_CLOUD_PROJECT_ID": ""},
+			modify: func(config *Config) {
+				config.ImageGenerators = []string{"flux-schnell"}
+				config.ReplicateAPIToken = "token"
+				config.GoogleCloudProjectID = ""
+				config.ImagenModel = ""
+				config.ImagenRegions = nil
+			},
+		},
+		{
+			name: "Fallback chain",
+			env:  map[string]string{"IMAGE_GENERATOR": "imagen, flux-pro,flux-schnell", "REPLICATE_API_TOKEN": "token"},
+			modify: func(config *Config) {
+				config.ImageGenerators = []string{"imagen", "flux-pro", "flux-schnell"}
+				config.ReplicateAPIToken = "token"
+			},
+		},
+		{
+			name:    "Fallback chain requires the settings of every generator",
+			env:     map[string]string{"IMAGE_GENERATOR": "flux-schnell,imagen", "REPLICATE_API_TOKEN": "token", "GOOGLE_CLOUD_PROJECT_ID": ""},
+			wantErr: true,
+		},
+		{
+			name: "Imagen regions",
+			env:  map[string]string{"IMAGEN_REGIONS": "us-central1, europe-west4"},
+			modify: func(config *Config) {
+				config.ImagenRegions = []string{"us-central1", "europe-west4"}
+			},
+		},
+		{
+			name:    "Flux requires a token",
+			env:     map[string]string{"IMAGE_GENERATOR": "flux-pro"},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown image generator",
+			env:     map[string]string{"IMAGE_GENERATOR": "crayons"},
+			wantErr: true,
+		},
+		{
+			name: "Image storage format",
+			env:  map[string]string{"IMAGE_STORAGE_FORMAT": "jpeg"},
+			modify: func(config *Config) {
+				config.ImageStorageFormat = "image/jpeg"
+			},
+		},
+		{
+			name:    "Unsupported image storage format",
+			env:     map[string]string{"IMAGE_STORAGE_FORMAT": "bmp"},
+			wantErr: true,
+		},
+		{
+			name: "Reject duplicate photos",
+			env:  map[string]string{"DUPLICATE_PHOTO_ACTION": "reject", "DUPLICATE_PHOTO_MAX_DISTANCE": "4"},
+			modify: func(config *Config) {
+				config.DuplicateCheck.Action = image_processor.DuplicateActionReject
+				config.DuplicateCheck.MaxDistance = 4
+			},
+		},
+		{
+			name:    "Unknown duplicate photo action",
+			env:     map[string]string{"DUPLICATE_PHOTO_ACTION": "shrug"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid duplicate photo distance",
+			env:     map[string]string{"DUPLICATE_PHOTO_MAX_DISTANCE": "65"},
+			wantErr: true,
+		},
+		{
+			name: "Debug endpoints",
+			env:  map[string]string{"DEBUG_ENDPOINTS": "true"},
+			modify: func(config *Config) {
+				config.DebugEndpoints = true
+			},
+		},
+		{
+			name:    "Invalid debug endpoints setting",
+			env:     map[string]string{"DEBUG_ENDPOINTS": "sometimes"},
+			wantErr: true,
+		},
+	}
+
+	keys := []string{
+		"PAGE_TOKEN_SECRET", "FIREBASE_CREDENTIALS_JSON", "SPIRIT_DATA_PROVIDER", "SPIRIT_DATA_BASE_URL",
+		"SPIRIT_DATA_API_KEY", "SPIRIT_DATA_MODEL", "OPENAI_API_KEY", "IMAGE_GENERATOR",
+		"GOOGLE_CLOUD_PROJECT_ID", "IMAGEN_MODEL", "IMAGEN_REGIONS", "REPLICATE_API_TOKEN",
+		"IMAGE_STORAGE_FORMAT", "DUPLICATE_PHOTO_ACTION", "DUPLICATE_PHOTO_MAX_DISTANCE", "PUBLIC_URL",
+		"DATABASE_PATH", "FILES_DIR", "FILE_URL_SECRET", "DEBUG_ENDPOINTS",
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range keys {
+				value, ok := tt.env[key]
+				if !ok {
+					value = baseEnv[key]
+				}
+				t.Setenv(key, value)
+			}
+
+			backend := tt.backend
+			if backend == "" {
+				backend = BackendFirebase
+			}
+			config, err := loadConfig(backend)
+
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			expected := baseConfig
+			tt.modify(&expected)
+			assert.Equal(t, expected, config)
+		})
+	}
+}
+
 func ptr(s string) *string {
 	return &s
 }
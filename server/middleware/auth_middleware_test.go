@@ -118,3 +118,16 @@ func TestGetAuthenticatedUser(t *testing.T) {
 		})
 	}
 }
+
+func TestLocalAuth(t *testing.T) {
+	token, err := LocalAuth{}.VerifyIDToken(context.Background(), "alice_01")
+	if err != nil || token.UID != "alice_01" {
+		t.Errorf("VerifyIDToken(alice_01) = %v, %v", token, err)
+	}
+
+	for _, invalid := range []string{"", "../bob", "alice/spirits", "a b"} {
+		if _, err := (LocalAuth{}).VerifyIDToken(context.Background(), invalid); err == nil {
+			t.Errorf("VerifyIDToken(%q) accepted an invalid user ID", invalid)
+		}
+	}
+}
@@ -58,11 +58,40 @@ func GetOptionalStringArrayField(doc map[string]interface{}, fieldName string) [
 	return nil
 }
 
-func getImageURL(ctx context.Context, storageClient StorageInterface, doc map[string]interface{}, pathField string) *string {
-	if path, ok := doc[pathField].(string); ok {
-		if url, err := storageClient.GetDownloadURL(ctx, "spirit-snap.appspot.com", path); err == nil {
-			return &url
+// Helper function to safely extract maps of strings from the doc
+func GetOptionalStringMapField(doc map[string]interface{}, fieldName string) map[string]string {
+	value, ok := doc[fieldName]
+	if !ok || value == nil {
+		return nil
+	}
+
+	if m, ok := value.(map[string]interface{}); ok {
+		result := make(map[string]string, len(m))
+		for key, v := range m {
+			if str, ok := v.(string); ok {
+				result[key] = str
+			}
 		}
+		return result
+	}
+
+	if m, ok := value.(map[string]string); ok {
+		return m
+	}
+
+	return nil
+}
+
+func getImageURL(ctx context.Context, storageClient StorageInterface, path string) *string {
+	if path == "" {
+		return nil
+	}
+	return getDownloadURL(ctx, storageClient, path)
+}
+
+func getDownloadURL(ctx context.Context, storageClient StorageInterface, path string) *string {
+	if url, err := storageClient.GetDownloadURL(ctx, "spirit-snap.appspot.com", path); err == nil {
+		return &url
 	}
 	return nil
 }
@@ -1,19 +1,283 @@
 package models
 
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"spirit-snap/server/logic/spirit_types"
+	"spirit-snap/server/wrappers/datastore"
+)
+
+// Move categories. A damaging move's category decides which stats it attacks
+// and defends with.
+const (
+	// Attacks with strength against toughness.
+	MoveCategoryPhysical = "physical"
+	// Attacks with arcana against aura.
+	MoveCategoryArcane = "arcane"
+	// Deals no damage and only has effects.
+	MoveCategoryStatus = "status"
+)
+
+// Move target patterns.
+const (
+	// One opposing spirit in the arena.
+	MoveTargetSingle = "single"
+	// One opposing spirit in the arena and the spirits next to it.
+	MoveTargetAdjacent = "adjacent"
+	// Every opposing spirit in the arena.
+	MoveTargetAllFrontline = "allFrontline"
+	// The spirit using the move.
+	MoveTargetSelf = "self"
+)
+
+// Kinds of move effects.
+const (
+	// Restores Amount percent of the spirit's maximum hit points.
+	MoveEffectHeal = "heal"
+	// Raises Stat by Stages, or lowers it when Stages is negative.
+	MoveEffectStatStage = "statStage"
+	// Inflicts Status.
+	MoveEffectApplyStatus = "applyStatus"
+	// Cures Status, or every status when it is empty.
+	MoveEffectCureStatus = "cureStatus"
+	// Absorbs damage worth Amount percent of the spirit's maximum hit points.
+	MoveEffectBarrier = "barrier"
+)
+
+// Who a move effect applies to.
+const (
+	EffectTargetUser   = "user"
+	EffectTargetTarget = "target"
+)
+
+// Stats that stat stage effects change.
+var MoveEffectStats = []string{"strength", "toughness", "agility", "arcana", "aura", "luck"}
+
+// Statuses moves inflict and cure.
+var MoveStatuses = []string{"burn", "poison", "freeze", "sleep", "confusion", "fear"}
+
+// Stat stages range from -MaxStatStages to MaxStatStages.
+const MaxStatStages = 6
+
+// MoveEffect is something a move does besides dealing damage.
+type MoveEffect struct {
+	Kind   string `json:"kind" firestore:"kind"`
+	Target string `json:"target" firestore:"target"`
+	// Percent chance of the effect happening, from 1 to 100. 0 means it
+	// always happens.
+	Chance int `json:"chance,omitempty" firestore:"chance,omitempty"`
+	// For heal and barrier, a percent of the spirit's maximum hit points.
+	Amount int `json:"amount,omitempty" firestore:"amount,omitempty"`
+	// For statStage.
+	Stat   string `json:"stat,omitempty" firestore:"stat,omitempty"`
+	Stages int    `json:"stages,omitempty" firestore:"stages,omitempty"`
+	// For applyStatus and cureStatus.
+	Status string `json:"status,omitempty" firestore:"status,omitempty"`
+	// For applyStatus and barrier, the number of turns the effect lasts. 0
+	// means until it is cured or broken.
+	Duration int `json:"duration,omitempty" firestore:"duration,omitempty"`
+}
+
+// MoveDocument is the schema of a move as it is stored in the moves
+// collection.
+//
+// Moves written before moves were seeded from the move data file only have a
+// name and a type. Their Category is empty and the rest of their fields are
+// zero.
+//
+// Battles only use Power, Category and Accuracy for now. Priority, Target,
+// Cooldown, Uses and Effects are validated and stored but battles ignore
+// them: every move hits the one spirit it is aimed at, can be used every
+// turn, and status moves cannot be used at all.
+type MoveDocument struct {
+	ID   string `firestore:"-" datastore:"id"`
+	Name string `firestore:"name"`
+	Type string `firestore:"type"`
+
+	// Version of the move data file the move was seeded from.
+	DataVersion int    `firestore:"dataVersion"`
+	Category    string `firestore:"category"`
+	// Base power of damaging moves. Status moves have 0.
+	Power int `firestore:"power"`
+	// Percent chance of the move hitting, from 1 to 100.
+	Accuracy int `firestore:"accuracy"`
+	// Moves with a higher priority go first. Most moves have 0.
+	Priority int    `firestore:"priority"`
+	Target   string `firestore:"target"`
+	// Number of turns before the move can be used again. 0 means every turn.
+	Cooldown int `firestore:"cooldown"`
+	// Number of times the move can be used in a battle. 0 means no limit.
+	Uses    int          `firestore:"uses"`
+	Effects []MoveEffect `firestore:"effects"`
+}
+
+// Defined reports whether the move has the fields seeded from the move data
+// file, as opposed to only a name and a type.
+func (m MoveDocument) Defined() bool {
+	return m.Category != ""
+}
+
+// Validate checks that a move is complete and that its fields are in range.
+// The returned error lists every problem.
+func (m MoveDocument) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if m.Name == "" {
+		fail("name is required")
+	}
+	if !spirit_types.Type(m.Type).Valid() {
+		fail("unknown type %q", m.Type)
+	}
+	switch m.Category {
+	case MoveCategoryPhysical, MoveCategoryArcane:
+		if m.Power < 1 || m.Power > 200 {
+			fail("power must be between 1 and 200, got %d", m.Power)
+		}
+	case MoveCategoryStatus:
+		if m.Power != 0 {
+			fail("status moves have no power, got %d", m.Power)
+		}
+		if len(m.Effects) == 0 {
+			fail("status moves need at least one effect")
+		}
+	default:
+		fail("unknown category %q", m.Category)
+	}
+	if m.Accuracy < 1 || m.Accuracy > 100 {
+		fail("accuracy must be between 1 and 100, got %d", m.Accuracy)
+	}
+	if m.Priority < -3 || m.Priority > 3 {
+		fail("priority must be between -3 and 3, got %d", m.Priority)
+	}
+	switch m.Target {
+	case MoveTargetSingle, MoveTargetAdjacent, MoveTargetAllFrontline:
+	case MoveTargetSelf:
+		if m.Category != MoveCategoryStatus {
+			fail("only status moves can target self")
+		}
+	default:
+		fail("unknown target %q", m.Target)
+	}
+	if m.Cooldown < 0 || m.Uses < 0 {
+		fail("cooldown and uses cannot be negative")
+	}
+	if m.Cooldown > 0 && m.Uses > 0 {
+		fail("moves have a cooldown or limited uses, not both")
+	}
+	for i, effect := range m.Effects {
+		if err := effect.validate(m.Target); err != nil {
+			fail("effect %d: %w", i, err)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("move %q: %w", m.Name, err)
+	}
+	return nil
+}
+
+// Checks an effect of a move with the given target pattern.
+func (e MoveEffect) validate(moveTarget string) error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	switch e.Target {
+	case EffectTargetUser:
+	case EffectTargetTarget:
+		if moveTarget == MoveTargetSelf {
+			fail("moves that target self only have effects on the user")
+		}
+	default:
+		fail("unknown effect target %q", e.Target)
+	}
+	if e.Chance < 0 || e.Chance > 100 {
+		fail("chance must be between 0 and 100, got %d", e.Chance)
+	}
+	if e.Duration < 0 {
+		fail("duration cannot be negative")
+	}
+
+	switch e.Kind {
+	case MoveEffectHeal, MoveEffectBarrier:
+		if e.Amount < 1 || e.Amount > 100 {
+			fail("%s amount must be between 1 and 100, got %d", e.Kind, e.Amount)
+		}
+	case MoveEffectStatStage:
+		if !slices.Contains(MoveEffectStats, e.Stat) {
+			fail("unknown stat %q", e.Stat)
+		}
+		if e.Stages == 0 || e.Stages < -MaxStatStages || e.Stages > MaxStatStages {
+			fail("stages must be between -%d and %d and not 0, got %d", MaxStatStages, MaxStatStages, e.Stages)
+		}
+	case MoveEffectApplyStatus:
+		if !slices.Contains(MoveStatuses, e.Status) {
+			fail("unknown status %q", e.Status)
+		}
+	case MoveEffectCureStatus:
+		if e.Status != "" && !slices.Contains(MoveStatuses, e.Status) {
+			fail("unknown status %q", e.Status)
+		}
+	default:
+		fail("unknown effect kind %q", e.Kind)
+	}
+	return errors.Join(errs...)
+}
+
 type Move struct {
 	ID   *string `json:"id"`
 	Name *string `json:"name"`
 	Type *string `json:"type"`
+
+	// The fields below are null for moves that only have a name and a type.
+	Category *string      `json:"category"`
+	Power    *int         `json:"power"`
+	Accuracy *int         `json:"accuracy"`
+	Priority *int         `json:"priority"`
+	Target   *string      `json:"target"`
+	Cooldown *int         `json:"cooldown"`
+	Uses     *int         `json:"uses"`
+	Effects  []MoveEffect `json:"effects"`
 }
 
-func BuildMovefromDocData(doc map[string]interface{}) *Move {
-	id := GetOptionalStringField(doc, "id")
-	name := GetOptionalStringField(doc, "name")
-	type_ := GetOptionalStringField(doc, "type")
+// DecodeMoveDocument converts a move document returned by the datastore.
+// Fields holding values of the wrong type are logged and left empty.
+func DecodeMoveDocument(doc map[string]interface{}) MoveDocument {
+	var moveDoc MoveDocument
+	if err := datastore.DecodeDocument(doc, &moveDoc); err != nil {
+		log.Printf("Malformed move document %v: %s", doc["id"], err)
+	}
+	return moveDoc
+}
 
-	return &Move{
-		ID:   id,
-		Name: name,
-		Type: type_,
+// BuildMove converts a stored move into the Move returned to clients.
+func BuildMove(doc MoveDocument) *Move {
+	move := &Move{
+		ID:   optionalString(doc.ID),
+		Name: optionalString(doc.Name),
+		Type: optionalString(doc.Type),
+	}
+	if doc.Defined() {
+		move.Category = &doc.Category
+		move.Power = &doc.Power
+		move.Accuracy = &doc.Accuracy
+		move.Priority = &doc.Priority
+		move.Target = &doc.Target
+		move.Cooldown = &doc.Cooldown
+		move.Uses = &doc.Uses
+		move.Effects = doc.Effects
 	}
+	return move
+}
+
+// BuildMovefromDocData is BuildMove for a move document returned by the
+// datastore.
+func BuildMovefromDocData(doc map[string]interface{}) *Move {
+	return BuildMove(DecodeMoveDocument(doc))
 }
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func validMove() MoveDocument {
+	return MoveDocument{
+		Name:     "Tempest Wing",
+		Type:     "Sky",
+		Category: MoveCategoryPhysical,
+		Power:    90,
+		Accuracy: 85,
+		Target:   MoveTargetSingle,
+		Cooldown: 1,
+		Effects: []MoveEffect{
+			{Kind: MoveEffectStatStage, Target: EffectTargetTarget, Stat: "agility", Stages: -1, Chance: 30},
+		},
+	}
+}
+
+func TestMoveDocument_Validate(t *testing.T) {
+	assert.NoError(t, validMove().Validate())
+
+	heal := MoveDocument{
+		Name: "Windborne Prayer", Type: "Sky", Category: MoveCategoryStatus, Accuracy: 100, Target: MoveTargetSelf,
+		Effects: []MoveEffect{{Kind: MoveEffectHeal, Target: EffectTargetUser, Amount: 40}, {Kind: MoveEffectCureStatus, Target: EffectTargetUser}},
+	}
+	assert.NoError(t, heal.Validate())
+
+	tests := []struct {
+		name     string
+		change   func(move *MoveDocument)
+		expected string
+	}{
+		{name: "Missing name", change: func(move *MoveDocument) { move.Name = "" }, expected: "name is required"},
+		{name: "Old type name", change: func(move *MoveDocument) { move.Type = "Weave" }, expected: `unknown type "Weave"`},
+		{name: "None type", change: func(move *MoveDocument) { move.Type = "None" }, expected: `unknown type "None"`},
+		{name: "Missing category", change: func(move *MoveDocument) { move.Category = "" }, expected: `unknown category ""`},
+		{name: "No power", change: func(move *MoveDocument) { move.Power = 0 }, expected: "power must be between 1 and 200"},
+		{name: "Status move with power", change: func(move *MoveDocument) { move.Category = MoveCategoryStatus }, expected: "status moves have no power"},
+		{name: "Accuracy over 100", change: func(move *MoveDocument) { move.Accuracy = 120 }, expected: "accuracy must be between 1 and 100"},
+		{name: "Priority out of range", change: func(move *MoveDocument) { move.Priority = 5 }, expected: "priority must be between -3 and 3"},
+		{name: "Unknown target", change: func(move *MoveDocument) { move.Target = "everyone" }, expected: `unknown target "everyone"`},
+		{name: "Damaging move targeting self", change: func(move *MoveDocument) { move.Target = MoveTargetSelf }, expected: "only status moves can target self"},
+		{name: "Cooldown and uses", change: func(move *MoveDocument) { move.Uses = 2 }, expected: "not both"},
+		{name: "Unknown effect", change: func(move *MoveDocument) { move.Effects[0].Kind = "drain" }, expected: `effect 0: unknown effect kind "drain"`},
+		{name: "Unknown stat", change: func(move *MoveDocument) { move.Effects[0].Stat = "speed" }, expected: `unknown stat "speed"`},
+		{name: "No stages", change: func(move *MoveDocument) { move.Effects[0].Stages = 0 }, expected: "stages must be between -6 and 6 and not 0"},
+		{name: "Chance over 100", change: func(move *MoveDocument) { move.Effects[0].Chance = 150 }, expected: "chance must be between 0 and 100"},
+		{name: "Heal without amount", change: func(move *MoveDocument) { move.Effects[0] = MoveEffect{Kind: MoveEffectHeal, Target: EffectTargetUser} }, expected: "heal amount must be between 1 and 100"},
+		{name: "Unknown status", change: func(move *MoveDocument) {
+			move.Effects[0] = MoveEffect{Kind: MoveEffectApplyStatus, Target: EffectTargetTarget, Status: "stun"}
+		}, expected: `unknown status "stun"`},
+		{name: "Unknown effect target", change: func(move *MoveDocument) { move.Effects[0].Target = "ally" }, expected: `unknown effect target "ally"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			move := validMove()
+			move.Effects = append([]MoveEffect(nil), move.Effects...)
+			tt.change(&move)
+
+			err := move.Validate()
+
+			assert.ErrorContains(t, err, tt.expected)
+		})
+	}
+
+	heal.Effects = append(heal.Effects, MoveEffect{Kind: MoveEffectBarrier, Target: EffectTargetTarget, Amount: 20})
+	assert.ErrorContains(t, heal.Validate(), "moves that target self only have effects on the user")
+}
+
+func TestBuildMovefromDocData(t *testing.T) {
+	// A move stored before the move data file, with a legacy type name.
+	legacy := BuildMovefromDocData(map[string]interface{}{"id": "move_1", "name": "Tailwind", "type": "Ritual"})
+
+	assert.Equal(t, &Move{ID: ptr("move_1"), Name: ptr("Tailwind"), Type: ptr("Ritual")}, legacy)
+
+	defined := BuildMovefromDocData(map[string]interface{}{
+		"id": "move_2", "name": "Tempest Wing", "type": "Sky", "dataVersion": int64(1),
+		"category": "physical", "power": int64(90), "accuracy": int64(85), "priority": int64(0),
+		"target": "single", "cooldown": int64(1), "uses": int64(0),
+		"effects": []interface{}{
+			map[string]interface{}{"kind": "statStage", "target": "target", "stat": "agility", "stages": int64(-1), "chance": int64(30)},
+		},
+	})
+
+	assert.Equal(t, &Move{
+		ID: ptr("move_2"), Name: ptr("Tempest Wing"), Type: ptr("Sky"),
+		Category: ptr("physical"), Power: ptr(90), Accuracy: ptr(85), Priority: ptr(0),
+		Target: ptr("single"), Cooldown: ptr(1), Uses: ptr(0),
+		Effects: []MoveEffect{{Kind: "statStage", Target: "target", Stat: "agility", Stages: -1, Chance: 30}},
+	}, defined)
+}
@@ -13,7 +13,17 @@ type Spirit struct {
 	SecondaryType     *string `json:"secondaryType"`
 	OriginalImageURL  *string `json:"originalImageDownloadUrl"`
 	GeneratedImageURL *string `json:"generatedImageDownloadUrl"`
-	Moves             []*Move `json:"moves"`
+	// Download URLs of resized copies of the generated image, keyed by the
+	// length of their longest side in pixels, e.g. "128". Not set for spirits
+	// created before renditions existed.
+	GeneratedImageRenditionURLs map[string]string `json:"generatedImageRenditionDownloadUrls,omitempty"`
+	// A tiny JPEG data URL of the generated image to show, blurred, while it
+	// loads.
+	GeneratedImagePlaceholder *string `json:"generatedImagePlaceholder,omitempty"`
+	Moves                     []*Move `json:"moves"`
+	// ID of the user's earlier spirit whose photo this spirit's photo
+	// duplicates. Only set when duplicates are flagged rather than rejected.
+	DuplicateOfSpiritID *string `json:"duplicateOfSpiritId,omitempty"`
 
 	Agility      *int `json:"agility"`
 	Arcana       *int `json:"arcana"`
@@ -51,60 +61,8 @@ func getMoves(ctx context.Context, datastoreClient DatastoreInterface, moveIds [
 	return moves
 }
 
+// BuildSpiritfromDocData is BuildSpirit for a spirit document returned by the
+// datastore.
 func BuildSpiritfromDocData(ctx context.Context, storageClient StorageInterface, doc map[string]interface{}, datastoreClient DatastoreInterface) Spirit {
-	id := GetOptionalStringField(doc, "id")
-	name := GetOptionalStringField(doc, "name")
-	description := GetOptionalStringField(doc, "description")
-	primaryType := GetOptionalStringField(doc, "primaryType")
-	secondaryType := GetOptionalStringField(doc, "secondaryType")
-
-	var originalUrl, generatedUrl *string
-	originalUrl = getImageURL(ctx, storageClient, doc, "originalImageFilePath")
-	generatedUrl = getImageURL(ctx, storageClient, doc, "generatedImageFilePath")
-
-	moveIds := GetOptionalStringArrayField(doc, "moveIds")
-	moves := []*Move{}
-	if moveIds != nil {
-		moves = getMoves(ctx, datastoreClient, moveIds)
-	} else {
-		moves = nil
-	}
-
-	// Extract numeric fields and set default values if not present
-	agility := GetOptionalIntField(doc, "agility")
-	arcana := GetOptionalIntField(doc, "arcana")
-	aura := GetOptionalIntField(doc, "aura")
-	charisma := GetOptionalIntField(doc, "charisma")
-	endurance := GetOptionalIntField(doc, "endurance")
-	height := GetOptionalIntField(doc, "height")
-	weight := GetOptionalIntField(doc, "weight")
-	intimidation := GetOptionalIntField(doc, "intimidation")
-	luck := GetOptionalIntField(doc, "luck")
-	strength := GetOptionalIntField(doc, "strength")
-	toughness := GetOptionalIntField(doc, "toughness")
-	hitPoints := GetOptionalIntField(doc, "hitPoints")
-
-	return Spirit{
-		ID:                id,
-		Name:              name,
-		Description:       description,
-		PrimaryType:       primaryType,
-		SecondaryType:     secondaryType,
-		OriginalImageURL:  originalUrl,
-		GeneratedImageURL: generatedUrl,
-		Moves:             moves,
-
-		Agility:      agility,
-		Arcana:       arcana,
-		Aura:         aura,
-		Charisma:     charisma,
-		Endurance:    endurance,
-		Height:       height,
-		Weight:       weight,
-		Intimidation: intimidation,
-		Luck:         luck,
-		Strength:     strength,
-		Toughness:    toughness,
-		HitPoints:    hitPoints,
-	}
+	return BuildSpirit(ctx, storageClient, DecodeSpiritDocument(doc), datastoreClient)
 }
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"context"
+	"log"
+	"spirit-snap/server/wrappers/datastore"
+)
+
+// SpiritDocument is the schema of a spirit as it is stored in the
+// users/{userId}/spirits collection. It is the only place the document's
+// field names are spelled out; everything that reads or writes spirit
+// documents goes through it.
+type SpiritDocument struct {
+	ID string `firestore:"-" datastore:"id"`
+	// Version of the schema the document was written with. Older documents are
+	// upgraded by the spirit migrations when they are read.
+	SchemaVersion int `firestore:"schemaVersion"`
+
+	Name                  string `firestore:"name"`
+	Description           string `firestore:"description"`
+	ImageGenerationPrompt string `firestore:"imageGenerationPrompt"`
+	PhotoObject           string `firestore:"photoObject"`
+	PrimaryType           string `firestore:"primaryType"`
+	SecondaryType         string `firestore:"secondaryType"`
+
+	// Stats are nil in documents that are missing them.
+	Height       *int `firestore:"height"`
+	Weight       *int `firestore:"weight"`
+	Strength     *int `firestore:"strength"`
+	Toughness    *int `firestore:"toughness"`
+	Agility      *int `firestore:"agility"`
+	Arcana       *int `firestore:"arcana"`
+	Aura         *int `firestore:"aura"`
+	Charisma     *int `firestore:"charisma"`
+	Intimidation *int `firestore:"intimidation"`
+	Endurance    *int `firestore:"endurance"`
+	Luck         *int `firestore:"luck"`
+	HitPoints    *int `firestore:"hitPoints"`
+
+	MoveIDs []string `firestore:"moveIds"`
+
+	// When the photo was processed, as an RFC 3339 UTC timestamp. Collections
+	// are listed newest first by this field.
+	ImageTimestamp string `firestore:"imageTimestamp"`
+	// Storage paths in the spirit-snap.appspot.com bucket.
+	OriginalImageFilePath  string `firestore:"originalImageFilePath,omitempty"`
+	GeneratedImageFilePath string `firestore:"generatedImageFilePath,omitempty"`
+	// Storage paths of the resized copies of the generated image, keyed by the
+	// length of their longest side.
+	GeneratedImageRenditionFilePaths map[string]string `firestore:"generatedImageRenditionFilePaths,omitempty"`
+	// A tiny JPEG data URL of the generated image.
+	GeneratedImagePlaceholder string `firestore:"generatedImagePlaceholder,omitempty"`
+	// Name of the service that generated the image, e.g. "imagen".
+	ImageProvider string `firestore:"imageProvider,omitempty"`
+
+	// Perceptual hash of the photo, as 16 hex digits.
+	PhotoHash string `firestore:"photoHash,omitempty"`
+	// ID of the user's earlier spirit whose photo this one duplicates.
+	DuplicateOfSpiritID string `firestore:"duplicateOfSpiritId,omitempty"`
+}
+
+// DecodeSpiritDocument converts a spirit document returned by the datastore,
+// first upgrading documents written with an older schema version. Fields
+// holding values of the wrong type are logged and left empty, so a single
+// malformed field does not hide the rest of the spirit.
+func DecodeSpiritDocument(doc map[string]interface{}) SpiritDocument {
+	if changes, err := UpgradeSpiritDocument(doc); err != nil {
+		log.Printf("Failed to upgrade spirit document %v: %s", doc["id"], err)
+	} else if len(changes) > 0 {
+		upgraded := make(map[string]interface{}, len(doc)+len(changes))
+		for field, value := range doc {
+			upgraded[field] = value
+		}
+		for field, value := range changes {
+			upgraded[field] = value
+		}
+		doc = upgraded
+	}
+
+	var spiritDoc SpiritDocument
+	if err := datastore.DecodeDocument(doc, &spiritDoc); err != nil {
+		log.Printf("Malformed spirit document %v: %s", doc["id"], err)
+	}
+	return spiritDoc
+}
+
+// BuildSpirit converts a stored spirit into the Spirit returned to clients,
+// looking up its moves and the download URLs of its images. Images that are
+// not stored yet are left out.
+func BuildSpirit(ctx context.Context, storageClient StorageInterface, doc SpiritDocument, datastoreClient DatastoreInterface) Spirit {
+	var moves []*Move
+	if doc.MoveIDs != nil {
+		moves = getMoves(ctx, datastoreClient, doc.MoveIDs)
+	}
+
+	var renditionUrls map[string]string
+	for size, path := range doc.GeneratedImageRenditionFilePaths {
+		if url := getDownloadURL(ctx, storageClient, path); url != nil {
+			if renditionUrls == nil {
+				renditionUrls = make(map[string]string)
+			}
+			renditionUrls[size] = *url
+		}
+	}
+
+	return Spirit{
+		ID:                optionalString(doc.ID),
+		Name:              optionalString(doc.Name),
+		Description:       optionalString(doc.Description),
+		PrimaryType:       optionalString(doc.PrimaryType),
+		SecondaryType:     optionalString(doc.SecondaryType),
+		OriginalImageURL:  getImageURL(ctx, storageClient, doc.OriginalImageFilePath),
+		GeneratedImageURL: getImageURL(ctx, storageClient, doc.GeneratedImageFilePath),
+		Moves:             moves,
+
+		GeneratedImageRenditionURLs: renditionUrls,
+		GeneratedImagePlaceholder:   optionalString(doc.GeneratedImagePlaceholder),
+		DuplicateOfSpiritID:         optionalString(doc.DuplicateOfSpiritID),
+
+		Agility:      doc.Agility,
+		Arcana:       doc.Arcana,
+		Aura:         doc.Aura,
+		Charisma:     doc.Charisma,
+		Endurance:    doc.Endurance,
+		Height:       doc.Height,
+		Weight:       doc.Weight,
+		Intimidation: doc.Intimidation,
+		Luck:         doc.Luck,
+		Strength:     doc.Strength,
+		Toughness:    doc.Toughness,
+		HitPoints:    doc.HitPoints,
+	}
+}
+
+// Empty strings are stored for fields that were never set, and are returned
+// to clients as null.
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
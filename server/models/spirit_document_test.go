@@ -0,0 +1,179 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"spirit-snap/server/wrappers/datastore"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockStorageClient struct{}
+
+// Returns a URL for every path except those starting with "missing/".
+func (m *mockStorageClient) GetDownloadURL(ctx context.Context, bucketName, objectName string) (string, error) {
+	if strings.HasPrefix(objectName, "missing/") {
+		return "", errors.New("object not found")
+	}
+	return "https://storage/" + objectName, nil
+}
+
+type mockDatastoreClient struct {
+	requestedIds []string
+}
+
+func (m *mockDatastoreClient) GetDocumentsByIds(ctx context.Context, collectionName string, ids []string) ([]map[string]interface{}, error) {
+	m.requestedIds = ids
+	var docs []map[string]interface{}
+	for _, id := range ids {
+		docs = append(docs, map[string]interface{}{"id": id, "name": "Move " + id, "type": "Sky"})
+	}
+	return docs, nil
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// A spirit document as the Firestore client returns it.
+var storedSpirit = map[string]interface{}{
+	"id":                     "spirit_1",
+	"schemaVersion":          int64(CurrentSpiritSchemaVersion),
+	"name":                   "Glimmering Griffon",
+	"description":            "A majestic griffon.",
+	"imageGenerationPrompt":  "A griffon with golden feathers.",
+	"photoObject":            "Bird",
+	"primaryType":            "Sky",
+	"secondaryType":          "None",
+	"height":                 int64(120),
+	"weight":                 int64(80),
+	"strength":               int64(70),
+	"toughness":              int64(60),
+	"agility":                int64(90),
+	"arcana":                 int64(50),
+	"aura":                   int64(40),
+	"charisma":               int64(30),
+	"intimidation":           int64(20),
+	"endurance":              int64(10),
+	"luck":                   int64(5),
+	"hitPoints":              int64(100),
+	"moveIds":                []interface{}{"move_1", "move_2"},
+	"imageTimestamp":         "2024-01-15T10:30:00Z",
+	"originalImageFilePath":  "photos/user/original.jpeg",
+	"generatedImageFilePath": "generatedImages/user/generated.png",
+	"generatedImageRenditionFilePaths": map[string]interface{}{
+		"128": "generatedImages/user/generated-128.jpeg",
+		"256": "missing/generated-256.jpeg",
+	},
+	"generatedImagePlaceholder": "data:image/jpeg;base64,abc",
+	"imageProvider":             "imagen",
+	"photoHash":                 "fedcba9876543210",
+	"duplicateOfSpiritId":       "spirit_0",
+}
+
+func TestDecodeSpiritDocument(t *testing.T) {
+	doc := DecodeSpiritDocument(storedSpirit)
+
+	assert.Equal(t, SpiritDocument{
+		ID:                     "spirit_1",
+		SchemaVersion:          CurrentSpiritSchemaVersion,
+		Name:                   "Glimmering Griffon",
+		Description:            "A majestic griffon.",
+		ImageGenerationPrompt:  "A griffon with golden feathers.",
+		PhotoObject:            "Bird",
+		PrimaryType:            "Sky",
+		SecondaryType:          "None",
+		Height:                 ptr(120),
+		Weight:                 ptr(80),
+		Strength:               ptr(70),
+		Toughness:              ptr(60),
+		Agility:                ptr(90),
+		Arcana:                 ptr(50),
+		Aura:                   ptr(40),
+		Charisma:               ptr(30),
+		Intimidation:           ptr(20),
+		Endurance:              ptr(10),
+		Luck:                   ptr(5),
+		HitPoints:              ptr(100),
+		MoveIDs:                []string{"move_1", "move_2"},
+		ImageTimestamp:         "2024-01-15T10:30:00Z",
+		OriginalImageFilePath:  "photos/user/original.jpeg",
+		GeneratedImageFilePath: "generatedImages/user/generated.png",
+		GeneratedImageRenditionFilePaths: map[string]string{
+			"128": "generatedImages/user/generated-128.jpeg",
+			"256": "missing/generated-256.jpeg",
+		},
+		GeneratedImagePlaceholder: "data:image/jpeg;base64,abc",
+		ImageProvider:             "imagen",
+		PhotoHash:                 "fedcba9876543210",
+		DuplicateOfSpiritID:       "spirit_0",
+	}, doc)
+}
+
+func TestSpiritDocument_EncodesEveryStoredField(t *testing.T) {
+	encoded, err := datastore.EncodeDocument(DecodeSpiritDocument(storedSpirit))
+
+	assert.NoError(t, err)
+	// Every field of the stored document survives a round trip, so no field
+	// name is misspelled in the schema.
+	for field := range storedSpirit {
+		if field != "id" {
+			assert.Contains(t, encoded, field)
+		}
+	}
+	assert.NotContains(t, encoded, "id")
+}
+
+func TestDecodeSpiritDocument_Malformed(t *testing.T) {
+	doc := DecodeSpiritDocument(map[string]interface{}{
+		"id":       "spirit_1",
+		"name":     "Glimmering Griffon",
+		"strength": "very",
+	})
+
+	assert.Equal(t, "Glimmering Griffon", doc.Name)
+	assert.Nil(t, doc.Strength)
+}
+
+func TestBuildSpirit(t *testing.T) {
+	datastoreClient := &mockDatastoreClient{}
+
+	spirit := BuildSpirit(context.Background(), &mockStorageClient{}, DecodeSpiritDocument(storedSpirit), datastoreClient)
+
+	assert.Equal(t, "spirit_1", *spirit.ID)
+	assert.Equal(t, "Glimmering Griffon", *spirit.Name)
+	assert.Equal(t, "A majestic griffon.", *spirit.Description)
+	assert.Equal(t, "Sky", *spirit.PrimaryType)
+	assert.Equal(t, "None", *spirit.SecondaryType)
+	assert.Equal(t, "https://storage/photos/user/original.jpeg", *spirit.OriginalImageURL)
+	assert.Equal(t, "https://storage/generatedImages/user/generated.png", *spirit.GeneratedImageURL)
+	// Renditions whose URL cannot be created are left out.
+	assert.Equal(t, map[string]string{"128": "https://storage/generatedImages/user/generated-128.jpeg"}, spirit.GeneratedImageRenditionURLs)
+	assert.Equal(t, "data:image/jpeg;base64,abc", *spirit.GeneratedImagePlaceholder)
+	assert.Equal(t, "spirit_0", *spirit.DuplicateOfSpiritID)
+	assert.Equal(t, []string{"move_1", "move_2"}, datastoreClient.requestedIds)
+	assert.Len(t, spirit.Moves, 2)
+	assert.Equal(t, "Move move_1", *spirit.Moves[0].Name)
+	assert.Equal(t, 70, *spirit.Strength)
+	assert.Equal(t, 100, *spirit.HitPoints)
+}
+
+func TestBuildSpirit_PartialDocument(t *testing.T) {
+	// A spirit that is still being created has no ID, moves or images.
+	doc := SpiritDocument{Name: "Glimmering Griffon", Strength: ptr(70)}
+
+	spirit := BuildSpirit(context.Background(), &mockStorageClient{}, doc, &mockDatastoreClient{})
+
+	assert.Nil(t, spirit.ID)
+	assert.Nil(t, spirit.Description)
+	assert.Nil(t, spirit.OriginalImageURL)
+	assert.Nil(t, spirit.GeneratedImageURL)
+	assert.Nil(t, spirit.GeneratedImageRenditionURLs)
+	assert.Nil(t, spirit.GeneratedImagePlaceholder)
+	assert.Nil(t, spirit.DuplicateOfSpiritID)
+	assert.Nil(t, spirit.Moves)
+	assert.Nil(t, spirit.Agility)
+	assert.Equal(t, 70, *spirit.Strength)
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+)
+
+// CurrentSpiritSchemaVersion is the schema version of the spirit documents
+// written by this server. Documents written before versioning was introduced
+// have no schemaVersion field and are version 0.
+const CurrentSpiritSchemaVersion = 1
+
+// SpiritMigration upgrades a spirit document from Version-1 to Version.
+//
+// Upgrade changes the raw document in place. Migrations may add and change
+// fields but must not remove them, because upgrades are written back as
+// updates of the changed fields. They only rewrite fields whose old form is
+// known; a field that is missing is left missing rather than given a made up
+// value.
+type SpiritMigration struct {
+	Version     int
+	Description string
+	Upgrade     func(doc map[string]interface{}) error
+}
+
+var spiritMigrations []SpiritMigration
+
+// RegisterSpiritMigration adds a migration to the end of the chain. Migrations
+// must be registered in order, one version at a time.
+func RegisterSpiritMigration(migration SpiritMigration) {
+	want := len(spiritMigrations) + 1
+	if migration.Version != want {
+		panic(fmt.Sprintf("spirit migration %d registered out of order, expected version %d", migration.Version, want))
+	}
+	spiritMigrations = append(spiritMigrations, migration)
+}
+
+// SpiritMigrations returns the registered migrations, oldest first.
+func SpiritMigrations() []SpiritMigration {
+	return slices.Clone(spiritMigrations)
+}
+
+func init() {
+	RegisterSpiritMigration(SpiritMigration{
+		Version:     1,
+		Description: "Store the secondary type of spirits that have none as None",
+		Upgrade:     fillMissingSecondaryType,
+	})
+	if len(spiritMigrations) != CurrentSpiritSchemaVersion {
+		panic("CurrentSpiritSchemaVersion does not match the registered spirit migrations")
+	}
+}
+
+// SpiritSchemaVersion returns the schema version of a raw spirit document.
+func SpiritSchemaVersion(doc map[string]interface{}) (int, error) {
+	value, ok := doc["schemaVersion"]
+	if !ok || value == nil {
+		return 0, nil
+	}
+	var version int
+	switch v := value.(type) {
+	case int:
+		version = v
+	case int64:
+		version = int(v)
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, fmt.Errorf("schemaVersion %v is not an integer", v)
+		}
+		version = int(v)
+	default:
+		return 0, fmt.Errorf("schemaVersion has type %T", value)
+	}
+	if version < 0 || version > CurrentSpiritSchemaVersion {
+		return 0, fmt.Errorf("unknown schemaVersion %d", version)
+	}
+	return version, nil
+}
+
+// UpgradeSpiritDocument runs the migrations a raw spirit document is missing
+// and returns the fields that changed, including schemaVersion. The returned
+// map is empty when the document is already current. doc itself is not
+// modified.
+func UpgradeSpiritDocument(doc map[string]interface{}) (map[string]interface{}, error) {
+	version, err := SpiritSchemaVersion(doc)
+	if err != nil {
+		return nil, err
+	}
+	changes := make(map[string]interface{})
+	if version == CurrentSpiritSchemaVersion {
+		return changes, nil
+	}
+
+	upgraded := make(map[string]interface{}, len(doc))
+	for field, value := range doc {
+		upgraded[field] = value
+	}
+	for _, migration := range spiritMigrations[version:] {
+		if err := migration.Upgrade(upgraded); err != nil {
+			return nil, fmt.Errorf("spirit migration %d: %w", migration.Version, err)
+		}
+	}
+	upgraded["schemaVersion"] = int64(CurrentSpiritSchemaVersion)
+
+	for field, value := range upgraded {
+		if original, ok := doc[field]; !ok || !reflect.DeepEqual(original, value) {
+			changes[field] = value
+		}
+	}
+	return changes, nil
+}
+
+// Spirits created by the first prompts have no secondary type, which the
+// server now stores as "None".
+func fillMissingSecondaryType(doc map[string]interface{}) error {
+	if secondaryType, _ := doc["secondaryType"].(string); secondaryType == "" {
+		doc["secondaryType"] = "None"
+	}
+	return nil
+}
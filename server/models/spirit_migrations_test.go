@@ -0,0 +1,108 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpiritMigrations_Ordered(t *testing.T) {
+	migrations := SpiritMigrations()
+
+	assert.Len(t, migrations, CurrentSpiritSchemaVersion)
+	for i, migration := range migrations {
+		assert.Equal(t, i+1, migration.Version)
+		assert.NotEmpty(t, migration.Description)
+	}
+}
+
+func TestRegisterSpiritMigration_OutOfOrder(t *testing.T) {
+	assert.Panics(t, func() {
+		RegisterSpiritMigration(SpiritMigration{Version: CurrentSpiritSchemaVersion + 2})
+	})
+	assert.Panics(t, func() {
+		RegisterSpiritMigration(SpiritMigration{Version: 1})
+	})
+	assert.Len(t, SpiritMigrations(), CurrentSpiritSchemaVersion)
+}
+
+func TestSpiritSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    int
+		wantErr bool
+	}{
+		{name: "missing", value: nil, want: 0},
+		{name: "int64", value: int64(1), want: 1},
+		{name: "whole float", value: float64(1), want: 1},
+		{name: "fractional float", value: 1.5, wantErr: true},
+		{name: "string", value: "2", wantErr: true},
+		{name: "newer than the server", value: int64(CurrentSpiritSchemaVersion + 1), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, err := SpiritSchemaVersion(map[string]interface{}{"schemaVersion": tt.value})
+
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, version)
+		})
+	}
+}
+
+func TestUpgradeSpiritDocument_Unversioned(t *testing.T) {
+	// A spirit created by the first prompts.
+	doc := map[string]interface{}{
+		"id":          "spirit_1",
+		"name":        "Glimmering Griffon",
+		"primaryType": "Sky",
+		"strength":    int64(70),
+		"agility":     72.6,
+		"moveIds":     []interface{}{"move_1"},
+	}
+
+	changes, err := UpgradeSpiritDocument(doc)
+
+	// Missing and malformed stats are left as they are.
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"schemaVersion": int64(CurrentSpiritSchemaVersion),
+		"secondaryType": "None",
+	}, changes)
+	// The document itself is left as it was read.
+	assert.NotContains(t, doc, "secondaryType")
+	assert.NotContains(t, doc, "schemaVersion")
+}
+
+func TestUpgradeSpiritDocument_Current(t *testing.T) {
+	changes, err := UpgradeSpiritDocument(storedSpirit)
+
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestUpgradeSpiritDocument_UnknownVersion(t *testing.T) {
+	_, err := UpgradeSpiritDocument(map[string]interface{}{"schemaVersion": int64(CurrentSpiritSchemaVersion + 1)})
+
+	assert.ErrorContains(t, err, "unknown schemaVersion")
+}
+
+func TestDecodeSpiritDocument_UpgradesOldDocuments(t *testing.T) {
+	doc := DecodeSpiritDocument(map[string]interface{}{
+		"id":       "spirit_1",
+		"name":     "Glimmering Griffon",
+		"strength": 70.4,
+	})
+
+	assert.Equal(t, CurrentSpiritSchemaVersion, doc.SchemaVersion)
+	assert.Equal(t, "None", doc.SecondaryType)
+	// Stats are not made up: missing ones stay missing, and the fractional
+	// strength cannot be decoded.
+	assert.Nil(t, doc.Strength)
+	assert.Nil(t, doc.Luck)
+	assert.Nil(t, doc.HitPoints)
+}
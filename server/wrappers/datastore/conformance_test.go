@@ -0,0 +1,366 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/stretchr/testify/assert"
+)
+
+// conformanceClient is the API that every datastore backend implements.
+type conformanceClient interface {
+	AddDocument(ctx context.Context, collectionName string, data interface{}) (string, error)
+	CreateDocument(ctx context.Context, collectionName string, id string, data interface{}) error
+	GetCollection(ctx context.Context, collectionName string, limit int, sortField string, sortDirection Direction, startAfter []interface{}) (*PageResult, error)
+	RunQuery(ctx context.Context, query Query) (*PageResult, error)
+	GetDocument(ctx context.Context, collectionName string, id string) (map[string]interface{}, error)
+	GetDocumentsByIds(ctx context.Context, collectionName string, ids []string) ([]map[string]interface{}, error)
+	GetDocumentsFilteredByValue(ctx context.Context, collectionName string, fieldName string, value any) ([]map[string]interface{}, error)
+	UpdateDocument(ctx context.Context, collectionName string, id string, updates map[string]interface{}) error
+	UpdateDocumentIf(ctx context.Context, collectionName string, id string, field string, expected interface{}, updates map[string]interface{}) error
+	DeleteDocument(ctx context.Context, collectionName string, id string) error
+	ListDocumentIDs(ctx context.Context, collectionName string) ([]string, error)
+	Close() error
+}
+
+var (
+	_ conformanceClient = (*Client)(nil)
+	_ conformanceClient = (*MemoryClient)(nil)
+	_ conformanceClient = (*SQLiteClient)(nil)
+)
+
+func TestMemoryClient_Conformance(t *testing.T) {
+	runConformanceTests(t, func(t *testing.T) conformanceClient {
+		return NewMemoryClient()
+	})
+}
+
+func TestSQLiteClient_Conformance(t *testing.T) {
+	runConformanceTests(t, func(t *testing.T) conformanceClient {
+		client, err := NewSQLiteClient(filepath.Join(t.TempDir(), "spirit-snap.db"))
+		assert.NoError(t, err)
+		return client
+	})
+}
+
+// Runs against the Firestore emulator, when FIRESTORE_EMULATOR_HOST is set:
+//
+//	gcloud emulators firestore start --host-port=localhost:8081
+//	FIRESTORE_EMULATOR_HOST=localhost:8081 go test ./wrappers/datastore/
+func TestClient_Conformance(t *testing.T) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+	}
+	runConformanceTests(t, func(t *testing.T) conformanceClient {
+		fsClient, err := firestore.NewClient(context.Background(), "spirit-snap-conformance")
+		assert.NoError(t, err)
+		return &Client{fsClient: fsClient}
+	})
+}
+
+// Tests that a backend behaves like Firestore. Every test uses collections of
+// its own, so backends that keep data between clients can share one database.
+func runConformanceTests(t *testing.T, newClient func(t *testing.T) conformanceClient) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, client conformanceClient, root string)
+	}{
+		{"AddAndGetDocument", testAddAndGetDocument},
+		{"ReadsAreCopies", testReadsAreCopies},
+		{"CreateDocument", testCreateDocument},
+		{"UpdateAndDeleteDocument", testUpdateAndDeleteDocument},
+		{"UpdateDocumentIf", testUpdateDocumentIf},
+		{"GetDocumentsByIds", testGetDocumentsByIds},
+		{"GetDocumentsFilteredByValue", testGetDocumentsFilteredByValue},
+		{"GetCollection", testGetCollection},
+		{"RunQuery", testRunQuery},
+		{"RunQueryPages", testRunQueryPages},
+		{"RunQuerySelect", testRunQuerySelect},
+		{"RunQueryInvalid", testRunQueryInvalid},
+		{"NestedCollections", testNestedCollections},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newClient(t)
+			defer client.Close()
+			id, err := newDocumentID()
+			assert.NoError(t, err)
+			tt.test(t, client, "conformance-"+id)
+		})
+	}
+}
+
+// Adds spirits with the given names and strengths and returns their IDs keyed
+// by name.
+func addSpirits(t *testing.T, client conformanceClient, collection string, spirits map[string]int) map[string]string {
+	ids := make(map[string]string)
+	for name, strength := range spirits {
+		id, err := client.AddDocument(context.Background(), collection, map[string]interface{}{
+			"name":     name,
+			"strength": strength,
+			"moveIds":  []string{"move_" + name},
+		})
+		assert.NoError(t, err)
+		ids[name] = id
+	}
+	return ids
+}
+
+func documentNames(docs []map[string]interface{}) []string {
+	var names []string
+	for _, doc := range docs {
+		names = append(names, doc["name"].(string))
+	}
+	return names
+}
+
+func testAddAndGetDocument(t *testing.T, client conformanceClient, root string) {
+	ctx := context.Background()
+	caught := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+	id, err := client.AddDocument(ctx, root, testDocument{
+		ID:       "ignored",
+		Name:     "Glimmering Griffon",
+		Level:    12,
+		Rating:   4.5,
+		Shiny:    true,
+		Caught:   caught,
+		MoveIDs:  []string{"move_1"},
+		Stats:    testStats{Strength: 70},
+		Untagged: "kept",
+		Ignored:  "dropped",
+	})
+	assert.NoError(t, err)
+	assert.Len(t, id, 20)
+
+	doc, err := client.GetDocument(ctx, root, id)
+
+	// Documents are stored with the types Firestore returns.
+	assert.NoError(t, err)
+	assert.Equal(t, id, doc["id"])
+	assert.Equal(t, "Glimmering Griffon", doc["name"])
+	assert.Equal(t, int64(12), doc["level"])
+	assert.Equal(t, 4.5, doc["rating"])
+	assert.Equal(t, true, doc["shiny"])
+	assert.True(t, caught.Equal(doc["caught"].(time.Time)))
+	assert.Equal(t, []interface{}{"move_1"}, doc["moveIds"])
+	assert.Equal(t, map[string]interface{}{"strength": int64(70)}, doc["stats"])
+	assert.Nil(t, doc["trainer"])
+	assert.Contains(t, doc, "trainer")
+	assert.Equal(t, "kept", doc["Untagged"])
+	assert.NotContains(t, doc, "nickname")
+	assert.NotContains(t, doc, "Ignored")
+
+	_, err = client.GetDocument(ctx, root, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func testReadsAreCopies(t *testing.T, client conformanceClient, root string) {
+	ctx := context.Background()
+	moveIds := []interface{}{"move_1"}
+	id, err := client.AddDocument(ctx, root, map[string]interface{}{"moveIds": moveIds})
+	assert.NoError(t, err)
+	moveIds[0] = "changed"
+
+	doc, _ := client.GetDocument(ctx, root, id)
+	doc["moveIds"].([]interface{})[0] = "changed"
+	doc["name"] = "changed"
+
+	doc, _ = client.GetDocument(ctx, root, id)
+	assert.Equal(t, []interface{}{"move_1"}, doc["moveIds"])
+	assert.NotContains(t, doc, "name")
+}
+
+func testCreateDocument(t *testing.T, client conformanceClient, root string) {
+	ctx := context.Background()
+
+	err := client.CreateDocument(ctx, root, "000001", map[string]interface{}{"name": "First", "strength": 1})
+
+	assert.NoError(t, err)
+	doc, err := client.GetDocument(ctx, root, "000001")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": "000001", "name": "First", "strength": int64(1)}, doc)
+
+	assert.ErrorIs(t, client.CreateDocument(ctx, root, "000001", map[string]interface{}{"name": "Second"}), ErrAlreadyExists)
+	doc, _ = client.GetDocument(ctx, root, "000001")
+	assert.Equal(t, "First", doc["name"])
+}
+
+func testUpdateAndDeleteDocument(t *testing.T, client conformanceClient, root string) {
+	ctx := context.Background()
+	id, err := client.AddDocument(ctx, root, map[string]interface{}{"name": "Old", "strength": 1})
+	assert.NoError(t, err)
+
+	err = client.UpdateDocument(ctx, root, id, map[string]interface{}{"name": "New", "paths.128": "a.jpeg"})
+
+	assert.NoError(t, err)
+	doc, _ := client.GetDocument(ctx, root, id)
+	assert.Equal(t, "New", doc["name"])
+	assert.Equal(t, int64(1), doc["strength"])
+	assert.Equal(t, map[string]interface{}{"128": "a.jpeg"}, doc["paths"])
+
+	assert.ErrorIs(t, client.UpdateDocument(ctx, root, "missing", map[string]interface{}{"name": "New"}), ErrNotFound)
+	assert.NoError(t, client.DeleteDocument(ctx, root, id))
+	assert.NoError(t, client.DeleteDocument(ctx, root, id))
+	_, err = client.GetDocument(ctx, root, id)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func testUpdateDocumentIf(t *testing.T, client conformanceClient, root string) {
+	ctx := context.Background()
+	id, err := client.AddDocument(ctx, root, map[string]interface{}{"name": "Job", "attempts": 1})
+	assert.NoError(t, err)
+
+	err = client.UpdateDocumentIf(ctx, root, id, "attempts", 1, map[string]interface{}{"attempts": 2, "stage": "queued"})
+
+	assert.NoError(t, err)
+	doc, _ := client.GetDocument(ctx, root, id)
+	assert.Equal(t, map[string]interface{}{"id": id, "name": "Job", "attempts": int64(2), "stage": "queued"}, doc)
+
+	// A second writer that read the same document loses.
+	err = client.UpdateDocumentIf(ctx, root, id, "attempts", int64(1), map[string]interface{}{"attempts": 2, "stage": "other"})
+	assert.ErrorIs(t, err, ErrConditionFailed)
+	doc, _ = client.GetDocument(ctx, root, id)
+	assert.Equal(t, "queued", doc["stage"])
+
+	assert.NoError(t, client.UpdateDocumentIf(ctx, root, id, "missing", nil, map[string]interface{}{"missing": "set"}))
+	assert.ErrorIs(t, client.UpdateDocumentIf(ctx, root, id, "missing", nil, map[string]interface{}{"missing": "again"}), ErrConditionFailed)
+	assert.ErrorIs(t, client.UpdateDocumentIf(ctx, root, "missing", "attempts", 1, map[string]interface{}{"attempts": 2}), ErrNotFound)
+}
+
+func testGetDocumentsByIds(t *testing.T, client conformanceClient, root string) {
+	ctx := context.Background()
+	ids := addSpirits(t, client, root, map[string]int{"a": 10, "b": 20, "c": 20})
+
+	docs, err := client.GetDocumentsByIds(ctx, root, []string{ids["c"], ids["a"]})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c", "a"}, documentNames(docs))
+	assert.Equal(t, ids["c"], docs[0]["id"])
+
+	_, err = client.GetDocumentsByIds(ctx, root, []string{ids["a"], "missing"})
+	assert.Error(t, err)
+}
+
+func testGetDocumentsFilteredByValue(t *testing.T, client conformanceClient, root string) {
+	ctx := context.Background()
+	addSpirits(t, client, root, map[string]int{"a": 10, "b": 20, "c": 20})
+
+	docs, err := client.GetDocumentsFilteredByValue(ctx, root, "strength", 20)
+
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"b", "c"}, documentNames(docs))
+
+	docs, err = client.GetDocumentsFilteredByValue(ctx, root, "name", "z")
+	assert.NoError(t, err)
+	assert.Empty(t, docs)
+}
+
+func testGetCollection(t *testing.T, client conformanceClient, root string) {
+	ctx := context.Background()
+	addSpirits(t, client, root, map[string]int{"a": 10, "b": 20, "c": 30})
+
+	first, err := client.GetCollection(ctx, root, 2, "strength", Desc, nil)
+	assert.NoError(t, err)
+	second, err := client.GetCollection(ctx, root, 2, "strength", Desc, first.LastCursor)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"c", "b"}, documentNames(first.Documents))
+	assert.Equal(t, []interface{}{int64(20)}, first.LastCursor)
+	assert.True(t, first.HasMore)
+	assert.Equal(t, []string{"a"}, documentNames(second.Documents))
+	assert.False(t, second.HasMore)
+}
+
+func testRunQuery(t *testing.T, client conformanceClient, root string) {
+	ctx := context.Background()
+	addSpirits(t, client, root, map[string]int{"a": 10, "b": 20, "c": 30, "d": 40, "e": 50})
+	// A document without the ordered field is left out of ordered queries.
+	client.AddDocument(ctx, root, map[string]interface{}{"name": "no strength"})
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{name: "Order descending", query: NewQuery(root).OrderBy("strength", Desc).Limit(10), want: []string{"e", "d", "c", "b", "a"}},
+		{name: "Range", query: NewQuery(root).Where("strength", GreaterThan, 10).Where("strength", LessThanOrEqual, 40.0).OrderBy("strength", Asc).Limit(10), want: []string{"b", "c", "d"}},
+		{name: "Not equal", query: NewQuery(root).Where("strength", NotEqual, 30).OrderBy("strength", Asc).Limit(10), want: []string{"a", "b", "d", "e"}},
+		{name: "In", query: NewQuery(root).Where("name", In, []string{"a", "e"}).OrderBy("name", Asc).Limit(10), want: []string{"a", "e"}},
+		{name: "Array contains", query: NewQuery(root).Where("moveIds", ArrayContains, "move_b").OrderBy("name", Asc).Limit(10), want: []string{"b"}},
+		{name: "Array contains any", query: NewQuery(root).Where("moveIds", ArrayContainsAny, []string{"move_c", "move_d"}).OrderBy("name", Asc).Limit(10), want: []string{"c", "d"}},
+		{name: "Start after", query: NewQuery(root).OrderBy("strength", Asc).Limit(2).StartAfter(20), want: []string{"c", "d"}},
+		{name: "Range filters skip other types", query: NewQuery(root).Where("name", GreaterThan, 0).OrderBy("name", Asc).Limit(10), want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := client.RunQuery(ctx, tt.query)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, documentNames(result.Documents))
+		})
+	}
+}
+
+func testRunQueryPages(t *testing.T, client conformanceClient, root string) {
+	ctx := context.Background()
+	// Ties on strength are broken by document ID.
+	addSpirits(t, client, root, map[string]int{"a": 10, "b": 10, "c": 10, "d": 20, "e": 20})
+	query := NewQuery(root).OrderBy("strength", Desc).OrderBy(DocumentID, Desc).Limit(2)
+
+	var names []string
+	for pages := 1; pages <= 5; pages++ {
+		result, err := client.RunQuery(ctx, query)
+		assert.NoError(t, err)
+		names = append(names, documentNames(result.Documents)...)
+		if !result.HasMore {
+			assert.Equal(t, 3, pages)
+			break
+		}
+		query = query.StartAfter(result.LastCursor...)
+	}
+	assert.ElementsMatch(t, []string{"a", "b", "c", "d", "e"}, names)
+	assert.ElementsMatch(t, []string{"d", "e"}, names[:2])
+}
+
+func testRunQuerySelect(t *testing.T, client conformanceClient, root string) {
+	ctx := context.Background()
+	ids := addSpirits(t, client, root, map[string]int{"a": 10})
+
+	result, err := client.RunQuery(ctx, NewQuery(root).Select("strength").Limit(10))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{{"id": ids["a"], "strength": int64(10)}}, result.Documents)
+}
+
+func testRunQueryInvalid(t *testing.T, client conformanceClient, root string) {
+	_, err := client.RunQuery(context.Background(), NewQuery(root))
+
+	assert.Error(t, err)
+}
+
+func testNestedCollections(t *testing.T, client conformanceClient, root string) {
+	ctx := context.Background()
+	// A parent document that exists, and one that only holds a subcollection.
+	userId, err := client.AddDocument(ctx, root, map[string]interface{}{"name": "with a document"})
+	assert.NoError(t, err)
+	addSpirits(t, client, fmt.Sprintf("%s/%s/spirits", root, userId), map[string]int{"a": 10})
+	addSpirits(t, client, root+"/u2/spirits", map[string]int{"b": 10, "c": 20})
+
+	ids, err := client.ListDocumentIDs(ctx, root)
+	assert.NoError(t, err)
+	spirits, err := client.GetCollection(ctx, root+"/u2/spirits", 10, "strength", Asc, nil)
+	assert.NoError(t, err)
+	parent, err := client.GetCollection(ctx, root, 10, "name", Asc, nil)
+	assert.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{userId, "u2"}, ids)
+	assert.Equal(t, []string{"b", "c"}, documentNames(spirits.Documents))
+	// Subcollections are not part of their parent collection.
+	assert.Equal(t, []string{"with a document"}, documentNames(parent.Documents))
+}
@@ -3,13 +3,27 @@ package datastore
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"cloud.google.com/go/firestore"
 	firebase "firebase.google.com/go"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// ErrNotFound is returned when a requested document does not exist.
+var ErrNotFound = errors.New("document not found")
+
+// ErrAlreadyExists is returned when creating a document with the ID of an
+// existing document.
+var ErrAlreadyExists = errors.New("document already exists")
+
+// ErrConditionFailed is returned by UpdateDocumentIf when the document no
+// longer holds the expected value.
+var ErrConditionFailed = errors.New("document does not hold the expected value")
+
 // Direction is the sort direction for result ordering.
 type Direction int32
 
@@ -23,6 +37,7 @@ const (
 
 type firestoreClientInterface interface {
 	Collection(collectionPath string) *firestore.CollectionRef
+	RunTransaction(ctx context.Context, f func(context.Context, *firestore.Transaction) error, opts ...firestore.TransactionOption) error
 	Close() error
 }
 
@@ -59,6 +74,30 @@ func (r *Client) AddDocument(ctx context.Context, collectionName string, data in
 	return docRef.ID, err
 }
 
+// CreateDocument adds a document with the given ID, unless the collection
+// already has a document with that ID. Writers racing to create the same
+// document can rely on exactly one of them succeeding.
+//
+// Parameters:
+//   - ctx: The context for the client operations.
+//   - collectionName: The name of the collection to add the document to.
+//   - id: The ID of the new document.
+//   - data: The document data to be added.
+//
+// Returns:
+//   - ErrAlreadyExists if the document exists, or another error if the
+//     operation fails.
+func (r *Client) CreateDocument(ctx context.Context, collectionName string, id string, data interface{}) error {
+	_, err := r.fsClient.Collection(collectionName).Doc(id).Create(ctx, data)
+	if status.Code(err) == codes.AlreadyExists {
+		return ErrAlreadyExists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create document with ID %s: %w", id, err)
+	}
+	return nil
+}
+
 // PageResult represents a page of documents with cursor information
 type PageResult struct {
 	Documents  []map[string]interface{}
@@ -80,49 +119,15 @@ type PageResult struct {
 //   - A PageResult containing the retrieved documents, the last cursor value, and a flag indicating if there are more pages.
 //   - An error if the operation fails.
 func (r *Client) GetCollection(ctx context.Context, collectionName string, limit int, sortField string, sortDirection Direction, startAfter []interface{}) (*PageResult, error) {
-	query := r.fsClient.Collection(collectionName).
-		OrderBy(sortField, firestore.Direction(sortDirection)).
-		Limit(limit + 1) // Fetch one extra to determine if there are more pages
+	query := NewQuery(collectionName).
+		OrderBy(sortField, sortDirection).
+		Limit(limit)
 
 	if len(startAfter) > 0 {
 		query = query.StartAfter(startAfter...)
 	}
 
-	iter := query.Documents(ctx)
-	var docs []map[string]interface{}
-	var lastDoc *firestore.DocumentSnapshot
-
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("error fetching documents: %v", err)
-		}
-
-		lastDoc = doc
-		docData := doc.Data()
-		docData["id"] = doc.Ref.ID
-		docs = append(docs, docData)
-	}
-
-	hasMore := len(docs) > limit
-	if hasMore {
-		docs = docs[:limit] // Remove the extra document we fetched
-	}
-
-	// Get the cursor values for the last document based on the sort field
-	var lastCursor []interface{}
-	if lastDoc != nil {
-		lastCursor = []interface{}{lastDoc.Data()[sortField]}
-	}
-
-	return &PageResult{
-		Documents:  docs,
-		LastCursor: lastCursor,
-		HasMore:    hasMore,
-	}, nil
+	return r.RunQuery(ctx, query)
 }
 
 // Close closes the Client client connection.
@@ -177,3 +182,147 @@ func (r *Client) GetDocumentsFilteredByValue(ctx context.Context, collectionName
 
 	return results, nil
 }
+
+// GetDocument retrieves a single document by ID.
+//
+// Parameters:
+//   - ctx: The context for the client operations.
+//   - collectionName: The name of the collection holding the document.
+//   - id: The ID of the document.
+//
+// Returns:
+//   - The document data, with its ID stored under "id".
+//   - ErrNotFound if the document does not exist, or another error if the
+//     operation fails.
+func (r *Client) GetDocument(ctx context.Context, collectionName string, id string) (map[string]interface{}, error) {
+	doc, err := r.fsClient.Collection(collectionName).Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve document with ID %s: %w", id, err)
+	}
+
+	docData := doc.Data()
+	docData["id"] = doc.Ref.ID
+	return docData, nil
+}
+
+// UpdateDocument sets the given fields on an existing document. Fields that
+// are not in updates are left unchanged.
+//
+// Parameters:
+//   - ctx: The context for the client operations.
+//   - collectionName: The name of the collection holding the document.
+//   - id: The ID of the document.
+//   - updates: The fields to set, keyed by field name.
+//
+// Returns:
+//   - ErrNotFound if the document does not exist, or another error if the
+//     operation fails.
+func (r *Client) UpdateDocument(ctx context.Context, collectionName string, id string, updates map[string]interface{}) error {
+	var fsUpdates []firestore.Update
+	for field, value := range updates {
+		fsUpdates = append(fsUpdates, firestore.Update{Path: field, Value: value})
+	}
+
+	_, err := r.fsClient.Collection(collectionName).Doc(id).Update(ctx, fsUpdates)
+	if status.Code(err) == codes.NotFound {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update document with ID %s: %w", id, err)
+	}
+	return nil
+}
+
+// UpdateDocumentIf sets the given fields on an existing document, like
+// UpdateDocument, but only if one of its top level fields holds an expected
+// value. The check and the update happen in a transaction, so of several
+// writers expecting the same value only one succeeds. Numbers compare by
+// value, and a missing field holds nil.
+//
+// Parameters:
+//   - ctx: The context for the client operations.
+//   - collectionName: The name of the collection holding the document.
+//   - id: The ID of the document.
+//   - field: The field to check.
+//   - expected: The value the field must hold.
+//   - updates: The fields to set, keyed by field name.
+//
+// Returns:
+//   - ErrNotFound if the document does not exist, ErrConditionFailed if the
+//     field holds another value, or another error if the operation fails.
+func (r *Client) UpdateDocumentIf(ctx context.Context, collectionName string, id string, field string, expected interface{}, updates map[string]interface{}) error {
+	var fsUpdates []firestore.Update
+	for path, value := range updates {
+		fsUpdates = append(fsUpdates, firestore.Update{Path: path, Value: value})
+	}
+
+	ref := r.fsClient.Collection(collectionName).Doc(id)
+	err := r.fsClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snapshot, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		holds, err := valueEquals(snapshot.Data()[field], expected)
+		if err != nil {
+			return err
+		}
+		if !holds {
+			return ErrConditionFailed
+		}
+		return tx.Update(ref, fsUpdates)
+	})
+	if status.Code(err) == codes.NotFound {
+		return ErrNotFound
+	}
+	if err != nil && !errors.Is(err, ErrConditionFailed) {
+		return fmt.Errorf("failed to update document with ID %s: %w", id, err)
+	}
+	return err
+}
+
+// DeleteDocument deletes a document. Deleting a document that does not exist
+// is not an error.
+//
+// Parameters:
+//   - ctx: The context for the client operations.
+//   - collectionName: The name of the collection holding the document.
+//   - id: The ID of the document.
+//
+// Returns:
+//   - An error if the operation fails, otherwise nil.
+func (r *Client) DeleteDocument(ctx context.Context, collectionName string, id string) error {
+	if _, err := r.fsClient.Collection(collectionName).Doc(id).Delete(ctx); err != nil {
+		return fmt.Errorf("failed to delete document with ID %s: %w", id, err)
+	}
+	return nil
+}
+
+// ListDocumentIDs returns the IDs of every document in a collection, including
+// documents that do not exist themselves but have subcollections, such as
+// users/{userId} documents that only hold a spirits collection.
+//
+// Parameters:
+//   - ctx: The context for the client operations.
+//   - collectionName: The name of the collection to list.
+//
+// Returns:
+//   - The document IDs, in no particular order.
+//   - An error if the operation fails.
+func (r *Client) ListDocumentIDs(ctx context.Context, collectionName string) ([]string, error) {
+	iter := r.fsClient.Collection(collectionName).DocumentRefs(ctx)
+	var ids []string
+	for {
+		ref, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error listing documents: %v", err)
+		}
+		ids = append(ids, ref.ID)
+	}
+	return ids, nil
+}
@@ -0,0 +1,318 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Typed documents are structs whose fields are tagged with the name of the
+// document field they are stored in, the same way the Firestore client reads
+// and writes structs:
+//
+//	type SpiritDocument struct {
+//		ID          string `firestore:"-" datastore:"id"`
+//		Name        string `firestore:"name"`
+//		PhotoHash   string `firestore:"photoHash,omitempty"`
+//	}
+//
+// Structs can be passed to AddDocument as they are. The maps returned by the
+// read methods are turned back into structs with DecodeDocument. Fields
+// without a tag use the Go field name and fields tagged "-" are never stored.
+// The document ID, which the read methods return under "id", is decoded into
+// the string field tagged `datastore:"id"`.
+
+// DecodeDocument copies the fields of doc into the struct that dst points to.
+// Document fields that the struct does not have are ignored and struct fields
+// missing from the document are left unchanged.
+//
+// Numbers are converted between integer and floating point types, so
+// documents written by other clients decode as well. A field whose value has
+// the wrong type is skipped and the others are still decoded; the returned
+// error lists every skipped field.
+func DecodeDocument(doc map[string]interface{}, dst interface{}) error {
+	value := reflect.ValueOf(dst)
+	if value.Kind() != reflect.Pointer || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("DecodeDocument needs a pointer to a struct, got %T", dst)
+	}
+	var errs []error
+	decodeStruct(value.Elem(), doc, "", &errs, true)
+	return errors.Join(errs...)
+}
+
+// EncodeDocument returns the fields of a typed document as a map, the same
+// way the Firestore client stores the struct. Integers become int64 and
+// floating point numbers become float64. Nil pointers, slices and maps become
+// nil. Empty fields tagged omitempty are left out.
+func EncodeDocument(src interface{}) (map[string]interface{}, error) {
+	value := reflect.ValueOf(src)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil, fmt.Errorf("EncodeDocument got a nil %T", src)
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("EncodeDocument needs a struct, got %T", src)
+	}
+	encoded, err := encodeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return encoded.(map[string]interface{}), nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Parses the firestore tag of a struct field. Returns an empty name for
+// fields that are not stored.
+func fieldName(field reflect.StructField) (name string, omitEmpty bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	tag := field.Tag.Get("firestore")
+	if tag == "-" {
+		return "", false
+	}
+	name, options, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name, strings.Contains(options, "omitempty")
+}
+
+func decodeStruct(dst reflect.Value, doc map[string]interface{}, path string, errs *[]error, topLevel bool) {
+	dstType := dst.Type()
+	for i := 0; i < dstType.NumField(); i++ {
+		field := dstType.Field(i)
+		if topLevel && field.Tag.Get("datastore") == "id" {
+			if id, ok := doc["id"].(string); ok && field.Type.Kind() == reflect.String {
+				dst.Field(i).SetString(id)
+			}
+			continue
+		}
+		name, _ := fieldName(field)
+		if name == "" {
+			continue
+		}
+		src, ok := doc[name]
+		if !ok {
+			continue
+		}
+		if err := decodeValue(dst.Field(i), src, path+name, errs); err != nil {
+			*errs = append(*errs, err)
+		}
+	}
+}
+
+func decodeValue(dst reflect.Value, src interface{}, path string, errs *[]error) error {
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	mismatch := fmt.Errorf("field %s: cannot decode %T into %s", path, src, dst.Type())
+
+	if dst.Type() == timeType {
+		t, ok := src.(time.Time)
+		if !ok {
+			return mismatch
+		}
+		dst.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch dst.Kind() {
+	case reflect.Pointer:
+		elem := reflect.New(dst.Type().Elem())
+		if err := decodeValue(elem.Elem(), src, path, errs); err != nil {
+			return err
+		}
+		dst.Set(elem)
+	case reflect.Interface:
+		dst.Set(reflect.ValueOf(src))
+	case reflect.String:
+		s, ok := src.(string)
+		if !ok {
+			return mismatch
+		}
+		dst.SetString(s)
+	case reflect.Bool:
+		b, ok := src.(bool)
+		if !ok {
+			return mismatch
+		}
+		dst.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := toInt(src)
+		if !ok || dst.OverflowInt(n) {
+			return mismatch
+		}
+		dst.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := toInt(src)
+		if !ok || n < 0 || dst.OverflowUint(uint64(n)) {
+			return mismatch
+		}
+		dst.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		n, ok := toFloat(src)
+		if !ok {
+			return mismatch
+		}
+		dst.SetFloat(n)
+	case reflect.Slice:
+		srcValue := reflect.ValueOf(src)
+		if srcValue.Kind() != reflect.Slice {
+			return mismatch
+		}
+		slice := reflect.MakeSlice(dst.Type(), srcValue.Len(), srcValue.Len())
+		for i := 0; i < srcValue.Len(); i++ {
+			if err := decodeValue(slice.Index(i), srcValue.Index(i).Interface(), fmt.Sprintf("%s[%d]", path, i), errs); err != nil {
+				return err
+			}
+		}
+		dst.Set(slice)
+	case reflect.Map:
+		srcValue := reflect.ValueOf(src)
+		if srcValue.Kind() != reflect.Map || srcValue.Type().Key().Kind() != reflect.String || dst.Type().Key().Kind() != reflect.String {
+			return mismatch
+		}
+		m := reflect.MakeMapWithSize(dst.Type(), srcValue.Len())
+		iter := srcValue.MapRange()
+		for iter.Next() {
+			elem := reflect.New(dst.Type().Elem()).Elem()
+			if err := decodeValue(elem, iter.Value().Interface(), path+"."+iter.Key().String(), errs); err != nil {
+				return err
+			}
+			m.SetMapIndex(iter.Key().Convert(dst.Type().Key()), elem)
+		}
+		dst.Set(m)
+	case reflect.Struct:
+		m, ok := src.(map[string]interface{})
+		if !ok {
+			return mismatch
+		}
+		decodeStruct(dst, m, path+".", errs, false)
+	default:
+		return mismatch
+	}
+	return nil
+}
+
+// Accepts floating point numbers without a fractional part, which is how
+// JSON based clients often write integers.
+func toInt(src interface{}) (int64, bool) {
+	switch n := src.(type) {
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case float32, float64:
+		f, _ := toFloat(n)
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(f), true
+	}
+	return 0, false
+}
+
+func toFloat(src interface{}) (float64, bool) {
+	switch n := src.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func encodeValue(value reflect.Value) (interface{}, error) {
+	if value.Type() == timeType {
+		return value.Interface(), nil
+	}
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if value.IsNil() {
+			return nil, nil
+		}
+		return encodeValue(value.Elem())
+	case reflect.String:
+		return value.String(), nil
+	case reflect.Bool:
+		return value.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return int64(value.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), nil
+	case reflect.Slice, reflect.Array:
+		if value.Kind() == reflect.Slice && value.IsNil() {
+			return nil, nil
+		}
+		list := make([]interface{}, value.Len())
+		for i := range list {
+			elem, err := encodeValue(value.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			list[i] = elem
+		}
+		return list, nil
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("cannot encode %s, map keys must be strings", value.Type())
+		}
+		if value.IsNil() {
+			return nil, nil
+		}
+		m := make(map[string]interface{}, value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			elem, err := encodeValue(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			m[iter.Key().String()] = elem
+		}
+		return m, nil
+	case reflect.Struct:
+		m := make(map[string]interface{})
+		for i := 0; i < value.NumField(); i++ {
+			name, omitEmpty := fieldName(value.Type().Field(i))
+			if name == "" {
+				continue
+			}
+			field := value.Field(i)
+			if omitEmpty && isEmpty(field) {
+				continue
+			}
+			encoded, err := encodeValue(field)
+			if err != nil {
+				return nil, err
+			}
+			m[name] = encoded
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("cannot encode %s", value.Type())
+}
+
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return value.Len() == 0
+	}
+	return value.IsZero()
+}
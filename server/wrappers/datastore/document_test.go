@@ -0,0 +1,148 @@
+package datastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testStats struct {
+	Strength int `firestore:"strength"`
+}
+
+type testDocument struct {
+	ID         string            `firestore:"-" datastore:"id"`
+	Name       string            `firestore:"name"`
+	Nickname   string            `firestore:"nickname,omitempty"`
+	Level      int               `firestore:"level"`
+	Rating     float64           `firestore:"rating"`
+	Shiny      bool              `firestore:"shiny"`
+	Caught     time.Time         `firestore:"caught"`
+	MoveIDs    []string          `firestore:"moveIds"`
+	Paths      map[string]string `firestore:"paths,omitempty"`
+	Stats      testStats         `firestore:"stats"`
+	Trainer    *string           `firestore:"trainer"`
+	Untagged   string
+	Ignored    string `firestore:"-"`
+	unexported string
+}
+
+func TestDecodeDocument(t *testing.T) {
+	caught := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	// Shaped like the maps returned by the Firestore client.
+	doc := map[string]interface{}{
+		"id":       "spirit_1",
+		"name":     "Glimmering Griffon",
+		"level":    int64(12),
+		"rating":   int64(4),
+		"shiny":    true,
+		"caught":   caught,
+		"moveIds":  []interface{}{"move_1", "move_2"},
+		"paths":    map[string]interface{}{"128": "a.jpeg"},
+		"stats":    map[string]interface{}{"strength": float64(70)},
+		"trainer":  "ash",
+		"Untagged": "kept",
+		"Ignored":  "dropped",
+		"extra":    "not in the struct",
+	}
+
+	var decoded testDocument
+	err := DecodeDocument(doc, &decoded)
+
+	assert.NoError(t, err)
+	trainer := "ash"
+	assert.Equal(t, testDocument{
+		ID:       "spirit_1",
+		Name:     "Glimmering Griffon",
+		Level:    12,
+		Rating:   4,
+		Shiny:    true,
+		Caught:   caught,
+		MoveIDs:  []string{"move_1", "move_2"},
+		Paths:    map[string]string{"128": "a.jpeg"},
+		Stats:    testStats{Strength: 70},
+		Trainer:  &trainer,
+		Untagged: "kept",
+	}, decoded)
+}
+
+func TestDecodeDocument_WrongTypes(t *testing.T) {
+	doc := map[string]interface{}{
+		"name":    42,
+		"level":   12.5,
+		"moveIds": []interface{}{"move_1", 7},
+		"stats":   map[string]interface{}{"strength": "strong"},
+		"shiny":   true,
+	}
+
+	var decoded testDocument
+	err := DecodeDocument(doc, &decoded)
+
+	// Every bad field is reported and the good ones are still decoded.
+	assert.ErrorContains(t, err, "field name")
+	assert.ErrorContains(t, err, "field level")
+	assert.ErrorContains(t, err, "field moveIds[1]")
+	assert.ErrorContains(t, err, "field stats.strength")
+	assert.True(t, decoded.Shiny)
+	assert.Nil(t, decoded.MoveIDs)
+}
+
+func TestDecodeDocument_NotAStruct(t *testing.T) {
+	var decoded testDocument
+	assert.Error(t, DecodeDocument(map[string]interface{}{}, decoded))
+	assert.Error(t, DecodeDocument(map[string]interface{}{}, (*testDocument)(nil)))
+}
+
+func TestEncodeDocument(t *testing.T) {
+	caught := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	doc := testDocument{
+		ID:       "spirit_1",
+		Name:     "Glimmering Griffon",
+		Level:    12,
+		Rating:   4.5,
+		Caught:   caught,
+		MoveIDs:  []string{"move_1"},
+		Paths:    map[string]string{},
+		Stats:    testStats{Strength: 70},
+		Untagged: "kept",
+		Ignored:  "dropped",
+	}
+
+	encoded, err := EncodeDocument(&doc)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name":     "Glimmering Griffon",
+		"level":    int64(12),
+		"rating":   4.5,
+		"shiny":    false,
+		"caught":   caught,
+		"moveIds":  []interface{}{"move_1"},
+		"stats":    map[string]interface{}{"strength": int64(70)},
+		"trainer":  nil,
+		"Untagged": "kept",
+	}, encoded)
+}
+
+func TestEncodeDocument_RoundTrip(t *testing.T) {
+	trainer := "ash"
+	doc := testDocument{
+		ID:       "spirit_1",
+		Name:     "Glimmering Griffon",
+		Nickname: "Glim",
+		Level:    12,
+		MoveIDs:  []string{"move_1", "move_2"},
+		Paths:    map[string]string{"128": "a.jpeg"},
+		Stats:    testStats{Strength: 70},
+		Trainer:  &trainer,
+	}
+
+	encoded, err := EncodeDocument(doc)
+	assert.NoError(t, err)
+	encoded["id"] = doc.ID
+	var decoded testDocument
+	assert.NoError(t, DecodeDocument(encoded, &decoded))
+
+	assert.Equal(t, doc, decoded)
+}
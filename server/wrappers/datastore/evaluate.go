@@ -0,0 +1,354 @@
+package datastore
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The clients that do not run on Firestore evaluate queries themselves. The
+// functions in this file give them Firestore's semantics: values are stored
+// with the types Firestore returns, compared in Firestore's type order, and
+// queries filter, sort, page and select fields the same way.
+
+// evaluateQuery runs a validated query over every document of its collection.
+// The documents must be normalized and have their ID under "id".
+func evaluateQuery(query Query, docs []map[string]interface{}) (*PageResult, error) {
+	filters := make([]Filter, len(query.Filters))
+	for i, filter := range query.Filters {
+		value, err := normalizeValue(filter.Value)
+		if err != nil {
+			return nil, err
+		}
+		filters[i] = Filter{Field: filter.Field, Operator: filter.Operator, Value: value}
+	}
+	cursor, err := normalizeValue(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	var matching []map[string]interface{}
+	for _, doc := range docs {
+		if matchesQuery(doc, filters, query.Orders) {
+			matching = append(matching, doc)
+		}
+	}
+	docs = matching
+
+	slices.SortFunc(docs, func(a, b map[string]interface{}) int {
+		if c := compareOrdered(query.Orders, orderValues(a, query.Orders), orderValues(b, query.Orders)); c != 0 {
+			return c
+		}
+		// Firestore always breaks ties by document ID.
+		return strings.Compare(a["id"].(string), b["id"].(string))
+	})
+	if cursorValues, _ := cursor.([]interface{}); len(cursorValues) > 0 {
+		start := len(docs)
+		for i, doc := range docs {
+			if compareOrdered(query.Orders, orderValues(doc, query.Orders), cursorValues) > 0 {
+				start = i
+				break
+			}
+		}
+		docs = docs[start:]
+	}
+	if len(docs) > query.MaxResults+1 {
+		docs = docs[:query.MaxResults+1]
+	}
+	if len(query.Fields) > 0 {
+		for i, doc := range docs {
+			selected := map[string]interface{}{"id": doc["id"]}
+			for _, field := range query.Fields {
+				if value, ok := lookupField(doc, field); ok {
+					selected[field] = value
+				}
+			}
+			docs[i] = selected
+		}
+	}
+	return newPageResult(query, docs), nil
+}
+
+const documentIDAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// Returns a random 20 character ID, the same shape as the IDs Firestore
+// generates.
+func newDocumentID() (string, error) {
+	id := make([]byte, 20)
+	for i := range id {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(documentIDAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		id[i] = documentIDAlphabet[n.Int64()]
+	}
+	return string(id), nil
+}
+
+// Converts a document to the values Firestore would return for it, so that
+// reads never share memory with the caller's data.
+func normalizeDocument(data interface{}) (map[string]interface{}, error) {
+	normalized, err := normalizeValue(data)
+	if err != nil {
+		return nil, err
+	}
+	doc, ok := normalized.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot store %T as a document", data)
+	}
+	return doc, nil
+}
+
+func normalizeValue(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	return encodeValue(reflect.ValueOf(value))
+}
+
+// Returns a deep copy of a stored document with its ID under "id".
+func withID(doc map[string]interface{}, id string) map[string]interface{} {
+	copied := copyValue(doc).(map[string]interface{})
+	copied["id"] = id
+	return copied
+}
+
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			copied[key] = copyValue(elem)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, elem := range v {
+			copied[i] = copyValue(elem)
+		}
+		return copied
+	}
+	return value
+}
+
+func setField(doc map[string]interface{}, path []string, value interface{}) {
+	if len(path) == 1 {
+		doc[path[0]] = value
+		return
+	}
+	child, ok := doc[path[0]].(map[string]interface{})
+	if !ok {
+		child = make(map[string]interface{})
+		doc[path[0]] = child
+	}
+	setField(child, path[1:], value)
+}
+
+// Looks up a possibly nested field. DocumentID refers to the document's ID.
+func lookupField(doc map[string]interface{}, field string) (interface{}, bool) {
+	if field == DocumentID {
+		return doc["id"], true
+	}
+	var value interface{} = doc
+	for _, part := range strings.Split(field, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func matchesQuery(doc map[string]interface{}, filters []Filter, orders []Order) bool {
+	for _, filter := range filters {
+		if !matches(doc, filter) {
+			return false
+		}
+	}
+	for _, order := range orders {
+		if _, ok := lookupField(doc, order.Field); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func matches(doc map[string]interface{}, filter Filter) bool {
+	value, ok := lookupField(doc, filter.Field)
+	if !ok {
+		return false
+	}
+	switch filter.Operator {
+	case Equal:
+		return compareValues(value, filter.Value) == 0
+	case NotEqual:
+		return value != nil && compareValues(value, filter.Value) != 0
+	case LessThan, LessThanOrEqual, GreaterThan, GreaterThanOrEqual:
+		// Range filters only match values of the same type.
+		if typeRank(value) != typeRank(filter.Value) {
+			return false
+		}
+		c := compareValues(value, filter.Value)
+		switch filter.Operator {
+		case LessThan:
+			return c < 0
+		case LessThanOrEqual:
+			return c <= 0
+		case GreaterThan:
+			return c > 0
+		default:
+			return c >= 0
+		}
+	case ArrayContains:
+		array, _ := value.([]interface{})
+		return containsValue(array, filter.Value)
+	case ArrayContainsAny:
+		array, _ := value.([]interface{})
+		candidates, _ := filter.Value.([]interface{})
+		for _, candidate := range candidates {
+			if containsValue(array, candidate) {
+				return true
+			}
+		}
+		return false
+	case In:
+		candidates, _ := filter.Value.([]interface{})
+		return containsValue(candidates, value)
+	}
+	return false
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	return slices.ContainsFunc(values, func(v interface{}) bool {
+		return compareValues(v, value) == 0
+	})
+}
+
+func orderValues(doc map[string]interface{}, orders []Order) []interface{} {
+	values := make([]interface{}, len(orders))
+	for i, order := range orders {
+		values[i], _ = lookupField(doc, order.Field)
+	}
+	return values
+}
+
+// Compares two sets of order values, following each order's direction.
+func compareOrdered(orders []Order, a, b []interface{}) int {
+	for i, order := range orders {
+		if i >= len(a) || i >= len(b) {
+			break
+		}
+		c := compareValues(a[i], b[i])
+		if order.Direction == Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// Ranks value types in the order Firestore sorts them.
+func typeRank(value interface{}) int {
+	switch value.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case int64, float64:
+		return 2
+	case time.Time:
+		return 3
+	case string:
+		return 4
+	case []interface{}:
+		return 5
+	case map[string]interface{}:
+		return 6
+	}
+	return 7
+}
+
+// Reports whether a stored value equals expected, with numbers compared by
+// value.
+func valueEquals(stored, expected interface{}) (bool, error) {
+	a, err := normalizeValue(stored)
+	if err != nil {
+		return false, err
+	}
+	b, err := normalizeValue(expected)
+	if err != nil {
+		return false, err
+	}
+	return compareValues(a, b) == 0, nil
+}
+
+// Compares two normalized values. Values of different types are ordered by
+// type, and integers and floating point numbers compare by value.
+func compareValues(a, b interface{}) int {
+	if c := typeRank(a) - typeRank(b); c != 0 {
+		return c
+	}
+	switch a := a.(type) {
+	case bool:
+		b := b.(bool)
+		if a == b {
+			return 0
+		} else if !a {
+			return -1
+		}
+		return 1
+	case int64, float64:
+		x, _ := toFloat(a)
+		y, _ := toFloat(b)
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+		return 0
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	case string:
+		return strings.Compare(a, b.(string))
+	case []interface{}:
+		b := b.([]interface{})
+		for i := 0; i < len(a) && i < len(b); i++ {
+			if c := compareValues(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+		return len(a) - len(b)
+	case map[string]interface{}:
+		// Maps compare by their sorted keys and then by the values under them.
+		b := b.(map[string]interface{})
+		aKeys, bKeys := sortedKeys(a), sortedKeys(b)
+		for i := 0; i < len(aKeys) && i < len(bKeys); i++ {
+			if c := strings.Compare(aKeys[i], bKeys[i]); c != 0 {
+				return c
+			}
+			if c := compareValues(a[aKeys[i]], b[bKeys[i]]); c != 0 {
+				return c
+			}
+		}
+		return len(aKeys) - len(bKeys)
+	}
+	return 0
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,213 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// MemoryClient keeps documents in memory. It has the same methods and query
+// semantics as Client, so the server can run without Firestore during local
+// development and in tests. Everything is lost when the process exits.
+type MemoryClient struct {
+	mu sync.RWMutex
+	// Documents keyed by collection path, then by document ID.
+	collections map[string]map[string]map[string]interface{}
+}
+
+func NewMemoryClient() *MemoryClient {
+	return &MemoryClient{collections: make(map[string]map[string]map[string]interface{})}
+}
+
+// AddDocument adds a document with a generated ID. data is a map or a typed
+// document struct, and is stored the way Firestore stores it.
+func (m *MemoryClient) AddDocument(ctx context.Context, collectionName string, data interface{}) (string, error) {
+	doc, err := normalizeDocument(data)
+	if err != nil {
+		return "", err
+	}
+	id, err := newDocumentID()
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	collection, ok := m.collections[collectionName]
+	if !ok {
+		collection = make(map[string]map[string]interface{})
+		m.collections[collectionName] = collection
+	}
+	collection[id] = doc
+	return id, nil
+}
+
+// CreateDocument adds a document with the given ID, or returns
+// ErrAlreadyExists if the collection has a document with that ID.
+func (m *MemoryClient) CreateDocument(ctx context.Context, collectionName string, id string, data interface{}) error {
+	doc, err := normalizeDocument(data)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	collection, ok := m.collections[collectionName]
+	if !ok {
+		collection = make(map[string]map[string]interface{})
+		m.collections[collectionName] = collection
+	}
+	if _, exists := collection[id]; exists {
+		return ErrAlreadyExists
+	}
+	collection[id] = doc
+	return nil
+}
+
+// GetCollection retrieves a page of documents sorted by a single field.
+func (m *MemoryClient) GetCollection(ctx context.Context, collectionName string, limit int, sortField string, sortDirection Direction, startAfter []interface{}) (*PageResult, error) {
+	query := NewQuery(collectionName).OrderBy(sortField, sortDirection).Limit(limit)
+	if len(startAfter) > 0 {
+		query = query.StartAfter(startAfter...)
+	}
+	return m.RunQuery(ctx, query)
+}
+
+// RunQuery retrieves a page of documents matching the query. Like Firestore,
+// documents that lack a field the query orders by are left out.
+func (m *MemoryClient) RunQuery(ctx context.Context, query Query) (*PageResult, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	var docs []map[string]interface{}
+	for id, stored := range m.collections[query.Collection] {
+		docs = append(docs, withID(stored, id))
+	}
+	m.mu.RUnlock()
+	return evaluateQuery(query, docs)
+}
+
+// GetDocumentsByIds retrieves documents by ID, failing if any is missing.
+func (m *MemoryClient) GetDocumentsByIds(ctx context.Context, collectionName string, ids []string) ([]map[string]interface{}, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var results []map[string]interface{}
+	for _, id := range ids {
+		doc, ok := m.collections[collectionName][id]
+		if !ok {
+			return nil, fmt.Errorf("document with ID %s does not exist", id)
+		}
+		results = append(results, withID(doc, id))
+	}
+	return results, nil
+}
+
+// GetDocumentsFilteredByValue retrieves the documents whose field equals value.
+func (m *MemoryClient) GetDocumentsFilteredByValue(ctx context.Context, collectionName string, fieldName string, value any) ([]map[string]interface{}, error) {
+	value, err := normalizeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var results []map[string]interface{}
+	for id, doc := range m.collections[collectionName] {
+		if matches(withID(doc, id), Filter{Field: fieldName, Operator: Equal, Value: value}) {
+			results = append(results, withID(doc, id))
+		}
+	}
+	return results, nil
+}
+
+// GetDocument retrieves a single document by ID, or ErrNotFound.
+func (m *MemoryClient) GetDocument(ctx context.Context, collectionName string, id string) (map[string]interface{}, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	doc, ok := m.collections[collectionName][id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return withID(doc, id), nil
+}
+
+// UpdateDocument sets the given fields on an existing document, or returns
+// ErrNotFound. Like Firestore, field names containing dots set nested fields.
+func (m *MemoryClient) UpdateDocument(ctx context.Context, collectionName string, id string, updates map[string]interface{}) error {
+	normalized, err := normalizeDocument(updates)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	doc, ok := m.collections[collectionName][id]
+	if !ok {
+		return ErrNotFound
+	}
+	for field, value := range normalized {
+		setField(doc, strings.Split(field, "."), value)
+	}
+	return nil
+}
+
+// UpdateDocumentIf sets the given fields on an existing document if its field
+// holds the expected value, and returns ErrConditionFailed otherwise.
+func (m *MemoryClient) UpdateDocumentIf(ctx context.Context, collectionName string, id string, field string, expected interface{}, updates map[string]interface{}) error {
+	normalized, err := normalizeDocument(updates)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	doc, ok := m.collections[collectionName][id]
+	if !ok {
+		return ErrNotFound
+	}
+	holds, err := valueEquals(doc[field], expected)
+	if err != nil {
+		return err
+	}
+	if !holds {
+		return ErrConditionFailed
+	}
+	for path, value := range normalized {
+		setField(doc, strings.Split(path, "."), value)
+	}
+	return nil
+}
+
+// DeleteDocument deletes a document. Deleting a document that does not exist
+// is not an error.
+func (m *MemoryClient) DeleteDocument(ctx context.Context, collectionName string, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.collections[collectionName], id)
+	return nil
+}
+
+// ListDocumentIDs returns the IDs of every document in a collection, including
+// documents that only exist as the parent of a subcollection.
+func (m *MemoryClient) ListDocumentIDs(ctx context.Context, collectionName string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var ids []string
+	for id := range m.collections[collectionName] {
+		ids = append(ids, id)
+	}
+	prefix := collectionName + "/"
+	for path, collection := range m.collections {
+		if rest, ok := strings.CutPrefix(path, prefix); ok && len(collection) > 0 {
+			id, _, _ := strings.Cut(rest, "/")
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+func (m *MemoryClient) Close() error {
+	return nil
+}
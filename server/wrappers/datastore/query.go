@@ -0,0 +1,217 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// Operator is a comparison used to filter query results.
+type Operator string
+
+const (
+	Equal              Operator = "=="
+	NotEqual           Operator = "!="
+	LessThan           Operator = "<"
+	LessThanOrEqual    Operator = "<="
+	GreaterThan        Operator = ">"
+	GreaterThanOrEqual Operator = ">="
+	// ArrayContains matches documents whose array field contains the value.
+	ArrayContains Operator = "array-contains"
+	// ArrayContainsAny matches documents whose array field contains any of the
+	// values in the given slice.
+	ArrayContainsAny Operator = "array-contains-any"
+	// In matches documents whose field equals any of the values in the given
+	// slice.
+	In Operator = "in"
+)
+
+// DocumentID is the special field name that refers to a document's ID. It can
+// be used to order results, which gives queries a stable tie-breaker.
+const DocumentID = firestore.DocumentID
+
+// Filter restricts a query to documents whose field compares to a value.
+type Filter struct {
+	Field    string
+	Operator Operator
+	Value    interface{}
+}
+
+// Order sorts query results by a field.
+type Order struct {
+	Field     string
+	Direction Direction
+}
+
+// Query describes a query over a single collection. Queries are built by
+// chaining methods on the value returned by NewQuery. Every method returns a
+// new Query so a partially built query can be safely reused as a base for
+// several others.
+//
+// Example:
+//
+//	query := datastore.NewQuery("users/123/spirits").
+//		Where("primaryType", datastore.Equal, "Flame").
+//		OrderBy("strength", datastore.Desc).
+//		Limit(20)
+type Query struct {
+	Collection string
+	Filters    []Filter
+	Orders     []Order
+	// Maximum number of documents to return. Must be positive.
+	MaxResults int
+	// Cursor values to start after, one for each Order.
+	Cursor []interface{}
+	// Fields to return. All fields are returned when empty.
+	Fields []string
+}
+
+// NewQuery starts a query over the named collection.
+func NewQuery(collectionName string) Query {
+	return Query{Collection: collectionName}
+}
+
+// Where adds a filter. Multiple filters are combined with AND.
+func (q Query) Where(field string, op Operator, value interface{}) Query {
+	q.Filters = append(slices.Clip(q.Filters), Filter{Field: field, Operator: op, Value: value})
+	return q
+}
+
+// OrderBy adds a sort order. Results are sorted by each order in turn.
+func (q Query) OrderBy(field string, direction Direction) Query {
+	q.Orders = append(slices.Clip(q.Orders), Order{Field: field, Direction: direction})
+	return q
+}
+
+// Limit sets the maximum number of documents to return.
+func (q Query) Limit(limit int) Query {
+	q.MaxResults = limit
+	return q
+}
+
+// StartAfter resumes the query after the document with the given cursor
+// values, as returned in PageResult.LastCursor. Empty values are ignored.
+func (q Query) StartAfter(cursor ...interface{}) Query {
+	q.Cursor = cursor
+	return q
+}
+
+// Select restricts the fields returned for each document. The document ID is
+// always returned.
+func (q Query) Select(fields ...string) Query {
+	q.Fields = fields
+	return q
+}
+
+// Validate checks that the query is well formed.
+func (q Query) Validate() error {
+	if q.Collection == "" {
+		return fmt.Errorf("query has no collection")
+	}
+	if q.MaxResults <= 0 {
+		return fmt.Errorf("query limit must be positive, got %d", q.MaxResults)
+	}
+	if len(q.Cursor) > 0 && len(q.Cursor) != len(q.Orders) {
+		return fmt.Errorf("query cursor has %d values but the query has %d orders", len(q.Cursor), len(q.Orders))
+	}
+	for _, filter := range q.Filters {
+		switch filter.Operator {
+		case Equal, NotEqual, LessThan, LessThanOrEqual, GreaterThan, GreaterThanOrEqual, ArrayContains, ArrayContainsAny, In:
+		default:
+			return fmt.Errorf("unsupported query operator %q", filter.Operator)
+		}
+	}
+	return nil
+}
+
+// cursorFor returns the cursor values of a document for the query's orders.
+func (q Query) cursorFor(doc map[string]interface{}) []interface{} {
+	cursor := make([]interface{}, len(q.Orders))
+	for i, order := range q.Orders {
+		if order.Field == DocumentID {
+			cursor[i] = doc["id"]
+		} else {
+			cursor[i] = doc[order.Field]
+		}
+	}
+	return cursor
+}
+
+// RunQuery retrieves a page of documents matching the query.
+//
+// Firestore may require a composite index for queries that combine filters
+// and orders on different fields. The error returned in that case contains a
+// link to create the index.
+//
+// Parameters:
+//   - ctx: The context for the client operations.
+//   - query: The query to run.
+//
+// Returns:
+//   - A PageResult containing the matching documents, the cursor of the last
+//     returned document for each of the query's orders, and a flag indicating
+//     if there are more pages.
+//   - An error if the query is invalid or the operation fails.
+func (r *Client) RunQuery(ctx context.Context, query Query) (*PageResult, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+
+	fsQuery := r.fsClient.Collection(query.Collection).Query
+	if len(query.Fields) > 0 {
+		fsQuery = fsQuery.Select(query.Fields...)
+	}
+	for _, filter := range query.Filters {
+		fsQuery = fsQuery.Where(filter.Field, string(filter.Operator), filter.Value)
+	}
+	for _, order := range query.Orders {
+		fsQuery = fsQuery.OrderBy(order.Field, firestore.Direction(order.Direction))
+	}
+	if len(query.Cursor) > 0 {
+		fsQuery = fsQuery.StartAfter(query.Cursor...)
+	}
+	// Fetch one extra to determine if there are more pages
+	fsQuery = fsQuery.Limit(query.MaxResults + 1)
+
+	iter := fsQuery.Documents(ctx)
+	defer iter.Stop()
+	var docs []map[string]interface{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error fetching documents: %v", err)
+		}
+
+		docData := doc.Data()
+		docData["id"] = doc.Ref.ID
+		docs = append(docs, docData)
+	}
+
+	return newPageResult(query, docs), nil
+}
+
+// newPageResult trims the extra look-ahead document and computes the cursor
+// of the last returned document.
+func newPageResult(query Query, docs []map[string]interface{}) *PageResult {
+	hasMore := len(docs) > query.MaxResults
+	if hasMore {
+		docs = docs[:query.MaxResults]
+	}
+
+	var lastCursor []interface{}
+	if len(docs) > 0 {
+		lastCursor = query.cursorFor(docs[len(docs)-1])
+	}
+
+	return &PageResult{
+		Documents:  docs,
+		LastCursor: lastCursor,
+		HasMore:    hasMore,
+	}
+}
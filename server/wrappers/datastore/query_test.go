@@ -0,0 +1,92 @@
+package datastore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuery_BuilderDoesNotShareState(t *testing.T) {
+	base := NewQuery("users/123/spirits").Where("primaryType", Equal, "Flame")
+
+	strong := base.Where("strength", GreaterThanOrEqual, 70)
+	fast := base.Where("agility", GreaterThanOrEqual, 70)
+
+	assert.Len(t, base.Filters, 1)
+	assert.Equal(t, "strength", strong.Filters[1].Field)
+	assert.Equal(t, "agility", fast.Filters[1].Field)
+}
+
+func TestQuery_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   Query
+		wantErr bool
+	}{
+		{
+			name:  "Valid query",
+			query: NewQuery("spirits").OrderBy("strength", Desc).OrderBy(DocumentID, Desc).Limit(10).StartAfter(70, "abc"),
+		},
+		{
+			name:    "Missing collection",
+			query:   NewQuery("").Limit(10),
+			wantErr: true,
+		},
+		{
+			name:    "Missing limit",
+			query:   NewQuery("spirits"),
+			wantErr: true,
+		},
+		{
+			name:    "Cursor does not match orders",
+			query:   NewQuery("spirits").OrderBy("strength", Desc).Limit(10).StartAfter(70, "abc"),
+			wantErr: true,
+		},
+		{
+			name:    "Unknown operator",
+			query:   NewQuery("spirits").Where("name", Operator("like"), "Grif").Limit(10),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.query.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestNewPageResult(t *testing.T) {
+	query := NewQuery("spirits").OrderBy("strength", Desc).OrderBy(DocumentID, Desc).Limit(2)
+	docs := []map[string]interface{}{
+		{"id": "a", "strength": 90},
+		{"id": "b", "strength": 80},
+		{"id": "c", "strength": 70},
+	}
+
+	result := newPageResult(query, docs)
+
+	assert.Len(t, result.Documents, 2)
+	assert.True(t, result.HasMore)
+	// The cursor must point at the last returned document, not the look-ahead.
+	assert.Equal(t, []interface{}{80, "b"}, result.LastCursor)
+}
+
+func TestNewPageResult_LastPage(t *testing.T) {
+	query := NewQuery("spirits").OrderBy("strength", Desc).Limit(2)
+
+	result := newPageResult(query, []map[string]interface{}{{"id": "a", "strength": 90}})
+	assert.Len(t, result.Documents, 1)
+	assert.False(t, result.HasMore)
+	assert.Equal(t, []interface{}{90}, result.LastCursor)
+
+	empty := newPageResult(query, nil)
+	assert.Empty(t, empty.Documents)
+	assert.False(t, empty.HasMore)
+	assert.Nil(t, empty.LastCursor)
+}
@@ -0,0 +1,436 @@
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteClient stores documents in a SQLite database file, for running the
+// server without Google Cloud. It has the same methods and query semantics
+// as Client.
+//
+// Every document is a row of the documents table, keyed by its collection
+// path and ID. Fields are stored as JSON in the format of the Firestore REST
+// API, e.g. {"strength": {"integerValue": "70"}}, so integers, floating point
+// numbers and timestamps keep their types. Queries read the whole collection
+// and are evaluated in Go, which is fast enough for collections of a few
+// thousand documents, such as a user's spirits.
+type SQLiteClient struct {
+	db *sql.DB
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS documents (
+	collection TEXT NOT NULL,
+	id         TEXT NOT NULL,
+	fields     TEXT NOT NULL,
+	PRIMARY KEY (collection, id)
+) WITHOUT ROWID;
+`
+
+// NewSQLiteClient opens the database at path, creating it if needed. A path
+// of ":memory:" opens a database that only lasts as long as the client.
+func NewSQLiteClient(path string) (*SQLiteClient, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening SQLite database %s: %v", path, err)
+	}
+	// A single connection serializes writes, which SQLite needs anyway, and
+	// keeps ":memory:" databases from being opened once per connection.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating SQLite schema in %s: %v", path, err)
+	}
+	return &SQLiteClient{db: db}, nil
+}
+
+// AddDocument adds a document with a generated ID. data is a map or a typed
+// document struct, and is stored the way Firestore stores it.
+func (c *SQLiteClient) AddDocument(ctx context.Context, collectionName string, data interface{}) (string, error) {
+	doc, err := normalizeDocument(data)
+	if err != nil {
+		return "", err
+	}
+	id, err := newDocumentID()
+	if err != nil {
+		return "", err
+	}
+	fields, err := encodeFields(doc)
+	if err != nil {
+		return "", err
+	}
+	_, err = c.db.ExecContext(ctx, "INSERT INTO documents (collection, id, fields) VALUES (?, ?, ?)", collectionName, id, fields)
+	if err != nil {
+		return "", fmt.Errorf("failed to add document to %s: %w", collectionName, err)
+	}
+	return id, nil
+}
+
+// CreateDocument adds a document with the given ID, or returns
+// ErrAlreadyExists if the collection has a document with that ID.
+func (c *SQLiteClient) CreateDocument(ctx context.Context, collectionName string, id string, data interface{}) error {
+	doc, err := normalizeDocument(data)
+	if err != nil {
+		return err
+	}
+	fields, err := encodeFields(doc)
+	if err != nil {
+		return err
+	}
+	result, err := c.db.ExecContext(ctx, "INSERT INTO documents (collection, id, fields) VALUES (?, ?, ?) ON CONFLICT DO NOTHING", collectionName, id, fields)
+	if err != nil {
+		return fmt.Errorf("failed to create document with ID %s: %w", id, err)
+	}
+	if created, err := result.RowsAffected(); err != nil || created == 0 {
+		return ErrAlreadyExists
+	}
+	return nil
+}
+
+// GetCollection retrieves a page of documents sorted by a single field.
+func (c *SQLiteClient) GetCollection(ctx context.Context, collectionName string, limit int, sortField string, sortDirection Direction, startAfter []interface{}) (*PageResult, error) {
+	query := NewQuery(collectionName).OrderBy(sortField, sortDirection).Limit(limit)
+	if len(startAfter) > 0 {
+		query = query.StartAfter(startAfter...)
+	}
+	return c.RunQuery(ctx, query)
+}
+
+// RunQuery retrieves a page of documents matching the query. Like Firestore,
+// documents that lack a field the query orders by are left out.
+func (c *SQLiteClient) RunQuery(ctx context.Context, query Query) (*PageResult, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+	docs, err := c.collection(ctx, query.Collection)
+	if err != nil {
+		return nil, err
+	}
+	return evaluateQuery(query, docs)
+}
+
+// GetDocumentsByIds retrieves documents by ID, failing if any is missing.
+func (c *SQLiteClient) GetDocumentsByIds(ctx context.Context, collectionName string, ids []string) ([]map[string]interface{}, error) {
+	var results []map[string]interface{}
+	for _, id := range ids {
+		doc, err := c.GetDocument(ctx, collectionName, id)
+		if errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("document with ID %s does not exist", id)
+		}
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, doc)
+	}
+	return results, nil
+}
+
+// GetDocumentsFilteredByValue retrieves the documents whose field equals value.
+func (c *SQLiteClient) GetDocumentsFilteredByValue(ctx context.Context, collectionName string, fieldName string, value any) ([]map[string]interface{}, error) {
+	value, err := normalizeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := c.collection(ctx, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	var results []map[string]interface{}
+	for _, doc := range docs {
+		if matches(doc, Filter{Field: fieldName, Operator: Equal, Value: value}) {
+			results = append(results, doc)
+		}
+	}
+	return results, nil
+}
+
+// GetDocument retrieves a single document by ID, or ErrNotFound.
+func (c *SQLiteClient) GetDocument(ctx context.Context, collectionName string, id string) (map[string]interface{}, error) {
+	return getSQLiteDocument(ctx, c.db, collectionName, id)
+}
+
+// UpdateDocument sets the given fields on an existing document, or returns
+// ErrNotFound. Like Firestore, field names containing dots set nested fields.
+func (c *SQLiteClient) UpdateDocument(ctx context.Context, collectionName string, id string, updates map[string]interface{}) error {
+	return c.updateDocument(ctx, collectionName, id, nil, updates)
+}
+
+// UpdateDocumentIf sets the given fields on an existing document if its field
+// holds the expected value, and returns ErrConditionFailed otherwise.
+func (c *SQLiteClient) UpdateDocumentIf(ctx context.Context, collectionName string, id string, field string, expected interface{}, updates map[string]interface{}) error {
+	return c.updateDocument(ctx, collectionName, id, func(doc map[string]interface{}) error {
+		holds, err := valueEquals(doc[field], expected)
+		if err != nil {
+			return err
+		}
+		if !holds {
+			return ErrConditionFailed
+		}
+		return nil
+	}, updates)
+}
+
+// Updates a document in a transaction, after check, if not nil, accepts the
+// stored document.
+func (c *SQLiteClient) updateDocument(ctx context.Context, collectionName string, id string, check func(doc map[string]interface{}) error, updates map[string]interface{}) error {
+	normalized, err := normalizeDocument(updates)
+	if err != nil {
+		return err
+	}
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to update document with ID %s: %w", id, err)
+	}
+	defer tx.Rollback()
+
+	doc, err := getSQLiteDocument(ctx, tx, collectionName, id)
+	if err != nil {
+		return err
+	}
+	delete(doc, "id")
+	if check != nil {
+		if err := check(doc); err != nil {
+			return err
+		}
+	}
+	for field, value := range normalized {
+		setField(doc, strings.Split(field, "."), value)
+	}
+	fields, err := encodeFields(doc)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE documents SET fields = ? WHERE collection = ? AND id = ?", fields, collectionName, id); err != nil {
+		return fmt.Errorf("failed to update document with ID %s: %w", id, err)
+	}
+	return tx.Commit()
+}
+
+// DeleteDocument deletes a document. Deleting a document that does not exist
+// is not an error.
+func (c *SQLiteClient) DeleteDocument(ctx context.Context, collectionName string, id string) error {
+	if _, err := c.db.ExecContext(ctx, "DELETE FROM documents WHERE collection = ? AND id = ?", collectionName, id); err != nil {
+		return fmt.Errorf("failed to delete document with ID %s: %w", id, err)
+	}
+	return nil
+}
+
+// ListDocumentIDs returns the IDs of every document in a collection, including
+// documents that only exist as the parent of a subcollection.
+func (c *SQLiteClient) ListDocumentIDs(ctx context.Context, collectionName string) ([]string, error) {
+	// The prefix is matched with substr rather than LIKE, so that "_" and "%"
+	// in collection names are not wildcards.
+	prefix := collectionName + "/"
+	rows, err := c.db.QueryContext(ctx, `
+		SELECT id FROM documents WHERE collection = ?
+		UNION
+		SELECT DISTINCT collection FROM documents WHERE substr(collection, 1, length(?)) = ?`,
+		collectionName, prefix, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("error listing documents: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, fmt.Errorf("error listing documents: %v", err)
+		}
+		// Subcollection paths are reduced to the ID of their parent document.
+		if rest, ok := strings.CutPrefix(value, prefix); ok {
+			value, _, _ = strings.Cut(rest, "/")
+		}
+		if !slices.Contains(ids, value) {
+			ids = append(ids, value)
+		}
+	}
+	return ids, rows.Err()
+}
+
+func (c *SQLiteClient) Close() error {
+	return c.db.Close()
+}
+
+// Either the database or a transaction.
+type sqliteQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func getSQLiteDocument(ctx context.Context, db sqliteQuerier, collectionName string, id string) (map[string]interface{}, error) {
+	var fields string
+	err := db.QueryRowContext(ctx, "SELECT fields FROM documents WHERE collection = ? AND id = ?", collectionName, id).Scan(&fields)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve document with ID %s: %w", id, err)
+	}
+	doc, err := decodeFields(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode document with ID %s: %w", id, err)
+	}
+	doc["id"] = id
+	return doc, nil
+}
+
+// Reads every document of a collection.
+func (c *SQLiteClient) collection(ctx context.Context, collectionName string) ([]map[string]interface{}, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT id, fields FROM documents WHERE collection = ?", collectionName)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching documents: %v", err)
+	}
+	defer rows.Close()
+
+	var docs []map[string]interface{}
+	for rows.Next() {
+		var id, fields string
+		if err := rows.Scan(&id, &fields); err != nil {
+			return nil, fmt.Errorf("error fetching documents: %v", err)
+		}
+		doc, err := decodeFields(fields)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode document with ID %s: %w", id, err)
+		}
+		doc["id"] = id
+		docs = append(docs, doc)
+	}
+	return docs, rows.Err()
+}
+
+// storedValue is a value in the JSON format of the Firestore REST API. Exactly
+// one field is set.
+type storedValue struct {
+	Null      *struct{}    `json:"nullValue,omitempty"`
+	Boolean   *bool        `json:"booleanValue,omitempty"`
+	Integer   *string      `json:"integerValue,omitempty"`
+	Double    *float64     `json:"doubleValue,omitempty"`
+	Timestamp *string      `json:"timestampValue,omitempty"`
+	String    *string      `json:"stringValue,omitempty"`
+	Array     *storedArray `json:"arrayValue,omitempty"`
+	Map       *storedMap   `json:"mapValue,omitempty"`
+}
+
+type storedArray struct {
+	Values []storedValue `json:"values"`
+}
+
+type storedMap struct {
+	Fields map[string]storedValue `json:"fields"`
+}
+
+func encodeFields(doc map[string]interface{}) (string, error) {
+	fields, err := toStoredMap(doc)
+	if err != nil {
+		return "", err
+	}
+	encoded, err := json.Marshal(fields)
+	return string(encoded), err
+}
+
+func decodeFields(encoded string) (map[string]interface{}, error) {
+	var fields map[string]storedValue
+	if err := json.Unmarshal([]byte(encoded), &fields); err != nil {
+		return nil, err
+	}
+	return fromStoredMap(fields)
+}
+
+func toStoredMap(m map[string]interface{}) (map[string]storedValue, error) {
+	fields := make(map[string]storedValue, len(m))
+	for key, value := range m {
+		stored, err := toStoredValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", key, err)
+		}
+		fields[key] = stored
+	}
+	return fields, nil
+}
+
+// Converts a normalized value.
+func toStoredValue(value interface{}) (storedValue, error) {
+	switch v := value.(type) {
+	case nil:
+		return storedValue{Null: &struct{}{}}, nil
+	case bool:
+		return storedValue{Boolean: &v}, nil
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return storedValue{Integer: &s}, nil
+	case float64:
+		return storedValue{Double: &v}, nil
+	case time.Time:
+		s := v.UTC().Format(time.RFC3339Nano)
+		return storedValue{Timestamp: &s}, nil
+	case string:
+		return storedValue{String: &v}, nil
+	case []interface{}:
+		array := &storedArray{Values: make([]storedValue, len(v))}
+		for i, elem := range v {
+			stored, err := toStoredValue(elem)
+			if err != nil {
+				return storedValue{}, err
+			}
+			array.Values[i] = stored
+		}
+		return storedValue{Array: array}, nil
+	case map[string]interface{}:
+		fields, err := toStoredMap(v)
+		if err != nil {
+			return storedValue{}, err
+		}
+		return storedValue{Map: &storedMap{Fields: fields}}, nil
+	}
+	return storedValue{}, fmt.Errorf("cannot store %T", value)
+}
+
+func fromStoredMap(fields map[string]storedValue) (map[string]interface{}, error) {
+	m := make(map[string]interface{}, len(fields))
+	for key, stored := range fields {
+		value, err := fromStoredValue(stored)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", key, err)
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+func fromStoredValue(stored storedValue) (interface{}, error) {
+	switch {
+	case stored.Boolean != nil:
+		return *stored.Boolean, nil
+	case stored.Integer != nil:
+		return strconv.ParseInt(*stored.Integer, 10, 64)
+	case stored.Double != nil:
+		return *stored.Double, nil
+	case stored.Timestamp != nil:
+		return time.Parse(time.RFC3339Nano, *stored.Timestamp)
+	case stored.String != nil:
+		return *stored.String, nil
+	case stored.Array != nil:
+		array := make([]interface{}, len(stored.Array.Values))
+		for i, elem := range stored.Array.Values {
+			value, err := fromStoredValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			array[i] = value
+		}
+		return array, nil
+	case stored.Map != nil:
+		return fromStoredMap(stored.Map.Fields)
+	}
+	return nil, nil
+}
@@ -0,0 +1,76 @@
+package datastore
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLiteClient_PersistsDocuments(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "spirit-snap.db")
+	client, err := NewSQLiteClient(path)
+	assert.NoError(t, err)
+	id, err := client.AddDocument(ctx, "users/u1/spirits", map[string]interface{}{"name": "Glimmering Griffon"})
+	assert.NoError(t, err)
+	assert.NoError(t, client.Close())
+
+	reopened, err := NewSQLiteClient(path)
+	assert.NoError(t, err)
+	defer reopened.Close()
+	doc, err := reopened.GetDocument(ctx, "users/u1/spirits", id)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Glimmering Griffon", doc["name"])
+}
+
+func TestSQLiteClient_StoresFirestoreValues(t *testing.T) {
+	caught := time.Date(2024, 1, 15, 10, 30, 0, 123, time.UTC)
+	doc := map[string]interface{}{
+		"name":     "Glimmering Griffon",
+		"strength": int64(70),
+		// A whole floating point number stays a float.
+		"rating":  float64(4),
+		"shiny":   false,
+		"caught":  caught,
+		"trainer": nil,
+		"moveIds": []interface{}{"move_1", int64(2)},
+		"stats":   map[string]interface{}{"luck": 0.5},
+	}
+
+	encoded, err := encodeFields(doc)
+	assert.NoError(t, err)
+	decoded, err := decodeFields(encoded)
+
+	assert.NoError(t, err)
+	assert.Equal(t, doc, decoded)
+	assert.Contains(t, encoded, `"strength":{"integerValue":"70"}`)
+	assert.Contains(t, encoded, `"caught":{"timestampValue":"2024-01-15T10:30:00.000000123Z"}`)
+}
+
+func TestSQLiteClient_UpdateIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewSQLiteClient(":memory:")
+	assert.NoError(t, err)
+	defer client.Close()
+	id, err := client.AddDocument(ctx, "counters", map[string]interface{}{"count": 0})
+	assert.NoError(t, err)
+
+	// Concurrent updates of different fields must not overwrite each other.
+	done := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func(field string) {
+			done <- client.UpdateDocument(ctx, "counters", id, map[string]interface{}{field: true})
+		}(string(rune('a' + i)))
+	}
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, <-done)
+	}
+
+	doc, err := client.GetDocument(ctx, "counters", id)
+	assert.NoError(t, err)
+	assert.Len(t, doc, 12)
+}
@@ -3,7 +3,9 @@ package file_storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"time"
 
 	gcs "cloud.google.com/go/storage"
@@ -63,6 +65,35 @@ func (c *Client) Write(ctx context.Context, bucketName string, filePath string,
 	return nil
 }
 
+// Reads a file from Firebase Storage.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - bucketName: The name of the storage bucket (optional if using default bucket).
+//   - filePath: The path of the object in the bucket.
+//
+// Returns:
+//   - The contents of the file.
+//   - An error if any issue occurs during the download.
+func (c *Client) Read(ctx context.Context, bucketName string, filePath string) ([]byte, error) {
+	bucket, err := c.Client.Bucket(bucketName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bucket: %v", err)
+	}
+
+	reader, err := bucket.Object(filePath).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open object reader: %v", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object: %v", err)
+	}
+	return data, nil
+}
+
 // GetDownloadURL retrieves the download URL for a file in Firebase Storage.
 //
 // Parameters:
@@ -91,3 +122,26 @@ func (c *Client) GetDownloadURL(ctx context.Context, bucketName string, filePath
 
 	return url, nil
 }
+
+// Delete removes a file from Firebase Storage. Deleting a file that does not
+// exist is not an error, so deletes can be safely retried.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - bucketName: The name of the storage bucket (optional if using default bucket).
+//   - filePath: The path of the object in the bucket.
+//
+// Returns:
+//   - An error if any issue occurs during the process.
+func (c *Client) Delete(ctx context.Context, bucketName string, filePath string) error {
+	bucket, err := c.Client.Bucket(bucketName)
+	if err != nil {
+		return fmt.Errorf("failed to get bucket: %v", err)
+	}
+
+	err = bucket.Object(filePath).Delete(ctx)
+	if err != nil && !errors.Is(err, gcs.ErrObjectNotExist) {
+		return fmt.Errorf("failed to delete object: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package file_storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalClient keeps files in a directory on disk, at {Dir}/{bucket}/{path},
+// for running the server without Firebase Storage. Download URLs point at the
+// client's own ServeHTTP and, like the V4 signed URLs of Cloud Storage, carry
+// an expiry time and an HMAC-SHA256 signature of the file and expiry time.
+// ServeHTTP only serves files whose URLs have a valid signature and have not
+// expired.
+type LocalClient struct {
+	// Directory the files are stored in.
+	Dir string
+
+	urlSigner
+}
+
+// NewLocalClient creates a client storing files in dir, which is created if
+// it does not exist. URLs are signed with secret, so they stop working when
+// the secret changes.
+func NewLocalClient(dir string, baseURL string, secret []byte) (*LocalClient, error) {
+	signer, err := newURLSigner(strings.TrimSuffix(baseURL, "/"), secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %v", err)
+	}
+	return &LocalClient{Dir: dir, urlSigner: signer}, nil
+}
+
+// Write stores a file, replacing any file with the same path. The file is
+// written to a temporary file first, so readers never see part of it. The
+// content type is not stored; ServeHTTP infers it from the file name or
+// contents.
+func (c *LocalClient) Write(ctx context.Context, bucketName string, filePath string, data []byte, contentType string) error {
+	name, err := c.fileName(bucketName, filePath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %v", err)
+	}
+	if err := os.Rename(file.Name(), name); err != nil {
+		return fmt.Errorf("failed to move file into place: %v", err)
+	}
+	return nil
+}
+
+// Read returns the contents of a file, or ErrObjectNotFound.
+func (c *LocalClient) Read(ctx context.Context, bucketName string, filePath string) ([]byte, error) {
+	name, err := c.fileName(bucketName, filePath)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s: %w", filePath, ErrObjectNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", filePath, err)
+	}
+	return data, nil
+}
+
+// GetDownloadURL returns a signed URL that ServeHTTP serves the file at until
+// URLExpiry has passed. Like signed URLs, it can be created before the file
+// exists.
+func (c *LocalClient) GetDownloadURL(ctx context.Context, bucketName string, filePath string) (string, error) {
+	if _, err := c.fileName(bucketName, filePath); err != nil {
+		return "", err
+	}
+	return c.signedURL(bucketName, filePath), nil
+}
+
+// Delete removes a file. Deleting a file that does not exist is not an error.
+func (c *LocalClient) Delete(ctx context.Context, bucketName string, filePath string) error {
+	name, err := c.fileName(bucketName, filePath)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete %s: %v", filePath, err)
+	}
+	return nil
+}
+
+// ServeHTTP serves GET and HEAD requests for URLs made by GetDownloadURL. It
+// expects the prefix of BaseURL to have been stripped from the request path.
+// Requests with a missing or invalid signature, or an expired URL, are
+// forbidden.
+func (c *LocalClient) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	bucketName, filePath, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if !ok || filePath == "" {
+		http.NotFound(w, r)
+		return
+	}
+	name, err := c.fileName(bucketName, filePath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !c.checkSignature(w, r, bucketName, filePath) {
+		return
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, filePath, info.ModTime(), file)
+}
+
+// Returns the name of the file an object is stored in. Paths that would
+// leave the bucket's directory are rejected.
+func (c *LocalClient) fileName(bucketName string, filePath string) (string, error) {
+	if strings.Contains(bucketName, "/") || !filepath.IsLocal(bucketName) {
+		return "", fmt.Errorf("invalid bucket name %q", bucketName)
+	}
+	if !filepath.IsLocal(filepath.FromSlash(filePath)) {
+		return "", fmt.Errorf("invalid file path %q", filePath)
+	}
+	return filepath.Join(c.Dir, bucketName, filepath.FromSlash(filePath)), nil
+}
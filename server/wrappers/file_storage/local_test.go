@@ -0,0 +1,139 @@
+package file_storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLocalClient(t *testing.T, baseURL string) *LocalClient {
+	client, err := NewLocalClient(t.TempDir(), baseURL, []byte("secret"))
+	assert.NoError(t, err)
+	return client
+}
+
+func TestLocalClient_WriteReadDelete(t *testing.T) {
+	ctx := context.Background()
+	client := newTestLocalClient(t, "http://localhost:8080/files")
+
+	assert.NoError(t, client.Write(ctx, testBucket, "photos/u1/a.jpeg", []byte("first"), "image/jpeg"))
+	assert.NoError(t, client.Write(ctx, testBucket, "photos/u1/a.jpeg", []byte("photo"), "image/jpeg"))
+
+	read, err := client.Read(ctx, testBucket, "photos/u1/a.jpeg")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("photo"), read)
+	stored, err := os.ReadFile(filepath.Join(client.Dir, testBucket, "photos", "u1", "a.jpeg"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("photo"), stored)
+	entries, err := os.ReadDir(filepath.Join(client.Dir, testBucket, "photos", "u1"))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary files should be removed")
+
+	assert.NoError(t, client.Delete(ctx, testBucket, "photos/u1/a.jpeg"))
+	assert.NoError(t, client.Delete(ctx, testBucket, "photos/u1/a.jpeg"))
+	_, err = client.Read(ctx, testBucket, "photos/u1/a.jpeg")
+	assert.ErrorIs(t, err, ErrObjectNotFound)
+}
+
+func TestLocalClient_RejectsPathsOutsideDir(t *testing.T) {
+	ctx := context.Background()
+	client := newTestLocalClient(t, "http://localhost:8080/files")
+
+	for _, path := range []string{"../escaped.jpeg", "photos/../../escaped.jpeg", "/etc/passwd", ""} {
+		assert.Error(t, client.Write(ctx, testBucket, path, []byte("photo"), "image/jpeg"), path)
+		_, err := client.GetDownloadURL(ctx, testBucket, path)
+		assert.Error(t, err, path)
+	}
+	assert.Error(t, client.Write(ctx, "..", "escaped.jpeg", []byte("photo"), "image/jpeg"))
+	assert.Error(t, client.Write(ctx, "a/b", "escaped.jpeg", []byte("photo"), "image/jpeg"))
+}
+
+func TestNewLocalClient_RequiresSecret(t *testing.T) {
+	_, err := NewLocalClient(t.TempDir(), "http://localhost:8080/files", nil)
+
+	assert.Error(t, err)
+}
+
+func TestLocalClient_ServesSignedURLs(t *testing.T) {
+	// Setup
+	ctx := context.Background()
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	client := newTestLocalClient(t, server.URL+"/files/")
+	client.now = func() time.Time { return time.Unix(1705314600, 0) }
+	mux.Handle("/files/", http.StripPrefix("/files", client))
+	filePath := "generatedImages/u1/2024-01-15T10:30:00Z generated.png"
+	assert.NoError(t, client.Write(ctx, testBucket, filePath, []byte("\x89PNG\r\n\x1a\nimage"), "image/png"))
+
+	// Execute
+	downloadURL, err := client.GetDownloadURL(ctx, testBucket, filePath)
+	assert.NoError(t, err)
+	resp, err := http.Get(downloadURL)
+
+	// Assert
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	parsed, err := url.Parse(downloadURL)
+	assert.NoError(t, err)
+	assert.Equal(t, "/files/spirit-snap.appspot.com/generatedImages/u1/2024-01-15T10:30:00Z%20generated.png", parsed.EscapedPath())
+	assert.Equal(t, "1705919400", parsed.Query().Get("expires"))
+	assert.NotEmpty(t, parsed.Query().Get("signature"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "\x89PNG\r\n\x1a\nimage", string(body))
+}
+
+func TestLocalClient_ServeHTTPErrors(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1705314600, 0)
+	client := newTestLocalClient(t, "http://localhost:8080/files")
+	client.now = func() time.Time { return now }
+	assert.NoError(t, client.Write(ctx, testBucket, "photos/a.jpeg", []byte("photo"), "image/jpeg"))
+	signedPath := func(filePath string) string {
+		downloadURL, err := client.GetDownloadURL(ctx, testBucket, filePath)
+		assert.NoError(t, err)
+		return downloadURL[len(client.BaseURL):]
+	}
+	validPath := signedPath("photos/a.jpeg")
+	otherPath := signedPath("photos/b.jpeg")
+	query := validPath[len("/"+testBucket+"/photos/a.jpeg"):]
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		elapsed    time.Duration
+		wantStatus int
+	}{
+		{name: "Valid signature", method: http.MethodGet, path: validPath, wantStatus: http.StatusOK},
+		{name: "HEAD request", method: http.MethodHead, path: validPath, wantStatus: http.StatusOK},
+		{name: "Just before expiry", method: http.MethodGet, path: validPath, elapsed: DefaultURLExpiry - time.Second, wantStatus: http.StatusOK},
+		{name: "Expired", method: http.MethodGet, path: validPath, elapsed: DefaultURLExpiry, wantStatus: http.StatusForbidden},
+		{name: "Missing signature", method: http.MethodGet, path: "/" + testBucket + "/photos/a.jpeg", wantStatus: http.StatusForbidden},
+		{name: "Signature for another file", method: http.MethodGet, path: "/" + testBucket + "/photos/a.jpeg" + otherPath[len("/"+testBucket+"/photos/b.jpeg"):], wantStatus: http.StatusForbidden},
+		{name: "Extended expiry", method: http.MethodGet, path: "/" + testBucket + "/photos/a.jpeg" + query[:len("?expires=")] + "9" + query[len("?expires="):], wantStatus: http.StatusForbidden},
+		{name: "Missing file", method: http.MethodGet, path: otherPath, wantStatus: http.StatusNotFound},
+		{name: "Bucket only", method: http.MethodGet, path: "/" + testBucket, wantStatus: http.StatusNotFound},
+		{name: "Wrong method", method: http.MethodPost, path: validPath, wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client.now = func() time.Time { return now.Add(tt.elapsed) }
+			w := httptest.NewRecorder()
+
+			client.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
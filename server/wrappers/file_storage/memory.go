@@ -0,0 +1,128 @@
+package file_storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrObjectNotFound is returned when reading a file that does not exist.
+var ErrObjectNotFound = errors.New("object not found")
+
+// MemoryClient keeps files in memory, for running the server without Firebase
+// Storage. Download URLs point at the client's own ServeHTTP, which the
+// server mounts at BaseURL, and are signed like those of LocalClient.
+// Everything is lost when the process exits.
+type MemoryClient struct {
+	urlSigner
+
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+type memoryObject struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+// NewMemoryClient creates an empty client. URLs are signed with secret, so
+// they stop working when the secret changes.
+func NewMemoryClient(baseURL string, secret []byte) (*MemoryClient, error) {
+	signer, err := newURLSigner(strings.TrimSuffix(baseURL, "/"), secret)
+	if err != nil {
+		return nil, err
+	}
+	return &MemoryClient{
+		urlSigner: signer,
+		objects:   make(map[string]memoryObject),
+	}, nil
+}
+
+// Write stores a file, replacing any file with the same path.
+func (c *MemoryClient) Write(ctx context.Context, bucketName string, filePath string, data []byte, contentType string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.objects[objectKey(bucketName, filePath)] = memoryObject{
+		data:        bytes.Clone(data),
+		contentType: contentType,
+		modified:    time.Now(),
+	}
+	return nil
+}
+
+// Read returns the contents of a file, or ErrObjectNotFound.
+func (c *MemoryClient) Read(ctx context.Context, bucketName string, filePath string) ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	object, ok := c.objects[objectKey(bucketName, filePath)]
+	if !ok {
+		return nil, fmt.Errorf("failed to read %s: %w", filePath, ErrObjectNotFound)
+	}
+	return bytes.Clone(object.data), nil
+}
+
+// GetDownloadURL returns a signed URL that ServeHTTP serves the file at until
+// URLExpiry has passed. Like signed URLs, it can be created before the file
+// exists.
+func (c *MemoryClient) GetDownloadURL(ctx context.Context, bucketName string, filePath string) (string, error) {
+	return c.signedURL(bucketName, filePath), nil
+}
+
+// Delete removes a file. Deleting a file that does not exist is not an error.
+func (c *MemoryClient) Delete(ctx context.Context, bucketName string, filePath string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.objects, objectKey(bucketName, filePath))
+	return nil
+}
+
+// ServeHTTP serves GET and HEAD requests for URLs made by GetDownloadURL. It
+// expects the prefix of BaseURL to have been stripped from the request path.
+// Requests with a missing or invalid signature, or an expired URL, are
+// forbidden.
+func (c *MemoryClient) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	bucketName, filePath, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if !ok || filePath == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !c.checkSignature(w, r, bucketName, filePath) {
+		return
+	}
+
+	c.mu.RLock()
+	object, found := c.objects[objectKey(bucketName, filePath)]
+	c.mu.RUnlock()
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	if object.contentType != "" {
+		w.Header().Set("Content-Type", object.contentType)
+	}
+	http.ServeContent(w, r, filePath, object.modified, bytes.NewReader(object.data))
+}
+
+func objectKey(bucketName, filePath string) string {
+	return bucketName + "/" + filePath
+}
+
+// Escapes each segment of a path, keeping the slashes between them.
+func escapePath(filePath string) string {
+	segments := strings.Split(filePath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
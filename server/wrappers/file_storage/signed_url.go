@@ -0,0 +1,73 @@
+package file_storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultURLExpiry is how long download URLs are valid for, the same as the
+// signed URLs of Client.
+const DefaultURLExpiry = 7 * 24 * time.Hour
+
+// urlSigner makes and checks the download URLs of LocalClient and
+// MemoryClient. Like the V4 signed URLs of Cloud Storage, they carry an
+// expiry time and an HMAC-SHA256 signature of the file and expiry time.
+type urlSigner struct {
+	// URL that the client's ServeHTTP is reachable at, e.g.
+	// "http://localhost:8080/files".
+	BaseURL string
+	// How long download URLs are valid for.
+	URLExpiry time.Duration
+
+	secret []byte
+	now    func() time.Time
+}
+
+func newURLSigner(baseURL string, secret []byte) (urlSigner, error) {
+	if len(secret) == 0 {
+		return urlSigner{}, fmt.Errorf("a secret is needed to sign download URLs")
+	}
+	return urlSigner{
+		BaseURL:   baseURL,
+		URLExpiry: DefaultURLExpiry,
+		secret:    secret,
+		now:       time.Now,
+	}, nil
+}
+
+// Returns a URL for the file that is valid until URLExpiry has passed.
+func (s *urlSigner) signedURL(bucketName string, filePath string) string {
+	expires := strconv.FormatInt(s.now().Add(s.URLExpiry).Unix(), 10)
+	signature := base64.RawURLEncoding.EncodeToString(s.sign(bucketName, filePath, expires))
+	return s.BaseURL + "/" + escapePath(bucketName+"/"+filePath) + "?expires=" + expires + "&signature=" + signature
+}
+
+// Reports whether r carries a valid signature for the file that has not
+// expired. If not, it responds with 403 Forbidden.
+func (s *urlSigner) checkSignature(w http.ResponseWriter, r *http.Request, bucketName string, filePath string) bool {
+	query := r.URL.Query()
+	expires := query.Get("expires")
+	signature, err := base64.RawURLEncoding.DecodeString(query.Get("signature"))
+	if err != nil || !hmac.Equal(signature, s.sign(bucketName, filePath, expires)) {
+		http.Error(w, "Invalid signature", http.StatusForbidden)
+		return false
+	}
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !s.now().Before(time.Unix(expiresAt, 0)) {
+		http.Error(w, "URL has expired", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// Signs the object and expiry time of a download URL.
+func (s *urlSigner) sign(bucketName string, filePath string, expires string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(bucketName + "/" + filePath + "\n" + expires))
+	return mac.Sum(nil)
+}